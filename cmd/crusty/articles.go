@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"crusty-buffer/internal/client"
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/worker"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var showRaw bool
+
+var showCmd = &cobra.Command{
+	Use:   "show [id]",
+	Short: "Print an archived article",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := mustParseID(args[0])
+		ctx := context.Background()
+
+		if showRaw {
+			raw, err := fetchRaw(ctx, id)
+			if err != nil {
+				logger.Fatal("Failed to read raw html", zap.Error(err))
+			}
+			os.Stdout.Write(raw)
+			return
+		}
+
+		article, err := fetchArticle(ctx, id)
+		if err != nil {
+			logger.Fatal("Failed to read article", zap.Error(err))
+		}
+		printArticle(article)
+	},
+}
+
+var reprocessCmd = &cobra.Command{
+	Use:   "reprocess [id]",
+	Short: "Re-run extraction from the stored raw HTML (no network)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := mustParseID(args[0])
+		ctx := context.Background()
+
+		var article *model.Article
+		st, ok := openLocalStore()
+		if ok {
+			defer st.Close()
+			var err error
+			article, err = worker.NewWorker(st, logger, worker.WithSanitizePolicy(loadSanitizePolicy())).Reprocess(ctx, id)
+			if err != nil {
+				logger.Fatal("Failed to reprocess", zap.Error(err))
+			}
+		} else {
+			var err error
+			article, err = client.New(serverURL).Reprocess(ctx, id)
+			if err != nil {
+				logger.Fatal("Failed to reprocess", zap.Error(err))
+			}
+		}
+
+		logger.Info("Reprocessed", zap.String("id", id.String()), zap.String("title", article.Title))
+	},
+}
+
+// fetchArticle reads an article with content, locally if possible, else through the server
+func fetchArticle(ctx context.Context, id uuid.UUID) (*model.Article, error) {
+	if st, ok := openLocalStore(); ok {
+		defer st.Close()
+		return st.Get(ctx, id)
+	}
+	return client.New(serverURL).Get(ctx, id, true)
+}
+
+// fetchRaw is fetchArticle for the originally downloaded HTML
+func fetchRaw(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	if st, ok := openLocalStore(); ok {
+		defer st.Close()
+		return st.GetRaw(ctx, id)
+	}
+	return client.New(serverURL).Raw(ctx, id)
+}
+
+func printArticle(a *model.Article) {
+	fmt.Printf("Title:   %s\n", a.Title)
+	fmt.Printf("URL:     %s\n", a.URL)
+	fmt.Printf("Status:  %s\n", a.Status)
+	fmt.Printf("Saved:   %s\n", a.CreatedAt.Format("Jan 02, 2006 15:04"))
+	if a.ErrorMessage != "" {
+		fmt.Printf("Error:   %s\n", a.ErrorMessage)
+	}
+	if len(a.History) > 0 {
+		fmt.Println("History:")
+		for _, ev := range a.History {
+			fmt.Printf("  %s  %-10s", ev.At.Format("Jan 02 15:04:05"), ev.To)
+			if ev.Worker != "" {
+				fmt.Printf("  by %s", ev.Worker)
+			}
+			if ev.Error != "" {
+				fmt.Printf("  (%s)", ev.Error)
+			}
+			fmt.Println()
+		}
+	}
+	if a.Content != "" {
+		fmt.Println()
+		fmt.Println(a.Content)
+	}
+}
+
+func mustParseID(s string) uuid.UUID {
+	id, err := uuid.Parse(s)
+	if err != nil {
+		logger.Fatal("Invalid ID", zap.String("id", s))
+	}
+	return id
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"crusty-buffer/internal/client"
+	"crusty-buffer/internal/store"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	backupOut     string
+	restoreMerge  bool
+	restoreDryRun bool
+
+	// crusty server's restore endpoint
+	remoteRestore  bool
+	maxRestoreSize int64
+)
+
+func describeBackup(info *store.BackupInfo) string {
+	return fmt.Sprintf("%d article(s), %d with content, from the %s backend on %s",
+		info.Articles, info.Contents, info.Backend, info.CreatedAt.Local().Format("Jan 02, 2006 15:04"))
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Write a snapshot of everything (articles, indexes, queue, content) to a .tar.zst file",
+	Run: func(cmd *cobra.Command, args []string) {
+		out := backupOut
+		if out == "" {
+			out = "crusty-" + time.Now().Format("2006-01-02") + ".tar.zst"
+		}
+		// Written next to out and renamed once it reads back, so a failed
+		// backup never leaves half a file under the real name
+		tmp, err := os.CreateTemp(filepath.Dir(out), ".crusty-backup-*")
+		if err != nil {
+			logger.Fatal("Failed to create file", zap.Error(err))
+		}
+		defer os.Remove(tmp.Name())
+
+		ctx := context.Background()
+		if st, ok := openLocalStore(); ok {
+			_, err = st.Backup(ctx, tmp)
+			st.Close()
+		} else {
+			err = client.New(serverURL).Backup(ctx, tmp)
+		}
+		if err != nil {
+			tmp.Close()
+			logger.Fatal("Backup failed", zap.Error(err))
+		}
+
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			logger.Fatal("Failed to read the backup back", zap.Error(err))
+		}
+		info, err := store.ReadBackup(tmp)
+		if err != nil {
+			tmp.Close()
+			logger.Fatal("Backup doesn't read back", zap.Error(err))
+		}
+		if err := tmp.Close(); err != nil {
+			logger.Fatal("Failed to write file", zap.Error(err))
+		}
+		if err := os.Rename(tmp.Name(), out); err != nil {
+			logger.Fatal("Failed to write file", zap.Error(err))
+		}
+		fmt.Printf("Backed up %s to %s\n", describeBackup(info), out)
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file.tar.zst>",
+	Short: "Load a backup into an empty store (or a live one with --merge), then check every article reads back",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Open(args[0])
+		if err != nil {
+			logger.Fatal("Failed to open backup", zap.Error(err))
+		}
+		defer f.Close()
+
+		info, err := store.ReadBackup(f)
+		if err != nil {
+			logger.Fatal("Backup is damaged", zap.Error(err))
+		}
+		fmt.Printf("Backup of %s\n", describeBackup(info))
+		if restoreDryRun {
+			return
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			logger.Fatal("Failed to read backup", zap.Error(err))
+		}
+
+		ctx := context.Background()
+		var problems []string
+		if st, ok := openLocalStore(); ok {
+			info, err = st.LoadBackup(ctx, f, restoreMerge)
+			if err == nil {
+				problems, err = store.CheckRestored(ctx, st, info)
+			}
+			st.Close()
+		} else {
+			info, problems, err = client.New(serverURL).LoadBackup(ctx, f, restoreMerge)
+		}
+		var apiErr *client.Error
+		if errors.Is(err, store.ErrNotEmpty) || errors.As(err, &apiErr) && apiErr.Code == "not_empty" {
+			logger.Fatal("The store has articles already; pass --merge to restore over them (the backup's copies win)")
+		} else if err != nil {
+			logger.Fatal("Restore failed", zap.Error(err))
+		}
+
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			logger.Fatal("Restored, but some articles didn't read back", zap.Int("problems", len(problems)))
+		}
+		fmt.Printf("Restored %d article(s), all read back\n", info.Articles)
+	},
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"crusty-buffer/internal/client"
+	"crusty-buffer/internal/export"
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	exportTag   string
+	exportOut   string
+	exportTitle string
+	exportDir   string
+	exportSync  bool
+)
+
+// exportSource is what exports read: articles with their content, and their
+// images. A local store has it, and remoteSource gives it to client.Client.
+type exportSource interface {
+	Get(ctx context.Context, id uuid.UUID) (*model.Article, error)
+	List(ctx context.Context, opts store.ListOptions) ([]model.Article, string, error)
+	GetAsset(ctx context.Context, hash string) (*store.Asset, error)
+}
+
+type remoteSource struct{ *client.Client }
+
+func (r remoteSource) Get(ctx context.Context, id uuid.UUID) (*model.Article, error) {
+	return r.Client.Get(ctx, id, true)
+}
+
+// openExportSource needs Badger for the content, so it goes through the
+// server while that has the lock
+func openExportSource() (exportSource, func()) {
+	if st, ok := openLocalStore(); ok {
+		return st, st.Close
+	}
+	return remoteSource{client.New(serverURL)}, func() {}
+}
+
+// exportArticles loads the archived articles named by args, or else every
+// archived article (tagged tag, if given), oldest first. Listed ones come
+// without their content.
+func exportArticles(ctx context.Context, src exportSource, args []string, tag string) ([]*model.Article, error) {
+	var articles []*model.Article
+	for _, arg := range args {
+		a, err := src.Get(ctx, mustParseID(arg))
+		if err != nil {
+			return nil, fmt.Errorf("article %s: %w", arg, err)
+		}
+		if a.Status != model.StatusArchived {
+			return nil, fmt.Errorf("article %s is %s, not archived", arg, a.Status)
+		}
+		articles = append(articles, a)
+	}
+	if len(args) > 0 {
+		return articles, nil
+	}
+
+	opts := store.ListOptions{
+		Limit:  200,
+		Tag:    model.NormalizeTag(tag),
+		Status: []model.ArticleStatus{model.StatusArchived},
+		Order:  store.OrderOldest,
+	}
+	for {
+		page, next, err := src.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range page {
+			articles = append(articles, &page[i])
+		}
+		if next == "" {
+			return articles, nil
+		}
+		opts.Cursor = next
+	}
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export archived articles to read elsewhere",
+}
+
+var exportEPUBCmd = &cobra.Command{
+	Use:   "epub [id]...",
+	Short: "Write articles as one EPUB, with a table of contents if there are several",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 && exportTag == "" {
+			logger.Fatal("Give article IDs or --tag")
+		}
+		ctx := context.Background()
+		src, done := openExportSource()
+		defer done()
+
+		articles, err := exportArticles(ctx, src, args, exportTag)
+		if err != nil {
+			logger.Fatal("Failed to read articles", zap.Error(err))
+		}
+		for i, a := range articles {
+			if a.Content != "" {
+				continue
+			}
+			if articles[i], err = src.Get(ctx, a.ID); err != nil {
+				logger.Fatal("Failed to read article", zap.String("id", a.ID.String()), zap.Error(err))
+			}
+		}
+		title := exportTitle
+		if title == "" {
+			title = "crusty-buffer"
+			if exportTag != "" {
+				title = "#" + model.NormalizeTag(exportTag)
+			}
+		}
+
+		f, err := os.Create(exportOut)
+		if err != nil {
+			logger.Fatal("Failed to create file", zap.Error(err))
+		}
+		if err := export.EPUB(ctx, f, src, title, articles); err != nil {
+			f.Close()
+			os.Remove(exportOut)
+			logger.Fatal("Failed to export", zap.Error(err))
+		}
+		if err := f.Close(); err != nil {
+			logger.Fatal("Failed to write file", zap.Error(err))
+		}
+		fmt.Printf("Wrote %d article(s) to %s\n", len(articles), exportOut)
+	},
+}
+
+var exportMarkdownCmd = &cobra.Command{
+	Use:   "md [id]...",
+	Short: "Write articles as Markdown files with YAML front matter (all archived ones by default)",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		src, done := openExportSource()
+		defer done()
+
+		articles, err := exportArticles(ctx, src, args, exportTag)
+		if err != nil {
+			logger.Fatal("Failed to read articles", zap.Error(err))
+		}
+		v, err := export.OpenVault(exportDir, src)
+		if err != nil {
+			logger.Fatal("Failed to open directory", zap.Error(err))
+		}
+
+		var counts [3]int
+		for _, a := range articles {
+			outcome, err := v.Export(ctx, a, exportSync)
+			if err != nil {
+				// Keep what was written so far on record
+				v.Close()
+				logger.Fatal("Failed to export", zap.String("id", a.ID.String()), zap.Error(err))
+			}
+			if outcome == export.Kept {
+				logger.Warn("Edited by hand, left alone", zap.String("id", a.ID.String()), zap.String("file", export.FileName(a)))
+			}
+			counts[outcome]++
+		}
+		if err := v.Close(); err != nil {
+			logger.Fatal("Failed to save sync state", zap.Error(err))
+		}
+		fmt.Printf("Wrote %d, unchanged %d, kept %d in %s\n", counts[export.Written], counts[export.Unchanged], counts[export.Kept], exportDir)
+	},
+}
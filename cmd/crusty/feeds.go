@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"crusty-buffer/internal/client"
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	feedName     string
+	feedEvery    time.Duration
+	feedBackfill int
+)
+
+// subscriber edits the feed subscriptions. client.Client has it, and localSubscriber gives it to a store.
+type subscriber interface {
+	Feeds(ctx context.Context) ([]model.Feed, error)
+	AddFeed(ctx context.Context, url, name string, interval time.Duration, backfill int) (*model.Feed, error)
+	DeleteFeed(ctx context.Context, rawURL string) error
+}
+
+type localSubscriber struct{ store.Store }
+
+func (l localSubscriber) AddFeed(ctx context.Context, url, name string, interval time.Duration, backfill int) (*model.Feed, error) {
+	f, err := store.AddFeed(ctx, l.Store, url, name, interval, backfill)
+	return &f, err
+}
+
+// openSubscriber, like openEditor, works next to the server in hybrid mode
+func openSubscriber() (subscriber, func()) {
+	st, err := openClientStore()
+	if err != nil {
+		logger.Debug("Store is busy, falling back to the server API", zap.Error(err))
+		return client.New(serverURL), func() {}
+	}
+	return localSubscriber{st}, st.Close
+}
+
+var feedCmd = &cobra.Command{
+	Use:   "feed",
+	Short: "Follow RSS/Atom feeds (crusty server saves their new entries)",
+}
+
+var feedAddCmd = &cobra.Command{
+	Use:   "add [url]",
+	Short: "Subscribe to a feed",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sub, done := openSubscriber()
+		defer done()
+
+		f, err := sub.AddFeed(context.Background(), args[0], feedName, feedEvery, feedBackfill)
+		if err != nil {
+			logger.Fatal("Failed to add feed", zap.Error(err))
+		}
+		fmt.Printf("Following %s every %s\n", f.URL, f.Interval)
+	},
+}
+
+var feedListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List feed subscriptions",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		sub, done := openSubscriber()
+		defer done()
+
+		feeds, err := sub.Feeds(context.Background())
+		if err != nil {
+			logger.Fatal("Failed to list feeds", zap.Error(err))
+		}
+		if len(feeds) == 0 {
+			fmt.Println("No feeds.")
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tURL\tEVERY\tLAST FETCH")
+		for _, f := range feeds {
+			last := "never"
+			if f.LastFetchAt != nil {
+				last = f.LastFetchAt.Format("Jan 02 15:04")
+			}
+			if f.LastError != "" {
+				last += " (" + f.LastError + ")"
+			}
+			name := f.Name
+			if name == "" {
+				name = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", name, f.URL, f.Interval, last)
+		}
+		tw.Flush()
+	},
+}
+
+var feedRmCmd = &cobra.Command{
+	Use:   "rm [url]",
+	Short: "Unsubscribe from a feed (articles already saved stay)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sub, done := openSubscriber()
+		defer done()
+
+		if err := sub.DeleteFeed(context.Background(), args[0]); err != nil {
+			logger.Fatal("Failed to remove feed", zap.Error(err))
+		}
+	},
+}
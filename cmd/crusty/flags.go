@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+
+	"crusty-buffer/internal/client"
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	markUnread bool
+	unfavorite bool
+)
+
+// editor changes an article's metadata (tags and the reader's flags).
+// client.Client has it, and localEditor gives it to a store.
+type editor interface {
+	articleLister
+	UpdateTags(ctx context.Context, id uuid.UUID, add, remove []string) (*model.Article, error)
+	UpdateFlags(ctx context.Context, id uuid.UUID, u store.FlagUpdate) (*model.Article, error)
+	Tags(ctx context.Context) ([]store.TagCount, error)
+}
+
+type localEditor struct{ store.Store }
+
+func (e localEditor) UpdateTags(ctx context.Context, id uuid.UUID, add, remove []string) (*model.Article, error) {
+	return store.UpdateTags(ctx, e.Store, id, add, remove)
+}
+
+func (e localEditor) UpdateFlags(ctx context.Context, id uuid.UUID, u store.FlagUpdate) (*model.Article, error) {
+	return store.UpdateFlags(ctx, e.Store, id, u)
+}
+
+// openEditor only touches metadata, so in hybrid mode it works next to the server
+func openEditor() (editor, func()) {
+	st, err := openClientStore()
+	if err != nil {
+		logger.Debug("Store is busy, falling back to the server API", zap.Error(err))
+		return client.New(serverURL), func() {}
+	}
+	return localEditor{st}, st.Close
+}
+
+// updateFlags applies u to every ID in args
+func updateFlags(args []string, u store.FlagUpdate) {
+	e, done := openEditor()
+	defer done()
+
+	for _, arg := range args {
+		if _, err := e.UpdateFlags(context.Background(), mustParseID(arg), u); err != nil {
+			logger.Fatal("Failed to update article", zap.String("id", arg), zap.Error(err))
+		}
+	}
+}
+
+var readCmd = &cobra.Command{
+	Use:   "read [id]...",
+	Short: "Mark articles as read",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		read := !markUnread
+		updateFlags(args, store.FlagUpdate{Read: &read})
+	},
+}
+
+var favCmd = &cobra.Command{
+	Use:   "fav [id]...",
+	Short: "Add articles to the favorites",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		favorite := !unfavorite
+		updateFlags(args, store.FlagUpdate{Favorite: &favorite})
+	},
+}
+
+var inboxCmd = &cobra.Command{
+	Use:   "inbox",
+	Short: "List the inbox, newest first (* marks unread)",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		e, done := openEditor()
+		defer done()
+
+		var articles []model.Article
+		opts := store.ListOptions{Folder: model.FolderInbox, Limit: 200}
+		for {
+			page, next, err := e.List(context.Background(), opts)
+			if err != nil {
+				logger.Fatal("Failed to list inbox", zap.Error(err))
+			}
+			articles = append(articles, page...)
+			if next == "" {
+				break
+			}
+			opts.Cursor = next
+		}
+		printArticles(articles)
+	},
+}
+
+var inboxDoneCmd = &cobra.Command{
+	Use:   "done [id]...",
+	Short: "Move articles from the inbox to the archive",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		folder := model.FolderArchive
+		updateFlags(args, store.FlagUpdate{Folder: &folder})
+	},
+}
+
+var inboxBackCmd = &cobra.Command{
+	Use:   "back [id]...",
+	Short: "Move articles back to the inbox",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		folder := model.FolderInbox
+		updateFlags(args, store.FlagUpdate{Folder: &folder})
+	},
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"crusty-buffer/internal/client"
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// listFilter holds the `crusty list` flags
+type listFilter struct {
+	status     []string
+	domain     string
+	tag        string
+	url        string
+	unread     bool
+	favorite   bool
+	folder     string
+	since      string
+	until      string
+	site       string
+	author     string
+	lang       string
+	minMinutes int
+	maxMinutes int
+	sortBy     string
+	reverse    bool
+	limit      int
+}
+
+var listFlags listFilter
+
+// articleLister is the part of store.Store that list needs; client.Client has it too
+type articleLister interface {
+	List(ctx context.Context, opts store.ListOptions) ([]model.Article, string, error)
+}
+
+// sortKeys maps --sort values to "a comes before b". Times and sizes go
+// biggest first, text A-Z; articles missing the field always go last.
+var sortKeys = map[string]func(a, b *model.Article) bool{
+	"created": func(a, b *model.Article) bool { return a.CreatedAt.After(b.CreatedAt) },
+	"archived": func(a, b *model.Article) bool {
+		return timeAfter(a.ArchivedAt, b.ArchivedAt)
+	},
+	"published": func(a, b *model.Article) bool {
+		return timeAfter(a.PublishedTime, b.PublishedTime)
+	},
+	"title":        func(a, b *model.Article) bool { return textBefore(a.Title, b.Title) },
+	"site":         func(a, b *model.Article) bool { return textBefore(a.Site(), b.Site()) },
+	"author":       func(a, b *model.Article) bool { return textBefore(a.Byline, b.Byline) },
+	"words":        func(a, b *model.Article) bool { return a.WordCount > b.WordCount },
+	"reading-time": func(a, b *model.Article) bool { return a.ReadingTime > b.ReadingTime },
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved articles",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		less, ok := sortKeys[listFlags.sortBy]
+		if !ok {
+			logger.Fatal("Unknown --sort", zap.String("sort", listFlags.sortBy), zap.Strings("want", sortKeyNames()))
+		}
+
+		var src articleLister
+		if st, err := openClientStore(); err == nil {
+			defer st.Close()
+			src = st
+		} else {
+			logger.Debug("Store is busy, falling back to the server API", zap.Error(err))
+			src = client.New(serverURL)
+		}
+
+		opts := store.ListOptions{
+			Limit:    200,
+			Domain:   strings.ToLower(strings.TrimPrefix(listFlags.domain, "www.")),
+			Tag:      model.NormalizeTag(listFlags.tag),
+			URL:      listFlags.url,
+			Unread:   listFlags.unread,
+			Favorite: listFlags.favorite,
+			Folder:   model.Folder(listFlags.folder),
+			Since:    mustParseDate("since", listFlags.since),
+			Until:    mustParseDate("until", listFlags.until),
+		}
+		for _, s := range listFlags.status {
+			opts.Status = append(opts.Status, model.ArticleStatus(s))
+		}
+
+		// Sorting needs everything, so read every page first
+		var articles []model.Article
+		ctx := context.Background()
+		for {
+			page, next, err := src.List(ctx, opts)
+			if err != nil {
+				logger.Fatal("Failed to list articles", zap.Error(err))
+			}
+			for _, a := range page {
+				if listFlags.match(&a) {
+					articles = append(articles, a)
+				}
+			}
+			if next == "" {
+				break
+			}
+			opts.Cursor = next
+		}
+
+		sort.SliceStable(articles, func(i, j int) bool {
+			if listFlags.reverse {
+				return less(&articles[j], &articles[i])
+			}
+			return less(&articles[i], &articles[j])
+		})
+		if listFlags.limit > 0 && len(articles) > listFlags.limit {
+			articles = articles[:listFlags.limit]
+		}
+
+		printArticles(articles)
+	},
+}
+
+// printArticles is the table list and inbox print. Unread articles get a *, favorites a ★.
+func printArticles(articles []model.Article) {
+	if len(articles) == 0 {
+		fmt.Println("No articles.")
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\t\tSTATUS\tSAVED\tMIN\tSITE\tTITLE")
+	for _, a := range articles {
+		title := a.Title
+		if title == "" {
+			title = a.URL
+		}
+		minutes := "-"
+		if a.ReadingTime > 0 {
+			minutes = fmt.Sprint(a.ReadingTime)
+		}
+		marks := ""
+		if a.ReadAt == nil {
+			marks += "*"
+		}
+		if a.Favorite {
+			marks += "★"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			a.ID, marks, a.Status, a.CreatedAt.Format("2006-01-02"), minutes, a.Site(), title)
+	}
+	tw.Flush()
+}
+
+// match applies the filters the store can't
+func (f *listFilter) match(a *model.Article) bool {
+	if f.site != "" && !containsFold(a.Site(), f.site) && !containsFold(a.URL, f.site) {
+		return false
+	}
+	if f.author != "" && !containsFold(a.Byline, f.author) {
+		return false
+	}
+	// "en" matches "en-US" too
+	if f.lang != "" && !strings.HasPrefix(strings.ToLower(a.Language), strings.ToLower(f.lang)) {
+		return false
+	}
+	if f.minMinutes > 0 && a.ReadingTime < f.minMinutes {
+		return false
+	}
+	if f.maxMinutes > 0 && a.ReadingTime > f.maxMinutes {
+		return false
+	}
+	return true
+}
+
+// mustParseDate accepts 2006-01-02 or RFC 3339; "" is no bound
+func mustParseDate(flag, raw string) time.Time {
+	if raw == "" {
+		return time.Time{}
+	}
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, raw, time.Local); err == nil {
+			return t
+		}
+	}
+	logger.Fatal("Invalid date, want 2006-01-02 or RFC 3339", zap.String("flag", flag), zap.String("value", raw))
+	return time.Time{}
+}
+
+func timeAfter(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a != nil
+	}
+	return a.After(*b)
+}
+
+func textBefore(a, b string) bool {
+	if a == "" || b == "" {
+		return a != ""
+	}
+	return strings.ToLower(a) < strings.ToLower(b)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func sortKeyNames() []string {
+	names := make([]string, 0, len(sortKeys))
+	for name := range sortKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -3,13 +3,17 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"crusty-buffer/internal/client"
 	"crusty-buffer/internal/model"
+	web "crusty-buffer/internal/server"
 	"crusty-buffer/internal/store"
 	"crusty-buffer/internal/worker"
 
@@ -21,8 +25,63 @@ var (
 	logger     *zap.Logger
 	redisAddr  string
 	badgerPath string
+	httpAddr   string
+	backend    string
+	serverURL  string
+	retry      = worker.DefaultRetryPolicy()
+	workers    int
+	polite     = worker.DefaultPoliteness()
+	scrapeCfg  = worker.DefaultScraperConfig()
+	scrapeTime time.Duration
+	imageCfg   = worker.DefaultImageConfig()
 )
 
+const (
+	backendHybrid = "hybrid"
+	backendBadger = "badger"
+)
+
+// openStore opens the full store for the server process
+func openStore() (store.Store, error) {
+	switch backend {
+	case backendHybrid:
+		return store.NewHybridStore(redisAddr, badgerPath)
+	case backendBadger:
+		return store.NewBadgerStore(badgerPath)
+	default:
+		return nil, fmt.Errorf("unknown backend %q (want %s or %s)", backend, backendBadger, backendHybrid)
+	}
+}
+
+// openLocalStore opens the full store (content included) if nobody else holds it.
+// ok=false means the server has the Badger lock, and the caller should use its API instead.
+func openLocalStore() (st store.Store, ok bool) {
+	st, err := openStore()
+	if err != nil {
+		logger.Debug("Store is busy, falling back to the server API", zap.Error(err))
+		return nil, false
+	}
+	return st, true
+}
+
+// openClientStore opens the store for short-lived CLI commands.
+// Hybrid mode skips Badger so it can run next to the server; badger mode
+// has to take the directory lock, so the server must be stopped first.
+func openClientStore() (store.Store, error) {
+	switch backend {
+	case backendHybrid:
+		return store.NewHybridStore(redisAddr, "")
+	case backendBadger:
+		st, err := store.NewBadgerStore(badgerPath)
+		if err != nil {
+			return nil, fmt.Errorf("%w (is crusty server running? stop it first in badger mode)", err)
+		}
+		return st, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q (want %s or %s)", backend, backendBadger, backendHybrid)
+	}
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "crusty",
 	Short: "crusty-buffer - A self-hosted read-it-later tool",
@@ -58,55 +117,137 @@ var serverCmd = &cobra.Command{
 			cancel()
 		}()
 
-		// Initialize Store (FULL MODE - Redis + Badger)
-		st, err := store.NewHybridStore(redisAddr, badgerPath)
+		// Initialize Store (FULL MODE - Redis + Badger, or Badger only)
+		st, err := openStore()
 		if err != nil {
 			logger.Fatal("Failed to init store", zap.Error(err))
 		}
 		defer st.Close()
 
 		// Start Worker
-		w := worker.NewWorker(st, logger)
-		go w.Start(ctx)
+		scrapeCfg.UserAgent = polite.UserAgent
+		scraper, err := worker.NewDefaultScraper(scrapeCfg)
+		if err != nil {
+			logger.Fatal("Failed to init scraper", zap.Error(err))
+		}
+		w := worker.NewWorker(st, logger,
+			worker.WithScraper(scraper),
+			worker.WithScrapeTimeout(scrapeTime),
+			worker.WithRetryPolicy(retry),
+			worker.WithConcurrency(workers),
+			worker.WithPoliteness(polite),
+			worker.WithImages(imageCfg),
+			worker.WithSanitizePolicy(loadSanitizePolicy()),
+			worker.WithTrashRetention(trashRetention),
+		)
+		workerDone := make(chan struct{})
+		go func() {
+			defer close(workerDone)
+			w.Start(ctx)
+		}()
+
+		// Start Web UI
+		srv := web.NewServer(st, logger,
+			web.WithReprocessor(w),
+			web.WithRemoteRestore(remoteRestore),
+			web.WithMaxRestoreSize(maxRestoreSize),
+		)
+		go func() {
+			if err := srv.Start(httpAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Web server failed", zap.Error(err))
+				cancel()
+			}
+		}()
 
-		logger.Info("Server running.")
+		logger.Info("Server running.", zap.String("http", httpAddr))
 		fmt.Println("Press 'q' + Enter or Ctrl+C to stop.")
 		
 		// Block until shutdown
 		<-ctx.Done()
-		
-		time.Sleep(1 * time.Second)
+
+		// Give in-flight requests a moment to finish
+		shutdownCtx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+		defer stop()
+		if err := srv.Stop(shutdownCtx); err != nil {
+			logger.Error("Web server shutdown failed", zap.Error(err))
+		}
+
+		// Let in-flight jobs finish before the store is closed
+		logger.Info("Waiting for in-flight jobs...")
+		<-workerDone
 		logger.Info("Goodbye!")
 	},
 }
 
+var addDedupe string
+
 var addCmd = &cobra.Command{
 	Use:   "add [url]",
 	Short: "Archive a URL immediately",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		url := args[0]
-		
-		// Initialize Store (CLIENT MODE - Redis Only)
-		// Passing "" as the second argument ensures we don't try to open the BadgerDB file lock.
-		st, err := store.NewHybridStore(redisAddr, "") 
-		if err != nil {
-			logger.Fatal("Failed to init store", zap.Error(err))
+		ctx := context.Background()
+		mode := store.DedupeMode(addDedupe)
+		if !store.ValidDedupe(mode) {
+			logger.Fatal("--dedupe must be version, skip or always", zap.String("dedupe", addDedupe))
 		}
-		defer st.Close()
 
-		// Create Article
-		article := model.NewArticle(url)
+		var (
+			article *model.Article
+			created bool
+			lister  store.Lister
+			err     error
+		)
+		if backend == backendBadger {
+			// Badger mode: the server holds the directory lock, so go through its API
+			c := client.New(serverURL)
+			article, created, err = c.Add(ctx, url, mode, addTags...)
+			lister = c
+		} else {
+			// Initialize Store (CLIENT MODE - Redis Only)
+			// Passing "" as the second argument ensures we don't try to open the BadgerDB file lock.
+			st, openErr := store.NewHybridStore(redisAddr, "")
+			if openErr != nil {
+				logger.Fatal("Failed to init store", zap.Error(openErr))
+			}
+			defer st.Close()
 
-		// Save (Pushes to Redis Queue, ignores Badger because content is empty)
-		ctx := context.Background()
-		if err := st.Save(ctx, &article); err != nil {
-			logger.Fatal("Failed to save article", zap.Error(err))
+			// Save (Pushes to Redis Queue, ignores Badger because content is empty)
+			article, created, err = store.Add(ctx, st, url, addTags, mode)
+			lister = st
+		}
+		if err != nil {
+			logger.Fatal("Failed to queue article", zap.Error(err))
 		}
 
-		logger.Info("Article queued", 
-			zap.String("id", article.ID.String()), 
-			zap.String("url", url))
+		if created {
+			logger.Info("Article queued",
+				zap.String("id", article.ID.String()),
+				zap.String("url", url))
+		} else {
+			logger.Info("Already in the archive, not adding it again",
+				zap.String("id", article.ID.String()),
+				zap.String("status", string(article.Status)))
+		}
+
+		snapshots, err := store.Snapshots(ctx, lister, article.Canonical())
+		if err != nil {
+			logger.Warn("Could not look for earlier snapshots", zap.Error(err))
+			return
+		}
+		earlier := 0
+		for _, a := range snapshots {
+			if a.CreatedAt.Before(article.CreatedAt) {
+				earlier++
+			}
+		}
+		switch {
+		case earlier == 1:
+			fmt.Printf("1 earlier snapshot of %s\n", article.Canonical())
+		case earlier > 1:
+			fmt.Printf("%d earlier snapshots of %s\n", earlier, article.Canonical())
+		}
 	},
 }
 
@@ -120,9 +261,114 @@ func main() {
 
 	rootCmd.PersistentFlags().StringVar(&redisAddr, "redis", "localhost:6379", "Address of Redis server")
 	rootCmd.PersistentFlags().StringVar(&badgerPath, "badger", "./badger-data", "Path to BadgerDB data directory")
+	rootCmd.PersistentFlags().StringVar(&backend, "backend", backendHybrid, "Storage backend: badger (no Redis) or hybrid (Redis + Badger)")
+	rootCmd.PersistentFlags().StringVar(&serverURL, "server", "http://localhost:8080", "URL of a running crusty server (used by clients in badger mode)")
+
+	serverCmd.Flags().StringVar(&httpAddr, "http-addr", ":8080", "Address for the web UI to listen on")
+	serverCmd.Flags().IntVar(&workers, "workers", 1, "Number of jobs to process in parallel")
+	serverCmd.Flags().IntVar(&retry.MaxAttempts, "max-attempts", retry.MaxAttempts, "Scrape attempts before a job goes to the dead-letter queue")
+	serverCmd.Flags().DurationVar(&retry.BaseDelay, "retry-base", retry.BaseDelay, "Wait before the first retry (doubles every attempt)")
+	serverCmd.Flags().DurationVar(&retry.MaxDelay, "retry-max", retry.MaxDelay, "Upper bound for the wait between retries")
+
+	serverCmd.Flags().Float64Var(&polite.Rate, "host-rate", polite.Rate, "Requests per second allowed to a single host (0 = unlimited)")
+	serverCmd.Flags().IntVar(&polite.Burst, "host-burst", polite.Burst, "Requests a host may receive back-to-back before --host-rate kicks in")
+	serverCmd.Flags().DurationVar(&polite.MinDelay, "host-delay", polite.MinDelay, "Minimum gap between two requests to the same host")
+	serverCmd.Flags().BoolVar(&polite.Robots, "robots", polite.Robots, "Fetch and obey robots.txt")
+	serverCmd.Flags().StringVar(&polite.UserAgent, "user-agent", polite.UserAgent, "User-Agent sent when fetching pages and robots.txt")
+	serverCmd.Flags().DurationVar(&scrapeTime, "scrape-timeout", 30*time.Second, "Give up on a page download after this long")
+	serverCmd.Flags().StringVar(&scrapeCfg.Proxy, "proxy", "", "Proxy URL for page downloads (default: HTTP_PROXY/HTTPS_PROXY)")
+	serverCmd.Flags().Int64Var(&scrapeCfg.MaxBodySize, "max-page-size", scrapeCfg.MaxBodySize, "Largest page body to download, in bytes")
+	serverCmd.Flags().IntVar(&scrapeCfg.MaxRedirects, "max-redirects", scrapeCfg.MaxRedirects, "Redirects to follow before giving up")
+	serverCmd.Flags().BoolVar(&scrapeCfg.InsecureSkipVerify, "insecure-tls", false, "Skip TLS certificate verification (self-signed intranet pages)")
+	serverCmd.Flags().StringVar(&scrapeCfg.CAFile, "ca-file", "", "PEM file with extra CA certificates to trust")
+	serverCmd.Flags().BoolVar(&imageCfg.Enabled, "images", imageCfg.Enabled, "Download images so articles work offline")
+	serverCmd.Flags().IntVar(&imageCfg.MaxImages, "max-images", imageCfg.MaxImages, "Images to download per article")
+	serverCmd.Flags().Int64Var(&imageCfg.MaxSize, "max-image-size", imageCfg.MaxSize, "Largest image to download, in bytes")
+	rootCmd.PersistentFlags().StringVar(&sanitizePolicy, "sanitize-policy", "", "JSON file with the HTML allowlist (default: built-in, see sanitize --print-policy)")
+	serverCmd.Flags().BoolVar(&scrapeCfg.Cookies, "cookies", scrapeCfg.Cookies, "Keep cookies between requests (helps with consent redirects)")
+	serverCmd.Flags().DurationVar(&trashRetention, "trash-retention", worker.DefaultTrashRetention, "Purge trashed articles after this long (0 = never)")
+	serverCmd.Flags().BoolVar(&remoteRestore, "remote-restore", false, "Accept restores from other machines, not just this one (there's no authentication)")
+	serverCmd.Flags().Int64Var(&maxRestoreSize, "max-restore-size", web.DefaultMaxRestoreSize, "Largest backup a restore through the server accepts, in bytes")
+
+	dlqRequeueCmd.Flags().BoolVar(&dlqRequeueAll, "all", false, "Requeue every job in the dead-letter queue")
+	dlqCmd.AddCommand(dlqListCmd, dlqRequeueCmd)
+	queueCmd.AddCommand(dlqCmd)
 
 	rootCmd.AddCommand(serverCmd)
+	addCmd.Flags().StringSliceVar(&addTags, "tag", nil, "Tag the article (repeatable)")
+	addCmd.Flags().StringVar(&addDedupe, "dedupe", string(store.DedupeVersion), "If the URL is archived already: version (new snapshot unless one is queued), skip, or always (don't check)")
 	rootCmd.AddCommand(addCmd)
+	rootCmd.AddCommand(queueCmd)
+
+	showCmd.Flags().BoolVar(&showRaw, "raw", false, "Print the page as originally downloaded instead of the readable version")
+	rootCmd.AddCommand(showCmd)
+	rootCmd.AddCommand(reprocessCmd)
+
+	listCmd.Flags().StringSliceVar(&listFlags.status, "status", nil, "Only these statuses (pending, processing, retrying, archived, failed, deleted)")
+	listCmd.Flags().StringVar(&listFlags.domain, "domain", "", "Only articles from exactly this domain (e.g. example.com)")
+	listCmd.Flags().StringVar(&listFlags.tag, "tag", "", "Only articles with this tag")
+	listCmd.Flags().StringVar(&listFlags.url, "url", "", "Only snapshots of this URL (compared canonically)")
+	listCmd.Flags().BoolVar(&listFlags.unread, "unread", false, "Only articles not read yet")
+	listCmd.Flags().BoolVar(&listFlags.favorite, "favorite", false, "Only favorites")
+	listCmd.Flags().StringVar(&listFlags.folder, "folder", "", "Only articles in this folder (inbox, archive)")
+	listCmd.Flags().StringVar(&listFlags.since, "since", "", "Only articles saved on or after this date (2006-01-02)")
+	listCmd.Flags().StringVar(&listFlags.until, "until", "", "Only articles saved before this date (2006-01-02)")
+	listCmd.Flags().StringVar(&listFlags.site, "site", "", "Only articles whose site name or URL contains this")
+	listCmd.Flags().StringVar(&listFlags.author, "author", "", "Only articles whose byline contains this")
+	listCmd.Flags().StringVar(&listFlags.lang, "lang", "", "Only articles in this language (e.g. en, de)")
+	listCmd.Flags().IntVar(&listFlags.minMinutes, "min-minutes", 0, "Only articles that take at least this long to read")
+	listCmd.Flags().IntVar(&listFlags.maxMinutes, "max-minutes", 0, "Only articles that take at most this long to read")
+	listCmd.Flags().StringVar(&listFlags.sortBy, "sort", "created", "Sort by created, archived, published, title, site, author, words or reading-time")
+	listCmd.Flags().BoolVar(&listFlags.reverse, "reverse", false, "Reverse the sort order")
+	listCmd.Flags().IntVar(&listFlags.limit, "limit", 0, "Show at most this many articles (0 = all)")
+	rootCmd.AddCommand(listCmd)
+
+	searchCmd.Flags().IntVar(&searchLimit, "limit", 10, "Show at most this many results")
+	rootCmd.AddCommand(searchCmd)
+	rootCmd.AddCommand(reindexCmd)
+
+	sanitizeCmd.Flags().BoolVar(&sanitizeAll, "all", false, "Sanitize every stored article")
+	sanitizeCmd.Flags().BoolVar(&printPolicy, "print-policy", false, "Print the policy in use as JSON and exit")
+	rootCmd.AddCommand(sanitizeCmd)
+
+	readCmd.Flags().BoolVar(&markUnread, "unread", false, "Mark as unread instead")
+	favCmd.Flags().BoolVar(&unfavorite, "remove", false, "Remove from favorites instead")
+	inboxCmd.AddCommand(inboxDoneCmd, inboxBackCmd)
+	rootCmd.AddCommand(readCmd, favCmd, inboxCmd)
+
+	tagCmd.AddCommand(tagAddCmd, tagRmCmd)
+	rootCmd.AddCommand(tagCmd, tagsCmd)
+
+	rmCmd.Flags().BoolVar(&rmPurge, "purge", false, "Delete for good instead of moving to the trash")
+	rootCmd.AddCommand(rmCmd)
+	trashEmptyCmd.Flags().DurationVar(&trashOlderThan, "older-than", 0, "Only purge articles trashed at least this long ago")
+	trashCmd.AddCommand(trashRestoreCmd, trashEmptyCmd)
+	rootCmd.AddCommand(trashCmd)
+
+	watchAddCmd.Flags().DurationVar(&watchEvery, "every", 24*time.Hour, "How often to take a snapshot (at least 1m)")
+	watchAddCmd.Flags().StringSliceVar(&watchTags, "tag", nil, "Tag every snapshot (repeatable)")
+	watchCmd.AddCommand(watchAddCmd, watchLsCmd, watchRmCmd)
+	rootCmd.AddCommand(watchCmd, diffCmd)
+
+	feedAddCmd.Flags().StringVar(&feedName, "name", "", "Tag for the feed's articles (default: the feed's title)")
+	feedAddCmd.Flags().DurationVar(&feedEvery, "every", model.DefaultFeedInterval, "How often to fetch the feed (at least 5m)")
+	feedAddCmd.Flags().IntVar(&feedBackfill, "backfill", 5, "How many entries already in the feed to save (newest first)")
+	feedCmd.AddCommand(feedAddCmd, feedListCmd, feedRmCmd)
+	rootCmd.AddCommand(feedCmd)
+
+	exportEPUBCmd.Flags().StringVar(&exportTag, "tag", "", "Export every archived article with this tag")
+	exportEPUBCmd.Flags().StringVarP(&exportOut, "output", "o", "crusty.epub", "File to write")
+	exportEPUBCmd.Flags().StringVar(&exportTitle, "title", "", "Title of a bundle (default: the tag)")
+	exportMarkdownCmd.Flags().StringVar(&exportTag, "tag", "", "Only articles with this tag")
+	exportMarkdownCmd.Flags().StringVar(&exportDir, "dir", "./vault", "Directory to write to (e.g. an Obsidian vault)")
+	exportMarkdownCmd.Flags().BoolVar(&exportSync, "sync", false, "Only write new and changed articles, and leave files edited by hand alone")
+	exportCmd.AddCommand(exportEPUBCmd, exportMarkdownCmd)
+	rootCmd.AddCommand(exportCmd)
+
+	backupCmd.Flags().StringVarP(&backupOut, "output", "o", "", "File to write (default crusty-<date>.tar.zst)")
+	restoreCmd.Flags().BoolVar(&restoreMerge, "merge", false, "Restore into a store that has articles; the backup's copies replace ones with the same ID")
+	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "Only check the backup, don't load it")
+	rootCmd.AddCommand(backupCmd, restoreCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"crusty-buffer/internal/client"
+	"crusty-buffer/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var dlqRequeueAll bool
+
+// deadLetters is what the dlq commands need. A store has it, and remoteDLQ
+// gives it to client.Client.
+type deadLetters interface {
+	ListDeadLetters(ctx context.Context) ([]uuid.UUID, error)
+	RequeueDeadLetter(ctx context.Context, id uuid.UUID) error
+	Get(ctx context.Context, id uuid.UUID) (*model.Article, error)
+}
+
+type remoteDLQ struct{ *client.Client }
+
+func (r remoteDLQ) Get(ctx context.Context, id uuid.UUID) (*model.Article, error) {
+	return r.Client.Get(ctx, id, false)
+}
+
+// openDeadLetters only touches metadata, so in hybrid mode it works next to
+// the server; in badger mode it goes through the server while that runs
+func openDeadLetters() (deadLetters, func()) {
+	st, err := openClientStore()
+	if err != nil {
+		logger.Debug("Store is busy, falling back to the server API", zap.Error(err))
+		return remoteDLQ{client.New(serverURL)}, func() {}
+	}
+	return st, st.Close
+}
+
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "Inspect the archive queue",
+}
+
+var dlqCmd = &cobra.Command{
+	Use:   "dlq",
+	Short: "Jobs that ran out of retry attempts",
+}
+
+var dlqListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List dead-lettered jobs",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		st, done := openDeadLetters()
+		defer done()
+
+		ctx := context.Background()
+		ids, err := st.ListDeadLetters(ctx)
+		if err != nil {
+			logger.Fatal("Failed to read dead-letter queue", zap.Error(err))
+		}
+		if len(ids) == 0 {
+			fmt.Println("Dead-letter queue is empty.")
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tATTEMPTS\tURL\tERROR")
+		for _, id := range ids {
+			article, err := st.Get(ctx, id)
+			if err != nil {
+				fmt.Fprintf(tw, "%s\t-\t-\t(%v)\n", id, err)
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", id, article.Attempts, article.URL, article.ErrorMessage)
+		}
+		tw.Flush()
+	},
+}
+
+var dlqRequeueCmd = &cobra.Command{
+	Use:   "requeue [id...]",
+	Short: "Give dead-lettered jobs a fresh set of attempts",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if dlqRequeueAll == (len(args) > 0) {
+			return fmt.Errorf("pass either job IDs or --all")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		st, done := openDeadLetters()
+		defer done()
+
+		ctx := context.Background()
+		var ids []uuid.UUID
+		if dlqRequeueAll {
+			var err error
+			ids, err = st.ListDeadLetters(ctx)
+			if err != nil {
+				logger.Fatal("Failed to read dead-letter queue", zap.Error(err))
+			}
+		} else {
+			for _, arg := range args {
+				id, err := uuid.Parse(arg)
+				if err != nil {
+					logger.Fatal("Invalid ID", zap.String("id", arg))
+				}
+				ids = append(ids, id)
+			}
+		}
+
+		for _, id := range ids {
+			if err := st.RequeueDeadLetter(ctx, id); err != nil {
+				logger.Error("Failed to requeue", zap.String("id", id.String()), zap.Error(err))
+				continue
+			}
+			logger.Info("Requeued", zap.String("id", id.String()))
+		}
+	},
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"crusty-buffer/internal/sanitize"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	sanitizePolicy string
+	sanitizeAll    bool
+	printPolicy    bool
+)
+
+// loadSanitizePolicy returns the --sanitize-policy file, or the default policy
+func loadSanitizePolicy() sanitize.Policy {
+	if sanitizePolicy == "" {
+		return sanitize.DefaultPolicy()
+	}
+	p, err := sanitize.LoadPolicy(sanitizePolicy)
+	if err != nil {
+		logger.Fatal("Failed to load sanitize policy", zap.Error(err))
+	}
+	return p
+}
+
+var sanitizeCmd = &cobra.Command{
+	Use:   "sanitize [id...]",
+	Short: "Re-clean stored article content with the current sanitize policy",
+	Long: `Re-clean stored article content with the current sanitize policy.
+
+New articles are sanitized by the worker. Run this once with --all to clean
+articles archived before that, or after tightening --sanitize-policy.
+It needs the Badger directory, so stop the server first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if printPolicy {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(loadSanitizePolicy())
+			return
+		}
+		if !sanitizeAll && len(args) == 0 {
+			logger.Fatal("Give article IDs or --all")
+		}
+
+		st, err := openStore()
+		if err != nil {
+			logger.Fatal("Failed to open store (is crusty server running? stop it first)", zap.Error(err))
+		}
+		defer st.Close()
+
+		ctx := context.Background()
+		s := sanitize.New(loadSanitizePolicy())
+		seen, changed := 0, 0
+
+		clean := func(id uuid.UUID) error {
+			article, err := st.Get(ctx, id)
+			if err != nil {
+				return fmt.Errorf("read %s: %w", id, err)
+			}
+			seen++
+			if article.Content == "" {
+				return nil
+			}
+			content := s.Sanitize(article.Content)
+			if content == article.Content {
+				return nil
+			}
+			article.Content = content
+			if err := st.Save(ctx, article); err != nil {
+				return fmt.Errorf("save %s: %w", id, err)
+			}
+			changed++
+			return nil
+		}
+
+		if sanitizeAll {
+			err = st.Walk(ctx, clean)
+		} else {
+			for _, arg := range args {
+				if err = clean(mustParseID(arg)); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			logger.Fatal("Sanitize failed", zap.Error(err), zap.Int("changed", changed))
+		}
+
+		fmt.Printf("Sanitized %d of %d articles\n", changed, seen)
+	},
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"crusty-buffer/internal/client"
+	"crusty-buffer/internal/store"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var searchLimit int
+
+var searchCmd = &cobra.Command{
+	Use:   "search \"query\"",
+	Short: "Full-text search over archived articles (word* matches prefixes)",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		query := strings.Join(args, " ")
+		opts := store.SearchOptions{Limit: searchLimit}
+		ctx := context.Background()
+
+		var results []store.SearchResult
+		var err error
+		if st, ok := openLocalStore(); ok {
+			defer st.Close()
+			results, _, err = st.Search(ctx, query, opts)
+		} else {
+			results, _, err = client.New(serverURL).Search(ctx, query, opts)
+		}
+		if err != nil {
+			logger.Fatal("Search failed", zap.Error(err))
+		}
+
+		if len(results) == 0 {
+			fmt.Println("No matches.")
+			return
+		}
+
+		color := isTerminal(os.Stdout)
+		for _, r := range results {
+			title := r.Article.Title
+			if title == "" {
+				title = r.Article.URL
+			}
+			fmt.Printf("%s  %s\n", r.Article.ID, title)
+			fmt.Printf("    %s\n", r.Article.URL)
+			if r.Snippet != "" {
+				fmt.Printf("    %s\n", markSnippet(r.Snippet, r.Highlights, color))
+			}
+			fmt.Println()
+		}
+	},
+}
+
+var reindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Rebuild the search index for every stored article",
+	Long: `Rebuild the search index for every stored article.
+
+Articles are indexed when they are saved, so this is only needed once for
+articles archived before search existed. Stop the server first.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		st, err := openStore()
+		if err != nil {
+			logger.Fatal("Failed to open store (is crusty server running? stop it first)", zap.Error(err))
+		}
+		defer st.Close()
+
+		ctx := context.Background()
+		n := 0
+		err = st.Walk(ctx, func(id uuid.UUID) error {
+			article, err := st.Get(ctx, id)
+			if err != nil {
+				return fmt.Errorf("read %s: %w", id, err)
+			}
+			if article.Content == "" {
+				return nil
+			}
+			// Saving with content is what indexes an article
+			if err := st.Save(ctx, article); err != nil {
+				return fmt.Errorf("save %s: %w", id, err)
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			logger.Fatal("Reindex failed", zap.Error(err), zap.Int("indexed", n))
+		}
+		fmt.Printf("Indexed %d articles\n", n)
+	},
+}
+
+// markSnippet highlights matches with bold/underline on a terminal, or *stars* otherwise
+func markSnippet(snippet string, ranges [][2]int, color bool) string {
+	open, close := "*", "*"
+	if color {
+		open, close = "\x1b[1;4m", "\x1b[0m"
+	}
+
+	var sb strings.Builder
+	pos := 0
+	for _, r := range ranges {
+		if r[0] < pos || r[1] > len(snippet) || r[0] >= r[1] {
+			continue
+		}
+		sb.WriteString(snippet[pos:r[0]])
+		sb.WriteString(open)
+		sb.WriteString(snippet[r[0]:r[1]])
+		sb.WriteString(close)
+		pos = r[1]
+	}
+	sb.WriteString(snippet[pos:])
+	return sb.String()
+}
+
+func isTerminal(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
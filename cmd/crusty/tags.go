@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var addTags []string
+
+var tagCmd = &cobra.Command{
+	Use:   "tag",
+	Short: "Add or remove tags on an article",
+}
+
+var tagAddCmd = &cobra.Command{
+	Use:   "add [id] [tag]...",
+	Short: "Tag an article",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		retag(mustParseID(args[0]), args[1:], nil)
+	},
+}
+
+var tagRmCmd = &cobra.Command{
+	Use:   "rm [id] [tag]...",
+	Short: "Remove tags from an article",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		retag(mustParseID(args[0]), nil, args[1:])
+	},
+}
+
+func retag(id uuid.UUID, add, remove []string) {
+	t, done := openEditor()
+	defer done()
+
+	article, err := t.UpdateTags(context.Background(), id, add, remove)
+	if err != nil {
+		logger.Fatal("Failed to update tags", zap.Error(err))
+	}
+	fmt.Println(strings.Join(article.Tags, " "))
+}
+
+var tagsCmd = &cobra.Command{
+	Use:   "tags",
+	Short: "List tags and how many articles have each",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		t, done := openEditor()
+		defer done()
+
+		tags, err := t.Tags(context.Background())
+		if err != nil {
+			logger.Fatal("Failed to list tags", zap.Error(err))
+		}
+		if len(tags) == 0 {
+			fmt.Println("No tags.")
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, tag := range tags {
+			fmt.Fprintf(tw, "%s\t%d\n", tag.Tag, tag.Count)
+		}
+		tw.Flush()
+	},
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"crusty-buffer/internal/client"
+	"crusty-buffer/internal/store"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	rmPurge        bool
+	trashOlderThan time.Duration
+	trashRetention time.Duration
+)
+
+// trasher is what the trash commands need; the store and the API client both have it
+type trasher interface {
+	store.Purger
+	Trash(ctx context.Context, id uuid.UUID) error
+	Restore(ctx context.Context, id uuid.UUID) error
+}
+
+// openTrasher uses the full local store if it's free, else the running server.
+// Purging has to reach the content in Badger, so openClientStore won't do.
+func openTrasher() (trasher, func()) {
+	if st, ok := openLocalStore(); ok {
+		return st, st.Close
+	}
+	return client.New(serverURL), func() {}
+}
+
+var rmCmd = &cobra.Command{
+	Use:   "rm [id]...",
+	Short: "Move articles to the trash (or purge them with --purge)",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		st, done := openTrasher()
+		defer done()
+
+		ctx := context.Background()
+		for _, arg := range args {
+			id := mustParseID(arg)
+			action, err := "Trashed", error(nil)
+			if rmPurge {
+				action, err = "Purged", st.Delete(ctx, id)
+			} else {
+				err = st.Trash(ctx, id)
+			}
+			if err != nil {
+				logger.Fatal("Failed to delete article", zap.String("id", arg), zap.Error(err))
+			}
+			logger.Info(action, zap.String("id", arg))
+		}
+	},
+}
+
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "List articles in the trash",
+	Run: func(cmd *cobra.Command, args []string) {
+		st, done := openTrasher()
+		defer done()
+
+		opts := store.ListOptions{Trashed: true, Limit: 200}
+		for {
+			page, next, err := st.List(context.Background(), opts)
+			if err != nil {
+				logger.Fatal("Failed to list trash", zap.Error(err))
+			}
+			for _, a := range page {
+				title := a.Title
+				if title == "" {
+					title = a.URL
+				}
+				fmt.Printf("%s  %s  %s\n", a.ID, a.DeletedAt.Format("2006-01-02"), title)
+			}
+			if next == "" {
+				return
+			}
+			opts.Cursor = next
+		}
+	},
+}
+
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore [id]...",
+	Short: "Take articles back out of the trash",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		st, done := openTrasher()
+		defer done()
+
+		for _, arg := range args {
+			if err := st.Restore(context.Background(), mustParseID(arg)); err != nil {
+				logger.Fatal("Failed to restore article", zap.String("id", arg), zap.Error(err))
+			}
+			logger.Info("Restored", zap.String("id", arg))
+		}
+	},
+}
+
+var trashEmptyCmd = &cobra.Command{
+	Use:   "empty",
+	Short: "Purge everything in the trash",
+	Run: func(cmd *cobra.Command, args []string) {
+		st, done := openTrasher()
+		defer done()
+
+		n, err := store.EmptyTrash(context.Background(), st, time.Now().Add(-trashOlderThan))
+		if err != nil {
+			logger.Fatal("Failed to empty trash", zap.Int("purged", n), zap.Error(err))
+		}
+		logger.Info("Emptied trash", zap.Int("purged", n))
+	},
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"crusty-buffer/internal/client"
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	watchEvery time.Duration
+	watchTags  []string
+)
+
+// watcher edits the watch list. client.Client has it, and localWatcher gives it to a store.
+type watcher interface {
+	Watches(ctx context.Context) ([]model.Watch, error)
+	AddWatch(ctx context.Context, url string, interval time.Duration, tags []string) (*model.Watch, error)
+	DeleteWatch(ctx context.Context, rawURL string) error
+}
+
+type localWatcher struct{ store.Store }
+
+func (l localWatcher) AddWatch(ctx context.Context, url string, interval time.Duration, tags []string) (*model.Watch, error) {
+	w, err := store.AddWatch(ctx, l.Store, url, interval, tags)
+	return &w, err
+}
+
+// openWatcher, like openEditor, works next to the server in hybrid mode
+func openWatcher() (watcher, func()) {
+	st, err := openClientStore()
+	if err != nil {
+		logger.Debug("Store is busy, falling back to the server API", zap.Error(err))
+		return client.New(serverURL), func() {}
+	}
+	return localWatcher{st}, st.Close
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Snapshot pages again and again (crusty server does the snapshotting)",
+}
+
+var watchAddCmd = &cobra.Command{
+	Use:   "add [url]",
+	Short: "Watch a page, or change how often it's snapshotted",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		wt, done := openWatcher()
+		defer done()
+
+		w, err := wt.AddWatch(context.Background(), args[0], watchEvery, watchTags)
+		if err != nil {
+			logger.Fatal("Failed to add watch", zap.Error(err))
+		}
+		fmt.Printf("Watching %s every %s\n", w.URL, w.Interval)
+	},
+}
+
+var watchLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List watched pages",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		wt, done := openWatcher()
+		defer done()
+
+		watches, err := wt.Watches(context.Background())
+		if err != nil {
+			logger.Fatal("Failed to list watches", zap.Error(err))
+		}
+		if len(watches) == 0 {
+			fmt.Println("Not watching anything.")
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "URL\tEVERY\tLAST CHECK\tTAGS")
+		for _, w := range watches {
+			last := "never"
+			if w.LastCheckAt != nil {
+				last = w.LastCheckAt.Format("Jan 02 15:04")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", w.URL, w.Interval, last, strings.Join(w.Tags, " "))
+		}
+		tw.Flush()
+	},
+}
+
+var watchRmCmd = &cobra.Command{
+	Use:   "rm [url]",
+	Short: "Stop watching a page (its snapshots stay)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		wt, done := openWatcher()
+		defer done()
+
+		if err := wt.DeleteWatch(context.Background(), args[0]); err != nil {
+			logger.Fatal("Failed to remove watch", zap.Error(err))
+		}
+	},
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [id] [id]",
+	Short: "Show how the text changed between two snapshots",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		from, to := mustParseID(args[0]), mustParseID(args[1])
+		ctx := context.Background()
+
+		var d string
+		var err error
+		if st, ok := openLocalStore(); ok {
+			defer st.Close()
+			d, err = store.SnapshotDiff(ctx, st, from, to)
+		} else {
+			d, err = client.New(serverURL).Diff(ctx, from, to)
+		}
+		if err != nil {
+			logger.Fatal("Failed to diff", zap.Error(err))
+		}
+		if d == "" {
+			fmt.Println("The text didn't change.")
+			return
+		}
+		fmt.Print(d)
+	},
+}
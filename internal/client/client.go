@@ -0,0 +1,455 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/google/uuid"
+)
+
+// Client talks to a running crusty server over its /api/v1 JSON API.
+// The CLI uses it when it can't open the store itself, e.g. in badger mode
+// where the server holds the directory lock.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// New returns a client for the server at baseURL (e.g. "http://localhost:8080")
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Error is a non-2xx answer from the API
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("api error %d (%s): %s", e.Status, e.Code, e.Message)
+}
+
+// Add queues url for archiving and returns the pending article. With a
+// dedupe mode that finds an existing snapshot, that one comes back instead
+// and created is false.
+func (c *Client) Add(ctx context.Context, url string, dedupe store.DedupeMode, tags ...string) (article *model.Article, created bool, err error) {
+	article = &model.Article{}
+	req := map[string]interface{}{"url": url, "tags": tags, "dedupe": dedupe}
+	status, err := c.send(ctx, http.MethodPost, "/api/v1/articles", req, article)
+	if err != nil {
+		return nil, false, err
+	}
+	return article, status == http.StatusAccepted, nil
+}
+
+// Get fetches one article, with its readable content if withContent is set
+func (c *Client) Get(ctx context.Context, id uuid.UUID, withContent bool) (*model.Article, error) {
+	var article model.Article
+	path := "/api/v1/articles/" + id.String()
+	if withContent {
+		path += "?content=true"
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &article); err != nil {
+		return nil, err
+	}
+	return &article, nil
+}
+
+// List fetches one page of articles, like store.Store.List
+func (c *Client) List(ctx context.Context, opts store.ListOptions) ([]model.Article, string, error) {
+	q := url.Values{}
+	if opts.Cursor != "" {
+		q.Set("cursor", opts.Cursor)
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	for _, status := range opts.Status {
+		q.Add("status", string(status))
+	}
+	if opts.Domain != "" {
+		q.Set("domain", opts.Domain)
+	}
+	if opts.Tag != "" {
+		q.Set("tag", opts.Tag)
+	}
+	if opts.URL != "" {
+		q.Set("url", opts.URL)
+	}
+	if !opts.Since.IsZero() {
+		q.Set("since", opts.Since.Format(time.RFC3339Nano))
+	}
+	if !opts.Until.IsZero() {
+		q.Set("until", opts.Until.Format(time.RFC3339Nano))
+	}
+	if opts.Order != "" {
+		q.Set("order", string(opts.Order))
+	}
+	if opts.Trashed {
+		q.Set("trashed", "true")
+	}
+	if opts.Unread {
+		q.Set("unread", "true")
+	}
+	if opts.Favorite {
+		q.Set("favorite", "true")
+	}
+	if opts.Folder != "" {
+		q.Set("folder", string(opts.Folder))
+	}
+
+	var resp struct {
+		Articles   []model.Article `json:"articles"`
+		NextCursor string          `json:"next_cursor"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v1/articles?"+q.Encode(), nil, &resp); err != nil {
+		return nil, "", err
+	}
+	return resp.Articles, resp.NextCursor, nil
+}
+
+// Search runs a full-text query, like store.Store.Search
+func (c *Client) Search(ctx context.Context, query string, opts store.SearchOptions) ([]store.SearchResult, string, error) {
+	q := url.Values{"q": {query}}
+	if opts.Cursor != "" {
+		q.Set("cursor", opts.Cursor)
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	for _, status := range opts.Status {
+		q.Add("status", string(status))
+	}
+
+	var resp struct {
+		Results    []store.SearchResult `json:"results"`
+		NextCursor string               `json:"next_cursor"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v1/search?"+q.Encode(), nil, &resp); err != nil {
+		return nil, "", err
+	}
+	return resp.Results, resp.NextCursor, nil
+}
+
+// Raw fetches the page as it was originally downloaded
+func (c *Client) Raw(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/view/"+id.String()+"/raw", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("is the server running? %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{Status: resp.StatusCode, Code: "raw_unavailable", Message: resp.Status}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// GetAsset downloads a stored image, like store.Store.GetAsset
+func (c *Client) GetAsset(ctx context.Context, hash string) (*store.Asset, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/assets/"+hash, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("is the server running? %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, store.ErrNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, &Error{Status: resp.StatusCode, Code: "asset_unavailable", Message: resp.Status}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &store.Asset{Hash: hash, ContentType: resp.Header.Get("Content-Type"), Data: data}, nil
+}
+
+// Reprocess asks the server to re-run extraction on the stored raw HTML
+func (c *Client) Reprocess(ctx context.Context, id uuid.UUID) (*model.Article, error) {
+	var article model.Article
+	if err := c.do(ctx, http.MethodPost, "/api/v1/articles/"+id.String()+"/reprocess", nil, &article); err != nil {
+		return nil, err
+	}
+	return &article, nil
+}
+
+// UpdateFlags changes the reader's flags, like store.UpdateFlags
+func (c *Client) UpdateFlags(ctx context.Context, id uuid.UUID, u store.FlagUpdate) (*model.Article, error) {
+	var article model.Article
+	if err := c.do(ctx, http.MethodPatch, "/api/v1/articles/"+id.String(), u, &article); err != nil {
+		return nil, err
+	}
+	return &article, nil
+}
+
+// UpdateTags adds and removes tags on an article, like store.UpdateTags
+func (c *Client) UpdateTags(ctx context.Context, id uuid.UUID, add, remove []string) (*model.Article, error) {
+	var article model.Article
+	req := map[string][]string{"add": add, "remove": remove}
+	if err := c.do(ctx, http.MethodPost, "/api/v1/articles/"+id.String()+"/tags", req, &article); err != nil {
+		return nil, err
+	}
+	return &article, nil
+}
+
+// Tags lists every tag with its article count
+func (c *Client) Tags(ctx context.Context) ([]store.TagCount, error) {
+	var resp struct {
+		Tags []store.TagCount `json:"tags"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v1/tags", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Tags, nil
+}
+
+// Watches lists the watch list
+func (c *Client) Watches(ctx context.Context) ([]model.Watch, error) {
+	var resp struct {
+		Watches []model.Watch `json:"watches"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v1/watches", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Watches, nil
+}
+
+// AddWatch puts url on the watch list, like store.AddWatch
+func (c *Client) AddWatch(ctx context.Context, url string, interval time.Duration, tags []string) (*model.Watch, error) {
+	req := map[string]interface{}{"url": url, "interval": interval.String(), "tags": tags}
+	var w model.Watch
+	if err := c.do(ctx, http.MethodPost, "/api/v1/watches", req, &w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// DeleteWatch takes rawURL off the watch list
+func (c *Client) DeleteWatch(ctx context.Context, rawURL string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/watches?url="+url.QueryEscape(rawURL), nil, nil)
+}
+
+// Feeds lists the feed subscriptions
+func (c *Client) Feeds(ctx context.Context) ([]model.Feed, error) {
+	var resp struct {
+		Feeds []model.Feed `json:"feeds"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v1/feeds", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Feeds, nil
+}
+
+// AddFeed subscribes to a feed, like store.AddFeed
+func (c *Client) AddFeed(ctx context.Context, url, name string, interval time.Duration, backfill int) (*model.Feed, error) {
+	req := map[string]interface{}{"url": url, "name": name, "backfill": backfill}
+	if interval > 0 {
+		req["interval"] = interval.String()
+	}
+	var f model.Feed
+	if err := c.do(ctx, http.MethodPost, "/api/v1/feeds", req, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// DeleteFeed unsubscribes from the feed at rawURL
+func (c *Client) DeleteFeed(ctx context.Context, rawURL string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/feeds?url="+url.QueryEscape(rawURL), nil, nil)
+}
+
+// Diff fetches the unified diff between two snapshots, like store.SnapshotDiff
+func (c *Client) Diff(ctx context.Context, from, to uuid.UUID) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/v1/diff/"+from.String()+"/"+to.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("is the server running? %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return "", &Error{Status: resp.StatusCode, Code: apiErr.Error.Code, Message: apiErr.Error.Message}
+	}
+	d, err := io.ReadAll(resp.Body)
+	return string(d), err
+}
+
+// Trash moves an article to the trash
+func (c *Client) Trash(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/articles/"+id.String(), nil, nil)
+}
+
+// Restore takes an article back out of the trash
+func (c *Client) Restore(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/articles/"+id.String()+"/restore", nil, nil)
+}
+
+// Delete purges an article for good, like store.Store.Delete
+func (c *Client) Delete(ctx context.Context, id uuid.UUID) error {
+	err := c.do(ctx, http.MethodDelete, "/api/v1/articles/"+id.String()+"?purge=true", nil, nil)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+// ListDeadLetters lists the jobs that ran out of attempts, like store.Store.ListDeadLetters
+func (c *Client) ListDeadLetters(ctx context.Context) ([]uuid.UUID, error) {
+	var resp struct {
+		IDs []uuid.UUID `json:"ids"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v1/queue/dead", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.IDs, nil
+}
+
+// RequeueDeadLetter gives a dead-lettered job a fresh set of attempts
+func (c *Client) RequeueDeadLetter(ctx context.Context, id uuid.UUID) error {
+	err := c.do(ctx, http.MethodPost, "/api/v1/queue/dead/"+id.String()+"/requeue", nil, nil)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+// Backup downloads a backup archive of everything on the server into w
+func (c *Client) Backup(ctx context.Context, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/v1/backup", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.untimed().Do(req)
+	if err != nil {
+		return fmt.Errorf("is the server running? %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// LoadBackup has the server restore a backup, like store.Store.LoadBackup,
+// and returns the articles that didn't read back afterwards
+func (c *Client) LoadBackup(ctx context.Context, r io.Reader, merge bool) (*store.BackupInfo, []string, error) {
+	path := "/api/v1/restore"
+	if merge {
+		path += "?merge=true"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/zstd")
+	resp, err := c.untimed().Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("is the server running? %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, readError(resp)
+	}
+	var out struct {
+		store.BackupInfo
+		Problems []string `json:"problems"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, nil, err
+	}
+	return &out.BackupInfo, out.Problems, nil
+}
+
+// untimed is for whole-library transfers, which take as long as they take
+func (c *Client) untimed() *http.Client {
+	return &http.Client{Transport: c.http.Transport}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	_, err := c.send(ctx, method, path, in, out)
+	return err
+}
+
+// send is do for callers that care which 2xx it was
+func (c *Client) send(ctx context.Context, method, path string, in, out interface{}) (int, error) {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, &body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("is the server running? %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, readError(resp)
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// readError turns an API error response into an *Error
+func readError(resp *http.Response) error {
+	var apiErr struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&apiErr)
+	return &Error{Status: resp.StatusCode, Code: apiErr.Error.Code, Message: apiErr.Error.Message}
+}
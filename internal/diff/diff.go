@@ -0,0 +1,179 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op is what an Edit does to a line
+type Op byte
+
+const (
+	Equal  Op = ' '
+	Delete Op = '-'
+	Insert Op = '+'
+)
+
+// Edit is one line of a diff
+type Edit struct {
+	Op   Op
+	Line string
+}
+
+// maxCells bounds the LCS table; past it the middle is just replaced wholesale
+const maxCells = 4 << 20
+
+// Compute returns the edits that turn a into b, keeping as many lines as possible
+func Compute(a, b []string) []Edit {
+	// Pages mostly change in one place, so trim what's shared at both ends first
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	var edits []Edit
+	for _, line := range a[:pre] {
+		edits = append(edits, Edit{Equal, line})
+	}
+	edits = append(edits, middle(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, line := range a[len(a)-suf:] {
+		edits = append(edits, Edit{Equal, line})
+	}
+	return edits
+}
+
+// middle is the LCS diff of what's left once the common ends are gone
+func middle(a, b []string) []Edit {
+	var edits []Edit
+	if len(a)*len(b) > maxCells {
+		for _, line := range a {
+			edits = append(edits, Edit{Delete, line})
+		}
+		for _, line := range b {
+			edits = append(edits, Edit{Insert, line})
+		}
+		return edits
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, Edit{Equal, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, Edit{Delete, a[i]})
+			i++
+		default:
+			edits = append(edits, Edit{Insert, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, Edit{Delete, a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, Edit{Insert, b[j]})
+	}
+	return edits
+}
+
+// Changed says whether any edit isn't Equal
+func Changed(edits []Edit) bool {
+	for _, e := range edits {
+		if e.Op != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// Unified renders edits as a unified diff with context lines around each change.
+// It's "" when nothing changed.
+func Unified(edits []Edit, nameA, nameB string, context int) string {
+	if !Changed(edits) {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+
+	// Line numbers before each edit, 1-based like diff(1)
+	lineA, lineB := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	lineA[0], lineB[0] = 1, 1
+	for k, e := range edits {
+		lineA[k+1], lineB[k+1] = lineA[k], lineB[k]
+		if e.Op != Insert {
+			lineA[k+1]++
+		}
+		if e.Op != Delete {
+			lineB[k+1]++
+		}
+	}
+
+	for k := 0; k < len(edits); {
+		if edits[k].Op == Equal {
+			k++
+			continue
+		}
+		// A hunk runs from context lines before this change to context lines
+		// after the last change that is at most 2*context equal lines away
+		start := max(k-context, 0)
+		end := k
+		for end < len(edits) {
+			if edits[end].Op != Equal {
+				end++
+				continue
+			}
+			run := end
+			for run < len(edits) && edits[run].Op == Equal {
+				run++
+			}
+			if run == len(edits) || run-end > 2*context {
+				end = min(end+context, len(edits))
+				break
+			}
+			end = run
+		}
+
+		countA, countB := lineA[end]-lineA[start], lineB[end]-lineB[start]
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(lineA[start], countA), hunkRange(lineB[start], countB))
+		for _, e := range edits[start:end] {
+			sb.WriteByte(byte(e.Op))
+			sb.WriteString(e.Line)
+			sb.WriteByte('\n')
+		}
+		k = end
+	}
+	return sb.String()
+}
+
+// hunkRange is diff(1)'s "start,count", where an empty range starts one line early
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
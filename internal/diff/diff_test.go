@@ -0,0 +1,42 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLines(t *testing.T) {
+	lines := Lines(`<h1>Title</h1><p>First  <em>para</em>graph.</p><script>x()</script><ul><li>one</li><li>two<br>three</li></ul>`)
+	assert.Equal(t, []string{"Title", "First para graph.", "one", "two", "three"}, lines)
+	assert.Equal(t, Hash(lines), Hash(Lines(`<h1 class="big">Title</h1><p>First <em>para</em>graph.</p><ul><li>one</li><li>two<br/>three</li></ul>`)),
+		"Markup alone doesn't change the hash")
+	assert.NotEqual(t, Hash(lines), Hash(lines[1:]))
+}
+
+func TestUnified(t *testing.T) {
+	a := strings.Split("a b c d e f g h i j", " ")
+	b := strings.Split("a b C d e f g h i j k", " ")
+
+	assert.Equal(t, `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+ b
+-c
++C
+ d
+ e
+@@ -9,2 +9,3 @@
+ i
+ j
++k
+`, Unified(Compute(a, b), "old", "new", 2))
+
+	// Close changes share a hunk
+	assert.Equal(t, 1, strings.Count(Unified(Compute(a, b), "old", "new", 4), "@@ -"))
+
+	assert.Empty(t, Unified(Compute(a, a), "old", "new", 3))
+	assert.Equal(t, "--- old\n+++ new\n@@ -0,0 +1 @@\n+x\n", Unified(Compute(nil, []string{"x"}), "old", "new", 3))
+}
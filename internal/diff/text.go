@@ -0,0 +1,73 @@
+// Package diff compares snapshots of a page by their readable text.
+package diff
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// blocks end a line of text
+var blocks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Br: true, atom.Li: true, atom.Tr: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Pre: true, atom.Blockquote: true, atom.Figcaption: true, atom.Section: true,
+	atom.Article: true, atom.Header: true, atom.Footer: true, atom.Dt: true, atom.Dd: true,
+	atom.Table: true, atom.Ul: true, atom.Ol: true, atom.Hr: true,
+}
+
+// Lines is the visible text of an HTML fragment, one line per block
+// (paragraph, heading, list item...), whitespace collapsed, empty lines dropped.
+// Markup that doesn't change the text doesn't change the lines.
+func Lines(fragment string) []string {
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	var lines []string
+	var sb strings.Builder
+	flush := func() {
+		if line := strings.Join(strings.Fields(sb.String()), " "); line != "" {
+			lines = append(lines, line)
+		}
+		sb.Reset()
+	}
+
+	skip := 0
+	for {
+		switch tt := z.Next(); tt {
+		case html.ErrorToken:
+			flush()
+			return lines
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			if a == atom.Script || a == atom.Style {
+				if tt == html.EndTagToken {
+					skip = max(skip-1, 0)
+				} else {
+					skip++
+				}
+			}
+			if blocks[a] {
+				flush()
+			} else {
+				sb.WriteByte(' ')
+			}
+		case html.TextToken:
+			if skip == 0 {
+				sb.Write(z.Text())
+			}
+		}
+	}
+}
+
+// Hash fingerprints lines, so an unchanged page can be spotted without the old copy
+func Hash(lines []string) string {
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
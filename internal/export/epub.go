@@ -0,0 +1,414 @@
+// Package export turns archived articles into files for reading elsewhere.
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ErrNothingToExport is returned for a bundle without a single archived article
+var ErrNothingToExport = errors.New("no archived articles to export")
+
+// Assets is where the images of an article come from. store.Store and
+// client.Client both have it.
+type Assets interface {
+	GetAsset(ctx context.Context, hash string) (*store.Asset, error)
+}
+
+// assetPrefix is how localized images are referenced in Article.Content
+const assetPrefix = "/assets/"
+
+// Image types EPUB 3 readers must support; anything else is left out
+var imageExts = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/svg+xml": ".svg",
+	"image/webp":    ".webp",
+}
+
+// Book is what an EPUB is made of, ready to be written out
+type book struct {
+	ID       string
+	Title    string
+	Creator  string
+	Language string
+	Date     time.Time
+	Modified time.Time
+	Chapters []chapter
+	Images   []image
+	TOCPage  bool // Put the table of contents in the reading order (bundles)
+}
+
+type chapter struct {
+	File    string // Relative to OEBPS/
+	Article *model.Article
+	Date    time.Time
+	Body    template.HTML // Well-formed XHTML
+}
+
+type image struct {
+	File      string
+	MediaType string
+	Data      []byte
+}
+
+// EPUB writes articles as one EPUB 3, in the order given. A single article
+// gets its own title and byline; a bundle is called title and has a table
+// of contents page. Images stored with the articles are included; remote ones
+// can't be in an EPUB, so they're dropped.
+func EPUB(ctx context.Context, w io.Writer, assets Assets, title string, articles []*model.Article) error {
+	if len(articles) == 0 {
+		return ErrNothingToExport
+	}
+
+	b := book{
+		Title:    title,
+		Creator:  "crusty-buffer",
+		Language: articles[0].Language,
+		Modified: time.Now().UTC(),
+		TOCPage:  len(articles) > 1,
+	}
+	ids := make([]string, len(articles))
+	for i, a := range articles {
+		ids[i] = a.ID.String()
+	}
+	if len(articles) == 1 {
+		a := articles[0]
+		b.ID = "urn:uuid:" + a.ID.String()
+		b.Title = a.Title
+		if a.Byline != "" {
+			b.Creator = a.Byline
+		}
+	} else {
+		// The same articles make the same book, so readers see an update, not a copy
+		sort.Strings(ids)
+		b.ID = "urn:uuid:" + uuid.NewSHA1(uuid.NameSpaceURL, []byte(strings.Join(ids, ","))).String()
+	}
+	if b.Title == "" {
+		b.Title = articles[0].URL
+	}
+	if b.Language == "" {
+		b.Language = "en"
+	}
+
+	images := make(map[string]string) // asset hash -> file
+	for i, a := range articles {
+		date := published(a)
+		if date.After(b.Date) {
+			b.Date = date
+		}
+		body, err := xhtmlBody(a.Content, func(src string) string {
+			return b.image(ctx, assets, images, src)
+		})
+		if err != nil {
+			return fmt.Errorf("article %s: %w", a.ID, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		b.Chapters = append(b.Chapters, chapter{
+			File:    fmt.Sprintf("text/ch%03d.xhtml", i+1),
+			Article: a,
+			Date:    date,
+			Body:    template.HTML(body),
+		})
+	}
+	return b.write(w)
+}
+
+// published is the best date we have for when an article was written
+func published(a *model.Article) time.Time {
+	switch {
+	case a.PublishedTime != nil:
+		return *a.PublishedTime
+	case a.ArchivedAt != nil:
+		return *a.ArchivedAt
+	}
+	return a.CreatedAt
+}
+
+// image adds a stored image to the book once and returns its path relative
+// to a chapter, or "" if it can't be included
+func (b *book) image(ctx context.Context, assets Assets, seen map[string]string, src string) string {
+	if !strings.HasPrefix(src, assetPrefix) {
+		return ""
+	}
+	hash := strings.TrimPrefix(src, assetPrefix)
+	if file, ok := seen[hash]; ok {
+		return file
+	}
+	seen[hash] = ""
+	asset, err := assets.GetAsset(ctx, hash)
+	if err != nil {
+		return ""
+	}
+	mediaType, _, _ := strings.Cut(asset.ContentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	ext, ok := imageExts[mediaType]
+	if !ok {
+		return ""
+	}
+	file := fmt.Sprintf("images/%03d%s", len(b.Images)+1, ext)
+	b.Images = append(b.Images, image{File: file, MediaType: mediaType, Data: asset.Data})
+	seen[hash] = "../" + file
+	return seen[hash]
+}
+
+// xhtmlBody re-serializes an HTML fragment as XHTML. Every <img> src goes
+// through image; those it has no local copy for are removed.
+func xhtmlBody(fragment string, image func(src string) string) (string, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), body)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+	fixImages(body, image)
+
+	var buf bytes.Buffer
+	for n := body.FirstChild; n != nil; n = n.NextSibling {
+		// html.Render closes void elements with "/>", which is all XHTML needs
+		if err := html.Render(&buf, n); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+func fixImages(n *html.Node, image func(src string) string) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode && (c.DataAtom == atom.Source || c.DataAtom == atom.Img) {
+			src := ""
+			if c.DataAtom == atom.Img {
+				src = image(attr(c, "src"))
+			}
+			if src == "" {
+				n.RemoveChild(c)
+				c = next
+				continue
+			}
+			var attrs []html.Attribute
+			for _, a := range c.Attr {
+				switch a.Key {
+				case "src":
+					a.Val = src
+				case "srcset", "sizes", "loading", "decoding":
+					continue
+				}
+				attrs = append(attrs, a)
+			}
+			if !hasAttr(c, "alt") {
+				attrs = append(attrs, html.Attribute{Key: "alt", Val: ""}) // Required in XHTML
+			}
+			c.Attr = attrs
+		}
+		fixImages(c, image)
+		c = next
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+var epubTemplates = template.Must(template.New("epub").Funcs(template.FuncMap{
+	"date":         func(t time.Time) string { return t.UTC().Format("2006-01-02T15:04:05Z") },
+	"human":        func(t time.Time) string { return t.Format("Jan 02, 2006") },
+	"inc":          func(i int) int { return i + 1 },
+	"chapterTitle": chapterTitle,
+}).Parse(epubFiles))
+
+// write zips the book up. The mimetype has to come first, uncompressed.
+func (b *book) write(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	mt, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mt, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	type part struct {
+		name, tmpl string
+		data       interface{}
+	}
+	parts := []part{
+		{"META-INF/container.xml", "container", b},
+		{"OEBPS/content.opf", "opf", b},
+		{"OEBPS/nav.xhtml", "nav", b},
+		{"OEBPS/toc.ncx", "ncx", b},
+		{"OEBPS/style.css", "css", b},
+	}
+	for _, ch := range b.Chapters {
+		parts = append(parts, part{"OEBPS/" + ch.File, "chapter", map[string]interface{}{"Book": b, "Chapter": ch}})
+	}
+	for _, p := range parts {
+		fw, err := zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+		// html/template would escape the declaration, so it goes in by hand
+		if p.tmpl != "css" {
+			if _, err := io.WriteString(fw, xml.Header); err != nil {
+				return err
+			}
+		}
+		if err := epubTemplates.ExecuteTemplate(fw, p.tmpl, p.data); err != nil {
+			return err
+		}
+	}
+	for _, img := range b.Images {
+		// Already compressed; deflating them again only costs time
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: "OEBPS/" + img.File, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(img.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// chapterTitle is what the table of contents calls an article
+func chapterTitle(a *model.Article) string {
+	if a.Title != "" {
+		return a.Title
+	}
+	return a.URL
+}
+
+const epubFiles = `
+{{define "container"}}<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+{{end}}
+
+{{define "opf"}}<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid" xml:lang="{{.Language}}">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="uid">{{.ID}}</dc:identifier>
+    <dc:title>{{.Title}}</dc:title>
+    <dc:creator>{{.Creator}}</dc:creator>
+    <dc:language>{{.Language}}</dc:language>
+    <dc:date>{{date .Date}}</dc:date>
+    <dc:publisher>crusty-buffer</dc:publisher>
+    <meta property="dcterms:modified">{{date .Modified}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="css" href="style.css" media-type="text/css"/>
+    {{- range $i, $ch := .Chapters}}
+    <item id="ch{{inc $i}}" href="{{$ch.File}}" media-type="application/xhtml+xml"/>
+    {{- end}}
+    {{- range $i, $img := .Images}}
+    <item id="img{{inc $i}}" href="{{$img.File}}" media-type="{{$img.MediaType}}"/>
+    {{- end}}
+  </manifest>
+  <spine toc="ncx">
+    {{- if .TOCPage}}
+    <itemref idref="nav"/>
+    {{- end}}
+    {{- range $i, $ch := .Chapters}}
+    <itemref idref="ch{{inc $i}}"/>
+    {{- end}}
+  </spine>
+</package>
+{{end}}
+
+{{define "nav"}}<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{.Language}}" lang="{{.Language}}">
+<head>
+  <title>{{.Title}}</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>{{.Title}}</h1>
+    <ol>
+      {{- range .Chapters}}
+      <li><a href="{{.File}}">{{chapterTitle .Article}}</a>{{with .Article.Byline}} <span class="byline">{{.}}</span>{{end}}</li>
+      {{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+{{end}}
+
+{{define "ncx"}}<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="{{.ID}}"/>
+  </head>
+  <docTitle><text>{{.Title}}</text></docTitle>
+  <navMap>
+    {{- range $i, $ch := .Chapters}}
+    <navPoint id="np{{inc $i}}" playOrder="{{inc $i}}">
+      <navLabel><text>{{chapterTitle $ch.Article}}</text></navLabel>
+      <content src="{{$ch.File}}"/>
+    </navPoint>
+    {{- end}}
+  </navMap>
+</ncx>
+{{end}}
+
+{{define "css"}}body { line-height: 1.5; }
+h1 { font-size: 1.6em; line-height: 1.2; }
+.meta { font-size: .85em; color: #555; }
+img { max-width: 100%; height: auto; }
+pre { white-space: pre-wrap; }
+{{end}}
+
+{{define "chapter"}}<!DOCTYPE html>
+{{- $a := .Chapter.Article}}
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{or $a.Language .Book.Language}}" lang="{{or $a.Language .Book.Language}}">
+<head>
+  <title>{{chapterTitle $a}}</title>
+  <link rel="stylesheet" type="text/css" href="../style.css"/>
+</head>
+<body>
+  <section epub:type="chapter">
+    <h1>{{chapterTitle $a}}</h1>
+    <p class="meta">{{with $a.Byline}}{{.}} &#183; {{end}}{{with $a.Site}}{{.}} &#183; {{end}}{{human .Chapter.Date}}<br/><a href="{{$a.URL}}">{{$a.URL}}</a></p>
+    {{.Chapter.Body}}
+  </section>
+</body>
+</html>
+{{end}}
+`
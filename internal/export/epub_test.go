@@ -0,0 +1,126 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type assetMap map[string]*store.Asset
+
+func (m assetMap) GetAsset(ctx context.Context, hash string) (*store.Asset, error) {
+	if a, ok := m[hash]; ok {
+		return a, nil
+	}
+	return nil, store.ErrNotFound
+}
+
+func readZip(t *testing.T, data []byte) (*zip.Reader, map[string]string) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		files[f.Name] = string(b)
+	}
+	return zr, files
+}
+
+func TestEPUB_SingleArticle(t *testing.T) {
+	published := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	a := model.NewArticle("https://example.com/post")
+	a.Title = "Pointers & You"
+	a.Byline = "Jane Doe"
+	a.Language = "de"
+	a.PublishedTime = &published
+	a.Content = `<p>Hi<br>there</p><img src="/assets/abc" alt="chart"><img src="https://cdn.example.com/x.png"><picture><source srcset="/a.webp"><img src="/assets/abc" srcset="/assets/abc 2x"></picture>`
+
+	var buf bytes.Buffer
+	assets := assetMap{"abc": {Hash: "abc", ContentType: "image/png", Data: []byte("PNG")}}
+	require.NoError(t, EPUB(context.Background(), &buf, assets, "ignored", []*model.Article{&a}))
+
+	zr, files := readZip(t, buf.Bytes())
+	assert.Equal(t, "mimetype", zr.File[0].Name, "The mimetype goes first")
+	assert.Equal(t, zip.Store, zr.File[0].Method)
+	assert.Equal(t, "application/epub+zip", files["mimetype"])
+	assert.Contains(t, files["META-INF/container.xml"], `full-path="OEBPS/content.opf"`)
+
+	opf := files["OEBPS/content.opf"]
+	assert.Contains(t, opf, "<dc:identifier id=\"uid\">urn:uuid:"+a.ID.String())
+	assert.Contains(t, opf, "<dc:title>Pointers &amp; You</dc:title>")
+	assert.Contains(t, opf, "<dc:creator>Jane Doe</dc:creator>")
+	assert.Contains(t, opf, "<dc:language>de</dc:language>")
+	assert.Contains(t, opf, "<dc:date>2024-05-01T09:00:00Z</dc:date>")
+	assert.Contains(t, opf, `href="images/001.png" media-type="image/png"`)
+	assert.NotContains(t, opf, `<itemref idref="nav"/>`, "No contents page for one article")
+	assert.Equal(t, "PNG", files["OEBPS/images/001.png"])
+	assert.Len(t, zr.File, 8, "The image is stored once")
+
+	ch := files["OEBPS/text/ch001.xhtml"]
+	assert.Contains(t, ch, "<br/>")
+	assert.Equal(t, 2, strings.Count(ch, `src="../images/001.png"`))
+	assert.NotContains(t, ch, "cdn.example.com", "Remote images can't be in the book")
+	assert.NotContains(t, ch, "srcset")
+	assert.NotContains(t, ch, "<source")
+	assert.Contains(t, ch, "Jane Doe")
+
+	// Every XML file has to be well-formed
+	for name, content := range files {
+		if strings.HasSuffix(name, ".xhtml") || strings.HasSuffix(name, ".opf") || strings.HasSuffix(name, ".ncx") || strings.HasSuffix(name, ".xml") {
+			require.True(t, strings.HasPrefix(content, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n"), name)
+			d := xml.NewDecoder(strings.NewReader(content))
+			d.Strict = true
+			for {
+				_, err := d.Token()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err, name)
+			}
+		}
+	}
+}
+
+func TestEPUB_Bundle(t *testing.T) {
+	a, b := model.NewArticle("https://example.com/a"), model.NewArticle("https://example.com/b")
+	a.Title, b.Title = "First", "Second"
+	a.Content, b.Content = "<p>one</p>", "<p>two</p>"
+
+	var one, two bytes.Buffer
+	require.NoError(t, EPUB(context.Background(), &one, assetMap{}, "Reading list", []*model.Article{&a, &b}))
+	require.NoError(t, EPUB(context.Background(), &two, assetMap{}, "Reading list", []*model.Article{&b, &a}))
+
+	_, files := readZip(t, one.Bytes())
+	opf := files["OEBPS/content.opf"]
+	assert.Contains(t, opf, "<dc:title>Reading list</dc:title>")
+	assert.Contains(t, opf, `<itemref idref="nav"/>`)
+	assert.Less(t, strings.Index(opf, `idref="ch1"`), strings.Index(opf, `idref="ch2"`))
+	assert.Contains(t, files["OEBPS/nav.xhtml"], `<a href="text/ch001.xhtml">First</a>`)
+	assert.Contains(t, files["OEBPS/nav.xhtml"], `<a href="text/ch002.xhtml">Second</a>`)
+	assert.Contains(t, files["OEBPS/text/ch002.xhtml"], "<p>two</p>")
+
+	_, again := readZip(t, two.Bytes())
+	id := func(opf string) string {
+		_, rest, _ := strings.Cut(opf, "<dc:identifier")
+		v, _, _ := strings.Cut(rest, "</dc:identifier>")
+		return v
+	}
+	assert.Equal(t, id(opf), id(again["OEBPS/content.opf"]), "Same articles, same book")
+
+	assert.ErrorIs(t, EPUB(context.Background(), io.Discard, assetMap{}, "", nil), ErrNothingToExport)
+}
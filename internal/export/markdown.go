@@ -0,0 +1,563 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/sanitize"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatter is the YAML block on top of a Markdown export. Obsidian shows
+// it as the note's properties.
+type frontMatter struct {
+	Title       string     `yaml:"title"`
+	URL         string     `yaml:"url"`
+	Author      string     `yaml:"author,omitempty"`
+	Site        string     `yaml:"site,omitempty"`
+	Language    string     `yaml:"lang,omitempty"`
+	Published   *time.Time `yaml:"published,omitempty"`
+	Saved       time.Time  `yaml:"saved"`
+	Archived    *time.Time `yaml:"archived,omitempty"`
+	Read        *time.Time `yaml:"read,omitempty"`
+	Favorite    bool       `yaml:"favorite,omitempty"`
+	Tags        []string   `yaml:"tags,omitempty"`
+	Words       int        `yaml:"words,omitempty"`
+	ReadingTime int        `yaml:"reading_time,omitempty"`
+	ID          string     `yaml:"crusty_id"`
+}
+
+// utc drops what YAML can't show anyway, so the same article always gives the same bytes
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC().Truncate(time.Second)
+	return &u
+}
+
+// FrontMatter is the article's metadata as a YAML block, "---" lines included.
+// It doesn't need Content.
+func FrontMatter(a *model.Article) ([]byte, error) {
+	fm := frontMatter{
+		Title:       chapterTitle(a),
+		URL:         a.URL,
+		Author:      a.Byline,
+		Site:        a.Site(),
+		Language:    a.Language,
+		Published:   utc(a.PublishedTime),
+		Saved:       *utc(&a.CreatedAt),
+		Archived:    utc(a.ArchivedAt),
+		Read:        utc(a.ReadAt),
+		Favorite:    a.Favorite,
+		Tags:        a.Tags,
+		Words:       a.WordCount,
+		ReadingTime: a.ReadingTime,
+		ID:          a.ID.String(),
+	}
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(fm); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	buf.WriteString("---\n")
+	return buf.Bytes(), nil
+}
+
+// Markdown renders an article as front matter plus its content in Markdown.
+// Every <img> src goes through image first (nil keeps them as they are);
+// those it returns "" for are left out.
+func Markdown(a *model.Article, image func(src string) string) ([]byte, error) {
+	fm, err := FrontMatter(a)
+	if err != nil {
+		return nil, err
+	}
+	body, err := HTMLToMarkdown(a.Content, image)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(fm)
+	buf.WriteString("\n# " + escapeText(chapterTitle(a), true) + "\n")
+	if body != "" {
+		buf.WriteString("\n" + body + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// Text renders an article as plain text: a short header, then the content
+// with lists and tables laid out, but no markup
+func Text(a *model.Article) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(chapterTitle(a) + "\n")
+	var meta []string
+	if a.Byline != "" {
+		meta = append(meta, a.Byline)
+	}
+	meta = append(meta, a.Site(), published(a).Format("Jan 02, 2006"))
+	sb.WriteString(strings.Join(meta, " · ") + "\n")
+	sb.WriteString(a.URL + "\n")
+
+	body, err := convert(a.Content, mdWriter{plain: true})
+	if err != nil {
+		return "", err
+	}
+	if body != "" {
+		sb.WriteString("\n" + body + "\n")
+	}
+	return sb.String(), nil
+}
+
+// HTMLToMarkdown converts an HTML fragment to CommonMark, with GitHub's
+// tables and strikethrough. Markup Markdown has no syntax for is reduced
+// to its text.
+func HTMLToMarkdown(fragment string, image func(src string) string) (string, error) {
+	return convert(fragment, mdWriter{image: image})
+}
+
+func convert(fragment string, m mdWriter) (string, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), body)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+	return tidy(m.children(body)), nil
+}
+
+type mdWriter struct {
+	image func(src string) string
+	plain bool // Just the text: no markup, no escapes, no images
+}
+
+// mdBlocks are elements that start and end a paragraph of their own
+var mdBlocks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.Header: true, atom.Footer: true, atom.Aside: true, atom.Nav: true, atom.Figure: true,
+	atom.Figcaption: true, atom.Details: true, atom.Summary: true, atom.Dl: true, atom.Dt: true,
+	atom.Dd: true, atom.Address: true, atom.Blockquote: true, atom.Pre: true, atom.Ul: true,
+	atom.Ol: true, atom.Li: true, atom.Table: true, atom.Hr: true, atom.Br: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+}
+
+func isBlock(n *html.Node) bool {
+	return n != nil && n.Type == html.ElementNode && mdBlocks[n.DataAtom]
+}
+
+func (m *mdWriter) children(n *html.Node) string {
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(m.node(c))
+	}
+	return sb.String()
+}
+
+func (m *mdWriter) node(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return m.text(n)
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Source, atom.Head, atom.Button, atom.Form:
+		return ""
+	}
+	if m.plain {
+		return m.plainNode(n)
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		text := oneLine(m.children(n))
+		if text == "" {
+			return ""
+		}
+		return "\n\n" + strings.Repeat("#", int(n.Data[1]-'0')) + " " + text + "\n\n"
+	case atom.Br:
+		return "\\\n"
+	case atom.Hr:
+		return "\n\n---\n\n"
+	case atom.Figcaption:
+		if text := oneLine(m.children(n)); text != "" {
+			return "\n\n_" + text + "_\n\n"
+		}
+		return ""
+	case atom.Strong, atom.B:
+		return wrap(m.children(n), "**")
+	case atom.Em, atom.I, atom.Cite:
+		return wrap(m.children(n), "_")
+	case atom.Del, atom.S, atom.Strike:
+		return wrap(m.children(n), "~~")
+	case atom.Code, atom.Kbd, atom.Samp:
+		return codeSpan(textContent(n))
+	case atom.Pre:
+		return codeBlock(n)
+	case atom.Blockquote:
+		return "\n\n" + prefixLines(tidy(m.children(n)), "> ", ">") + "\n\n"
+	case atom.Ul, atom.Ol:
+		return m.list(n)
+	case atom.Table:
+		return m.table(n)
+	case atom.A:
+		return m.link(n)
+	case atom.Img:
+		return m.img(n)
+	}
+	if mdBlocks[n.DataAtom] {
+		return "\n\n" + m.children(n) + "\n\n"
+	}
+	return m.children(n)
+}
+
+// plainNode is node for plain text
+func (m *mdWriter) plainNode(n *html.Node) string {
+	switch n.DataAtom {
+	case atom.Br:
+		return "\n"
+	case atom.Img:
+		return ""
+	case atom.Pre:
+		return "\n\n" + strings.Trim(textContent(n), "\n") + "\n\n"
+	case atom.Ul, atom.Ol:
+		return m.list(n)
+	case atom.Table:
+		return m.table(n)
+	case atom.Blockquote:
+		return "\n\n" + prefixLines(tidy(m.children(n)), "  ", "") + "\n\n"
+	}
+	if mdBlocks[n.DataAtom] {
+		return "\n\n" + m.children(n) + "\n\n"
+	}
+	return m.children(n)
+}
+
+// text collapses whitespace the way a browser would, and drops it next to blocks
+func (m *mdWriter) text(n *html.Node) string {
+	startsLine, endsLine := blockEdge(n, true), blockEdge(n, false)
+	s := strings.Join(strings.Fields(n.Data), " ")
+	if s == "" {
+		if startsLine || endsLine {
+			return ""
+		}
+		return " "
+	}
+	if isSpace(n.Data[0]) && !startsLine {
+		s = " " + s
+	}
+	if isSpace(n.Data[len(n.Data)-1]) && !endsLine {
+		s += " "
+	}
+	if m.plain {
+		return s
+	}
+	return escapeText(s, startsLine)
+}
+
+// blockEdge says whether n is the first (or last) thing on its line: nothing
+// but block boundaries before (after) it
+func blockEdge(n *html.Node, before bool) bool {
+	for ; n != nil; n = n.Parent {
+		sib := n.NextSibling
+		if before {
+			sib = n.PrevSibling
+		}
+		if sib != nil {
+			return isBlock(sib)
+		}
+		if isBlock(n.Parent) || n.Parent == nil || n.Parent.DataAtom == atom.Body {
+			return true
+		}
+	}
+	return true
+}
+
+func isSpace(b byte) bool { return b == ' ' || b == '\t' || b == '\n' || b == '\r' }
+
+func (m *mdWriter) link(n *html.Node) string {
+	text := strings.TrimSpace(m.children(n))
+	href := strings.TrimSpace(attr(n, "href"))
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return text
+	}
+	if text == "" {
+		text = escapeText(href, false)
+	}
+	return "[" + text + "](" + escapeURL(href) + ")"
+}
+
+func (m *mdWriter) img(n *html.Node) string {
+	src := attr(n, "src")
+	if m.image != nil {
+		src = m.image(src)
+	}
+	if src == "" {
+		return ""
+	}
+	return "![" + escapeText(oneLine(attr(n, "alt")), false) + "](" + escapeURL(src) + ")"
+}
+
+// list renders <ul>/<ol>, indenting whatever is inside an item under its marker
+func (m *mdWriter) list(n *html.Node) string {
+	num := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		num = start
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			continue
+		}
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = strconv.Itoa(num) + ". "
+			num++
+		}
+		indent := strings.Repeat(" ", len(marker))
+		sb.WriteString(marker + prefixLines(tidy(m.children(c)), indent, "")[len(indent):] + "\n")
+	}
+	return "\n\n" + sb.String() + "\n\n"
+}
+
+// table renders a GitHub table; the first row is the header
+func (m *mdWriter) table(n *html.Node) string {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Tr:
+				var row []string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+						row = append(row, strings.ReplaceAll(oneLine(m.children(cell)), "|", `\|`))
+					}
+				}
+				rows = append(rows, row)
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+
+	cols := 0
+	for _, row := range rows {
+		cols = max(cols, len(row))
+	}
+	if cols == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\n")
+	for i, row := range rows {
+		for len(row) < cols {
+			row = append(row, "")
+		}
+		sb.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 && !m.plain {
+			sb.WriteString("|" + strings.Repeat(" --- |", cols) + "\n")
+		}
+	}
+	return sb.String() + "\n"
+}
+
+// codeBlock fences a <pre>, keeping a language-* class as the info string
+func codeBlock(n *html.Node) string {
+	lang := language(n)
+	for c := n.FirstChild; c != nil && lang == ""; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Code {
+			lang = language(c)
+		}
+	}
+	code := strings.TrimRight(strings.TrimPrefix(textContent(n), "\n"), "\n ")
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return "\n\n" + fence + lang + "\n" + code + "\n" + fence + "\n\n"
+}
+
+func language(n *html.Node) string {
+	for _, class := range strings.Fields(attr(n, "class")) {
+		// Sanitized content has its classes prefixed
+		class = strings.TrimPrefix(class, sanitize.DefaultPrefix)
+		for _, prefix := range []string{"language-", "lang-"} {
+			if strings.HasPrefix(class, prefix) {
+				return strings.TrimPrefix(class, prefix)
+			}
+		}
+	}
+	return ""
+}
+
+func codeSpan(code string) string {
+	code = strings.Join(strings.Fields(code), " ")
+	if code == "" {
+		return ""
+	}
+	fence := "`"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		code = " " + code + " "
+	}
+	return fence + code + fence
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if n.Type == html.ElementNode && n.DataAtom == atom.Br {
+		return "\n"
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(textContent(c))
+	}
+	return sb.String()
+}
+
+// wrap puts marks around text, leaving the whitespace at either end outside,
+// where Markdown needs it
+func wrap(s, mark string) string {
+	text := strings.TrimSpace(s)
+	if text == "" {
+		return s
+	}
+	start := strings.Index(s, text)
+	return s[:start] + mark + text + mark + s[start+len(text):]
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(s, "\\\n", " ")), " ")
+}
+
+// prefixLines indents every line of s; blank ones get blank instead
+func prefixLines(s, prefix, blank string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = blank
+		} else {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tidy trims trailing blanks and squeezes runs of empty lines into one,
+// except inside code fences
+func tidy(s string) string {
+	var out []string
+	fence := ""
+	for _, line := range strings.Split(s, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if trimmed == fence {
+				fence = ""
+			}
+			out = append(out, line)
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") {
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, "`"))]
+		}
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			// A hard break at the end of a block has nothing to break
+			if n := len(out); n > 0 && hardBreak(out[n-1]) {
+				out[n-1] = out[n-1][:len(out[n-1])-1]
+			}
+			if len(out) == 0 || out[len(out)-1] == "" {
+				continue
+			}
+		}
+		out = append(out, line)
+	}
+	if n := len(out); n > 0 && hardBreak(out[n-1]) {
+		out[n-1] = out[n-1][:len(out[n-1])-1]
+	}
+	return strings.TrimRight(strings.Join(out, "\n"), "\n")
+}
+
+// hardBreak says whether line ends in a backslash that isn't escaped
+func hardBreak(line string) bool {
+	n := len(line) - len(strings.TrimRight(line, `\`))
+	return n%2 == 1
+}
+
+var mdEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`,
+	" #", ` \#`, // Obsidian would make #words tags
+)
+
+// escapeText backslashes what Markdown would read as markup. At the start of
+// a line a few more characters are special.
+func escapeText(s string, lineStart bool) string {
+	s = mdEscaper.Replace(s)
+	if !lineStart {
+		return s
+	}
+	switch {
+	case strings.HasPrefix(s, "#"), strings.HasPrefix(s, ">"),
+		strings.HasPrefix(s, "- "), strings.HasPrefix(s, "+ "), strings.HasPrefix(s, "="):
+		return `\` + s
+	}
+	if i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }); i > 0 && (strings.HasPrefix(s[i:], ". ") || strings.HasPrefix(s[i:], ") ")) {
+		return s[:i] + `\` + s[i:]
+	}
+	return s
+}
+
+// escapeURL keeps a link destination in one piece
+func escapeURL(u string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E").Replace(u)
+}
+
+// FileName is a stable name for the article's Markdown file: its title, made
+// safe for every file system, and the start of its ID so no two collide
+func FileName(a *model.Article) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range chapterTitle(a) {
+		switch {
+		case strings.ContainsRune(`/\:*?"<>|#^[]`, r), r < ' ':
+			space = true
+		case r == ' ':
+			space = true
+		default:
+			if space && sb.Len() > 0 {
+				sb.WriteByte(' ')
+			}
+			space = false
+			sb.WriteRune(r)
+		}
+		if sb.Len() >= 80 {
+			break
+		}
+	}
+	name := strings.Trim(sb.String(), ". ")
+	return fmt.Sprintf("%s (%s).md", name, a.ID.String()[:8])
+}
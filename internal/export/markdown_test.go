@@ -0,0 +1,164 @@
+package export
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestHTMLToMarkdown(t *testing.T) {
+	cases := []struct{ name, in, want string }{
+		{"paragraphs", "<p>One\n  two</p>\n<p>Three</p>", "One two\n\nThree"},
+		{"inline", `<p>A <b>bold</b> and<em> soft </em>word, <code>x := 1</code> and <a href="https://go.dev/x y">a link</a>.</p>`,
+			"A **bold** and _soft_ word, `x := 1` and [a link](https://go.dev/x%20y)."},
+		{"headings", "<h2>Intro</h2><p>Text</p><h3> More <i>here</i></h3>", "## Intro\n\nText\n\n### More _here_"},
+		{"break", "<p>line<br>next<br></p>", "line\\\nnext"},
+		{"escapes", "<p># not a heading, 1. not a list, *not* [a link] #tag</p>", "\\# not a heading, 1. not a list, \\*not\\* \\[a link\\] \\#tag"},
+		{"lists", "<ul><li>one</li><li>two<ol start=3><li>three</li></ol></li></ul>", "- one\n- two\n\n  3. three"},
+		{"quote", "<blockquote><p>Said</p><p>this</p></blockquote>", "> Said\n>\n> this"},
+		{"code", "<pre><code class=\"language-go\">func main() {\n\n\tfmt.Println(\"*hi*\")\n}\n</code></pre>",
+			"```go\nfunc main() {\n\n\tfmt.Println(\"*hi*\")\n}\n```"},
+		{"sanitized code", "<pre><code class=\"user-content-language-go\">x := 1</code></pre>", "```go\nx := 1\n```"},
+		{"fence in code", "<pre>a ``` b</pre>", "````\na ``` b\n````"},
+		{"table", "<table><thead><tr><th>Lang</th><th>Year</th></tr></thead><tbody><tr><td>Go</td><td>2009</td></tr><tr><td>a|b</td></tr></tbody></table>",
+			"| Lang | Year |\n| --- | --- |\n| Go | 2009 |\n| a\\|b |  |"},
+		{"images", `<figure><img src="/assets/abc" alt="A chart"><figcaption>Figure 1</figcaption></figure><img src="/assets/gone">`,
+			"![A chart](assets/abc.png)\n\n_Figure 1_"},
+		{"dropped", `<script>alert(1)</script><p>Kept</p><style>p{}</style>`, "Kept"},
+	}
+	image := func(src string) string {
+		if src == "/assets/abc" {
+			return "assets/abc.png"
+		}
+		return ""
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := HTMLToMarkdown(c.in, image)
+			require.NoError(t, err)
+			assert.Equal(t, c.want, got)
+		})
+	}
+}
+
+func TestMarkdown_FrontMatter(t *testing.T) {
+	published := time.Date(2024, 5, 1, 9, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	a := model.NewArticle("https://example.com/post")
+	a.Title = `Pointers: "a" guide`
+	a.Byline = "Jane Doe"
+	a.PublishedTime = &published
+	a.Tags = []string{"go", "reading"}
+	a.Content = "<p>Body</p>"
+
+	md, err := Markdown(&a, nil)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(md), "---\n"))
+	front, body, ok := strings.Cut(strings.TrimPrefix(string(md), "---\n"), "---\n")
+	require.True(t, ok)
+	assert.Equal(t, "\n# Pointers: \"a\" guide\n\nBody\n", body)
+
+	var fm frontMatter
+	require.NoError(t, yaml.Unmarshal([]byte(front), &fm))
+	assert.Equal(t, a.Title, fm.Title)
+	assert.Equal(t, "Jane Doe", fm.Author)
+	assert.Equal(t, "example.com", fm.Site)
+	assert.Equal(t, []string{"go", "reading"}, fm.Tags)
+	assert.True(t, published.Equal(*fm.Published))
+	assert.Equal(t, a.ID.String(), fm.ID)
+
+	text, err := Text(&a)
+	require.NoError(t, err)
+	assert.Equal(t, "Pointers: \"a\" guide\nJane Doe · example.com · May 01, 2024\nhttps://example.com/post\n\nBody\n", text)
+}
+
+type vaultSource struct {
+	assetMap
+	articles map[uuid.UUID]*model.Article
+	gets     int
+}
+
+func (s *vaultSource) Get(ctx context.Context, id uuid.UUID) (*model.Article, error) {
+	s.gets++
+	a, ok := s.articles[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	copy := *a
+	return &copy, nil
+}
+
+func TestVault_Sync(t *testing.T) {
+	dir := t.TempDir()
+	a := model.NewArticle("https://example.com/a")
+	a.Title, a.Content, a.ContentHash = "Hello / World", `<p>Hi <img src="/assets/abc"></p>`, "h1"
+	src := &vaultSource{
+		assetMap: assetMap{"abc": {Hash: "abc", ContentType: "image/png", Data: []byte("PNG")}},
+		articles: map[uuid.UUID]*model.Article{a.ID: &a},
+	}
+	listed := func() *model.Article {
+		meta := *src.articles[a.ID]
+		meta.Content = ""
+		return &meta
+	}
+
+	v, err := OpenVault(dir, src)
+	require.NoError(t, err)
+	out, err := v.Export(context.Background(), listed(), true)
+	require.NoError(t, err)
+	assert.Equal(t, Written, out)
+	require.NoError(t, v.Close())
+
+	name := "Hello World (" + a.ID.String()[:8] + ").md"
+	assert.Equal(t, name, FileName(&a))
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "Hi ![](assets/abc.png)")
+	img, err := os.ReadFile(filepath.Join(dir, "assets", "abc.png"))
+	require.NoError(t, err)
+	assert.Equal(t, "PNG", string(img))
+
+	// Nothing changed: no need to even fetch the content
+	v, err = OpenVault(dir, src)
+	require.NoError(t, err)
+	out, err = v.Export(context.Background(), listed(), true)
+	require.NoError(t, err)
+	assert.Equal(t, Unchanged, out)
+	assert.Equal(t, 1, src.gets)
+
+	// A new title renames the file
+	src.articles[a.ID].Title = "Renamed"
+	out, err = v.Export(context.Background(), listed(), true)
+	require.NoError(t, err)
+	assert.Equal(t, Written, out)
+	assert.NoFileExists(t, filepath.Join(dir, name))
+	renamed := filepath.Join(dir, "Renamed ("+a.ID.String()[:8]+").md")
+	assert.FileExists(t, renamed)
+
+	// Notes edited by hand are left alone by sync, but not by a full export
+	require.NoError(t, os.WriteFile(renamed, []byte("my notes"), 0o644))
+	src.articles[a.ID].ContentHash = "h2"
+	out, err = v.Export(context.Background(), listed(), true)
+	require.NoError(t, err)
+	assert.Equal(t, Kept, out)
+	out, err = v.Export(context.Background(), listed(), false)
+	require.NoError(t, err)
+	assert.Equal(t, Written, out)
+	require.NoError(t, v.Close())
+}
+
+func TestText_Plain(t *testing.T) {
+	got, err := convert(`<h2>Steps</h2><p>Run <code>go *test*</code>, see <a href="/x">docs</a>.<img src="/assets/abc"></p><ol><li>one</li><li>two</li></ol>`, mdWriter{plain: true})
+	require.NoError(t, err)
+	assert.Equal(t, "Steps\n\nRun go *test*, see docs.\n\n1. one\n2. two", got)
+}
@@ -0,0 +1,226 @@
+package export
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Source is where a Vault gets articles from. Get has to include Content.
+type Source interface {
+	Assets
+	Get(ctx context.Context, id uuid.UUID) (*model.Article, error)
+}
+
+// manifestName remembers what a Vault wrote, for the next sync
+const manifestName = ".crusty-export.json"
+
+// vaultAssets is where a Vault keeps images, relative to its notes
+const vaultAssets = "assets"
+
+type vaultEntry struct {
+	File string `json:"file"`
+	// Version fingerprints the metadata and text the file was made from;
+	// while it's the same, there's nothing to update
+	Version string `json:"version,omitempty"`
+	// Sum is of the bytes written, to notice a file edited by hand
+	Sum string `json:"sum"`
+}
+
+// Vault is a directory of Markdown files, one per article, for a notes app
+// like Obsidian. Images go into assets/ next to them. Close it to keep track
+// of what was written.
+type Vault struct {
+	Dir     string
+	src     Source
+	entries map[uuid.UUID]vaultEntry
+}
+
+// Outcome is what Export did with an article
+type Outcome int
+
+const (
+	Written   Outcome = iota
+	Unchanged         // Sync found nothing new
+	Kept              // The file was edited by hand, so sync left it alone
+)
+
+// OpenVault opens (or creates) the vault in dir
+func OpenVault(dir string, src Source) (*Vault, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	v := &Vault{Dir: dir, src: src, entries: make(map[uuid.UUID]vaultEntry)}
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return v, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &v.entries); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", manifestName, err)
+	}
+	return v, nil
+}
+
+// Close saves the manifest
+func (v *Vault) Close() error {
+	data, err := json.MarshalIndent(v.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(v.Dir, manifestName), data)
+}
+
+// version is empty when it can't be told from the metadata alone (articles
+// archived before ContentHash), which makes sync always rewrite them
+func version(a *model.Article) (string, error) {
+	if a.ContentHash == "" {
+		return "", nil
+	}
+	fm, err := FrontMatter(a)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(fm)
+	h.Write([]byte(a.ContentHash))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func sum(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// Export writes a's file. a may come without its Content (from List); it's
+// only fetched if the file needs writing. With sync, files that are up to
+// date, or that were edited since they were written, are left as they are.
+func (v *Vault) Export(ctx context.Context, a *model.Article, sync bool) (Outcome, error) {
+	ver, err := version(a)
+	if err != nil {
+		return 0, err
+	}
+	old, known := v.entries[a.ID]
+	var current []byte
+	if known {
+		current, err = os.ReadFile(filepath.Join(v.Dir, old.File))
+		if errors.Is(err, fs.ErrNotExist) {
+			known = false
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if sync && known {
+		if sum(current) != old.Sum {
+			return Kept, nil
+		}
+		if ver != "" && ver == old.Version {
+			return Unchanged, nil
+		}
+	}
+
+	if a.Content == "" {
+		if a, err = v.src.Get(ctx, a.ID); err != nil {
+			return 0, err
+		}
+		// The full article might be newer than what the caller listed
+		if ver, err = version(a); err != nil {
+			return 0, err
+		}
+	}
+	var imgErr error
+	md, err := Markdown(a, func(src string) string {
+		file, err := v.image(ctx, src)
+		if err != nil && imgErr == nil {
+			imgErr = err
+		}
+		return file
+	})
+	if err != nil {
+		return 0, err
+	}
+	if imgErr != nil {
+		return 0, imgErr
+	}
+
+	name := FileName(a)
+	if err := writeFile(filepath.Join(v.Dir, name), md); err != nil {
+		return 0, err
+	}
+	if known && old.File != name {
+		// Retitled: the old file goes, or there'd be two notes for one article
+		if err := os.Remove(filepath.Join(v.Dir, old.File)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, err
+		}
+	}
+	v.entries[a.ID] = vaultEntry{File: name, Version: ver, Sum: sum(md)}
+	return Written, nil
+}
+
+// image copies a stored image into the vault and returns its path relative
+// to the notes. Remote images stay remote.
+func (v *Vault) image(ctx context.Context, src string) (string, error) {
+	if !strings.HasPrefix(src, assetPrefix) {
+		return src, nil
+	}
+	hash := strings.TrimPrefix(src, assetPrefix)
+	if strings.ContainsAny(hash, `/\.`) {
+		return "", nil
+	}
+	// Named by hash, so one that's there is the right one
+	matches, _ := filepath.Glob(filepath.Join(v.Dir, vaultAssets, hash+".*"))
+	if len(matches) > 0 {
+		return vaultAssets + "/" + filepath.Base(matches[0]), nil
+	}
+
+	asset, err := v.src.GetAsset(ctx, hash)
+	if err != nil {
+		// Gone from the store; the article just goes without it
+		return "", nil
+	}
+	mediaType, _, _ := strings.Cut(asset.ContentType, ";")
+	ext, ok := imageExts[strings.TrimSpace(mediaType)]
+	if !ok {
+		ext = ".bin"
+	}
+	if err := os.MkdirAll(filepath.Join(v.Dir, vaultAssets), 0o755); err != nil {
+		return "", err
+	}
+	file := vaultAssets + "/" + hash + ext
+	if err := writeFile(filepath.Join(v.Dir, file), asset.Data); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// writeFile replaces path in one step, so a note app never sees half a file
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".crusty-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
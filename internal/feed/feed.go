@@ -0,0 +1,165 @@
+// Package feed reads RSS and Atom feeds, just enough to find new entries.
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// ErrNotAFeed is returned for XML that is neither RSS nor Atom
+var ErrNotAFeed = errors.New("not an RSS or Atom feed")
+
+// Feed is what Parse found
+type Feed struct {
+	Title   string
+	Entries []Entry // Newest first
+}
+
+// Entry is one post. GUID falls back to Link, so every entry has one.
+type Entry struct {
+	GUID      string
+	Link      string // Absolute
+	Title     string
+	Published time.Time // Zero if the feed didn't say
+}
+
+// The three dialects, decoded into one shape. RSS 2.0 and RSS 1.0 (RDF)
+// differ mostly in where items live; Atom names everything differently.
+type document struct {
+	XMLName xml.Name
+	// RSS 2.0 and 1.0
+	Channel struct {
+		Title string `xml:"title"`
+		Items []item `xml:"item"`
+	} `xml:"channel"`
+	Items []item `xml:"item"` // RSS 1.0 puts them next to the channel
+	// Atom
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type item struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	About   string `xml:"about,attr"` // rdf:about
+	PubDate string `xml:"pubDate"`
+	Date    string `xml:"date"` // dc:date
+}
+
+type atomEntry struct {
+	ID        string `xml:"id"`
+	Title     string `xml:"title"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	Links     []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+}
+
+// Parse reads an RSS 2.0, RSS 1.0 or Atom document. Relative links are
+// resolved against base (the feed's URL); entries without a usable link are dropped.
+func Parse(data []byte, base string) (*Feed, error) {
+	var doc document
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.CharsetReader = charset.NewReaderLabel
+	dec.Strict = false
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse feed: %w", err)
+	}
+	baseURL, _ := url.Parse(base)
+
+	var f Feed
+	switch doc.XMLName.Local {
+	case "rss", "RDF":
+		f.Title = doc.Channel.Title
+		for _, it := range append(doc.Channel.Items, doc.Items...) {
+			link := resolve(baseURL, it.Link)
+			if link == "" {
+				link = resolve(baseURL, it.About)
+			}
+			f.add(Entry{GUID: it.GUID, Link: link, Title: it.Title, Published: parseDate(it.PubDate, it.Date)})
+		}
+	case "feed":
+		f.Title = doc.Title
+		for _, e := range doc.Entries {
+			var link string
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					link = resolve(baseURL, l.Href)
+					break
+				}
+			}
+			f.add(Entry{GUID: e.ID, Link: link, Title: e.Title, Published: parseDate(e.Published, e.Updated)})
+		}
+	default:
+		return nil, ErrNotAFeed
+	}
+	f.Title = strings.TrimSpace(f.Title)
+
+	// Most feeds are newest first already; this fixes the ones that aren't
+	sort.SliceStable(f.Entries, func(i, j int) bool {
+		return f.Entries[i].Published.After(f.Entries[j].Published)
+	})
+	return &f, nil
+}
+
+func (f *Feed) add(e Entry) {
+	if e.Link == "" {
+		return
+	}
+	e.GUID = strings.TrimSpace(e.GUID)
+	if e.GUID == "" {
+		e.GUID = e.Link
+	}
+	e.Title = strings.TrimSpace(e.Title)
+	f.Entries = append(f.Entries, e)
+}
+
+// resolve makes ref absolute, or returns "" if it isn't an http(s) URL
+func resolve(base *url.URL, ref string) string {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || ref == "" {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	time.DateOnly,
+}
+
+// parseDate tries each candidate in every layout feeds are known to use
+func parseDate(candidates ...string) time.Time {
+	for _, s := range candidates {
+		s = strings.TrimSpace(s)
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,109 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rssFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+  <title> The Go Blog </title>
+  <atom:link href="https://go.dev/blog/feed.xml" rel="self"/>
+  <item>
+    <title>Older</title>
+    <link>/blog/older</link>
+    <pubDate>Mon, 01 Jan 2024 10:00:00 +0000</pubDate>
+  </item>
+  <item>
+    <title>Newer</title>
+    <link>https://go.dev/blog/newer</link>
+    <guid isPermaLink="false">tag:go.dev,2024:newer</guid>
+    <pubDate>Tue, 02 Jan 2024 10:00:00 +0000</pubDate>
+  </item>
+  <item><title>No link</title></item>
+</channel>
+</rss>`
+
+const atomFixture = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Example Atom</title>
+  <entry>
+    <id>urn:uuid:1</id>
+    <title>First</title>
+    <link rel="edit" href="https://example.com/edit/1"/>
+    <link href="https://example.com/posts/1"/>
+    <updated>2024-03-01T12:00:00Z</updated>
+  </entry>
+</feed>`
+
+const rdfFixture = `<?xml version="1.0"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/">
+  <channel rdf:about="https://example.org/"><title>RDF</title></channel>
+  <item rdf:about="https://example.org/a"><title>A</title><link>https://example.org/a</link></item>
+</rdf:RDF>`
+
+func TestParse(t *testing.T) {
+	f, err := Parse([]byte(rssFixture), "https://go.dev/blog/feed.xml")
+	require.NoError(t, err)
+	assert.Equal(t, "The Go Blog", f.Title)
+	require.Len(t, f.Entries, 2)
+	assert.Equal(t, "Newer", f.Entries[0].Title, "Newest first")
+	assert.Equal(t, "tag:go.dev,2024:newer", f.Entries[0].GUID)
+	assert.Equal(t, "https://go.dev/blog/older", f.Entries[1].Link)
+	assert.Equal(t, "https://go.dev/blog/older", f.Entries[1].GUID, "No guid: the link stands in")
+
+	f, err = Parse([]byte(atomFixture), "https://example.com/feed")
+	require.NoError(t, err)
+	assert.Equal(t, "Example Atom", f.Title)
+	require.Len(t, f.Entries, 1)
+	assert.Equal(t, Entry{GUID: "urn:uuid:1", Link: "https://example.com/posts/1", Title: "First", Published: f.Entries[0].Published}, f.Entries[0])
+	assert.Equal(t, 2024, f.Entries[0].Published.Year())
+
+	f, err = Parse([]byte(rdfFixture), "https://example.org/rss")
+	require.NoError(t, err)
+	assert.Equal(t, "RDF", f.Title)
+	require.Len(t, f.Entries, 1)
+	assert.Equal(t, "https://example.org/a", f.Entries[0].Link)
+
+	_, err = Parse([]byte(`<html><body>hi</body></html>`), "")
+	assert.ErrorIs(t, err, ErrNotAFeed)
+}
+
+func TestClient_Fetch_Conditional(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		assert.Equal(t, "crusty-test", r.UserAgent())
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Tue, 02 Jan 2024 10:00:00 GMT")
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(rssFixture))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.Client(), "crusty-test")
+	res, err := c.Fetch(context.Background(), srv.URL, "", "")
+	require.NoError(t, err)
+	assert.False(t, res.NotModified)
+	assert.Equal(t, `"v1"`, res.ETag)
+	assert.Equal(t, "Tue, 02 Jan 2024 10:00:00 GMT", res.LastModified)
+	assert.Len(t, res.Feed.Entries, 2)
+
+	res, err = c.Fetch(context.Background(), srv.URL, res.ETag, res.LastModified)
+	require.NoError(t, err)
+	assert.True(t, res.NotModified)
+	assert.Nil(t, res.Feed)
+	assert.Equal(t, `"v1"`, res.ETag, "Validators carry over")
+	assert.EqualValues(t, 2, hits.Load())
+}
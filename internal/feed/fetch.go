@@ -0,0 +1,82 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxFeedSize is plenty for any feed worth following
+const maxFeedSize = 5 << 20
+
+// Client downloads feeds, asking the server to skip ones that haven't changed
+type Client struct {
+	http      *http.Client
+	userAgent string
+}
+
+// NewClient returns a client that fetches with c, identifying itself as userAgent
+func NewClient(c *http.Client, userAgent string) *Client {
+	if c == nil {
+		c = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Client{http: c, userAgent: userAgent}
+}
+
+// Result is one fetch. When NotModified is set, Feed is nil and the
+// validators are the ones that were sent.
+type Result struct {
+	Feed         *Feed
+	NotModified  bool
+	ETag         string
+	LastModified string
+}
+
+// Fetch downloads and parses the feed at feedURL. etag and lastModified are
+// the validators from the previous fetch; the server answers 304 if nothing changed.
+func (c *Client) Fetch(ctx context.Context, feedURL, etag, lastModified string) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.9, */*;q=0.5")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return &Result{NotModified: true, ETag: etag, LastModified: lastModified}, nil
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("failed to fetch feed: HTTP %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFeedSize {
+		return nil, fmt.Errorf("feed is larger than %d bytes", maxFeedSize)
+	}
+	f, err := Parse(data, resp.Request.URL.String())
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		Feed:         f,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
@@ -1,17 +1,48 @@
 package model
 
 import (
+	"net/url"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 )
 
 type ArticleStatus string
 
+// See state.go for which moves between them are allowed
 const (
-	StatusPending  ArticleStatus = "pending"
-	StatusArchived ArticleStatus = "archived"
-	StatusFailed   ArticleStatus = "failed"
+	StatusPending    ArticleStatus = "pending"    // Queued, waiting for a worker
+	StatusProcessing ArticleStatus = "processing" // A worker has it
+	StatusRetrying   ArticleStatus = "retrying"   // A scrape failed; another attempt is scheduled
+	StatusArchived   ArticleStatus = "archived"
+	StatusFailed     ArticleStatus = "failed"
+	StatusDeleted    ArticleStatus = "deleted" // In the trash
+)
+
+// Statuses lists every ArticleStatus
+var Statuses = []ArticleStatus{StatusPending, StatusProcessing, StatusRetrying, StatusArchived, StatusFailed, StatusDeleted}
+
+// Folder is where the reader has filed an article. It's the reader's workflow,
+// not the pipeline's: an article can be StatusArchived and still in the inbox.
+type Folder string
+
+const (
+	FolderInbox   Folder = "inbox" // New articles; older ones without a folder count as here too
+	FolderArchive Folder = "archive"
+)
+
+// Folders lists every Folder
+var Folders = []Folder{FolderInbox, FolderArchive}
+
+// ErrorCode says WHY an article failed, so callers don't have to parse ErrorMessage
+type ErrorCode string
+
+const (
+	ErrorScrape          ErrorCode = "scrape_failed"
+	ErrorRobotsDisallowed ErrorCode = "robots_disallowed"
 )
 
 
@@ -26,14 +57,142 @@ type Article struct {
 	CreatedAt    time.Time     `json:"created_at"`
 	ArchivedAt   *time.Time    `json:"archived_at,omitempty"`
 	ErrorMessage string        `json:"error_message,omitempty"`
+	ErrorCode    ErrorCode     `json:"error_code,omitempty"`
+
+	// What URL points at, spelled canonically (see CanonicalizeURL). Once
+	// fetched, it follows redirects and the page's <link rel=canonical>.
+	// Articles with the same one are snapshots of the same page.
+	CanonicalURL string `json:"canonical_url,omitempty"`
+
+	// Retry bookkeeping: how many scrapes failed so far, and when the next one is due
+	Attempts      int        `json:"attempts,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+
+	// Set while the article is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// Every status change, oldest first. Only ever appended to (see Transition).
+	History []StatusEvent `json:"history,omitempty"`
+
+	// Snapshots taken for the watch list (see Watch) are Watched. PreviousID is
+	// the snapshot before, which the stored diff is against.
+	Watched     bool       `json:"watched,omitempty"`
+	ContentHash string     `json:"content_hash,omitempty"` // Of the readable text, see diff.Hash
+	PreviousID  *uuid.UUID `json:"previous_id,omitempty"`
+
+	// Normalized (see NormalizeTag), sorted, no duplicates
+	Tags []string `json:"tags,omitempty"`
+
+	// The reader's own flags, independent of Status
+	ReadAt   *time.Time `json:"read_at,omitempty"`
+	Favorite bool       `json:"favorite,omitempty"`
+	Folder   Folder     `json:"folder,omitempty"`
+
+	// What readability found on the page. Any of it may be missing.
+	Byline        string     `json:"byline,omitempty"`
+	SiteName      string     `json:"site_name,omitempty"`
+	Image         string     `json:"image,omitempty"` // Lead image; an /assets/ path once downloaded
+	Favicon       string     `json:"favicon,omitempty"`
+	Language      string     `json:"language,omitempty"`
+	PublishedTime *time.Time `json:"published_time,omitempty"`
+	Length        int        `json:"length,omitempty"` // Characters of readable text
+
+	// Computed from the readable text
+	WordCount   int `json:"word_count,omitempty"`
+	ReadingTime int `json:"reading_time,omitempty"` // Minutes
+}
+
+// WordsPerMinute is the reading speed ReadingTime assumes
+const WordsPerMinute = 230
+
+// ReadingMinutes estimates how long words take to read, rounded up
+func ReadingMinutes(words int) int {
+	if words <= 0 {
+		return 0
+	}
+	return (words + WordsPerMinute - 1) / WordsPerMinute
+}
+
+// Site is the site name if the page had one, else the article's Domain
+func (a Article) Site() string {
+	if a.SiteName != "" {
+		return a.SiteName
+	}
+	return a.Domain()
+}
+
+// Domain is the lowercase host of the article's URL without "www."
+func (a Article) Domain() string {
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// InFolder is the article's Folder, with the inbox for articles saved before folders existed
+func (a Article) InFolder() Folder {
+	if a.Folder == "" {
+		return FolderInbox
+	}
+	return a.Folder
+}
+
+// MaxTagLength caps a tag, in runes
+const MaxTagLength = 64
+
+// NormalizeTag lowercases a tag and turns spaces into dashes. Anything but
+// letters, digits, '-' and '_' is dropped, so tags are safe in keys and URLs.
+// It returns "" if nothing is left.
+func NormalizeTag(tag string) string {
+	var sb strings.Builder
+	n := 0
+	for _, r := range strings.ToLower(strings.Join(strings.Fields(tag), "-")) {
+		if n == MaxTagLength {
+			break
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			sb.WriteRune(r)
+			n++
+		}
+	}
+	return sb.String()
+}
+
+// NormalizeTags normalizes every tag, drops empty ones and duplicates, and sorts them
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	var out []string
+	for _, tag := range tags {
+		if tag = NormalizeTag(tag); tag != "" && !seen[tag] {
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// HasTag says whether the article is tagged with the (normalized) tag
+func (a Article) HasTag(tag string) bool {
+	for _, t := range a.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
 }
 
 // NewArticle creates a new Article instance with the given URL and default values.
 func NewArticle(rawURL string) Article {
+	now := time.Now()
 	return Article{
-		ID:        uuid.New(),
-		URL:       rawURL,
-		Status:    StatusPending,
-		CreatedAt: time.Now(),
+		ID:           uuid.New(),
+		URL:          rawURL,
+		CanonicalURL: CanonicalizeURL(rawURL),
+		Status:       StatusPending,
+		Folder:       FolderInbox,
+		CreatedAt:    now,
+		History:      []StatusEvent{{To: StatusPending, At: now}},
 	}
 }
\ No newline at end of file
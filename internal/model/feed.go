@@ -0,0 +1,87 @@
+package model
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// MinFeedInterval keeps the poller from hammering a blog
+	MinFeedInterval = 5 * time.Minute
+	// DefaultFeedInterval is how often a feed is polled unless told otherwise
+	DefaultFeedInterval = time.Hour
+)
+
+// ErrFeedInterval is returned for an interval under MinFeedInterval
+var ErrFeedInterval = errors.New("feed interval must be at least 5 minutes")
+
+// Feed is an RSS or Atom feed we follow. Every new entry becomes an Article
+// tagged with the feed's Name.
+type Feed struct {
+	URL      string   `json:"url"`  // Canonical, see CanonicalizeURL
+	Name     string   `json:"name"` // A normalized tag; the feed's title once it's been fetched, if not given
+	Title    string   `json:"title,omitempty"`
+	Interval Duration `json:"interval"`
+	// Entries already in the feed when it's first fetched are only saved up to
+	// this many (newest first); the rest count as read
+	Backfill int `json:"backfill,omitempty"`
+
+	// HTTP validators from the last fetch, so unchanged feeds cost a 304
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+
+	CreatedAt   time.Time  `json:"created_at"`
+	LastFetchAt *time.Time `json:"last_fetch_at,omitempty"`
+	NextFetchAt time.Time  `json:"next_fetch_at"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// NewFeed subscribes to rawURL, due right away. An empty name is filled in
+// from the feed's title on the first fetch.
+func NewFeed(rawURL, name string, interval time.Duration, backfill int) (Feed, error) {
+	if interval == 0 {
+		interval = DefaultFeedInterval
+	}
+	if interval < MinFeedInterval {
+		return Feed{}, ErrFeedInterval
+	}
+	now := time.Now()
+	return Feed{
+		URL:         CanonicalizeURL(rawURL),
+		Name:        NormalizeTag(name),
+		Interval:    Duration(interval),
+		Backfill:    max(backfill, 0),
+		CreatedAt:   now,
+		NextFetchAt: now,
+	}, nil
+}
+
+// Due says whether the feed should be fetched by now
+func (f Feed) Due(now time.Time) bool {
+	return !now.Before(f.NextFetchAt)
+}
+
+// Fetched records a fetch at now, failed if err isn't nil, and schedules the next one
+func (f *Feed) Fetched(now time.Time, err error) {
+	f.LastFetchAt = &now
+	f.NextFetchAt = now.Add(time.Duration(f.Interval))
+	f.LastError = ""
+	if err != nil {
+		f.LastError = err.Error()
+	}
+}
+
+// Named gives the feed a Name if it has none yet: its title, or else its host
+func (f *Feed) Named(title string) {
+	f.Title = title
+	if f.Name != "" {
+		return
+	}
+	if f.Name = NormalizeTag(title); f.Name == "" {
+		if u, err := url.Parse(f.URL); err == nil {
+			f.Name = NormalizeTag(strings.ReplaceAll(strings.TrimPrefix(u.Hostname(), "www."), ".", "-"))
+		}
+	}
+}
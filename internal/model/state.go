@@ -0,0 +1,107 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrIllegalTransition is returned for a status change the state machine doesn't allow
+var ErrIllegalTransition = errors.New("illegal status transition")
+
+// ErrHeld is the ErrIllegalTransition of a worker trying to move an article
+// another worker is processing
+var ErrHeld = fmt.Errorf("%w: held by another worker", ErrIllegalTransition)
+
+// transitions lists where an article may go from each status.
+//
+// processing -> processing is a job handed to a new worker after the old one
+// died and its lease ran out (see StatusEvent.Until); only one of them can
+// move it on from there.
+var transitions = map[ArticleStatus][]ArticleStatus{
+	StatusPending:    {StatusProcessing, StatusDeleted},
+	StatusProcessing: {StatusProcessing, StatusArchived, StatusRetrying, StatusFailed, StatusPending, StatusDeleted},
+	StatusRetrying:   {StatusProcessing, StatusPending, StatusFailed, StatusDeleted},
+	StatusArchived:   {StatusPending, StatusDeleted},
+	StatusFailed:     {StatusPending, StatusDeleted},
+	StatusDeleted:    {StatusPending, StatusArchived, StatusFailed},
+}
+
+// StatusEvent is one entry of an article's History
+type StatusEvent struct {
+	At     time.Time     `json:"at"`
+	From   ArticleStatus `json:"from,omitempty"` // Empty for the first event
+	To     ArticleStatus `json:"to"`
+	Worker string        `json:"worker,omitempty"` // Set when a worker made the change
+	Error  string        `json:"error,omitempty"`
+	// For a worker's claim, when its lease runs out. Until then no other
+	// worker may take the article over.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// CanTransition says whether an article may move from one status to another
+func CanTransition(from, to ArticleStatus) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves the article to ev.To and appends ev to its History.
+//
+// A non-empty ev.From must be the current status, which makes it a
+// compare-and-set. A worker can only move an article on from processing if it
+// is the one holding it, or claim it from another whose lease ran out by
+// ev.At; changes without a Worker (the user's) always can. ev.At defaults to now.
+func (a *Article) Transition(ev StatusEvent) error {
+	if ev.From != "" && ev.From != a.Status {
+		return fmt.Errorf("%w: expected %s, article is %s", ErrIllegalTransition, ev.From, a.Status)
+	}
+	if !CanTransition(a.Status, ev.To) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, a.Status, ev.To)
+	}
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+	if owner := a.Worker(); ev.Worker != "" && owner != "" && owner != ev.Worker {
+		if ev.To != StatusProcessing || !a.leaseExpired(ev.At) {
+			return fmt.Errorf("%w: %s is with %s", ErrHeld, a.ID, owner)
+		}
+	}
+	ev.From = a.Status
+	a.Status = ev.To
+	a.History = append(a.History, ev)
+	return nil
+}
+
+// Worker is the worker processing the article, if it is being processed
+func (a Article) Worker() string {
+	if a.Status != StatusProcessing || len(a.History) == 0 {
+		return ""
+	}
+	return a.History[len(a.History)-1].Worker
+}
+
+// leaseExpired says whether the worker's claim on the article had run out at
+// t. Claims from before leases were recorded have no Until and never hold.
+func (a Article) leaseExpired(t time.Time) bool {
+	until := a.History[len(a.History)-1].Until
+	return until == nil || !t.Before(*until)
+}
+
+// RestoreStatus is where a deleted article goes back to: the status it was
+// trashed from, except that anything in flight starts over as pending.
+func (a Article) RestoreStatus() ArticleStatus {
+	for i := len(a.History) - 1; i >= 0; i-- {
+		if ev := a.History[i]; ev.To == StatusDeleted {
+			switch ev.From {
+			case StatusArchived, StatusFailed:
+				return ev.From
+			}
+			break
+		}
+	}
+	return StatusPending
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// trackingParams only say where a click came from, never which page it was.
+// Anything starting with utm_ is dropped too.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+	"ref_src": true,
+}
+
+// CanonicalizeURL spells a URL the one way this archive compares URLs in:
+// lowercase scheme and host, no default port, no fragment, no tracking
+// parameters, the remaining query sorted and an empty path as "/".
+// A string that isn't an absolute URL comes back just trimmed.
+func CanonicalizeURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6
+	}
+	u.Host = host
+	if port != "" {
+		u.Host += ":" + port
+	}
+
+	u.Fragment, u.RawFragment = "", ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	q := u.Query()
+	for key := range q {
+		if k := strings.ToLower(key); strings.HasPrefix(k, "utm_") || trackingParams[k] {
+			q.Del(key)
+		}
+	}
+	u.RawQuery = q.Encode()
+	u.ForceQuery = false
+	return u.String()
+}
+
+// URLHash is a short, key-safe stand-in for a canonical URL
+func URLHash(canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Canonical is the article's CanonicalURL, worked out from URL for articles saved before it existed
+func (a Article) Canonical() string {
+	if a.CanonicalURL != "" {
+		return a.CanonicalURL
+	}
+	return CanonicalizeURL(a.URL)
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalizeURL(t *testing.T) {
+	cases := map[string]string{
+		"https://Example.COM/Post?b=2&a=1#comments":                "https://example.com/Post?a=1&b=2",
+		"HTTP://example.com:80":                                    "http://example.com/",
+		"https://example.com:443/x?utm_source=rss&utm_Medium=feed": "https://example.com/x",
+		"https://example.com:8443/x?fbclid=abc&id=7":               "https://example.com:8443/x?id=7",
+		"  https://example.com/x?  ":                               "https://example.com/x",
+		"not a url":                                                "not a url",
+	}
+	for in, want := range cases {
+		assert.Equal(t, want, CanonicalizeURL(in), in)
+	}
+
+	a := Article{URL: "https://www.example.com/a?gclid=1"}
+	assert.Equal(t, "https://www.example.com/a", a.Canonical(), "Worked out for old articles")
+	assert.Len(t, URLHash(a.Canonical()), 32)
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// MinWatchInterval keeps the watch list from hammering a site
+const MinWatchInterval = time.Minute
+
+// ErrWatchInterval is returned for an interval under MinWatchInterval
+var ErrWatchInterval = errors.New("watch interval must be at least a minute")
+
+// Watch is a page on the watch list: it gets snapshotted again every Interval
+type Watch struct {
+	URL         string     `json:"url"` // Canonical, see CanonicalizeURL
+	Interval    Duration   `json:"interval"`
+	Tags        []string   `json:"tags,omitempty"` // Given to every snapshot
+	CreatedAt   time.Time  `json:"created_at"`
+	LastCheckAt *time.Time `json:"last_check_at,omitempty"`
+	NextCheckAt time.Time  `json:"next_check_at"`
+}
+
+// NewWatch puts rawURL on the watch list, due right away
+func NewWatch(rawURL string, interval time.Duration, tags []string) (Watch, error) {
+	if interval < MinWatchInterval {
+		return Watch{}, ErrWatchInterval
+	}
+	now := time.Now()
+	return Watch{
+		URL:         CanonicalizeURL(rawURL),
+		Interval:    Duration(interval),
+		Tags:        NormalizeTags(tags),
+		CreatedAt:   now,
+		NextCheckAt: now,
+	}, nil
+}
+
+// Due says whether the next snapshot should be taken by now
+func (w Watch) Due(now time.Time) bool {
+	return !now.Before(w.NextCheckAt)
+}
+
+// Checked records a snapshot taken at now and schedules the next one
+func (w *Watch) Checked(now time.Time) {
+	w.LastCheckAt = &now
+	w.NextCheckAt = now.Add(time.Duration(w.Interval))
+}
+
+// Duration is a time.Duration that reads and writes JSON as "24h0m0s"
+type Duration time.Duration
+
+func (d Duration) String() string { return time.Duration(d).String() }
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
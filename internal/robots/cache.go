@@ -0,0 +1,107 @@
+package robots
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// Successful (or 4xx) answers are trusted for a day
+	cacheTTL = 24 * time.Hour
+	// Network errors and 5xx are retried much sooner
+	errorTTL = time.Minute
+)
+
+// ErrUnavailable comes with a "no" from Allowed when the host's robots.txt
+// answered 5xx: the host is off limits for now, not for good
+var ErrUnavailable = errors.New("robots.txt unavailable")
+
+// Cache fetches robots.txt once per host and remembers it
+type Cache struct {
+	client    *http.Client
+	userAgent string
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	rules   *Rules
+	expires time.Time
+}
+
+// NewCache returns a cache that fetches with client, identifying itself as userAgent
+func NewCache(client *http.Client, userAgent string) *Cache {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Cache{
+		client:    client,
+		userAgent: userAgent,
+		entries:   make(map[string]cacheEntry),
+	}
+}
+
+// Allowed reports whether the cache's user agent may fetch rawURL
+func (c *Cache) Allowed(ctx context.Context, rawURL string) (bool, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false, err
+	}
+
+	rules := c.rulesFor(ctx, u)
+	if rules == DisallowAll {
+		return false, ErrUnavailable
+	}
+	return rules.Allowed(c.userAgent, u.RequestURI()), nil
+}
+
+func (c *Cache) rulesFor(ctx context.Context, u *url.URL) *Rules {
+	origin := u.Scheme + "://" + u.Host
+
+	c.mu.Lock()
+	entry, ok := c.entries[origin]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.rules
+	}
+
+	rules, ttl := c.fetch(ctx, origin)
+
+	c.mu.Lock()
+	c.entries[origin] = cacheEntry{rules: rules, expires: time.Now().Add(ttl)}
+	c.mu.Unlock()
+	return rules
+}
+
+// fetch never fails: a host we can't reach is treated as allowing everything
+// and one answering 5xx as allowing nothing, but either only for errorTTL
+// so a flaky robots.txt is asked again soon.
+func (c *Cache) fetch(ctx context.Context, origin string) (*Rules, time.Duration) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return AllowAll, errorTTL
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return AllowAll, errorTTL
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return DisallowAll, errorTTL
+	case resp.StatusCode >= 400:
+		return AllowAll, cacheTTL
+	case resp.StatusCode >= 300:
+		// The client already followed redirects; anything left is odd
+		return AllowAll, errorTTL
+	}
+	return Parse(resp.Body), cacheTTL
+}
@@ -0,0 +1,163 @@
+package robots
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Rules is the parsed robots.txt of one host.
+// Only the bits that matter for a single fetcher are kept: per-agent Allow/Disallow lines.
+type Rules struct {
+	groups []group
+}
+
+type group struct {
+	agents []string
+	rules  []rule
+}
+
+type rule struct {
+	allow   bool
+	pattern string
+}
+
+// AllowAll is what a host without a robots.txt gets
+var AllowAll = &Rules{}
+
+// DisallowAll is what a host whose robots.txt can't be read right now gets
+// (RFC 9309 2.3.1.4: a 5xx means the whole site is off limits)
+var DisallowAll = &Rules{groups: []group{{agents: []string{"*"}, rules: []rule{{pattern: "/"}}}}}
+
+// Parse reads a robots.txt body. It never fails: junk lines are skipped,
+// which is how every crawler treats broken files anyway.
+func Parse(r io.Reader) *Rules {
+	rules := &Rules{}
+	var cur *group
+	inAgents := false // consecutive User-agent lines share one group
+
+	sc := bufio.NewScanner(io.LimitReader(r, 512<<10))
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				rules.groups = append(rules.groups, group{})
+				cur = &rules.groups[len(rules.groups)-1]
+			}
+			cur.agents = append(cur.agents, strings.ToLower(val))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			if cur == nil {
+				continue
+			}
+			// An empty Disallow means "everything is fine"
+			if val == "" {
+				continue
+			}
+			cur.rules = append(cur.rules, rule{allow: key == "allow", pattern: val})
+		default:
+			inAgents = false
+		}
+	}
+	return rules
+}
+
+// Allowed reports whether userAgent may fetch path (which may include a query).
+// The most specific matching group wins, then the longest matching rule; Allow wins ties.
+func (r *Rules) Allowed(userAgent, path string) bool {
+	g := r.groupFor(userAgent)
+	if g == nil {
+		return true
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	best := -1
+	allowed := true
+	for _, rl := range g.rules {
+		if !match(rl.pattern, path) {
+			continue
+		}
+		n := len(rl.pattern)
+		if n > best || (n == best && rl.allow) {
+			best = n
+			allowed = rl.allow
+		}
+	}
+	return allowed
+}
+
+// groupFor picks the group naming userAgent's product token, falling back to "*".
+// Only the token counts (RFC 9309 2.2.1): "crusty-buffer/1.0 (+https://...)"
+// is crusty-buffer, whatever else the rest of the string happens to contain.
+func (r *Rules) groupFor(userAgent string) *group {
+	token := productToken(userAgent)
+
+	var star *group
+	for i := range r.groups {
+		g := &r.groups[i]
+		for _, agent := range g.agents {
+			switch agent {
+			case token:
+				return g
+			case "*":
+				if star == nil {
+					star = g
+				}
+			}
+		}
+	}
+	return star
+}
+
+// productToken is the name at the start of a User-Agent string, lowercased
+func productToken(userAgent string) string {
+	token := strings.TrimSpace(userAgent)
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+	return strings.ToLower(token)
+}
+
+// match implements the robots.txt pattern language: '*' is any run of
+// characters and a trailing '$' anchors the end. Everything else is a prefix match.
+func match(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+
+	for i, part := range parts[1:] {
+		last := i == len(parts)-2
+		if last && anchored {
+			return strings.HasSuffix(rest, part)
+		}
+		idx := strings.Index(rest, part)
+		if idx < 0 {
+			return false
+		}
+		rest = rest[idx+len(part):]
+	}
+
+	if anchored && len(parts) == 1 {
+		return rest == ""
+	}
+	return true
+}
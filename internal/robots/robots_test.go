@@ -0,0 +1,102 @@
+package robots
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sample = `
+# Everyone stays out of /private, except the press page
+User-agent: *
+Disallow: /private
+Allow: /private/press
+Disallow: /*.pdf$
+
+User-agent: crusty-buffer
+User-agent: other-bot
+Disallow: /no-crusty/
+`
+
+func TestRules_Allowed(t *testing.T) {
+	rules := Parse(strings.NewReader(sample))
+
+	cases := []struct {
+		ua, path string
+		want     bool
+	}{
+		{"SomeBot/1.0", "/", true},
+		{"SomeBot/1.0", "/private/data", false},
+		{"SomeBot/1.0", "/private/press/today", true},
+		{"SomeBot/1.0", "/docs/file.pdf", false},
+		{"SomeBot/1.0", "/docs/file.pdf?x=1", true},
+		// Our own group replaces the * group entirely
+		{"crusty-buffer/1.0", "/private/data", true},
+		{"crusty-buffer/1.0", "/no-crusty/page", false},
+		{"Other-Bot", "/no-crusty/page", false},
+		// Only the product token picks a group, case-insensitively
+		{"CRUSTY-BUFFER/2.0", "/no-crusty/page", false},
+		{"SomeBot/1.0 (like crusty-buffer)", "/no-crusty/page", true},
+		{"SomeBot/1.0 (like crusty-buffer)", "/private/data", false},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, rules.Allowed(tc.ua, tc.path), "%s %s", tc.ua, tc.path)
+	}
+}
+
+func TestRules_EmptyDisallowAllowsEverything(t *testing.T) {
+	rules := Parse(strings.NewReader("User-agent: *\nDisallow:\n"))
+	assert.True(t, rules.Allowed("any", "/anything"))
+}
+
+func TestCache_FetchesOncePerHost(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			hits.Add(1)
+			assert.Equal(t, "crusty-test", r.UserAgent())
+			fmt.Fprint(w, "User-agent: *\nDisallow: /secret\n")
+		}
+	}))
+	defer srv.Close()
+
+	cache := NewCache(srv.Client(), "crusty-test")
+	ctx := context.Background()
+
+	ok, err := cache.Allowed(ctx, srv.URL+"/public")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = cache.Allowed(ctx, srv.URL+"/secret/plans")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Equal(t, int32(1), hits.Load())
+}
+
+func TestCache_MissingRobotsAllowsAll(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	ok, err := NewCache(srv.Client(), "crusty-test").Allowed(context.Background(), srv.URL+"/anything")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestCache_ServerErrorDisallowsAll(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ok, err := NewCache(srv.Client(), "crusty-test").Allowed(context.Background(), srv.URL+"/anything")
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.False(t, ok)
+}
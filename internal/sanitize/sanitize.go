@@ -0,0 +1,266 @@
+package sanitize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Policy is an allowlist: anything not named here is removed.
+//
+// Tags maps an allowed element to the attributes it may keep. Elements not in
+// Tags are unwrapped (their text survives), except the ones in Drop, which go
+// away together with everything inside them. URL attributes must use one of
+// Schemes or be relative. on* handlers and style are never kept unless a policy
+// explicitly lists them, which DefaultPolicy does not.
+//
+// Prefix goes in front of every kept id and class name, and of same-page
+// "#fragment" links so footnotes still work. Page markup then can't clobber
+// the app's own ids (window.foo, getElementById) or pick up its styles.
+type Policy struct {
+	Tags    map[string][]string `json:"tags"`
+	Global  []string            `json:"global"`  // Attributes allowed on every kept element
+	Drop    []string            `json:"drop"`    // Elements removed with their content
+	Schemes []string            `json:"schemes"` // Allowed URL schemes, lowercase
+	Prefix  string              `json:"prefix,omitempty"`
+}
+
+// DefaultPrefix is DefaultPolicy's Prefix
+const DefaultPrefix = "user-content-"
+
+// urlAttrs are checked against Schemes wherever they appear
+var urlAttrs = map[string]bool{
+	"href": true, "src": true, "cite": true, "poster": true, "action": true,
+	"background": true, "longdesc": true, "usemap": true, "formaction": true,
+}
+
+// DefaultPolicy keeps the markup a reader view needs and nothing that can run code
+func DefaultPolicy() Policy {
+	return Policy{
+		Tags: map[string][]string{
+			"a": {"href", "title"}, "abbr": {"title"}, "b": nil, "blockquote": {"cite"},
+			"br": nil, "caption": nil, "cite": nil, "code": nil, "col": {"span"},
+			"colgroup": {"span"}, "dd": nil, "del": nil, "details": nil, "dfn": nil,
+			"div": nil, "dl": nil, "dt": nil, "em": nil, "figcaption": nil, "figure": nil,
+			"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil, "hr": nil,
+			"i": nil, "img": {"src", "alt", "title", "width", "height"}, "ins": nil,
+			"kbd": nil, "li": nil, "mark": nil, "ol": {"start", "reversed"}, "p": nil,
+			"pre": nil, "q": {"cite"}, "s": nil, "small": nil, "span": nil, "strong": nil,
+			"sub": nil, "summary": nil, "sup": nil, "table": nil, "tbody": nil,
+			"td": {"colspan", "rowspan"}, "tfoot": nil, "th": {"colspan", "rowspan", "scope"},
+			"thead": nil, "time": {"datetime"}, "tr": nil, "u": nil, "ul": nil,
+			"article": nil, "section": nil, "header": nil, "footer": nil, "aside": nil,
+			"main": nil, "picture": nil,
+		},
+		Global: []string{"id", "class", "lang", "dir"},
+		Drop: []string{
+			"script", "style", "iframe", "frame", "frameset", "object", "embed", "applet",
+			"noscript", "template", "svg", "math", "link", "meta", "base", "head",
+			"title", "form", "input", "button", "select", "textarea", "option",
+			"audio", "video", "source", "track", "canvas", "dialog", "portal",
+		},
+		Schemes: []string{"http", "https", "mailto"},
+		Prefix:  DefaultPrefix,
+	}
+}
+
+// LoadPolicy reads a full Policy from a JSON file.
+// Start from DefaultPolicy (see `crusty sanitize --print-policy`) and edit it.
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Policy{}, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Sanitizer applies one Policy. Build it once; it's safe for concurrent use.
+type Sanitizer struct {
+	tags    map[string]map[string]bool
+	global  map[string]bool
+	drop    map[string]bool
+	schemes map[string]bool
+	prefix  string
+}
+
+// New compiles p into a Sanitizer
+func New(p Policy) *Sanitizer {
+	s := &Sanitizer{
+		tags:    make(map[string]map[string]bool, len(p.Tags)),
+		global:  toSet(p.Global),
+		drop:    toSet(p.Drop),
+		schemes: toSet(p.Schemes),
+		prefix:  p.Prefix,
+	}
+	for tag, attrs := range p.Tags {
+		s.tags[strings.ToLower(tag)] = toSet(attrs)
+	}
+	return s
+}
+
+// Sanitize cleans an HTML fragment. Invalid markup is repaired the way a
+// browser would, then filtered, so what comes out is what a browser would see.
+func (s *Sanitizer) Sanitize(fragment string) string {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), body)
+	if err != nil {
+		// The parser only fails on I/O errors, which a strings.Reader can't produce
+		return ""
+	}
+
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+	s.clean(body)
+
+	var sb strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		html.Render(&sb, c)
+	}
+	return sb.String()
+}
+
+// clean filters n's children in place
+func (s *Sanitizer) clean(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+
+		switch c.Type {
+		case html.TextNode:
+			// Keep
+		case html.ElementNode:
+			tag := strings.ToLower(c.Data)
+			allowed, ok := s.tags[tag]
+			switch {
+			case s.drop[tag] || c.Namespace != "":
+				// Foreign content (svg/math) is dropped wholesale
+				n.RemoveChild(c)
+			case !ok:
+				// Unknown wrapper: keep its (cleaned) children in its place
+				s.clean(c)
+				for gc := c.FirstChild; gc != nil; {
+					gcNext := gc.NextSibling
+					c.RemoveChild(gc)
+					n.InsertBefore(gc, c)
+					gc = gcNext
+				}
+				n.RemoveChild(c)
+			default:
+				c.Attr = s.cleanAttrs(tag, c.Attr, allowed)
+				if tag == "a" && hasAttr(c, "href") {
+					c.Attr = append(c.Attr, html.Attribute{Key: "rel", Val: "noopener noreferrer nofollow"})
+				}
+				s.clean(c)
+			}
+		default:
+			// Comments, doctypes, processing instructions
+			n.RemoveChild(c)
+		}
+
+		c = next
+	}
+}
+
+func (s *Sanitizer) cleanAttrs(tag string, attrs []html.Attribute, allowed map[string]bool) []html.Attribute {
+	kept := attrs[:0]
+	for _, a := range attrs {
+		key := strings.ToLower(a.Key)
+		if a.Namespace != "" || strings.HasPrefix(key, "on") {
+			continue
+		}
+		if !allowed[key] && !s.global[key] {
+			continue
+		}
+		if urlAttrs[key] && !s.safeURL(tag, key, a.Val) {
+			continue
+		}
+		a.Key = key
+		if s.prefix != "" {
+			switch {
+			case key == "id":
+				a.Val = s.prefixed(a.Val)
+			case key == "class":
+				classes := strings.Fields(a.Val)
+				for i, c := range classes {
+					classes[i] = s.prefixed(c)
+				}
+				a.Val = strings.Join(classes, " ")
+			case key == "href" && len(a.Val) > 1 && a.Val[0] == '#':
+				a.Val = "#" + s.prefixed(a.Val[1:])
+			}
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// prefixed adds the policy's Prefix once, so sanitizing twice changes nothing
+func (s *Sanitizer) prefixed(name string) string {
+	if name == "" || strings.HasPrefix(name, s.prefix) {
+		return name
+	}
+	return s.prefix + name
+}
+
+// safeURL accepts relative URLs and allowed schemes. Browsers ignore tabs,
+// newlines and leading control characters in a scheme ("java\tscript:"), so we do too.
+func (s *Sanitizer) safeURL(tag, key, raw string) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, raw)
+
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" {
+		// No scheme could still be "javascript:..." hiding behind odd encoding
+		return !strings.Contains(strings.ToLower(strings.SplitN(cleaned, "/", 2)[0]), ":")
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if tag == "img" && key == "src" && scheme == "data" {
+		// Inline raster images only; an SVG data URI can carry script
+		return safeDataImage(u.Opaque)
+	}
+	return s.schemes[scheme]
+}
+
+func safeDataImage(opaque string) bool {
+	mediaType := strings.ToLower(strings.SplitN(opaque, ";", 2)[0])
+	mediaType = strings.SplitN(mediaType, ",", 2)[0]
+	switch mediaType {
+	case "image/png", "image/gif", "image/jpeg", "image/webp", "image/avif":
+		return true
+	}
+	return false
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[strings.ToLower(item)] = true
+	}
+	return set
+}
@@ -0,0 +1,145 @@
+package sanitize
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// xssCorpus is a regression list of payloads, mostly from the OWASP filter
+// evasion cheat sheet. None of them may leave anything executable behind.
+var xssCorpus = []string{
+	`<script>alert(1)</script>`,
+	`<SCRIPT SRC=http://evil.example/xss.js></SCRIPT>`,
+	`<scr<script>ipt>alert(1)</scr</script>ipt>`,
+	`<img src=x onerror=alert(1)>`,
+	`<IMG SRC="javascript:alert('XSS');">`,
+	`<IMG SRC=JaVaScRiPt:alert('XSS')>`,
+	`<IMG SRC=&#106;&#97;&#118;&#97;&#115;&#99;&#114;&#105;&#112;&#116;&#58;&#97;&#108;&#101;&#114;&#116;&#40;&#39;&#88;&#83;&#83;&#39;&#41;>`,
+	`<IMG SRC="jav	ascript:alert('XSS');">`,
+	`<IMG SRC="jav&#x0A;ascript:alert('XSS');">`,
+	`<IMG SRC=" &#14;  javascript:alert('XSS');">`,
+	`<a href="javascript:alert(1)">click</a>`,
+	`<a href="  JAVASCRIPT:alert(1)">click</a>`,
+	`<a href="vbscript:msgbox(1)">click</a>`,
+	`<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">click</a>`,
+	`<img src="data:image/svg+xml;base64,PHN2ZyBvbmxvYWQ9YWxlcnQoMSk+">`,
+	`<a href="java&#0000058script:alert(1)">click</a>`,
+	`<body onload=alert(1)>`,
+	`<div onmouseover="alert(1)">hover</div>`,
+	`<p ONCLICK=alert(1)>x</p>`,
+	`<svg onload=alert(1)>`,
+	`<svg><script>alert(1)</script></svg>`,
+	`<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`,
+	`<iframe src="javascript:alert(1)"></iframe>`,
+	`<iframe srcdoc="<script>alert(1)</script>"></iframe>`,
+	`<object data="javascript:alert(1)"></object>`,
+	`<embed src="javascript:alert(1)">`,
+	`<div style="background-image: url(javascript:alert(1))">x</div>`,
+	`<div style="width: expression(alert(1))">x</div>`,
+	`<style>@import 'http://evil.example/xss.css';</style>`,
+	`<link rel=stylesheet href="http://evil.example/xss.css">`,
+	`<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`,
+	`<base href="javascript:alert(1)//">`,
+	`<form action="javascript:alert(1)"><button>go</button></form>`,
+	`<button formaction="javascript:alert(1)">go</button>`,
+	`<input autofocus onfocus=alert(1)>`,
+	`<video><source onerror="alert(1)"></video>`,
+	`<details open ontoggle=alert(1)>`,
+	`<noscript><p title="</noscript><img src=x onerror=alert(1)>">`,
+	`<template><script>alert(1)</script></template>`,
+	`<!--<img src=x onerror=alert(1)>-->`,
+	`<a href="#" onclick="alert(1)">x</a>`,
+	`<img """><script>alert(1)</script>">`,
+	`<<SCRIPT>alert(1);//<</SCRIPT>`,
+	`<IMG SRC="javascript:alert('XSS')"`,
+	`<table background="javascript:alert(1)"><tr><td>x</td></tr></table>`,
+	`<blockquote cite="javascript:alert(1)">x</blockquote>`,
+}
+
+func TestSanitize_XSSCorpus(t *testing.T) {
+	s := New(DefaultPolicy())
+
+	for _, payload := range xssCorpus {
+		out := strings.ToLower(s.Sanitize(payload))
+
+		assert.NotContains(t, out, "<script", payload)
+		assert.NotContains(t, out, "javascript:", payload)
+		assert.NotContains(t, out, "vbscript:", payload)
+		assert.NotContains(t, out, "data:text", payload)
+		assert.NotContains(t, out, "data:image/svg", payload)
+		assert.NotContains(t, out, "<iframe", payload)
+		assert.NotContains(t, out, "<svg", payload)
+		assert.NotContains(t, out, "<object", payload)
+		assert.NotContains(t, out, "<embed", payload)
+		assert.NotContains(t, out, "<style", payload)
+		assert.NotContains(t, out, "style=", payload)
+		assert.NotContains(t, out, "<base", payload)
+		assert.NotContains(t, out, "<meta", payload)
+		assert.NotContains(t, out, "<form", payload)
+		assert.NotContains(t, out, "formaction", payload)
+		assert.NotRegexp(t, `<[^>]*\son[a-z]+\s*=`, out, payload)
+
+		// Running it again must not change anything, or `crusty sanitize` would never settle
+		assert.Equal(t, s.Sanitize(s.Sanitize(payload)), s.Sanitize(payload), payload)
+	}
+}
+
+func TestSanitize_KeepsArticleMarkup(t *testing.T) {
+	s := New(DefaultPolicy())
+
+	in := `<div id="readability-page-1" class="page"><h2>Title</h2>` +
+		`<p>Some <strong>bold</strong> and <a href="https://example.com/x">a link</a>.</p>` +
+		`<figure><img src="/assets/abc" alt="pic"/><figcaption>Caption</figcaption></figure>` +
+		`<pre><code>x &lt; y</code></pre></div>`
+	out := s.Sanitize(in)
+
+	assert.Contains(t, out, `<div id="user-content-readability-page-1" class="user-content-page">`)
+	assert.Contains(t, out, `<strong>bold</strong>`)
+	assert.Contains(t, out, `<a href="https://example.com/x" rel="noopener noreferrer nofollow">a link</a>`)
+	assert.Contains(t, out, `<img src="/assets/abc" alt="pic"/>`)
+	assert.Contains(t, out, `<figcaption>Caption</figcaption>`)
+	assert.Contains(t, out, `<code>x &lt; y</code>`)
+}
+
+func TestSanitize_PrefixesNames(t *testing.T) {
+	s := New(DefaultPolicy())
+
+	out := s.Sanitize(`<p id="config" class="admin  hidden">See <a href="#fn1">1</a></p><ol><li id="fn1">Note</li></ol>`)
+	assert.Equal(t, `<p id="user-content-config" class="user-content-admin user-content-hidden">See `+
+		`<a href="#user-content-fn1" rel="noopener noreferrer nofollow">1</a></p><ol><li id="user-content-fn1">Note</li></ol>`, out)
+	assert.Equal(t, out, s.Sanitize(out), "Prefixed once")
+
+	p := DefaultPolicy()
+	p.Prefix = ""
+	assert.Equal(t, `<p id="config">x</p>`, New(p).Sanitize(`<p id="config">x</p>`))
+}
+
+func TestSanitize_UnwrapsUnknownTags(t *testing.T) {
+	s := New(DefaultPolicy())
+
+	assert.Equal(t, `<p>hello <em>world</em></p>`, s.Sanitize(`<p><font color="red">hello <blink><em>world</em></blink></font></p>`))
+	// Dropped elements take their text with them
+	assert.Equal(t, `<p>ok</p>`, s.Sanitize(`<p>ok<script>var secret = 1</script></p>`))
+}
+
+func TestSanitize_DataImages(t *testing.T) {
+	s := New(DefaultPolicy())
+
+	assert.Contains(t, s.Sanitize(`<img src="data:image/png;base64,iVBORw0KGgo=">`), `src="data:image/png;base64,iVBORw0KGgo="`)
+	assert.NotContains(t, s.Sanitize(`<a href="data:image/png;base64,iVBORw0KGgo=">x</a>`), "data:")
+}
+
+func TestSanitize_CustomPolicy(t *testing.T) {
+	p := DefaultPolicy()
+	p.Tags["iframe"] = []string{"src"}
+	p.Drop = nil
+	s := New(p)
+
+	out := s.Sanitize(`<iframe src="https://video.example/embed/1" onload="alert(1)"></iframe>`)
+	assert.Equal(t, `<iframe src="https://video.example/embed/1"></iframe>`, out)
+
+	// Scheme rules still apply to tags a policy lets in
+	assert.NotContains(t, s.Sanitize(`<iframe src="javascript:alert(1)"></iframe>`), "javascript")
+}
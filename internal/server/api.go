@@ -0,0 +1,606 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const maxAPIListLimit = 200
+
+// apiError is the one error shape every /api/v1 endpoint returns
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type listResponse struct {
+	Articles   []model.Article `json:"articles"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+type searchResponse struct {
+	Results    []store.SearchResult `json:"results"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+type createRequest struct {
+	URL    string           `json:"url"`
+	Tags   []string         `json:"tags,omitempty"`
+	Dedupe store.DedupeMode `json:"dedupe,omitempty"` // See store.DedupeMode; "" is version
+}
+
+type tagsRequest struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+type tagsResponse struct {
+	Tags []store.TagCount `json:"tags"`
+}
+
+type watchRequest struct {
+	URL      string   `json:"url"`
+	Interval string   `json:"interval"` // A Go duration, "24h"
+	Tags     []string `json:"tags,omitempty"`
+}
+
+type watchesResponse struct {
+	Watches []model.Watch `json:"watches"`
+}
+
+type feedRequest struct {
+	URL      string `json:"url"`
+	Name     string `json:"name,omitempty"`     // Tag for its entries; "" takes the feed's title
+	Interval string `json:"interval,omitempty"` // A Go duration; "" is model.DefaultFeedInterval
+	Backfill int    `json:"backfill,omitempty"`
+}
+
+type feedsResponse struct {
+	Feeds []model.Feed `json:"feeds"`
+}
+
+type deadLettersResponse struct {
+	IDs []uuid.UUID `json:"ids"`
+}
+
+func (s *Server) apiRoutes() {
+	api := s.router.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/articles", s.apiCreateArticle).Methods("POST")
+	api.HandleFunc("/articles", s.apiListArticles).Methods("GET")
+	api.HandleFunc("/articles/{id}", s.apiGetArticle).Methods("GET")
+	api.HandleFunc("/articles/{id}", s.apiDeleteArticle).Methods("DELETE")
+	api.HandleFunc("/articles/{id}", s.apiUpdateArticle).Methods("PATCH")
+	api.HandleFunc("/articles/{id}/restore", s.apiRestoreArticle).Methods("POST")
+	api.HandleFunc("/articles/{id}/retry", s.apiRetryArticle).Methods("POST")
+	api.HandleFunc("/articles/{id}/reprocess", s.apiReprocessArticle).Methods("POST")
+	api.HandleFunc("/articles/{id}/tags", s.apiTagArticle).Methods("POST")
+	api.HandleFunc("/tags", s.apiListTags).Methods("GET")
+	api.HandleFunc("/search", s.apiSearch).Methods("GET")
+	api.HandleFunc("/watches", s.apiListWatches).Methods("GET")
+	api.HandleFunc("/watches", s.apiAddWatch).Methods("POST")
+	api.HandleFunc("/watches", s.apiDeleteWatch).Methods("DELETE")
+	api.HandleFunc("/diff/{from}/{to}", s.apiDiff).Methods("GET")
+	api.HandleFunc("/feeds", s.apiListFeeds).Methods("GET")
+	api.HandleFunc("/feeds", s.apiAddFeed).Methods("POST")
+	api.HandleFunc("/feeds", s.apiDeleteFeed).Methods("DELETE")
+	api.HandleFunc("/queue/dead", s.apiListDeadLetters).Methods("GET")
+	api.HandleFunc("/queue/dead/{id}/requeue", s.apiRequeueDeadLetter).Methods("POST")
+	api.HandleFunc("/backup", s.apiBackup).Methods("GET")
+	api.HandleFunc("/restore", s.apiRestore).Methods("POST")
+}
+
+func (s *Server) apiCreateArticle(w http.ResponseWriter, r *http.Request) {
+	var req createRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "request body must be JSON with a url field")
+		return
+	}
+	if !validURL(req.URL) {
+		writeError(w, http.StatusBadRequest, "invalid_url", "url must be an absolute http(s) URL")
+		return
+	}
+
+	// An existing snapshot comes back as 200, a newly queued article as 202
+	article, created, err := store.Add(r.Context(), s.store, req.URL, req.Tags, req.Dedupe)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	if !created {
+		article.Content = ""
+		writeJSON(w, http.StatusOK, article)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, article)
+}
+
+func (s *Server) apiListArticles(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	opts := store.ListOptions{
+		Cursor: q.Get("cursor"),
+		Domain: strings.ToLower(strings.TrimPrefix(q.Get("domain"), "www.")),
+		Tag:    model.NormalizeTag(q.Get("tag")),
+		URL:    q.Get("url"),
+		Order:  store.SortOrder(q.Get("order")),
+		Folder: model.Folder(q.Get("folder")),
+	}
+	opts.Trashed, _ = strconv.ParseBool(q.Get("trashed"))
+	opts.Unread, _ = strconv.ParseBool(q.Get("unread"))
+	opts.Favorite, _ = strconv.ParseBool(q.Get("favorite"))
+	var ok bool
+	if opts.Limit, ok = parseLimit(w, q); !ok {
+		return
+	}
+	if opts.Since, ok = parseTime(w, q, "since"); !ok {
+		return
+	}
+	if opts.Until, ok = parseTime(w, q, "until"); !ok {
+		return
+	}
+	if opts.Status, ok = parseStatuses(w, q); !ok {
+		return
+	}
+
+	articles, next, err := s.store.List(r.Context(), opts)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listResponse{Articles: articles, NextCursor: next})
+}
+
+func (s *Server) apiSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "missing_query", "q is required")
+		return
+	}
+
+	opts := store.SearchOptions{Cursor: q.Get("cursor")}
+	var ok bool
+	if opts.Limit, ok = parseLimit(w, q); !ok {
+		return
+	}
+	if opts.Status, ok = parseStatuses(w, q); !ok {
+		return
+	}
+
+	results, next, err := s.store.Search(r.Context(), query, opts)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, searchResponse{Results: results, NextCursor: next})
+}
+
+func (s *Server) apiGetArticle(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "markdown" && format != "text" {
+		writeError(w, http.StatusBadRequest, "invalid_format", "format must be json, markdown or text")
+		return
+	}
+
+	article, err := s.store.Get(r.Context(), id)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	if format == "markdown" || format == "text" {
+		s.writeArticleAs(w, r, article, format)
+		return
+	}
+
+	// Content can be huge, so it is opt-in
+	if withContent, _ := strconv.ParseBool(r.URL.Query().Get("content")); !withContent {
+		article.Content = ""
+	}
+
+	writeJSON(w, http.StatusOK, article)
+}
+
+func (s *Server) apiDeleteArticle(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+
+	// Trash by default; ?purge=true removes it for good
+	remove := s.store.Trash
+	if purge, _ := strconv.ParseBool(r.URL.Query().Get("purge")); purge {
+		remove = s.store.Delete
+	}
+	if err := remove(r.Context(), id); err != nil {
+		s.storeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) apiRestoreArticle(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.store.Restore(r.Context(), id); err != nil {
+		s.storeError(w, err)
+		return
+	}
+	article, err := s.store.Get(r.Context(), id)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	article.Content = ""
+
+	writeJSON(w, http.StatusOK, article)
+}
+
+// apiUpdateArticle changes the reader's flags: {"read": true, "favorite": true, "folder": "archive"}
+func (s *Server) apiUpdateArticle(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+	var req store.FlagUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "request body must be JSON with read, favorite and/or folder")
+		return
+	}
+
+	article, err := store.UpdateFlags(r.Context(), s.store, id, req)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, article)
+}
+
+// apiTagArticle adds and removes tags in one go and returns the article
+func (s *Server) apiTagArticle(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+	var req tagsRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "request body must be JSON with add and/or remove lists")
+		return
+	}
+
+	article, err := store.UpdateTags(r.Context(), s.store, id, req.Add, req.Remove)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	article.Content = ""
+
+	writeJSON(w, http.StatusOK, article)
+}
+
+func (s *Server) apiListTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.store.Tags(r.Context())
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tagsResponse{Tags: tags})
+}
+
+func (s *Server) apiRetryArticle(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+
+	article, err := s.store.Get(r.Context(), id)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	switch article.Status {
+	case model.StatusPending, model.StatusRetrying:
+		writeError(w, http.StatusConflict, "already_queued", "article is already waiting in the queue")
+		return
+	case model.StatusProcessing:
+		writeError(w, http.StatusConflict, "processing", "a worker is archiving the article right now")
+		return
+	}
+
+	if err := s.store.Requeue(r.Context(), id); err != nil {
+		s.storeError(w, err)
+		return
+	}
+
+	article, err = s.store.Get(r.Context(), id)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	article.Content = ""
+	writeJSON(w, http.StatusAccepted, article)
+}
+
+func (s *Server) apiReprocessArticle(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+	if s.reprocessor == nil {
+		writeError(w, http.StatusNotImplemented, "unsupported", "this server has no worker to reprocess with")
+		return
+	}
+
+	article, err := s.reprocessor.Reprocess(r.Context(), id)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+
+	article.Content = ""
+	writeJSON(w, http.StatusOK, article)
+}
+
+func (s *Server) apiListWatches(w http.ResponseWriter, r *http.Request) {
+	watches, err := s.store.Watches(r.Context())
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, watchesResponse{Watches: watches})
+}
+
+func (s *Server) apiAddWatch(w http.ResponseWriter, r *http.Request) {
+	var req watchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "request body must be JSON with url and interval fields")
+		return
+	}
+	if !validURL(req.URL) {
+		writeError(w, http.StatusBadRequest, "invalid_url", "url must be an absolute http(s) URL")
+		return
+	}
+	interval, err := time.ParseDuration(req.Interval)
+	if err != nil || interval < model.MinWatchInterval {
+		writeError(w, http.StatusBadRequest, "invalid_interval", "interval must be a duration of at least 1m, like 24h")
+		return
+	}
+
+	watch, err := store.AddWatch(r.Context(), s.store, req.URL, interval, req.Tags)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, watch)
+}
+
+// apiDeleteWatch takes the URL as ?url=, any spelling of it
+func (s *Server) apiDeleteWatch(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.DeleteWatch(r.Context(), r.URL.Query().Get("url")); err != nil {
+		s.storeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiDiff is the unified diff between two snapshots' text, empty if they read the same
+func (s *Server) apiDiff(w http.ResponseWriter, r *http.Request) {
+	from, err1 := uuid.Parse(mux.Vars(r)["from"])
+	to, err2 := uuid.Parse(mux.Vars(r)["to"])
+	if err1 != nil || err2 != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "ids must be UUIDs")
+		return
+	}
+
+	d, err := store.SnapshotDiff(r.Context(), s.store, from, to)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(d))
+}
+
+func (s *Server) apiListFeeds(w http.ResponseWriter, r *http.Request) {
+	feeds, err := s.store.Feeds(r.Context())
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, feedsResponse{Feeds: feeds})
+}
+
+func (s *Server) apiAddFeed(w http.ResponseWriter, r *http.Request) {
+	var req feedRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "request body must be JSON with a url field")
+		return
+	}
+	if !validURL(req.URL) {
+		writeError(w, http.StatusBadRequest, "invalid_url", "url must be an absolute http(s) URL")
+		return
+	}
+	var interval time.Duration
+	if req.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(req.Interval); err != nil || interval < model.MinFeedInterval {
+			writeError(w, http.StatusBadRequest, "invalid_interval", "interval must be a duration of at least 5m, like 1h")
+			return
+		}
+	}
+
+	f, err := store.AddFeed(r.Context(), s.store, req.URL, req.Name, interval, req.Backfill)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, f)
+}
+
+// apiDeleteFeed takes the URL as ?url=, like apiDeleteWatch
+func (s *Server) apiDeleteFeed(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.DeleteFeed(r.Context(), r.URL.Query().Get("url")); err != nil {
+		s.storeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) apiListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ids, err := s.store.ListDeadLetters(r.Context())
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	if ids == nil {
+		ids = []uuid.UUID{}
+	}
+	writeJSON(w, http.StatusOK, deadLettersResponse{IDs: ids})
+}
+
+// apiRequeueDeadLetter gives a dead-lettered job a fresh set of attempts
+func (s *Server) apiRequeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.RequeueDeadLetter(r.Context(), id); err != nil {
+		s.storeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// storeError maps store errors onto HTTP responses
+func (s *Server) storeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound), errors.Is(err, store.ErrNotWatched), errors.Is(err, store.ErrNotSubscribed):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, store.ErrInvalidCursor):
+		writeError(w, http.StatusBadRequest, "invalid_cursor", err.Error())
+	case errors.Is(err, store.ErrInvalidOrder):
+		writeError(w, http.StatusBadRequest, "invalid_order", "order must be newest or oldest")
+	case errors.Is(err, store.ErrInvalidFolder):
+		writeError(w, http.StatusBadRequest, "invalid_folder", "folder must be inbox or archive")
+	case errors.Is(err, store.ErrInvalidDedupe):
+		writeError(w, http.StatusBadRequest, "invalid_dedupe", "dedupe must be version, skip or always")
+	case errors.Is(err, model.ErrIllegalTransition):
+		writeError(w, http.StatusConflict, "illegal_transition", err.Error())
+	case errors.Is(err, store.ErrConflict):
+		writeError(w, http.StatusConflict, "conflict", err.Error())
+	case errors.Is(err, store.ErrInvalidBackup):
+		writeError(w, http.StatusBadRequest, "invalid_backup", err.Error())
+	case errors.Is(err, store.ErrNotEmpty):
+		writeError(w, http.StatusConflict, "not_empty", "the store has articles already; restore with merge=true to load over them")
+	default:
+		s.logger.Error("Store error", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "internal", "internal server error")
+	}
+}
+
+func parseID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "id must be a UUID")
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// parseLimit reads ?limit, capped at maxAPIListLimit. 0 means the store's default.
+func parseLimit(w http.ResponseWriter, q url.Values) (int, bool) {
+	raw := q.Get("limit")
+	if raw == "" {
+		return 0, true
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_limit", "limit must be a positive integer")
+		return 0, false
+	}
+	return min(limit, maxAPIListLimit), true
+}
+
+// parseTime reads an optional RFC 3339 timestamp, or a plain date (2006-01-02)
+func parseTime(w http.ResponseWriter, q url.Values, name string) (time.Time, bool) {
+	raw := q.Get(name)
+	if raw == "" {
+		return time.Time{}, true
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t, true
+		}
+	}
+	writeError(w, http.StatusBadRequest, "invalid_"+name, name+" must be an RFC 3339 time or a date like 2006-01-02")
+	return time.Time{}, false
+}
+
+// parseStatuses accepts both ?status=a&status=b and ?status=a,b
+func parseStatuses(w http.ResponseWriter, q url.Values) ([]model.ArticleStatus, bool) {
+	var statuses []model.ArticleStatus
+	for _, raw := range q["status"] {
+		for _, st := range strings.Split(raw, ",") {
+			status := model.ArticleStatus(strings.TrimSpace(st))
+			if !validStatus(status) {
+				writeError(w, http.StatusBadRequest, "invalid_status", "unknown status: "+string(status))
+				return nil, false
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, true
+}
+
+func validURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func validStatus(status model.ArticleStatus) bool {
+	for _, s := range model.Statuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiError{Error: apiErrorBody{Code: code, Message: message}})
+}
@@ -0,0 +1,728 @@
+package web
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newTestServer wires a Server to fake Redis + a temp Badger dir
+func newTestServer(t *testing.T) (*Server, *store.HybridStore) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	st, err := store.NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(st.Close)
+
+	return NewServer(st, zap.NewNop()), st
+}
+
+func doRequest(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestAPI_CreateAndGet(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	rec := doRequest(s, "POST", "/api/v1/articles", `{"url":"https://example.com/post"}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var created model.Article
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, model.StatusPending, created.Status)
+
+	rec = doRequest(s, "GET", "/api/v1/articles/"+created.ID.String(), "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var fetched model.Article
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &fetched))
+	assert.Equal(t, "https://example.com/post", fetched.URL)
+}
+
+func TestAPI_Errors(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{"POST", "/api/v1/articles", `{"url":"ftp://nope"}`, http.StatusBadRequest, "invalid_url"},
+		{"POST", "/api/v1/articles", `not json`, http.StatusBadRequest, "invalid_body"},
+		{"GET", "/api/v1/articles/not-a-uuid", "", http.StatusBadRequest, "invalid_id"},
+		{"GET", "/api/v1/articles/3b241101-e2bb-4255-8caf-4136c566a962", "", http.StatusNotFound, "not_found"},
+		{"DELETE", "/api/v1/articles/3b241101-e2bb-4255-8caf-4136c566a962", "", http.StatusNotFound, "not_found"},
+		{"GET", "/api/v1/articles?status=bogus", "", http.StatusBadRequest, "invalid_status"},
+		{"GET", "/api/v1/articles?cursor=abc", "", http.StatusBadRequest, "invalid_cursor"},
+		{"GET", "/api/v1/articles/3b241101-e2bb-4255-8caf-4136c566a962?format=pdf", "", http.StatusBadRequest, "invalid_format"},
+	}
+
+	for _, tc := range cases {
+		rec := doRequest(s, tc.method, tc.path, tc.body)
+		assert.Equal(t, tc.status, rec.Code, "%s %s", tc.method, tc.path)
+
+		var body apiError
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, tc.code, body.Error.Code, "%s %s", tc.method, tc.path)
+	}
+}
+
+func TestAPI_ListPaginationAndFilter(t *testing.T) {
+	s, st := newTestServer(t)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		a := model.NewArticle("https://example.com")
+		require.NoError(t, st.Save(ctx, &a))
+		if i%2 == 0 {
+			for _, to := range []model.ArticleStatus{model.StatusProcessing, model.StatusFailed} {
+				_, err := st.UpdateStatus(ctx, a.ID, model.StatusEvent{To: to})
+				require.NoError(t, err)
+			}
+		}
+	}
+
+	// Walk every page and count what comes back
+	seen := 0
+	cursor := ""
+	for {
+		rec := doRequest(s, "GET", "/api/v1/articles?limit=2&cursor="+cursor, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var page listResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		seen += len(page.Articles)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, 5, seen)
+
+	rec := doRequest(s, "GET", "/api/v1/articles?status=failed", "")
+	var failed listResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &failed))
+	assert.Len(t, failed.Articles, 3)
+}
+
+func TestAPI_DeleteAndRetry(t *testing.T) {
+	s, st := newTestServer(t)
+	ctx := context.Background()
+
+	a := model.NewArticle("https://example.com")
+	require.NoError(t, st.Save(ctx, &a))
+
+	// Still pending, so retry is a conflict
+	rec := doRequest(s, "POST", "/api/v1/articles/"+a.ID.String()+"/retry", "")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	a.Status = model.StatusFailed
+	a.ErrorMessage = "boom"
+	require.NoError(t, st.Save(ctx, &a))
+
+	rec = doRequest(s, "POST", "/api/v1/articles/"+a.ID.String()+"/retry", "")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	got, err := st.Get(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusPending, got.Status)
+	assert.Empty(t, got.ErrorMessage)
+
+	// A plain DELETE only moves it to the trash
+	rec = doRequest(s, "DELETE", "/api/v1/articles/"+a.ID.String(), "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	got, err = st.Get(ctx, a.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, got.DeletedAt)
+
+	rec = doRequest(s, "POST", "/api/v1/articles/"+a.ID.String()+"/restore", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	got, err = st.Get(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got.DeletedAt)
+
+	rec = doRequest(s, "DELETE", "/api/v1/articles/"+a.ID.String()+"?purge=true", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	_, err = st.Get(ctx, a.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestUI_TrashRestoreAndPurge(t *testing.T) {
+	s, st := newTestServer(t)
+	ctx := context.Background()
+
+	a := model.NewArticle("https://example.com/post")
+	a.Status = model.StatusArchived
+	a.Title = "Doomed"
+	require.NoError(t, st.Save(ctx, &a))
+
+	rec := doRequest(s, "POST", "/view/"+a.ID.String()+"/delete", "")
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.NotContains(t, doRequest(s, "GET", "/", "").Body.String(), "Doomed")
+	assert.Contains(t, doRequest(s, "GET", "/trash", "").Body.String(), "Doomed")
+	assert.Contains(t, doRequest(s, "GET", "/view/"+a.ID.String(), "").Body.String(), "In the trash")
+
+	rec = doRequest(s, "POST", "/view/"+a.ID.String()+"/restore", "")
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Contains(t, doRequest(s, "GET", "/", "").Body.String(), "Doomed")
+
+	rec = doRequest(s, "POST", "/view/"+a.ID.String()+"/purge", "")
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	_, err := st.Get(ctx, a.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	rec = doRequest(s, "POST", "/view/"+a.ID.String()+"/purge", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestViewRaw_IsSandboxed(t *testing.T) {
+	s, st := newTestServer(t)
+	ctx := context.Background()
+
+	a := model.NewArticle("https://example.com")
+	require.NoError(t, st.Save(ctx, &a))
+
+	rec := doRequest(s, "GET", "/view/"+a.ID.String()+"/raw", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	require.NoError(t, st.SaveRaw(ctx, a.ID, []byte("<script>alert(1)</script>")))
+	rec = doRequest(s, "GET", "/view/"+a.ID.String()+"/raw", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "<script>alert(1)</script>", rec.Body.String())
+	assert.Contains(t, rec.Header().Get("Content-Security-Policy"), "sandbox")
+}
+
+func TestAssets_ServedFromStore(t *testing.T) {
+	s, st := newTestServer(t)
+	hash := strings.Repeat("ab", 32)
+
+	rec := doRequest(s, "GET", "/assets/"+hash, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	require.NoError(t, st.PutAsset(context.Background(), store.Asset{Hash: hash, ContentType: "image/png", Data: []byte("png")}))
+	rec = doRequest(s, "GET", "/assets/"+hash, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "png", rec.Body.String())
+}
+
+func TestUI_ShowsMetadata(t *testing.T) {
+	s, st := newTestServer(t)
+
+	published := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	article := model.NewArticle("https://www.example.com/post")
+	require.NoError(t, st.Save(context.Background(), &article))
+	article.Status = model.StatusArchived
+	article.Title = "Hello"
+	article.Content = "<p>Body</p>"
+	article.Byline = "Jane Doe"
+	article.Language = "de"
+	article.PublishedTime = &published
+	article.ReadingTime = 4
+	require.NoError(t, st.Save(context.Background(), &article))
+
+	rec := doRequest(s, "GET", "/", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Jane Doe")
+	assert.Contains(t, rec.Body.String(), "example.com", "Falls back to the host without a site name")
+	assert.Contains(t, rec.Body.String(), "4 min read")
+
+	rec = doRequest(s, "GET", "/view/"+article.ID.String(), "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "published Mar 01, 2024")
+	assert.Contains(t, rec.Body.String(), `lang="de"`)
+}
+
+func TestSearch_APIAndPage(t *testing.T) {
+	s, st := newTestServer(t)
+
+	article := model.NewArticle("https://example.com/post")
+	article.Status = model.StatusArchived
+	article.Title = "Burrowing animals"
+	article.Content = "<p>Gophers dig <b>long</b> tunnels &amp; live in them.</p>"
+	require.NoError(t, st.Save(context.Background(), &article))
+
+	rec := doRequest(s, "GET", "/api/v1/search?q=gophers", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp searchResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 1)
+	assert.Equal(t, article.ID, resp.Results[0].Article.ID)
+
+	rec = doRequest(s, "GET", "/api/v1/search", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(s, "GET", "/search?q=gophers", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<mark>Gophers</mark> dig long tunnels &amp; live")
+
+	rec = doRequest(s, "GET", "/search?q=zebras", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "No articles match")
+}
+
+func TestAPI_ListDomainDateAndOrder(t *testing.T) {
+	s, st := newTestServer(t)
+	ctx := context.Background()
+
+	for i, u := range []string{"https://a.example/1", "https://www.b.example/2", "https://a.example/3"} {
+		a := model.NewArticle(u)
+		a.CreatedAt = time.Date(2024, 1, 1+i, 12, 0, 0, 0, time.UTC)
+		require.NoError(t, st.Save(ctx, &a))
+	}
+
+	list := func(query string) []string {
+		rec := doRequest(s, "GET", "/api/v1/articles?"+query, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var page listResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		var urls []string
+		for _, a := range page.Articles {
+			urls = append(urls, a.URL)
+		}
+		return urls
+	}
+
+	assert.Equal(t, []string{"https://a.example/3", "https://a.example/1"}, list("domain=a.example"))
+	assert.Equal(t, []string{"https://www.b.example/2"}, list("domain=www.b.example"))
+	assert.Equal(t, []string{"https://a.example/1", "https://a.example/3"}, list("domain=a.example&order=oldest"))
+	assert.Equal(t, []string{"https://www.b.example/2"}, list("since=2024-01-02&until=2024-01-03"))
+
+	assert.Equal(t, http.StatusBadRequest, doRequest(s, "GET", "/api/v1/articles?order=sideways", "").Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(s, "GET", "/api/v1/articles?since=yesterday", "").Code)
+}
+
+func TestTags_APIAndPages(t *testing.T) {
+	s, st := newTestServer(t)
+
+	rec := doRequest(s, "POST", "/api/v1/articles", `{"url":"https://example.com/post","tags":["Go","research"]}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var created model.Article
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, []string{"go", "research"}, created.Tags)
+
+	rec = doRequest(s, "POST", "/api/v1/articles/"+created.ID.String()+"/tags", `{"add":["later"],"remove":["research"]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var tagged model.Article
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tagged))
+	assert.Equal(t, []string{"go", "later"}, tagged.Tags)
+
+	rec = doRequest(s, "GET", "/api/v1/tags", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var tags tagsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tags))
+	assert.Equal(t, []store.TagCount{{Tag: "go", Count: 1}, {Tag: "later", Count: 1}}, tags.Tags)
+
+	rec = doRequest(s, "GET", "/api/v1/articles?tag=Later", "")
+	var page listResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	require.Len(t, page.Articles, 1)
+	assert.Equal(t, created.ID, page.Articles[0].ID)
+
+	rec = doRequest(s, "GET", "/tag/go", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "https://example.com/post")
+	assert.Contains(t, rec.Body.String(), `href="/tag/later"`)
+
+	rec = doRequest(s, "GET", "/tag/nothing", "")
+	assert.Contains(t, rec.Body.String(), "Nothing is tagged #nothing")
+
+	// The view page edits tags with a plain form post
+	req := httptest.NewRequest("POST", "/view/"+created.ID.String()+"/tags", strings.NewReader("add=a,+b&remove=go"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+
+	got, err := st.Get(context.Background(), created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "later"}, got.Tags)
+}
+
+func TestUI_ReadingWorkflow(t *testing.T) {
+	s, st := newTestServer(t)
+	ctx := context.Background()
+
+	a := model.NewArticle("https://example.com/post")
+	a.Status = model.StatusArchived
+	a.Title = "Unread so far"
+	require.NoError(t, st.Save(ctx, &a))
+
+	assert.Contains(t, doRequest(s, "GET", "/", "").Body.String(), "Unread so far")
+
+	// Opening it marks it read, which takes it off the default view
+	require.Equal(t, http.StatusOK, doRequest(s, "GET", "/view/"+a.ID.String(), "").Code)
+	got, err := st.Get(ctx, a.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, got.ReadAt)
+	assert.Contains(t, doRequest(s, "GET", "/", "").Body.String(), "All caught up")
+	assert.Contains(t, doRequest(s, "GET", "/?view=inbox", "").Body.String(), "Unread so far")
+
+	post := func(form string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/view/"+a.ID.String()+"/flags", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("favorite=true")
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/view/"+a.ID.String(), rec.Header().Get("Location"))
+	assert.Contains(t, doRequest(s, "GET", "/?view=favorites", "").Body.String(), "Unread so far")
+
+	rec = post("folder=archive&next=/")
+	assert.Equal(t, "/", rec.Header().Get("Location"))
+	assert.NotContains(t, doRequest(s, "GET", "/?view=inbox", "").Body.String(), "Unread so far")
+	assert.Contains(t, doRequest(s, "GET", "/?view=archive", "").Body.String(), "Unread so far")
+
+	assert.Equal(t, http.StatusBadRequest, post("folder=someday").Code)
+	post("read=false&next=//evil.example")
+	got, err = st.Get(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got.ReadAt)
+}
+
+func TestAPI_UpdateFlags(t *testing.T) {
+	s, st := newTestServer(t)
+
+	a := model.NewArticle("https://example.com/post")
+	require.NoError(t, st.Save(context.Background(), &a))
+
+	rec := doRequest(s, "PATCH", "/api/v1/articles/"+a.ID.String(), `{"read":true,"favorite":true,"folder":"archive"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var got model.Article
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.NotNil(t, got.ReadAt)
+	assert.True(t, got.Favorite)
+	assert.Equal(t, model.FolderArchive, got.Folder)
+
+	for _, query := range []string{"favorite=true", "folder=archive", "favorite=true&folder=archive"} {
+		rec = doRequest(s, "GET", "/api/v1/articles?"+query, "")
+		var page listResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		assert.Len(t, page.Articles, 1, query)
+	}
+	rec = doRequest(s, "GET", "/api/v1/articles?unread=true", "")
+	var page listResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Empty(t, page.Articles)
+
+	assert.Equal(t, http.StatusBadRequest, doRequest(s, "GET", "/api/v1/articles?folder=someday", "").Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(s, "PATCH", "/api/v1/articles/"+a.ID.String(), `{"folder":"someday"}`).Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(s, "PATCH", "/api/v1/articles/3b241101-e2bb-4255-8caf-4136c566a962", `{"read":true}`).Code)
+}
+
+func TestAPI_DedupeAndSnapshots(t *testing.T) {
+	s, st := newTestServer(t)
+	ctx := context.Background()
+
+	rec := doRequest(s, "POST", "/api/v1/articles", `{"url":"https://example.com/post?utm_source=x"}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var first model.Article
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &first))
+
+	rec = doRequest(s, "POST", "/api/v1/articles", `{"url":"https://example.com/post","dedupe":"version"}`)
+	require.Equal(t, http.StatusOK, rec.Code, "Still queued, so that one comes back")
+	var again model.Article
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &again))
+	assert.Equal(t, first.ID, again.ID)
+
+	rec = doRequest(s, "POST", "/api/v1/articles", `{"url":"https://example.com/post","dedupe":"always"}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var second model.Article
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &second))
+	assert.Equal(t, http.StatusBadRequest, doRequest(s, "POST", "/api/v1/articles", `{"url":"https://example.com/post","dedupe":"twice"}`).Code)
+
+	rec = doRequest(s, "GET", "/api/v1/articles?url="+url.QueryEscape("https://EXAMPLE.com/post#x"), "")
+	var page listResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Len(t, page.Articles, 2)
+
+	a, err := st.Get(ctx, second.ID)
+	require.NoError(t, err)
+	a.Status, a.Title = model.StatusArchived, "Post"
+	require.NoError(t, st.Save(ctx, a))
+	rec = doRequest(s, "GET", "/view/"+second.ID.String(), "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "1 earlier snapshot<")
+	assert.Contains(t, rec.Body.String(), "/view/"+first.ID.String())
+}
+
+func TestWatchesAndDiff_APIAndPage(t *testing.T) {
+	s, st := newTestServer(t)
+	ctx := context.Background()
+
+	rec := doRequest(s, "POST", "/api/v1/watches", `{"url":"https://example.com/news","interval":"24h","tags":["News"]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Equal(t, http.StatusBadRequest, doRequest(s, "POST", "/api/v1/watches", `{"url":"https://example.com/news","interval":"1s"}`).Code)
+
+	rec = doRequest(s, "GET", "/api/v1/watches", "")
+	var watches watchesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &watches))
+	require.Len(t, watches.Watches, 1)
+	assert.Equal(t, model.Duration(24*time.Hour), watches.Watches[0].Interval)
+
+	assert.Equal(t, http.StatusNoContent, doRequest(s, "DELETE", "/api/v1/watches?url="+url.QueryEscape("https://EXAMPLE.com/news"), "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(s, "DELETE", "/api/v1/watches?url="+url.QueryEscape("https://example.com/news"), "").Code)
+
+	a := model.NewArticle("https://example.com/news")
+	a.Status, a.Content = model.StatusArchived, "<p>Old news</p>"
+	require.NoError(t, st.Save(ctx, &a))
+	b := model.NewArticle("https://example.com/news")
+	b.Status, b.Title, b.Content, b.PreviousID = model.StatusArchived, "News", "<p>New news</p>", &a.ID
+	require.NoError(t, st.Save(ctx, &b))
+
+	rec = doRequest(s, "GET", "/api/v1/diff/"+a.ID.String()+"/"+b.ID.String(), "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "-Old news\n+New news\n")
+
+	rec = doRequest(s, "GET", "/diff/"+a.ID.String()+"/"+b.ID.String(), "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<span class="del">-Old news</span>`)
+	assert.Contains(t, rec.Body.String(), `<span class="ins">&#43;New news</span>`)
+
+	rec = doRequest(s, "GET", "/view/"+b.ID.String(), "")
+	assert.Contains(t, rec.Body.String(), `href="/diff/`+a.ID.String()+"/"+b.ID.String()+`">changes</a>`)
+
+	assert.Equal(t, http.StatusNotFound, doRequest(s, "GET", "/diff/"+uuid.NewString()+"/"+b.ID.String(), "").Code)
+}
+
+func TestAPI_Feeds(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	rec := doRequest(s, "POST", "/api/v1/feeds", `{"url":"https://example.com/feed.xml","name":"Example"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Equal(t, http.StatusBadRequest, doRequest(s, "POST", "/api/v1/feeds", `{"url":"https://example.com/feed.xml","interval":"1m"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(s, "POST", "/api/v1/feeds", `{"url":"feed.xml"}`).Code)
+
+	rec = doRequest(s, "GET", "/api/v1/feeds", "")
+	var feeds feedsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &feeds))
+	require.Len(t, feeds.Feeds, 1)
+	assert.Equal(t, "example", feeds.Feeds[0].Name)
+
+	assert.Equal(t, http.StatusNoContent, doRequest(s, "DELETE", "/api/v1/feeds?url="+url.QueryEscape("https://example.com/feed.xml"), "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(s, "DELETE", "/api/v1/feeds?url="+url.QueryEscape("https://example.com/feed.xml"), "").Code)
+}
+
+func TestAPI_DeadLetters(t *testing.T) {
+	s, st := newTestServer(t)
+	ctx := context.Background()
+	a := model.NewArticle("https://example.com/broken")
+	require.NoError(t, st.Save(ctx, &a))
+	id, err := st.PopQueue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, st.DeadLetter(ctx, id))
+
+	rec := doRequest(s, "GET", "/api/v1/queue/dead", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var dead deadLettersResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &dead))
+	assert.Equal(t, []uuid.UUID{a.ID}, dead.IDs)
+
+	path := "/api/v1/queue/dead/" + a.ID.String() + "/requeue"
+	assert.Equal(t, http.StatusNoContent, doRequest(s, "POST", path, "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(s, "POST", path, "").Code)
+	assert.JSONEq(t, `{"ids":[]}`, doRequest(s, "GET", "/api/v1/queue/dead", "").Body.String())
+}
+
+func TestAtomFeedAndOPDS(t *testing.T) {
+	s, st := newTestServer(t)
+	ctx := context.Background()
+
+	archived := func(title string, tags ...string) model.Article {
+		a := model.NewArticle("https://example.com/" + strings.ToLower(title))
+		a.Status, a.Title, a.Byline, a.Tags = model.StatusArchived, title, "Jo", tags
+		a.Content = `<p>` + title + ` body <img src="/assets/abc"></p>`
+		require.NoError(t, st.Save(ctx, &a))
+		return a
+	}
+	goPost := archived("Gophers", "go")
+	other := archived("Other")
+	pending := model.NewArticle("https://example.com/pending")
+	require.NoError(t, st.Save(ctx, &pending))
+	read := true
+	_, err := store.UpdateFlags(ctx, st, other.ID, store.FlagUpdate{Read: &read})
+	require.NoError(t, err)
+
+	var feed atomFeed
+	rec := doRequest(s, "GET", "/feed.atom", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "application/atom+xml")
+	require.NoError(t, xml.Unmarshal(rec.Body.Bytes(), &feed))
+	require.Len(t, feed.Entries, 2, "Only archived articles")
+	assert.Equal(t, "Other", feed.Entries[0].Title)
+	assert.Contains(t, feed.Entries[1].Content.Body, `<img src="http://example.com/assets/abc">`)
+	assert.Equal(t, "Jo", feed.Entries[1].Author.Name)
+
+	feed = atomFeed{}
+	rec = doRequest(s, "GET", "/feed.atom?tag=go", "")
+	require.NoError(t, xml.Unmarshal(rec.Body.Bytes(), &feed))
+	require.Len(t, feed.Entries, 1)
+	assert.Equal(t, "urn:uuid:"+goPost.ID.String(), feed.Entries[0].ID)
+
+	feed = atomFeed{}
+	rec = doRequest(s, "GET", "/feed.atom?unread=true", "")
+	require.NoError(t, xml.Unmarshal(rec.Body.Bytes(), &feed))
+	require.Len(t, feed.Entries, 1)
+	assert.Equal(t, "Gophers", feed.Entries[0].Title)
+
+	// The catalog: root -> tags -> a tag's shelf -> a download
+	rec = doRequest(s, "GET", "/opds", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "kind=navigation")
+	assert.Contains(t, rec.Body.String(), `href="http://example.com/opds/unread"`)
+	rec = doRequest(s, "GET", "/opds/tags", "")
+	assert.Contains(t, rec.Body.String(), `href="http://example.com/opds/tag/go"`)
+
+	feed = atomFeed{}
+	rec = doRequest(s, "GET", "/opds/tag/go", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "kind=acquisition")
+	require.NoError(t, xml.Unmarshal(rec.Body.Bytes(), &feed))
+	require.Len(t, feed.Entries, 1)
+	downloads := make(map[string]string)
+	for _, l := range feed.Entries[0].Links {
+		if l.Rel == opdsAcquisition {
+			downloads[l.Type] = strings.TrimPrefix(l.Href, "http://example.com")
+		}
+	}
+	download := downloads["text/html"]
+	require.Equal(t, "/download/"+goPost.ID.String()+".html", download)
+	require.Equal(t, "/export/"+goPost.ID.String()+".epub", downloads[epubType])
+	assert.Equal(t, http.StatusNotFound, doRequest(s, "GET", "/opds/nope", "").Code)
+
+	rec = doRequest(s, "GET", download, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `attachment; filename="gophers.html"`, rec.Header().Get("Content-Disposition"))
+	assert.Contains(t, rec.Body.String(), "<h1>Gophers</h1>")
+	assert.Contains(t, rec.Body.String(), "<p>Gophers body")
+	assert.Equal(t, http.StatusNotFound, doRequest(s, "GET", "/download/"+pending.ID.String()+".html", "").Code)
+}
+
+func TestExportEPUB(t *testing.T) {
+	s, st := newTestServer(t)
+	ctx := context.Background()
+
+	img := []byte("GIF89a")
+	hash := fmt.Sprintf("%x", sha256.Sum256(img))
+	require.NoError(t, st.PutAsset(ctx, store.Asset{Hash: hash, ContentType: "image/gif", Data: img}))
+	a := model.NewArticle("https://example.com/gophers")
+	a.Status, a.Title = model.StatusArchived, "Gophers!"
+	a.Content = `<p>Body<img src="/assets/` + hash + `"></p>`
+	require.NoError(t, st.Save(ctx, &a))
+
+	rec := doRequest(s, "GET", "/export/"+a.ID.String()+".epub", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, epubType, rec.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="gophers.epub"`, rec.Header().Get("Content-Disposition"))
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	require.NoError(t, err)
+	assert.Equal(t, "mimetype", zr.File[0].Name)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.Contains(t, names, "OEBPS/images/001.gif")
+
+	pending := model.NewArticle("https://example.com/pending")
+	require.NoError(t, st.Save(ctx, &pending))
+	assert.Equal(t, http.StatusNotFound, doRequest(s, "GET", "/export/"+pending.ID.String()+".epub", "").Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(s, "GET", "/export/nope.epub", "").Code)
+}
+
+func TestAPI_GetArticleFormats(t *testing.T) {
+	s, st := newTestServer(t)
+	a := model.NewArticle("https://example.com/gophers")
+	a.Status, a.Title, a.Byline = model.StatusArchived, "Gophers", "Jo"
+	a.Content = `<h2>Burrows</h2><p>Dig <b>deep</b>.<img src="/assets/abc" alt="hole"></p>`
+	require.NoError(t, st.Save(context.Background(), &a))
+
+	rec := doRequest(s, "GET", "/api/v1/articles/"+a.ID.String()+"?format=markdown", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/markdown; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "author: Jo\n")
+	assert.Contains(t, rec.Body.String(), "# Gophers\n\n## Burrows\n\nDig **deep**.![hole](http://example.com/assets/abc)\n")
+
+	rec = doRequest(s, "GET", "/api/v1/articles/"+a.ID.String()+"?format=text", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "\nBurrows\n\nDig deep.\n")
+}
+
+// doRestore posts a backup from remoteAddr
+func doRestore(s *Server, query, remoteAddr, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/v1/restore"+query, strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPI_BackupAndRestore(t *testing.T) {
+	s, st := newTestServer(t)
+	a := model.NewArticle("https://example.com/gophers")
+	a.Status, a.Title, a.Content = model.StatusArchived, "Gophers", "<p>Dig.</p>"
+	require.NoError(t, st.Save(context.Background(), &a))
+
+	rec := doRequest(s, "GET", "/api/v1/backup", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, backupType, rec.Header().Get("Content-Type"))
+	backup := rec.Body.String()
+
+	other, otherStore := newTestServer(t)
+	rec = doRestore(other, "", "192.0.2.1:1234", backup)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "Only from this machine")
+	other.maxRestoreSize = 100
+	rec = doRestore(other, "", "127.0.0.1:1234", backup)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	other.maxRestoreSize = DefaultMaxRestoreSize
+
+	rec = doRestore(other, "", "[::1]:1234", backup)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resp restoreResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Articles)
+	assert.Equal(t, 1, resp.Contents)
+	assert.Empty(t, resp.Problems)
+	got, err := otherStore.Get(context.Background(), a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Dig.</p>", got.Content)
+
+	rec = doRestore(other, "", "127.0.0.1:1234", backup)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "not_empty")
+	WithRemoteRestore(true)(other)
+	assert.Equal(t, http.StatusOK, doRestore(other, "?merge=true", "192.0.2.1:1234", backup).Code)
+
+	rec = doRestore(other, "?merge=true", "127.0.0.1:1234", backup[:len(backup)/2])
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid_backup")
+}
@@ -0,0 +1,407 @@
+package web
+
+import (
+	"encoding/xml"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Atom documents, for /feed.atom and the OPDS catalog (which is Atom too)
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"feed"`
+	Xmlns     string      `xml:"xmlns,attr"`
+	XmlnsDC   string      `xml:"xmlns:dc,attr,omitempty"`
+	XmlnsOPDS string      `xml:"xmlns:opds,attr,omitempty"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Author    *atomPerson `xml:"author,omitempty"`
+	Links     []atomLink  `xml:"link"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published,omitempty"`
+	Author     *atomPerson    `xml:"author,omitempty"`
+	Language   string         `xml:"dc:language,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    *atomText      `xml:"content,omitempty"`
+	Links      []atomLink     `xml:"link"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+const (
+	atomType           = "application/atom+xml"
+	opdsNavigationType = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquireType    = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opdsAcquisition    = "http://opds-spec.org/acquisition"
+	opdsImage          = "http://opds-spec.org/image"
+	feedPageSize       = 50
+)
+
+// baseURL is where this server is reachable from the client's side, for the
+// absolute links feeds need. A proxy in front says so with X-Forwarded-Proto.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "https" || proto == "http" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// absoluteAssets points the /assets/ images in content at base, since feed
+// readers don't resolve paths against the server
+func absoluteAssets(content, base string) string {
+	return strings.ReplaceAll(content, `"`+assetPrefix, `"`+base+assetPrefix)
+}
+
+// assetPrefix is where handleAsset serves stored images
+const assetPrefix = "/assets/"
+
+func atomTime(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+
+// updated is when an article last changed in a way a reader cares about
+func updated(a *model.Article) time.Time {
+	if a.ArchivedAt != nil {
+		return *a.ArchivedAt
+	}
+	return a.CreatedAt
+}
+
+// atomArticle fills in what both feeds say about an article
+func atomArticle(a *model.Article) atomEntry {
+	e := atomEntry{
+		ID:       "urn:uuid:" + a.ID.String(),
+		Title:    a.Title,
+		Updated:  atomTime(updated(a)),
+		Language: a.Language,
+		Links:    []atomLink{{Rel: "alternate", Href: a.URL, Type: "text/html"}},
+	}
+	if e.Title == "" {
+		e.Title = a.URL
+	}
+	if a.PublishedTime != nil {
+		e.Published = atomTime(*a.PublishedTime)
+	}
+	if a.Byline != "" {
+		e.Author = &atomPerson{Name: a.Byline}
+	}
+	for _, tag := range a.Tags {
+		e.Categories = append(e.Categories, atomCategory{Term: tag})
+	}
+	if a.Excerpt != "" {
+		e.Summary = &atomText{Type: "text", Body: a.Excerpt}
+	}
+	return e
+}
+
+// listArchived reads one page of archived articles, with their content
+func (s *Server) listArchived(r *http.Request, opts store.ListOptions) ([]*model.Article, string, error) {
+	opts.Status = []model.ArticleStatus{model.StatusArchived}
+	opts.Limit = feedPageSize
+	opts.Cursor = r.URL.Query().Get("cursor")
+	page, next, err := s.store.List(r.Context(), opts)
+	if err != nil {
+		return nil, "", err
+	}
+	articles := make([]*model.Article, 0, len(page))
+	for _, a := range page {
+		full, err := s.store.Get(r.Context(), a.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue // Purged since the listing
+		} else if err != nil {
+			return nil, "", err
+		}
+		articles = append(articles, full)
+	}
+	return articles, next, nil
+}
+
+func (s *Server) writeFeed(w http.ResponseWriter, contentType string, f atomFeed) {
+	w.Header().Set("Content-Type", contentType+";charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(f); err != nil {
+		s.logger.Error("Failed to write feed", zap.Error(err))
+	}
+}
+
+func (s *Server) feedError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	s.logger.Error("Failed to list articles for a feed", zap.Error(err))
+	http.Error(w, "Database error", http.StatusInternalServerError)
+}
+
+// handleAtom serves the newest archived articles, full content included.
+// ?tag= and ?unread=true narrow it down.
+func (s *Server) handleAtom(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := store.ListOptions{Tag: model.NormalizeTag(q.Get("tag"))}
+	opts.Unread, _ = strconv.ParseBool(q.Get("unread"))
+	articles, next, err := s.listArchived(r, opts)
+	if err != nil {
+		s.feedError(w, err)
+		return
+	}
+
+	base := baseURL(r)
+	title := "crusty-buffer"
+	self := url.Values{}
+	if opts.Tag != "" {
+		title += " #" + opts.Tag
+		self.Set("tag", opts.Tag)
+	}
+	if opts.Unread {
+		title += " (unread)"
+		self.Set("unread", "true")
+	}
+	selfURL := base + "/feed.atom"
+	if len(self) > 0 {
+		selfURL += "?" + self.Encode()
+	}
+
+	f := atomFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		XmlnsDC: "http://purl.org/dc/terms/",
+		ID:      selfURL,
+		Title:   title,
+		Updated: atomTime(time.Now()),
+		Links:   []atomLink{{Rel: "self", Href: selfURL, Type: atomType}},
+	}
+	if len(articles) > 0 {
+		f.Updated = atomTime(updated(articles[0]))
+	}
+	if next != "" {
+		self.Set("cursor", next)
+		f.Links = append(f.Links, atomLink{Rel: "next", Href: base + "/feed.atom?" + self.Encode(), Type: atomType})
+	}
+	for _, a := range articles {
+		e := atomArticle(a)
+		e.Links = append(e.Links, atomLink{Rel: "related", Href: base + "/view/" + a.ID.String(), Type: "text/html", Title: "crusty-buffer"})
+		e.Content = &atomText{Type: "html", Body: absoluteAssets(a.Content, base)}
+		f.Entries = append(f.Entries, e)
+	}
+	s.writeFeed(w, atomType, f)
+}
+
+// opdsFeed starts an OPDS catalog document; the root is its "start" link
+func opdsFeed(base, path, title string) atomFeed {
+	return atomFeed{
+		Xmlns:     "http://www.w3.org/2005/Atom",
+		XmlnsDC:   "http://purl.org/dc/terms/",
+		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+		ID:        base + path,
+		Title:     title,
+		Updated:   atomTime(time.Now()),
+		Author:    &atomPerson{Name: "crusty-buffer"},
+		Links: []atomLink{
+			{Rel: "self", Href: base + path, Type: opdsNavigationType},
+			{Rel: "start", Href: base + "/opds", Type: opdsNavigationType},
+		},
+	}
+}
+
+// opdsShelves are the acquisition feeds the root catalog links to
+var opdsShelves = []struct {
+	Name, Title, Summary string
+	Opts                 store.ListOptions
+}{
+	{"recent", "Recent", "Everything archived, newest first", store.ListOptions{}},
+	{"unread", "Unread", "Not read yet", store.ListOptions{Unread: true}},
+	{"favorites", "Favorites", "Starred articles", store.ListOptions{Favorite: true}},
+}
+
+// handleOPDS is the root of the catalog: the shelves, plus a way in by tag
+func (s *Server) handleOPDS(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	f := opdsFeed(base, "/opds", "crusty-buffer")
+	for _, shelf := range opdsShelves {
+		f.Entries = append(f.Entries, navEntry(base, "/opds/"+shelf.Name, shelf.Title, shelf.Summary, opdsAcquireType))
+	}
+	f.Entries = append(f.Entries, navEntry(base, "/opds/tags", "Tags", "Articles by tag", opdsNavigationType))
+	s.writeFeed(w, opdsNavigationType, f)
+}
+
+func navEntry(base, path, title, summary, typ string) atomEntry {
+	return atomEntry{
+		ID:      base + path,
+		Title:   title,
+		Updated: atomTime(time.Now()),
+		Summary: &atomText{Type: "text", Body: summary},
+		Links:   []atomLink{{Rel: "subsection", Href: base + path, Type: typ}},
+	}
+}
+
+func (s *Server) handleOPDSTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.store.Tags(r.Context())
+	if err != nil {
+		s.feedError(w, err)
+		return
+	}
+	base := baseURL(r)
+	f := opdsFeed(base, "/opds/tags", "Tags")
+	for _, tag := range tags {
+		summary := strconv.Itoa(tag.Count) + " articles"
+		f.Entries = append(f.Entries, navEntry(base, "/opds/tag/"+url.PathEscape(tag.Tag), "#"+tag.Tag, summary, opdsAcquireType))
+	}
+	s.writeFeed(w, opdsNavigationType, f)
+}
+
+// handleOPDSShelf is an acquisition feed: one of opdsShelves, or a tag
+func (s *Server) handleOPDSShelf(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var opts store.ListOptions
+	var path, title string
+	if tag := vars["tag"]; tag != "" {
+		opts.Tag = model.NormalizeTag(tag)
+		path, title = "/opds/tag/"+url.PathEscape(opts.Tag), "#"+opts.Tag
+	} else {
+		found := false
+		for _, shelf := range opdsShelves {
+			if shelf.Name == vars["shelf"] {
+				opts, path, title, found = shelf.Opts, "/opds/"+shelf.Name, shelf.Title, true
+			}
+		}
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	articles, next, err := s.listArchived(r, opts)
+	if err != nil {
+		s.feedError(w, err)
+		return
+	}
+
+	base := baseURL(r)
+	f := opdsFeed(base, path, title)
+	f.Links[0].Type = opdsAcquireType
+	if next != "" {
+		f.Links = append(f.Links, atomLink{Rel: "next", Href: base + path + "?cursor=" + url.QueryEscape(next), Type: opdsAcquireType})
+	}
+	for _, a := range articles {
+		e := atomArticle(a)
+		for _, d := range downloads {
+			e.Links = append(e.Links, atomLink{Rel: opdsAcquisition, Href: base + d.Path + a.ID.String() + d.Ext, Type: d.Type})
+		}
+		if strings.HasPrefix(a.Image, assetPrefix) {
+			e.Links = append(e.Links, atomLink{Rel: opdsImage, Href: base + a.Image})
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	s.writeFeed(w, opdsAcquireType, f)
+}
+
+// downloads are the formats an article can be fetched in, for OPDS acquisition
+// links. Readers take the first one they understand.
+var downloads = []struct{ Path, Ext, Type string }{
+	{"/export/", ".epub", epubType},
+	{"/download/", ".html", "text/html"},
+}
+
+var downloadTemplate = template.Must(template.ParseFS(templateFS, "templates/download.html"))
+
+// handleDownload serves an archived article as one standalone HTML file,
+// which e-readers open without needing the rest of the site
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.archivedArticle(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+downloadName(a)+`.html"`)
+	data := map[string]interface{}{
+		"Article": a,
+		"Content": template.HTML(absoluteAssets(a.Content, baseURL(r))),
+	}
+	if err := downloadTemplate.Execute(w, data); err != nil {
+		s.logger.Error("Template error", zap.String("page", "download"), zap.Error(err))
+	}
+}
+
+// archivedArticle loads the archived article {id} with its content. If there
+// is none it has already answered the request and returns false.
+func (s *Server) archivedArticle(w http.ResponseWriter, r *http.Request) (*model.Article, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil, false
+	}
+	a, err := s.store.Get(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && a.Status != model.StatusArchived) {
+		http.NotFound(w, r)
+		return nil, false
+	} else if err != nil {
+		s.logger.Error("Failed to read article", zap.Error(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return a, true
+}
+
+// downloadName is a filename made from the title: lowercase ASCII words joined by dashes
+func downloadName(a *model.Article) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(a.Title) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+		if sb.Len() >= 60 {
+			break
+		}
+	}
+	if sb.Len() == 0 {
+		return a.ID.String()
+	}
+	return sb.String()
+}
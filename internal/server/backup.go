@@ -0,0 +1,121 @@
+package web
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"crusty-buffer/internal/store"
+
+	"go.uber.org/zap"
+)
+
+const backupType = "application/zstd"
+
+type restoreResponse struct {
+	store.BackupInfo
+	// Articles of the backup that didn't read back after loading it
+	Problems []string `json:"problems,omitempty"`
+}
+
+// startedWriter notes whether anything went out, after which an error can
+// only be logged
+type startedWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (s *startedWriter) Write(p []byte) (int, error) {
+	s.started = true
+	return s.w.Write(p)
+}
+
+// noDeadlines lifts the server's read and write timeouts for this request,
+// which takes as long as the store is big
+func (s *Server) noDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	for _, err := range []error{rc.SetReadDeadline(time.Time{}), rc.SetWriteDeadline(time.Time{})} {
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			s.logger.Warn("Failed to lift the request timeouts", zap.Error(err))
+		}
+	}
+}
+
+// fromLoopback says whether the request came from this machine
+func fromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// apiBackup streams a backup archive (see store.Store.Backup) as it's made
+func (s *Server) apiBackup(w http.ResponseWriter, r *http.Request) {
+	s.noDeadlines(w)
+	w.Header().Set("Content-Type", backupType)
+	w.Header().Set("Content-Disposition", `attachment; filename="crusty-`+time.Now().Format("2006-01-02")+`.tar.zst"`)
+	out := &startedWriter{w: w}
+	info, err := s.store.Backup(r.Context(), out)
+	if err != nil {
+		if !out.started {
+			w.Header().Del("Content-Disposition")
+			s.storeError(w, err)
+			return
+		}
+		s.logger.Error("Backup failed midway", zap.Error(err))
+		return
+	}
+	s.logger.Info("Backup sent", zap.Int("articles", info.Articles))
+}
+
+// apiRestore loads a backup posted as the body, then reads every article
+// back. It goes to a temporary file first, as loading takes two passes.
+// Without ?merge=true the store has to be empty. As it overwrites anything,
+// only clients on this machine may, unless WithRemoteRestore says otherwise.
+func (s *Server) apiRestore(w http.ResponseWriter, r *http.Request) {
+	if !s.remoteRestore && !fromLoopback(r) {
+		writeError(w, http.StatusForbidden, "forbidden", "restore is only accepted from the server's own machine (see --remote-restore)")
+		return
+	}
+	s.noDeadlines(w)
+	body := http.MaxBytesReader(w, r.Body, s.maxRestoreSize)
+
+	tmp, err := os.CreateTemp("", "crusty-restore-*.tar.zst")
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "too_large", "the backup is larger than the server accepts (see --max-restore-size)")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_body", "could not read the upload")
+		return
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		s.storeError(w, err)
+		return
+	}
+
+	info, err := s.store.LoadBackup(r.Context(), tmp, r.URL.Query().Get("merge") == "true")
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	problems, err := store.CheckRestored(r.Context(), s.store, info)
+	if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	s.logger.Info("Backup restored", zap.Int("articles", info.Articles), zap.Int("problems", len(problems)))
+	writeJSON(w, http.StatusOK, restoreResponse{BackupInfo: *info, Problems: problems})
+}
@@ -0,0 +1,57 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"crusty-buffer/internal/export"
+	"crusty-buffer/internal/model"
+
+	"go.uber.org/zap"
+)
+
+const epubType = "application/epub+zip"
+
+// handleExportEPUB serves an archived article as an EPUB, images included
+func (s *Server) handleExportEPUB(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.archivedArticle(w, r)
+	if !ok {
+		return
+	}
+
+	// Built in memory so a failure can still be a proper error page
+	var buf bytes.Buffer
+	if err := export.EPUB(r.Context(), &buf, s.store, "", []*model.Article{a}); err != nil {
+		s.logger.Error("Failed to build EPUB", zap.String("id", a.ID.String()), zap.Error(err))
+		http.Error(w, "Export failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", epubType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+downloadName(a)+`.epub"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// writeArticleAs answers GET /api/v1/articles/{id}?format=markdown|text.
+// Images point back at this server.
+func (s *Server) writeArticleAs(w http.ResponseWriter, r *http.Request, a *model.Article, format string) {
+	var out []byte
+	var err error
+	contentType := "text/markdown; charset=utf-8"
+	if format == "text" {
+		var text string
+		text, err = export.Text(a)
+		out, contentType = []byte(text), "text/plain; charset=utf-8"
+	} else {
+		a.Content = absoluteAssets(a.Content, baseURL(r))
+		out, err = export.Markdown(a, nil)
+	}
+	if err != nil {
+		s.logger.Error("Failed to convert article", zap.String("id", a.ID.String()), zap.String("format", format), zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "internal", "internal server error")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(out)
+}
@@ -2,8 +2,13 @@ package web
 
 import (
 	"context"
+	"embed"
+	"errors"
 	"html/template"
+	"io/fs"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"crusty-buffer/internal/model"
@@ -14,73 +19,250 @@ import (
 	"go.uber.org/zap"
 )
 
+//go:embed templates
+var templateFS embed.FS
+
+//go:embed static
+var staticFS embed.FS
+
 type Server struct {
-	store  store.Store
-	logger *zap.Logger
-	router *mux.Router
-	server *http.Server
+	store       store.Store
+	logger      *zap.Logger
+	router      *mux.Router
+	server      *http.Server
+	templates   map[string]*template.Template
+	reprocessor Reprocessor
+
+	// POST /api/v1/restore: from other machines too, and how big
+	remoteRestore  bool
+	maxRestoreSize int64
+}
+
+// DefaultMaxRestoreSize caps the backup POST /api/v1/restore accepts
+const DefaultMaxRestoreSize = 16 << 30
+
+// Reprocessor re-runs extraction on an article's stored raw HTML (the worker implements it)
+type Reprocessor interface {
+	Reprocess(ctx context.Context, id uuid.UUID) (*model.Article, error)
 }
 
-func NewServer(st store.Store, logger *zap.Logger) *Server {
+// Option tweaks a Server at construction time
+type Option func(*Server)
+
+// WithReprocessor enables POST /api/v1/articles/{id}/reprocess
+func WithReprocessor(r Reprocessor) Option {
+	return func(s *Server) {
+		s.reprocessor = r
+	}
+}
+
+// WithRemoteRestore lets POST /api/v1/restore come from other machines, not
+// only this one. There's no authentication, so only for a trusted network.
+func WithRemoteRestore(allow bool) Option {
+	return func(s *Server) {
+		s.remoteRestore = allow
+	}
+}
+
+// WithMaxRestoreSize caps the backup POST /api/v1/restore accepts, in bytes (default 16GiB)
+func WithMaxRestoreSize(n int64) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.maxRestoreSize = n
+		}
+	}
+}
+
+func NewServer(st store.Store, logger *zap.Logger, opts ...Option) *Server {
 	s := &Server{
-		store:  st,
-		logger: logger,
-		router: mux.NewRouter(),
+		store:          st,
+		logger:         logger,
+		router:         mux.NewRouter(),
+		templates:      parseTemplates(),
+		maxRestoreSize: DefaultMaxRestoreSize,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 	s.routes()
 	return s
 }
 
+// parseTemplates builds one template set per page. Every page shares the
+// layout, so each set gets its own copy to keep the "content" blocks apart.
+// The files are embedded, so a parse error is a build bug, not a runtime one.
+func parseTemplates() map[string]*template.Template {
+	pages := map[string][]string{
+		"index":  {"templates/layout.html", "templates/index.html", "templates/partials/archive_card.html"},
+		"view":   {"templates/layout.html", "templates/view.html"},
+		"search": {"templates/layout.html", "templates/search.html"},
+		"trash":  {"templates/layout.html", "templates/trash.html"},
+		"diff":   {"templates/layout.html", "templates/diff.html"},
+		"tag":    {"templates/layout.html", "templates/tag.html", "templates/partials/archive_card.html"},
+	}
+	funcs := template.FuncMap{"highlight": highlight}
+
+	templates := make(map[string]*template.Template, len(pages))
+	for name, files := range pages {
+		templates[name] = template.Must(template.New(name).Funcs(funcs).ParseFS(templateFS, files...))
+	}
+	return templates
+}
+
+// highlight wraps the matched ranges of a search snippet in <mark>, escaping everything else
+func highlight(snippet string, ranges [][2]int) template.HTML {
+	var sb strings.Builder
+	pos := 0
+	for _, r := range ranges {
+		if r[0] < pos || r[1] > len(snippet) || r[0] >= r[1] {
+			continue
+		}
+		sb.WriteString(template.HTMLEscapeString(snippet[pos:r[0]]))
+		sb.WriteString("<mark>")
+		sb.WriteString(template.HTMLEscapeString(snippet[r[0]:r[1]]))
+		sb.WriteString("</mark>")
+		pos = r[1]
+	}
+	sb.WriteString(template.HTMLEscapeString(snippet[pos:]))
+	return template.HTML(sb.String())
+}
+
 func (s *Server) routes() {
 	// Static Files (CSS)
-	s.router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	static, _ := fs.Sub(staticFS, "static")
+	s.router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.FS(static))))
 
 	// App Routes
 	s.router.HandleFunc("/", s.handleIndex).Methods("GET")
 	s.router.HandleFunc("/add", s.handleAdd).Methods("POST")
+	s.router.HandleFunc("/search", s.handleSearch).Methods("GET")
 	s.router.HandleFunc("/view/{id}", s.handleView).Methods("GET")
+	s.router.HandleFunc("/view/{id}/raw", s.handleViewRaw).Methods("GET")
+	s.router.HandleFunc("/view/{id}/delete", s.handleTrash).Methods("POST")
+	s.router.HandleFunc("/view/{id}/restore", s.handleRestore).Methods("POST")
+	s.router.HandleFunc("/view/{id}/purge", s.handlePurge).Methods("POST")
+	s.router.HandleFunc("/view/{id}/tags", s.handleTags).Methods("POST")
+	s.router.HandleFunc("/view/{id}/flags", s.handleFlags).Methods("POST")
+	s.router.HandleFunc("/tag/{name}", s.handleTagList).Methods("GET")
+	s.router.HandleFunc("/trash", s.handleTrashList).Methods("GET")
+	s.router.HandleFunc("/diff/{from}/{to}", s.handleDiff).Methods("GET")
+	s.router.HandleFunc("/assets/{hash:[0-9a-f]{64}}", s.handleAsset).Methods("GET")
+	s.router.HandleFunc("/download/{id}.html", s.handleDownload).Methods("GET")
+	s.router.HandleFunc("/export/{id}.epub", s.handleExportEPUB).Methods("GET")
+
+	// Feeds: Atom for feed readers, OPDS for e-readers
+	s.router.HandleFunc("/feed.atom", s.handleAtom).Methods("GET")
+	s.router.HandleFunc("/opds", s.handleOPDS).Methods("GET")
+	s.router.HandleFunc("/opds/tags", s.handleOPDSTags).Methods("GET")
+	s.router.HandleFunc("/opds/tag/{tag}", s.handleOPDSShelf).Methods("GET")
+	s.router.HandleFunc("/opds/{shelf}", s.handleOPDSShelf).Methods("GET")
+
+	// JSON API
+	s.apiRoutes()
 }
 
-// Start launches the HTTP server
-func (s *Server) Start(port string) error {
+// Start launches the HTTP server and blocks until it stops.
+// It returns http.ErrServerClosed after a graceful Stop. Handlers that move
+// a whole store (backup, restore) lift the timeouts for themselves.
+func (s *Server) Start(addr string) error {
 	s.server = &http.Server{
-		Addr:         ":" + port,
+		Addr:         addr,
 		Handler:      s.router,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 	}
 
-	s.logger.Info("Web server listening", zap.String("addr", port))
+	s.logger.Info("Web server listening", zap.String("addr", addr))
 	return s.server.ListenAndServe()
 }
 
 // Stop gracefully shuts down
 func (s *Server) Stop(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
 	return s.server.Shutdown(ctx)
 }
 
+// render executes a page template inside the shared layout
+func (s *Server) render(w http.ResponseWriter, page string, data interface{}) {
+	tmpl, ok := s.templates[page]
+	if !ok {
+		s.logger.Error("Unknown template", zap.String("page", page))
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(w, "layout", data); err != nil {
+		s.logger.Error("Template error", zap.String("page", page), zap.Error(err))
+	}
+}
+
+// indexViews are the tabs on the home page, in order; the first is the default
+var indexViews = []struct {
+	Name, Label string
+	Opts        store.ListOptions
+}{
+	{"unread", "Unread", store.ListOptions{Unread: true}},
+	{"inbox", "Inbox", store.ListOptions{Folder: model.FolderInbox}},
+	{"favorites", "Favorites", store.ListOptions{Favorite: true}},
+	{"archive", "Archive", store.ListOptions{Folder: model.FolderArchive}},
+	{"all", "All", store.ListOptions{}},
+}
 
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	// Fetch recent articles
-	articles, err := s.store.List(r.Context(), 50)
-	if err != nil {
+	view := indexViews[0]
+	for _, v := range indexViews {
+		if v.Name == r.URL.Query().Get("view") {
+			view = v
+		}
+	}
+
+	// Fetch the view's articles, a page at a time
+	opts := view.Opts
+	opts.Limit = 50
+	opts.Cursor = r.URL.Query().Get("cursor")
+	articles, next, err := s.store.List(r.Context(), opts)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	} else if err != nil {
 		s.logger.Error("Failed to list articles", zap.Error(err))
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Render Template
-	tmpl, err := template.ParseFiles("templates/layout.html", "templates/index.html", "templates/partials/archive_card.html")
-	if err != nil {
-		s.logger.Error("Template error", zap.Error(err))
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		return
+	data := map[string]interface{}{
+		"Articles":   articles,
+		"NextCursor": next,
+		"View":       view.Name,
+		"Views":      indexViews,
 	}
+	s.render(w, "index", data)
+}
 
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
 	data := map[string]interface{}{
-		"Articles": articles,
+		"Title": "Search",
+		"Query": q,
 	}
-	tmpl.Execute(w, data)
+
+	if q != "" {
+		results, next, err := s.store.Search(r.Context(), q, store.SearchOptions{Cursor: r.URL.Query().Get("cursor")})
+		if errors.Is(err, store.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			s.logger.Error("Search failed", zap.Error(err))
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		data["Results"] = results
+		data["NextCursor"] = next
+	}
+	s.render(w, "search", data)
 }
 
 func (s *Server) handleView(w http.ResponseWriter, r *http.Request) {
@@ -100,22 +282,300 @@ func (s *Server) handleView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Render Template
-	// Note: We use template.HTML to trust the content (since we stripped bad tags already)
-	tmpl, err := template.ParseFiles("templates/layout.html", "templates/view.html")
+	// Opening an article is reading it. Pending and trashed ones have nothing to read yet.
+	if article.ReadAt == nil && article.Status == model.StatusArchived && article.DeletedAt == nil {
+		read := true
+		if updated, err := store.UpdateFlags(r.Context(), s.store, id, store.FlagUpdate{Read: &read}); err != nil {
+			s.logger.Warn("Failed to mark article read", zap.Error(err))
+		} else {
+			article.ReadAt = updated.ReadAt
+		}
+	}
+
+	// Older snapshots of the same page, newest first
+	snapshots, err := store.Snapshots(r.Context(), s.store, article.Canonical())
 	if err != nil {
-		s.logger.Error("Template error", zap.Error(err))
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		return
+		s.logger.Warn("Failed to list snapshots", zap.Error(err))
+	}
+	var earlier []model.Article
+	for _, a := range snapshots {
+		if a.CreatedAt.Before(article.CreatedAt) {
+			earlier = append(earlier, a)
+		}
 	}
 
+	// Note: We use template.HTML to trust the content. The worker runs it through
+	// internal/sanitize before saving; articles archived before that need `crusty sanitize --all`.
 	data := map[string]interface{}{
-		"Title":      article.Title,
-		"Content":    template.HTML(article.Content), 
+		"ID":          article.ID,
+		"Title":       article.Title,
+		"Content":     template.HTML(article.Content),
 		"OriginalURL": article.URL,
-		"Date":       article.CreatedAt.Format("Jan 02, 2006"),
+		"Date":        article.CreatedAt.Format("Jan 02, 2006"),
+		"Byline":      article.Byline,
+		"Site":        article.Site(),
+		"Image":       article.Image,
+		"Language":    article.Language,
+		"Published":   article.PublishedTime,
+		"ReadingTime": article.ReadingTime,
+		"WordCount":   article.WordCount,
+		"DeletedAt":   article.DeletedAt,
+		"Tags":        article.Tags,
+		"ReadAt":      article.ReadAt,
+		"Favorite":    article.Favorite,
+		"Folder":      article.InFolder(),
+		"Earlier":     earlier,
+		"PreviousID":  article.PreviousID,
+	}
+	s.render(w, "view", data)
+}
+
+// diffLine is one line of a unified diff, with the CSS class for its kind
+type diffLine struct {
+	Class string
+	Text  string
+}
+
+// handleDiff shows what changed in the text between two snapshots
+func (s *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	from, err1 := uuid.Parse(mux.Vars(r)["from"])
+	to, err2 := uuid.Parse(mux.Vars(r)["to"])
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	d, err := store.SnapshotDiff(r.Context(), s.store, from, to)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to diff snapshots", zap.Error(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	// SnapshotDiff may have stood in for a purged from; show it as just the ID then
+	a := &model.Article{ID: from}
+	if got, err := s.store.Get(r.Context(), from); err == nil {
+		a = got
+	}
+	b, err := s.store.Get(r.Context(), to)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var lines []diffLine
+	for _, line := range strings.Split(strings.TrimSuffix(d, "\n"), "\n") {
+		switch {
+		case line == "", strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "):
+			// The page header says which snapshots these are
+		case strings.HasPrefix(line, "@@"):
+			lines = append(lines, diffLine{"hunk", line})
+		case strings.HasPrefix(line, "+"):
+			lines = append(lines, diffLine{"ins", line})
+		case strings.HasPrefix(line, "-"):
+			lines = append(lines, diffLine{"del", line})
+		default:
+			lines = append(lines, diffLine{"", line})
+		}
+	}
+
+	s.render(w, "diff", map[string]interface{}{
+		"Title": "Changes to " + b.Title,
+		"From":  a,
+		"To":    b,
+		"Lines": lines,
+	})
+}
+
+// handleViewRaw serves the page exactly as it was downloaded.
+// It is someone else's HTML, scripts and all, so it gets a sandboxed CSP:
+// nothing runs and it can't touch our origin.
+func (s *Server) handleViewRaw(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	raw, err := s.store.GetRaw(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to read raw html", zap.Error(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "sandbox; default-src 'none'; img-src * data:; style-src * 'unsafe-inline'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(raw)
+}
+
+// handleAsset serves a stored image. Blobs are content-addressed, so they never
+// change and can be cached forever. SVGs can carry script, hence the sandbox.
+func (s *Server) handleAsset(w http.ResponseWriter, r *http.Request) {
+	asset, err := s.store.GetAsset(r.Context(), mux.Vars(r)["hash"])
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to read asset", zap.Error(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", asset.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Content-Security-Policy", "sandbox; default-src 'none'; style-src 'unsafe-inline'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(asset.Data)
+}
+
+// handleTrash moves an article to the trash; it can be restored from /trash
+func (s *Server) handleTrash(w http.ResponseWriter, r *http.Request) {
+	s.changeArticle(w, r, s.store.Trash, "/")
+}
+
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	s.changeArticle(w, r, s.store.Restore, "/view/"+mux.Vars(r)["id"])
+}
+
+func (s *Server) handlePurge(w http.ResponseWriter, r *http.Request) {
+	s.changeArticle(w, r, s.store.Delete, "/trash")
+}
+
+// changeArticle runs one of the store's per-article actions for a form post and redirects
+func (s *Server) changeArticle(w http.ResponseWriter, r *http.Request, action func(context.Context, uuid.UUID) error, redirect string) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
 	}
-	tmpl.Execute(w, data)
+
+	if err := action(r.Context(), id); errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to update article", zap.Error(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+func (s *Server) handleTrashList(w http.ResponseWriter, r *http.Request) {
+	articles, next, err := s.store.List(r.Context(), store.ListOptions{Limit: 50, Cursor: r.URL.Query().Get("cursor"), Trashed: true})
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to list trash", zap.Error(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":      "Trash",
+		"Articles":   articles,
+		"NextCursor": next,
+	}
+	s.render(w, "trash", data)
+}
+
+// handleFlags sets whichever of read, favorite and folder the form has, then
+// goes to next (a local path) or back to the article
+func (s *Server) handleFlags(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	var u store.FlagUpdate
+	if raw := r.PostForm.Get("read"); raw != "" {
+		read, _ := strconv.ParseBool(raw)
+		u.Read = &read
+	}
+	if raw := r.PostForm.Get("favorite"); raw != "" {
+		favorite, _ := strconv.ParseBool(raw)
+		u.Favorite = &favorite
+	}
+	if raw := r.PostForm.Get("folder"); raw != "" {
+		folder := model.Folder(raw)
+		u.Folder = &folder
+	}
+
+	_, err = store.UpdateFlags(r.Context(), s.store, id, u)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if errors.Is(err, store.ErrInvalidFolder) {
+		http.Error(w, "Invalid folder", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to update article", zap.Error(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	next := r.PostForm.Get("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/view/" + id.String()
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// handleTags adds the comma-separated tags in "add" and removes every "remove"
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	_, err = store.UpdateTags(r.Context(), s.store, id, strings.Split(r.PostForm.Get("add"), ","), r.PostForm["remove"])
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to update tags", zap.Error(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/view/"+id.String(), http.StatusSeeOther)
+}
+
+func (s *Server) handleTagList(w http.ResponseWriter, r *http.Request) {
+	tag := model.NormalizeTag(mux.Vars(r)["name"])
+	articles, next, err := s.store.List(r.Context(), store.ListOptions{Limit: 50, Cursor: r.URL.Query().Get("cursor"), Tag: tag})
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to list tag", zap.Error(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":      "#" + tag,
+		"Tag":        tag,
+		"Articles":   articles,
+		"NextCursor": next,
+	}
+	s.render(w, "tag", data)
 }
 
 func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
@@ -125,8 +585,8 @@ func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	article := model.NewArticle(url)
-	if err := s.store.Save(r.Context(), &article); err != nil {
+	tags := strings.Split(r.FormValue("tags"), ",")
+	if _, _, err := store.Add(r.Context(), s.store, url, tags, store.DedupeVersion); err != nil {
 		s.logger.Error("Failed to queue article", zap.Error(err))
 		http.Error(w, "Failed to save", http.StatusInternalServerError)
 		return
@@ -134,4 +594,4 @@ func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
 
 	// Redirect back home
 	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
\ No newline at end of file
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+)
+
+// Asset is a downloaded image, stored once no matter how many articles use it
+type Asset struct {
+	Hash        string // hex SHA-256 of Data
+	ContentType string
+	Data        []byte
+}
+
+// AssetRef records which remote URL an article's local asset came from.
+// Keeping the URL lets reprocessing rewrite <img> tags without the network.
+type AssetRef struct {
+	URL  string `json:"url"`
+	Hash string `json:"hash"`
+}
+
+// Asset keys live in Badger for both stores:
+//
+//	asset:<hash>              content type length (1 byte) + content type + bytes
+//	assets:<articleID>        JSON []AssetRef, the article's manifest
+//	assetref:<hash>:<id>      empty; one per article using the blob, for GC
+const (
+	prefixAsset    = "asset:"
+	prefixManifest = "assets:"
+	prefixAssetRef = "assetref:"
+)
+
+func assetKey(hash string) []byte     { return []byte(prefixAsset + hash) }
+func manifestKey(id uuid.UUID) []byte { return []byte(prefixManifest + id.String()) }
+func assetRefKey(hash string, id uuid.UUID) []byte {
+	return []byte(prefixAssetRef + hash + ":" + id.String())
+}
+
+func putAsset(db *badger.DB, a Asset) error {
+	if len(a.ContentType) > 255 {
+		return fmt.Errorf("content type too long")
+	}
+	return db.Update(func(txn *badger.Txn) error {
+		// Content-addressed: if it's there, it's identical
+		if _, err := txn.Get(assetKey(a.Hash)); err == nil {
+			return nil
+		}
+		val := make([]byte, 0, 1+len(a.ContentType)+len(a.Data))
+		val = append(val, byte(len(a.ContentType)))
+		val = append(val, a.ContentType...)
+		val = append(val, a.Data...)
+		return txn.Set(assetKey(a.Hash), val)
+	})
+}
+
+func getAsset(db *badger.DB, hash string) (*Asset, error) {
+	var a *Asset
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(assetKey(hash))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if len(val) < 1 || len(val) < 1+int(val[0]) {
+				return fmt.Errorf("corrupt asset %s", hash)
+			}
+			n := int(val[0])
+			a = &Asset{
+				Hash:        hash,
+				ContentType: string(val[1 : 1+n]),
+				Data:        append([]byte(nil), val[1+n:]...),
+			}
+			return nil
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	return a, err
+}
+
+func getManifest(txn *badger.Txn, id uuid.UUID) ([]AssetRef, error) {
+	var refs []AssetRef
+	err := getJSON(txn, manifestKey(id), &refs)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return refs, err
+}
+
+// setManifest replaces an article's manifest and garbage-collects blobs
+// that no article references anymore. refs=nil drops the manifest entirely.
+func setManifest(txn *badger.Txn, id uuid.UUID, refs []AssetRef) error {
+	old, err := getManifest(txn, id)
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool, len(refs))
+	for _, r := range refs {
+		keep[r.Hash] = true
+		if err := txn.Set(assetRefKey(r.Hash, id), nil); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range old {
+		if keep[r.Hash] {
+			continue
+		}
+		if err := txn.Delete(assetRefKey(r.Hash, id)); err != nil {
+			return err
+		}
+		if err := collectAsset(txn, r.Hash); err != nil {
+			return err
+		}
+	}
+
+	if len(refs) == 0 {
+		return txn.Delete(manifestKey(id))
+	}
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return err
+	}
+	return txn.Set(manifestKey(id), data)
+}
+
+// collectAsset deletes the blob if no assetref:<hash>:* key is left
+func collectAsset(txn *badger.Txn, hash string) error {
+	prefix := []byte(prefixAssetRef + hash + ":")
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+	it.Rewind()
+	inUse := it.Valid()
+	it.Close()
+
+	if inUse {
+		return nil
+	}
+	return txn.Delete(assetKey(hash))
+}
+
+// PutAsset stores a blob; storing the same hash twice is a no-op
+func (s *BadgerStore) PutAsset(ctx context.Context, a Asset) error {
+	return putAsset(s.db, a)
+}
+
+func (s *BadgerStore) GetAsset(ctx context.Context, hash string) (*Asset, error) {
+	return getAsset(s.db, hash)
+}
+
+func (s *BadgerStore) GetAssetManifest(ctx context.Context, id uuid.UUID) ([]AssetRef, error) {
+	var refs []AssetRef
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		refs, err = getManifest(txn, id)
+		return err
+	})
+	return refs, err
+}
+
+func (s *BadgerStore) SetAssetManifest(ctx context.Context, id uuid.UUID, refs []AssetRef) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return setManifest(txn, id, refs)
+	})
+}
+
+var errNoBadger = errors.New("badgerdb is not initialized")
+
+func (s *HybridStore) PutAsset(ctx context.Context, a Asset) error {
+	if s.db == nil {
+		return errNoBadger
+	}
+	return putAsset(s.db, a)
+}
+
+func (s *HybridStore) GetAsset(ctx context.Context, hash string) (*Asset, error) {
+	if s.db == nil {
+		return nil, errNoBadger
+	}
+	return getAsset(s.db, hash)
+}
+
+func (s *HybridStore) GetAssetManifest(ctx context.Context, id uuid.UUID) ([]AssetRef, error) {
+	if s.db == nil {
+		return nil, errNoBadger
+	}
+	var refs []AssetRef
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		refs, err = getManifest(txn, id)
+		return err
+	})
+	return refs, err
+}
+
+func (s *HybridStore) SetAssetManifest(ctx context.Context, id uuid.UUID, refs []AssetRef) error {
+	if s.db == nil {
+		return errNoBadger
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return setManifest(txn, id, refs)
+	})
+}
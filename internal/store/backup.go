@@ -0,0 +1,1128 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/protobuf/proto"
+)
+
+// A backup is a zstd-compressed tar of:
+//
+//	backup.json    backupHeader, always first
+//	redis.jsonl    one redisRecord per key (hybrid only)
+//	badger/000000  Badger's own backup stream (DB.Backup), cut into chunks
+//	manifest.json  sha256 of every entry above, always last
+//
+// Nothing in it is applied blindly on restore: Badger entries get fresh
+// versions, and queued jobs are queued again rather than copied.
+const (
+	backupFormat   = 1
+	backupHeader   = "backup.json"
+	backupRedis    = "redis.jsonl"
+	backupBadger   = "badger/"
+	backupManifest = "manifest.json"
+	backupChunk    = 16 << 20
+)
+
+var (
+	ErrInvalidBackup = errors.New("invalid backup")
+	ErrNotEmpty      = errors.New("store is not empty")
+)
+
+// BackupInfo describes a backup archive
+type BackupInfo struct {
+	Format     int       `json:"format"`
+	Backend    string    `json:"backend"`
+	CreatedAt  time.Time `json:"created_at"`
+	Articles   int       `json:"articles"`
+	Contents   int       `json:"contents"` // Articles with readable content
+	RedisKeys  int       `json:"redis_keys,omitempty"`
+	BadgerKeys int       `json:"badger_keys"`
+
+	// What CheckRestored looks for: every article, and whether it had content
+	articles map[uuid.UUID]bool
+}
+
+type backupHeaderJSON struct {
+	Format    int       `json:"format"`
+	Backend   string    `json:"backend"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type backupManifestJSON struct {
+	Sums map[string]string `json:"sha256"`
+}
+
+// redisRecord is one Redis key. Values are GET's one value, HGETALL's
+// fields and values, LRANGE, SMEMBERS, or ZRANGE's members and scores.
+type redisRecord struct {
+	Key    string   `json:"key"`
+	Type   string   `json:"type"`
+	Values []string `json:"values"`
+}
+
+// redisBackupKeys are all the keys HybridStore writes
+var redisBackupKeys = []string{"article:*", "list:*", "tag:*", "url:*", "queue:*", "feed:*", "watches", "feeds"}
+
+// Backup writes Redis and Badger to w. Redis goes first: whatever its
+// snapshot has, Badger has too by the time it's read.
+func (s *HybridStore) Backup(ctx context.Context, w io.Writer) (*BackupInfo, error) {
+	if s.db == nil {
+		return nil, errNoBadger
+	}
+	records, err := s.snapshotRedis(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("snapshotting redis: %w", err)
+	}
+	return writeBackup(w, "hybrid", records, s.db)
+}
+
+// snapshotRedis reads every key in redisBackupKeys without holding Redis up
+// the way one script would. The keys are found with SCAN and read while
+// WATCHed; a write to any of them, or a key showing up in between, makes
+// the empty MULTI at the end fail, and the read starts over.
+func (s *HybridStore) snapshotRedis(ctx context.Context) ([]redisRecord, error) {
+	for i := 0; i < maxRetries; i++ {
+		keys, err := s.scanBackupKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var records []redisRecord
+		err = s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			var err error
+			if records, err = readRecords(ctx, tx, keys); err != nil {
+				return err
+			}
+			// New keys aren't WATCHed, so look for them again
+			again, err := s.scanBackupKeys(ctx)
+			if err != nil {
+				return err
+			}
+			if !slices.Equal(keys, again) {
+				return redis.TxFailedErr
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Ping(ctx)
+				return nil
+			})
+			return err
+		}, keys...)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return records, err
+	}
+	return nil, ErrConflict
+}
+
+// scanBackupKeys lists the keys matching redisBackupKeys, sorted
+func (s *HybridStore) scanBackupKeys(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, pattern := range redisBackupKeys {
+		it := s.rdb.Scan(ctx, 0, pattern, 1000).Iterator()
+		for it.Next(ctx) {
+			seen[it.Val()] = true
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// readRecords reads keys in pipelined batches: their types, then their values
+func readRecords(ctx context.Context, tx *redis.Tx, keys []string) ([]redisRecord, error) {
+	const batch = 1000
+	records := make([]redisRecord, 0, len(keys))
+	for start := 0; start < len(keys); start += batch {
+		chunk := keys[start:min(start+batch, len(keys))]
+
+		types := make([]*redis.StatusCmd, len(chunk))
+		_, err := tx.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range chunk {
+				types[i] = pipe.Type(ctx, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		reads := make([]redis.Cmder, len(chunk))
+		_, err = tx.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range chunk {
+				switch types[i].Val() {
+				case "string":
+					reads[i] = pipe.Get(ctx, key)
+				case "hash":
+					reads[i] = pipe.HGetAll(ctx, key)
+				case "list":
+					reads[i] = pipe.LRange(ctx, key, 0, -1)
+				case "set":
+					reads[i] = pipe.SMembers(ctx, key)
+				case "zset":
+					reads[i] = pipe.ZRangeWithScores(ctx, key, 0, -1)
+				}
+			}
+			return nil
+		})
+		// A key gone since the scan reads as nil; the rescan catches it
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+
+		for i, key := range chunk {
+			rec := redisRecord{Key: key, Type: types[i].Val()}
+			switch cmd := reads[i].(type) {
+			case nil:
+				continue
+			case *redis.StringCmd:
+				if cmd.Err() != nil {
+					continue
+				}
+				rec.Values = []string{cmd.Val()}
+			case *redis.MapStringStringCmd:
+				fields := make([]string, 0, len(cmd.Val()))
+				for field := range cmd.Val() {
+					fields = append(fields, field)
+				}
+				sort.Strings(fields)
+				for _, field := range fields {
+					rec.Values = append(rec.Values, field, cmd.Val()[field])
+				}
+			case *redis.StringSliceCmd:
+				rec.Values = cmd.Val()
+			case *redis.ZSliceCmd:
+				for _, z := range cmd.Val() {
+					rec.Values = append(rec.Values, fmt.Sprint(z.Member), strconv.FormatFloat(z.Score, 'f', -1, 64))
+				}
+			}
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+// Backup writes the whole Badger directory to w
+func (s *BadgerStore) Backup(ctx context.Context, w io.Writer) (*BackupInfo, error) {
+	return writeBackup(w, "badger", nil, s.db)
+}
+
+func writeBackup(w io.Writer, backend string, records []redisRecord, db *badger.DB) (*BackupInfo, error) {
+	header := backupHeaderJSON{Format: backupFormat, Backend: backend, CreatedAt: time.Now().UTC()}
+	aw, err := newArchiveWriter(w, header.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if err := aw.add(backupHeader, data); err != nil {
+		return nil, err
+	}
+
+	sc := newBackupScan(header)
+	if records != nil {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return nil, err
+			}
+			if err := sc.redis(rec); err != nil {
+				return nil, err
+			}
+		}
+		if err := aw.add(backupRedis, buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	// Badger's stream is read back as it's written, to count what's in it
+	chunks := &chunkWriter{aw: aw, prefix: backupBadger}
+	dec := &kvDecoder{fn: sc.kv}
+	if _, err := db.Backup(io.MultiWriter(chunks, dec), 0); err != nil {
+		return nil, fmt.Errorf("backing up badger: %w", err)
+	}
+	if err := dec.close(); err != nil {
+		return nil, err
+	}
+	if err := chunks.flush(); err != nil {
+		return nil, err
+	}
+	if err := aw.close(); err != nil {
+		return nil, err
+	}
+	return sc.result(), nil
+}
+
+// ReadBackup checks an archive from end to end, without loading it anywhere
+func ReadBackup(r io.Reader) (*BackupInfo, error) {
+	var sc *backupScan
+	err := walkBackup(r, func(h backupHeaderJSON) error {
+		sc = newBackupScan(h)
+		return nil
+	}, func(rec redisRecord) error {
+		return sc.redis(rec)
+	}, func(kv *pb.KV) error {
+		return sc.kv(kv)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sc.result(), nil
+}
+
+// CheckRestored reads back every article of a loaded backup, and says which
+// ones didn't make it (or lost their content on the way)
+func CheckRestored(ctx context.Context, st Store, info *BackupInfo) ([]string, error) {
+	var problems []string
+	for id, content := range info.articles {
+		a, err := st.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			problems = append(problems, fmt.Sprintf("%s: missing", id))
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if content && a.Content == "" {
+			problems = append(problems, fmt.Sprintf("%s: content missing", id))
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
+
+// LoadBackup restores a backup made by a HybridStore
+func (s *HybridStore) LoadBackup(ctx context.Context, r io.ReadSeeker, merge bool) (*BackupInfo, error) {
+	if s.db == nil {
+		return nil, errNoBadger
+	}
+	return loadBackup(ctx, s, "hybrid", r, merge, func() backupLoader {
+		return &hybridLoad{s: s, kvLoad: kvLoad{wb: s.db.NewWriteBatch()}, pipe: s.rdb.Pipeline()}
+	})
+}
+
+// LoadBackup restores a backup made by a BadgerStore
+func (s *BadgerStore) LoadBackup(ctx context.Context, r io.ReadSeeker, merge bool) (*BackupInfo, error) {
+	return loadBackup(ctx, s, "badger", r, merge, func() backupLoader {
+		return &badgerLoad{s: s, kvLoad: kvLoad{wb: s.db.NewWriteBatch()}}
+	})
+}
+
+// backupLoader applies one store's part of an archive
+type backupLoader interface {
+	// prepare notes what the store has for the backup's articles, before any of it is overwritten
+	prepare(ctx context.Context, info *BackupInfo) error
+	redis(ctx context.Context, rec redisRecord) error
+	kv(kv *pb.KV) error
+	// finish flushes, drops what's stale of the replaced articles, and
+	// queues again what was queued, if it's in info
+	finish(ctx context.Context, info *BackupInfo) error
+	cancel()
+}
+
+var errStopWalk = errors.New("stop")
+
+func loadBackup(ctx context.Context, st Store, backend string, r io.ReadSeeker, merge bool, newLoader func() backupLoader) (*BackupInfo, error) {
+	// A first pass checks the whole archive, so a damaged one changes nothing
+	info, err := ReadBackup(r)
+	if err != nil {
+		return nil, err
+	}
+	if info.Backend != backend {
+		return nil, fmt.Errorf("%w: made by the %s backend, not %s", ErrInvalidBackup, info.Backend, backend)
+	}
+	if !merge {
+		err := st.Walk(ctx, func(uuid.UUID) error { return errStopWalk })
+		if errors.Is(err, errStopWalk) {
+			return nil, ErrNotEmpty
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	// Articles in the backup win, but nothing of the store's copies is
+	// deleted up front: a load that fails halfway must not lose them. The
+	// backup's records go on top, and only then does finish drop the index
+	// entries, search terms and jobs the backup's copies don't have.
+	l := newLoader()
+	if err := l.prepare(ctx, info); err != nil {
+		l.cancel()
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		l.cancel()
+		return nil, err
+	}
+	err = walkBackup(r, func(backupHeaderJSON) error { return nil }, func(rec redisRecord) error {
+		return l.redis(ctx, rec)
+	}, l.kv)
+	if err != nil {
+		l.cancel()
+		return nil, err
+	}
+	if err := l.finish(ctx, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// kvLoad writes Badger entries with fresh versions, so they land on top of
+// whatever a live store has. Jobs are held back to be queued again.
+type kvLoad struct {
+	wb         *badger.WriteBatch
+	queued     []queuedJob
+	processing []uuid.UUID
+
+	// The store's copies of the backup's articles, and which of the keys
+	// that belong to one article the backup wrote
+	replaced map[uuid.UUID]*replacedArticle
+	written  map[string]bool
+}
+
+// replacedArticle is what the store had for an article the backup overwrites
+type replacedArticle struct {
+	meta    *model.Article
+	terms   []string
+	refs    []AssetRef
+	delayed [][]byte // Badger's delayed: keys
+}
+
+// articlePrefixes are the keys that belong to one article, past its listing indexes
+var articlePrefixes = []string{prefixContent, prefixRaw, prefixDiff, prefixIndexDoc, prefixManifest, prefixDelayed, prefixDead}
+
+type queuedJob struct {
+	seq uint64
+	id  uuid.UUID
+}
+
+const keyQueueSeq = "seq:queue"
+
+func (l *kvLoad) kv(kv *pb.KV) error {
+	key := string(kv.Key)
+	switch {
+	case key == keyQueueSeq:
+		// The store leases its own
+		return nil
+	case key == keyIndexDocs:
+		// Recounted once the index entries are in
+		return nil
+	case strings.HasPrefix(key, prefixQueue):
+		// Sequence numbers would collide with the store's
+		id, err := uuid.ParseBytes(kv.Value)
+		if err == nil && len(kv.Key) == len(prefixQueue)+8 {
+			l.queued = append(l.queued, queuedJob{binary.BigEndian.Uint64(kv.Key[len(prefixQueue):]), id})
+		}
+		return nil
+	case strings.HasPrefix(key, prefixProcessing):
+		// The worker that had it is gone
+		if id, err := uuid.Parse(strings.TrimPrefix(key, prefixProcessing)); err == nil {
+			l.processing = append(l.processing, id)
+		}
+		return nil
+	}
+
+	if l.written != nil && articleKeyed(key) {
+		l.written[key] = true
+	}
+
+	e := badger.NewEntry(kv.Key, kv.Value)
+	if len(kv.UserMeta) > 0 {
+		e = e.WithMeta(kv.UserMeta[0])
+	}
+	e.ExpiresAt = kv.ExpiresAt
+	return l.wb.SetEntry(e)
+}
+
+func (l *kvLoad) cancel() { l.wb.Cancel() }
+
+func articleKeyed(key string) bool {
+	for _, prefix := range articlePrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	// Content from before content:<id>
+	_, err := uuid.Parse(key)
+	return len(key) == 36 && err == nil
+}
+
+// note records the store's copy of a, which the backup is about to replace
+func (l *kvLoad) note(txn *badger.Txn, a *model.Article) error {
+	terms, _, err := docTerms(txn, a.ID)
+	if err != nil {
+		return err
+	}
+	refs, err := getManifest(txn, a.ID)
+	if err != nil {
+		return err
+	}
+	if l.replaced == nil {
+		l.replaced = make(map[uuid.UUID]*replacedArticle)
+		l.written = make(map[string]bool)
+	}
+	l.replaced[a.ID] = &replacedArticle{meta: a, terms: terms, refs: refs}
+	return nil
+}
+
+// dropStale removes the content, search terms and asset references a
+// replaced article had in the store, unless the backup's copy has them too
+func (l *kvLoad) dropStale(txn *badger.Txn, id uuid.UUID, old *replacedArticle) error {
+	for _, key := range [][]byte{contentKey(id), rawKey(id), diffKey(id), []byte(id.String())} {
+		if l.written[string(key)] {
+			continue
+		}
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	var terms []string
+	if l.written[string(indexDocKey(id))] {
+		var err error
+		if terms, _, err = docTerms(txn, id); err != nil {
+			return err
+		}
+	} else if err := txn.Delete(indexDocKey(id)); err != nil {
+		return err
+	}
+	keepTerms := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		keepTerms[term] = true
+	}
+	for _, term := range old.terms {
+		if keepTerms[term] {
+			continue
+		}
+		if err := txn.Delete(indexKey(term, id)); err != nil {
+			return err
+		}
+	}
+
+	var refs []AssetRef
+	if l.written[string(manifestKey(id))] {
+		var err error
+		if refs, err = getManifest(txn, id); err != nil {
+			return err
+		}
+	} else if err := txn.Delete(manifestKey(id)); err != nil {
+		return err
+	}
+	keepRefs := make(map[string]bool, len(refs))
+	for _, r := range refs {
+		keepRefs[r.Hash] = true
+	}
+	for _, r := range old.refs {
+		if keepRefs[r.Hash] {
+			continue
+		}
+		if err := txn.Delete(assetRefKey(r.Hash, id)); err != nil {
+			return err
+		}
+		if err := collectAsset(txn, r.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobs lists the held back jobs in the order they'd have run
+func (l *kvLoad) jobs(info *BackupInfo) []uuid.UUID {
+	sort.Slice(l.queued, func(i, j int) bool { return l.queued[i].seq < l.queued[j].seq })
+	ids := append([]uuid.UUID(nil), l.processing...)
+	for _, job := range l.queued {
+		ids = append(ids, job.id)
+	}
+	return restoredOnly(ids, info)
+}
+
+func restoredOnly(ids []uuid.UUID, info *BackupInfo) []uuid.UUID {
+	out := ids[:0]
+	seen := make(map[uuid.UUID]bool)
+	for _, id := range ids {
+		if _, ok := info.articles[id]; ok && !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+type badgerLoad struct {
+	kvLoad
+	s *BadgerStore
+}
+
+func (l *badgerLoad) redis(ctx context.Context, rec redisRecord) error {
+	return fmt.Errorf("%w: redis key %s in a badger backup", ErrInvalidBackup, rec.Key)
+}
+
+func (l *badgerLoad) prepare(ctx context.Context, info *BackupInfo) error {
+	return l.s.db.View(func(txn *badger.Txn) error {
+		for id := range info.articles {
+			var a model.Article
+			if err := getJSON(txn, articleKey(id), &a); errors.Is(err, ErrNotFound) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if err := l.note(txn, &a); err != nil {
+				return err
+			}
+		}
+		if len(l.replaced) == 0 {
+			return nil
+		}
+
+		// Delayed jobs are keyed by due time first, so it takes a scan
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefixDelayed)})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().Key()
+			if len(key) < len(prefixDelayed)+8 {
+				continue
+			}
+			id, err := uuid.ParseBytes(key[len(prefixDelayed)+8:])
+			if old, ok := l.replaced[id]; err == nil && ok {
+				old.delayed = append(old.delayed, it.Item().KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+}
+
+func (l *badgerLoad) finish(ctx context.Context, info *BackupInfo) error {
+	if err := l.wb.Flush(); err != nil {
+		return err
+	}
+	for id, old := range l.replaced {
+		if err := l.s.update(func(txn *badger.Txn) error { return l.dropReplaced(txn, id, old) }); err != nil {
+			return err
+		}
+	}
+	if err := countIndexDocs(l.s.db, true); err != nil {
+		return err
+	}
+	jobs := l.jobs(info)
+	for _, id := range jobs {
+		err := l.s.db.Update(func(txn *badger.Txn) error {
+			return l.s.enqueue(txn, id)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(jobs) > 0 {
+		l.s.wake()
+	}
+	return nil
+}
+
+// dropReplaced removes the listing index entries and jobs a replaced article
+// had in the store. Its jobs in the backup, if any, are queued again after.
+func (l *badgerLoad) dropReplaced(txn *badger.Txn, id uuid.UUID, old *replacedArticle) error {
+	keep := make(map[string]bool)
+	var a model.Article
+	if err := getJSON(txn, articleKey(id), &a); err == nil {
+		for _, key := range listKeys(&a) {
+			keep[string(key)] = true
+		}
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	for _, key := range listKeys(old.meta) {
+		if keep[string(key)] {
+			continue
+		}
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	if err := l.s.dequeue(txn, id); err != nil {
+		return err
+	}
+	if err := txn.Delete(processingKey(id)); err != nil {
+		return err
+	}
+	for _, key := range append(old.delayed, deadKey(id)) {
+		if l.written[string(key)] {
+			continue
+		}
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return l.dropStale(txn, id, old)
+}
+
+type hybridLoad struct {
+	kvLoad
+	s    *HybridStore
+	pipe redis.Pipeliner
+	// Jobs are held back here too, as the Redis lists hold them
+	queue, processing []string
+	// The backup's delayed and dead jobs, which replacing an article keeps
+	delayed, dead map[string]bool
+}
+
+func (l *hybridLoad) prepare(ctx context.Context, info *BackupInfo) error {
+	for id := range info.articles {
+		a, err := l.s.meta(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := l.s.db.View(func(txn *badger.Txn) error { return l.note(txn, a) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *hybridLoad) redis(ctx context.Context, rec redisRecord) error {
+	switch rec.Key {
+	case keyLeases:
+		// Leases belong to the workers of the instance that was backed up
+		return nil
+	case keyQueue:
+		l.queue = rec.Values
+		return nil
+	case keyProcessing:
+		l.processing = rec.Values
+		return nil
+	case keyDelayed:
+		l.delayed = make(map[string]bool)
+		for i := 0; i < len(rec.Values); i += 2 {
+			l.delayed[rec.Values[i]] = true
+		}
+	case keyDead:
+		l.dead = make(map[string]bool)
+		for _, v := range rec.Values {
+			l.dead[v] = true
+		}
+	}
+
+	vals := make([]interface{}, len(rec.Values))
+	for i, v := range rec.Values {
+		vals[i] = v
+	}
+	switch rec.Type {
+	case "string":
+		if len(vals) != 1 {
+			return fmt.Errorf("%w: %s has %d values", ErrInvalidBackup, rec.Key, len(vals))
+		}
+		l.pipe.Set(ctx, rec.Key, vals[0], 0)
+	case "hash":
+		l.pipe.HSet(ctx, rec.Key, vals...)
+	case "set":
+		l.pipe.SAdd(ctx, rec.Key, vals...)
+	case "zset":
+		if len(vals)%2 != 0 {
+			return fmt.Errorf("%w: %s has an odd number of values", ErrInvalidBackup, rec.Key)
+		}
+		members := make([]redis.Z, 0, len(vals)/2)
+		for i := 0; i < len(rec.Values); i += 2 {
+			score, err := strconv.ParseFloat(rec.Values[i+1], 64)
+			if err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrInvalidBackup, rec.Key, err)
+			}
+			members = append(members, redis.Z{Member: rec.Values[i], Score: score})
+		}
+		l.pipe.ZAdd(ctx, rec.Key, members...)
+	case "list":
+		// Appended without duplicates; past the queue, that is the dead letters
+		for _, v := range vals {
+			l.pipe.LRem(ctx, rec.Key, 0, v)
+			l.pipe.RPush(ctx, rec.Key, v)
+		}
+	default:
+		return fmt.Errorf("%w: %s has unknown type %q", ErrInvalidBackup, rec.Key, rec.Type)
+	}
+
+	if l.pipe.Len() >= 1000 {
+		_, err := l.pipe.Exec(ctx)
+		return err
+	}
+	return nil
+}
+
+func (l *hybridLoad) finish(ctx context.Context, info *BackupInfo) error {
+	if err := l.wb.Flush(); err != nil {
+		return err
+	}
+	// The rest of the walk goes in first, so the backup's metadata can be read back
+	if _, err := l.pipe.Exec(ctx); err != nil {
+		return err
+	}
+	for id, old := range l.replaced {
+		if err := retryUpdate(l.s.db, func(txn *badger.Txn) error { return l.dropStale(txn, id, old) }); err != nil {
+			return err
+		}
+
+		unindexList(ctx, l.pipe, old.meta)
+		if a, err := l.s.meta(ctx, id); err == nil {
+			indexList(ctx, l.pipe, a)
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		member := id.String()
+		l.pipe.LRem(ctx, keyQueue, 0, member)
+		l.pipe.LRem(ctx, keyProcessing, 0, member)
+		l.pipe.ZRem(ctx, keyLeases, member)
+		if !l.delayed[member] {
+			l.pipe.ZRem(ctx, keyDelayed, member)
+		}
+		if !l.dead[member] {
+			l.pipe.LRem(ctx, keyDead, 0, member)
+		}
+	}
+	if err := countIndexDocs(l.s.db, true); err != nil {
+		return err
+	}
+
+	// The right end of the queue is next, and jobs in progress were next
+	// before that; pushing from the right keeps them in order
+	var ids []uuid.UUID
+	for _, list := range [][]string{l.processing, l.queue} {
+		for i := len(list) - 1; i >= 0; i-- {
+			if id, err := uuid.Parse(list[i]); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	for _, id := range restoredOnly(ids, info) {
+		l.pipe.LPush(ctx, keyQueue, id.String())
+	}
+	_, err := l.pipe.Exec(ctx)
+	return err
+}
+
+// walkBackup reads an archive in order, checking it against its manifest
+func walkBackup(r io.Reader, header func(backupHeaderJSON) error, redisFn func(redisRecord) error, kvFn func(*pb.KV) error) error {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(damaged{zr})
+
+	sums := make(map[string]string)
+	dec := &kvDecoder{fn: kvFn}
+	var manifest *backupManifestJSON
+	for n := 0; ; n++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if errors.Is(err, ErrInvalidBackup) {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		if manifest != nil {
+			return fmt.Errorf("%w: %s after the manifest", ErrInvalidBackup, hdr.Name)
+		}
+		if (n == 0) != (hdr.Name == backupHeader) {
+			return fmt.Errorf("%w: %s has to come first", ErrInvalidBackup, backupHeader)
+		}
+
+		h := sha256.New()
+		body := io.TeeReader(tr, h)
+		switch {
+		case hdr.Name == backupHeader:
+			var hj backupHeaderJSON
+			if err := json.NewDecoder(body).Decode(&hj); err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrInvalidBackup, hdr.Name, err)
+			}
+			if hj.Format != backupFormat {
+				return fmt.Errorf("%w: format %d, want %d", ErrInvalidBackup, hj.Format, backupFormat)
+			}
+			if err := header(hj); err != nil {
+				return err
+			}
+		case hdr.Name == backupRedis:
+			d := json.NewDecoder(body)
+			for d.More() {
+				var rec redisRecord
+				if err := d.Decode(&rec); err != nil {
+					return fmt.Errorf("%w: %s: %v", ErrInvalidBackup, hdr.Name, err)
+				}
+				if err := redisFn(rec); err != nil {
+					return err
+				}
+			}
+		case strings.HasPrefix(hdr.Name, backupBadger):
+			if _, err := io.Copy(dec, body); err != nil {
+				return err
+			}
+		case hdr.Name == backupManifest:
+			manifest = &backupManifestJSON{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrInvalidBackup, hdr.Name, err)
+			}
+			continue
+		default:
+			return fmt.Errorf("%w: unexpected entry %s", ErrInvalidBackup, hdr.Name)
+		}
+		// Whatever a decoder left unread counts too
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return err
+		}
+		sums[hdr.Name] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if manifest == nil {
+		return fmt.Errorf("%w: no manifest, the archive is cut short", ErrInvalidBackup)
+	}
+	if err := dec.close(); err != nil {
+		return err
+	}
+	for name, want := range manifest.Sums {
+		got, ok := sums[name]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", ErrInvalidBackup, name)
+		}
+		if got != want {
+			return fmt.Errorf("%w: %s is damaged (checksum mismatch)", ErrInvalidBackup, name)
+		}
+	}
+	if len(sums) != len(manifest.Sums) {
+		return fmt.Errorf("%w: entries not in the manifest", ErrInvalidBackup)
+	}
+	return nil
+}
+
+// damaged marks read errors as ErrInvalidBackup: the file is cut short or corrupt
+type damaged struct{ r io.Reader }
+
+func (d damaged) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	return n, err
+}
+
+// archiveWriter writes the tar entries and keeps their checksums for the manifest
+type archiveWriter struct {
+	zw   *zstd.Encoder
+	tw   *tar.Writer
+	at   time.Time
+	sums map[string]string
+}
+
+func newArchiveWriter(w io.Writer, at time.Time) (*archiveWriter, error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveWriter{zw: zw, tw: tar.NewWriter(zw), at: at, sums: make(map[string]string)}, nil
+}
+
+func (a *archiveWriter) add(name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(data)), ModTime: a.at}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := a.tw.Write(data); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	a.sums[name] = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// close writes the manifest; without it the archive doesn't read back
+func (a *archiveWriter) close() error {
+	data, err := json.MarshalIndent(backupManifestJSON{Sums: a.sums}, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: backupManifest, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(data)), ModTime: a.at}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := a.tw.Write(data); err != nil {
+		return err
+	}
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.zw.Close()
+}
+
+// chunkWriter cuts a stream into numbered entries, since a tar entry has to
+// know its size up front
+type chunkWriter struct {
+	aw     *archiveWriter
+	prefix string
+	n      int
+	buf    bytes.Buffer
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	c.buf.Write(p)
+	if c.buf.Len() >= backupChunk {
+		if err := c.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (c *chunkWriter) flush() error {
+	if c.buf.Len() == 0 {
+		return nil
+	}
+	if err := c.aw.add(fmt.Sprintf("%s%06d", c.prefix, c.n), c.buf.Bytes()); err != nil {
+		return err
+	}
+	c.n++
+	c.buf.Reset()
+	return nil
+}
+
+// Badger's meta bit for a deletion marker (badger's bitDelete)
+const badgerBitDelete = 1 << 0
+
+// kvDecoder splits Badger's backup stream back into entries. The stream is
+// frames of a little-endian uint64 length and a pb.KVList; it's taken as a
+// Writer since it arrives in chunks, which don't care where frames end.
+type kvDecoder struct {
+	fn  func(*pb.KV) error
+	buf []byte
+}
+
+func (d *kvDecoder) Write(p []byte) (int, error) {
+	d.buf = append(d.buf, p...)
+	for len(d.buf) >= 8 {
+		size := binary.LittleEndian.Uint64(d.buf)
+		if uint64(len(d.buf)-8) < size {
+			break
+		}
+		var list pb.KVList
+		if err := proto.Unmarshal(d.buf[8:8+size], &list); err != nil {
+			return 0, fmt.Errorf("%w: badger stream: %v", ErrInvalidBackup, err)
+		}
+		d.buf = d.buf[8+size:]
+
+		now := uint64(time.Now().Unix())
+		var last []byte
+		for _, kv := range list.Kv {
+			// A key's older versions follow its newest one, which is the one that counts
+			if last != nil && bytes.Equal(kv.Key, last) {
+				continue
+			}
+			last = kv.Key
+			if len(kv.Meta) > 0 && kv.Meta[0]&badgerBitDelete != 0 {
+				continue
+			}
+			if kv.ExpiresAt != 0 && kv.ExpiresAt <= now {
+				continue
+			}
+			if bytes.HasPrefix(kv.Key, []byte("!badger!")) {
+				continue
+			}
+			if err := d.fn(kv); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (d *kvDecoder) close() error {
+	if len(d.buf) > 0 {
+		return fmt.Errorf("%w: badger stream is cut short", ErrInvalidBackup)
+	}
+	return nil
+}
+
+// backupScan counts what's in an archive as it goes by
+type backupScan struct {
+	info     BackupInfo
+	articles map[uuid.UUID]bool
+	contents map[uuid.UUID]bool
+}
+
+func newBackupScan(h backupHeaderJSON) *backupScan {
+	return &backupScan{
+		info:     BackupInfo{Format: h.Format, Backend: h.Backend, CreatedAt: h.CreatedAt},
+		articles: make(map[uuid.UUID]bool),
+		contents: make(map[uuid.UUID]bool),
+	}
+}
+
+func (sc *backupScan) redis(rec redisRecord) error {
+	sc.info.RedisKeys++
+	if rest, ok := strings.CutPrefix(rec.Key, "article:"); ok && rec.Type == "string" {
+		return sc.article(rest, rec.Values)
+	}
+	return nil
+}
+
+func (sc *backupScan) kv(kv *pb.KV) error {
+	sc.info.BadgerKeys++
+	key := string(kv.Key)
+	if rest, ok := strings.CutPrefix(key, prefixArticle); ok {
+		return sc.article(rest, []string{string(kv.Value)})
+	}
+	// Content of old hybrid archives sits under the bare ID, see HybridStore.Get
+	rest := strings.TrimPrefix(key, prefixContent)
+	if id, err := uuid.Parse(rest); err == nil && len(rest) == 36 && len(kv.Value) > 0 {
+		sc.contents[id] = true
+	}
+	return nil
+}
+
+func (sc *backupScan) article(idStr string, vals []string) error {
+	var a model.Article
+	if len(vals) != 1 || json.Unmarshal([]byte(vals[0]), &a) != nil {
+		return fmt.Errorf("%w: article %s doesn't read", ErrInvalidBackup, idStr)
+	}
+	sc.articles[a.ID] = true
+	return nil
+}
+
+func (sc *backupScan) result() *BackupInfo {
+	info := sc.info
+	info.articles = make(map[uuid.UUID]bool, len(sc.articles))
+	for id := range sc.articles {
+		info.articles[id] = sc.contents[id]
+		if sc.contents[id] {
+			info.Contents++
+		}
+	}
+	info.Articles = len(info.articles)
+	return &info
+}
@@ -0,0 +1,241 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBackup(t *testing.T, newStore func() Store) {
+	ctx := context.Background()
+	src := newStore()
+	archived := saveArchived(t, src, "Gophers", "<p>All about gophers.</p>")
+	require.NoError(t, src.SaveRaw(ctx, archived.ID, []byte("<html>raw</html>")))
+	require.NoError(t, src.PutAsset(ctx, Asset{Hash: "abc", ContentType: "image/png", Data: []byte("PNG")}))
+	require.NoError(t, src.SetAssetManifest(ctx, archived.ID, []AssetRef{{URL: "https://example.com/a.png", Hash: "abc"}}))
+	pending := model.NewArticle("https://example.com/pending")
+	pending.Tags = []string{"later"}
+	require.NoError(t, src.Save(ctx, &pending))
+	w, err := model.NewWatch("https://example.com/watched", time.Hour, nil)
+	require.NoError(t, err)
+	require.NoError(t, src.SaveWatch(ctx, w))
+	require.NoError(t, src.MarkSeen(ctx, "https://example.com/feed.xml", []string{"guid-1"}))
+
+	var buf bytes.Buffer
+	info, err := src.Backup(ctx, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, 2, info.Articles)
+	assert.Equal(t, 1, info.Contents)
+
+	read, err := ReadBackup(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, info.Articles, read.Articles)
+	assert.Equal(t, info.BadgerKeys, read.BadgerKeys)
+
+	_, err = ReadBackup(bytes.NewReader(buf.Bytes()[:buf.Len()-100]))
+	assert.ErrorIs(t, err, ErrInvalidBackup, "A cut short archive doesn't read")
+
+	// Into an empty store
+	dst := newStore()
+	info, err = dst.LoadBackup(ctx, bytes.NewReader(buf.Bytes()), false)
+	require.NoError(t, err)
+	problems, err := CheckRestored(ctx, dst, info)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	got, err := dst.Get(ctx, archived.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>All about gophers.</p>", got.Content)
+	raw, err := dst.GetRaw(ctx, archived.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "<html>raw</html>", string(raw))
+	asset, err := dst.GetAsset(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "PNG", string(asset.Data))
+	assert.Equal(t, []uuid.UUID{pending.ID}, listAll(t, dst, ListOptions{Tag: "later"}))
+	results, _, err := dst.Search(ctx, "gophers", SearchOptions{})
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+	watches, err := dst.Watches(ctx)
+	require.NoError(t, err)
+	assert.Len(t, watches, 1)
+	seen, err := dst.SeenEntries(ctx, "https://example.com/feed.xml", []string{"guid-1"})
+	require.NoError(t, err)
+	assert.True(t, seen["guid-1"])
+
+	id, err := dst.PopQueue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pending.ID, id, "Queued jobs are queued again")
+
+	// Into a live one: only with merge, and the backup's articles win
+	_, err = dst.LoadBackup(ctx, bytes.NewReader(buf.Bytes()), false)
+	assert.ErrorIs(t, err, ErrNotEmpty)
+
+	changed := *got
+	changed.Title, changed.Content = "Changed", "<p>Rewritten.</p>"
+	changed.Tags = []string{"stale"}
+	require.NoError(t, dst.Save(ctx, &changed))
+	require.NoError(t, dst.PutAsset(ctx, Asset{Hash: "def", ContentType: "image/png", Data: []byte("PNG2")}))
+	require.NoError(t, dst.SetAssetManifest(ctx, archived.ID, []AssetRef{{URL: "https://example.com/b.png", Hash: "def"}}))
+	other := saveArchived(t, dst, "Other", "<p>Only here.</p>")
+
+	// A load that fails halfway leaves the store's copies as they were
+	_, err = dst.LoadBackup(ctx, &cutOnReload{Reader: bytes.NewReader(buf.Bytes())}, true)
+	require.Error(t, err)
+	got, err = dst.Get(ctx, archived.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Changed", got.Title)
+	assert.Equal(t, "<p>Rewritten.</p>", got.Content)
+	results, _, err = dst.Search(ctx, "rewritten", SearchOptions{})
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	_, err = dst.LoadBackup(ctx, bytes.NewReader(buf.Bytes()), true)
+	require.NoError(t, err)
+	got, err = dst.Get(ctx, archived.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Gophers", got.Title)
+	assert.Equal(t, "<p>All about gophers.</p>", got.Content)
+	results, _, err = dst.Search(ctx, "rewritten", SearchOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, results, "No search terms left from the replaced copy")
+	assert.Empty(t, listAll(t, dst, ListOptions{Tag: "stale"}), "No index entries either")
+	refs, err := dst.GetAssetManifest(ctx, archived.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []AssetRef{{URL: "https://example.com/a.png", Hash: "abc"}}, refs)
+	_, err = dst.GetAsset(ctx, "def")
+	assert.ErrorIs(t, err, ErrNotFound, "Nor assets only the replaced copy used")
+	_, err = dst.Get(ctx, other.ID)
+	assert.NoError(t, err, "Articles only the store has stay")
+	assert.Len(t, listAll(t, dst, ListOptions{}), 3)
+
+	// The job leased before is queued once, not twice
+	next := model.NewArticle("https://example.com/next")
+	require.NoError(t, dst.Save(ctx, &next))
+	id, err = dst.PopQueue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pending.ID, id)
+	id, err = dst.PopQueue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, next.ID, id)
+}
+
+// cutOnReload reads an archive once, then fails halfway through the second read
+type cutOnReload struct {
+	*bytes.Reader
+	reloads int
+}
+
+func (r *cutOnReload) Seek(offset int64, whence int) (int64, error) {
+	r.reloads++
+	return r.Reader.Seek(offset, whence)
+}
+
+func (r *cutOnReload) Read(p []byte) (int, error) {
+	if r.reloads == 0 {
+		return r.Reader.Read(p)
+	}
+	if r.Size()-int64(r.Len()) >= r.Size()/2 {
+		return 0, errors.New("connection reset")
+	}
+	return r.Reader.Read(p[:min(len(p), 64)])
+}
+
+func TestBackup_Hybrid(t *testing.T) {
+	testBackup(t, func() Store {
+		mr, err := miniredis.Run()
+		require.NoError(t, err)
+		t.Cleanup(mr.Close)
+		st, err := NewHybridStore(mr.Addr(), t.TempDir())
+		require.NoError(t, err)
+		t.Cleanup(st.Close)
+		return st
+	})
+}
+
+// writeAfterRead runs write once, right after the first pipelined read of values
+type writeAfterRead struct {
+	write func()
+	done  bool
+}
+
+func (h *writeAfterRead) DialHook(next redis.DialHook) redis.DialHook          { return next }
+func (h *writeAfterRead) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+func (h *writeAfterRead) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		if !h.done && len(cmds) > 0 && cmds[0].Name() == "get" {
+			h.done = true
+			h.write()
+		}
+		return err
+	}
+}
+
+func TestBackup_HybridSnapshotReadsAgainAfterWrite(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+	st, err := NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+	ctx := context.Background()
+
+	a := saveArchived(t, st, "Before", "<p>Text.</p>")
+	other := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer other.Close()
+	st.rdb.AddHook(&writeAfterRead{write: func() {
+		a.Title = "After"
+		data, err := json.Marshal(a)
+		require.NoError(t, err)
+		require.NoError(t, other.Set(ctx, "article:"+a.ID.String(), data, 0).Err())
+	}})
+
+	records, err := st.snapshotRedis(ctx)
+	require.NoError(t, err)
+	for _, rec := range records {
+		if rec.Key == "article:"+a.ID.String() {
+			assert.Contains(t, rec.Values[0], `"title":"After"`, "The write since the read made it start over")
+			return
+		}
+	}
+	t.Fatal("article missing from the snapshot")
+}
+
+func TestBackup_Badger(t *testing.T) {
+	testBackup(t, func() Store {
+		st, err := NewBadgerStore("")
+		require.NoError(t, err)
+		t.Cleanup(st.Close)
+		return st
+	})
+}
+
+func TestBackup_WrongBackend(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+	var buf bytes.Buffer
+	_, err = st.Backup(context.Background(), &buf)
+	require.NoError(t, err)
+
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+	hs, err := NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer hs.Close()
+	_, err = hs.LoadBackup(context.Background(), bytes.NewReader(buf.Bytes()), false)
+	assert.ErrorIs(t, err, ErrInvalidBackup)
+}
@@ -0,0 +1,1072 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+)
+
+// Key layout for the embedded store. Everything lives in one Badger DB:
+//
+//	article:<id>               metadata JSON (no content)
+//	content:<id>               readable HTML
+//	raw:<id>                   page as downloaded, zstd-compressed
+//	diff:<id>                  diff against the previous snapshot, zstd-compressed
+//	recent:, status:, domain:, tag:, url:, unread:, favorite:, folder:, trash:
+//	                           listing indexes, see listKeys
+//	meta:list-indexes:v<n>     marks the listing indexes as built for listIndexVersion
+//	queue:<seq>                article ID waiting to be archived
+//	seq:queue                  Badger sequence handing out <seq>
+//	processing:<id>            leased job, value is the lease deadline (unix ms)
+//	delayed:<due><id>          job waiting for its next retry, ordered by due time
+//	dead:<id>                  job that ran out of attempts, value is when it died
+//	idx:, idxdoc:, meta:index-docs
+//	                           full-text index, see search.go
+//	asset:, assets:, assetref: downloaded images, see assets.go
+//	watch:<hash>               model.Watch JSON, by model.URLHash of the canonical URL
+//	feed:<hash>                model.Feed JSON, likewise
+//	feedseen:<hash>:<guid>     empty, one per feed entry already queued (guid hashed too)
+const (
+	prefixArticle    = "article:"
+	prefixContent    = "content:"
+	prefixRaw        = "raw:"
+	prefixDiff       = "diff:"
+	prefixWatch      = "watch:"
+	prefixFeed       = "feed:"
+	prefixFeedSeen   = "feedseen:"
+	prefixRecent     = "recent:"
+	prefixStatus     = "status:"
+	prefixDomain     = "domain:"
+	prefixTrash      = "trash:"
+	prefixTag        = "tag:"
+	prefixURL        = "url:"
+	prefixUnread     = "unread:"
+	prefixFavorite   = "favorite:"
+	prefixFolder     = "folder:"
+	prefixQueue      = "queue:"
+	prefixProcessing = "processing:"
+	prefixDelayed    = "delayed:"
+	prefixDead       = "dead:"
+)
+
+// BadgerStore is the Redis-free Store for single-user installs.
+// Metadata, content and the job queue all live in one Badger directory,
+// and PopQueue is woken up in-process instead of by BRPOP.
+type BadgerStore struct {
+	db       *badger.DB
+	seq      *badger.Sequence
+	leaseTTL time.Duration
+
+	mu     sync.Mutex
+	notify chan struct{}
+}
+
+// NewBadgerStore opens (or creates) the Badger directory at path.
+// Badger takes a directory lock, so only one process can hold it at a time.
+func NewBadgerStore(path string) (*BadgerStore, error) {
+	opts := badger.DefaultOptions(path)
+	opts.Logger = nil // Silence default logger
+	if path == "" {
+		opts = opts.WithInMemory(true)
+	}
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open badger: %w", err)
+	}
+
+	s := &BadgerStore{
+		db:       db,
+		leaseTTL: DefaultLeaseTTL,
+		notify:   make(chan struct{}),
+	}
+	if err := s.migrateList(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to build listing indexes: %w", err)
+	}
+	if err := countIndexDocs(db, false); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to count search documents: %w", err)
+	}
+
+	seq, err := db.GetSequence([]byte("seq:queue"), 100)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to init queue sequence: %w", err)
+	}
+
+	s.seq = seq
+	return s, nil
+}
+
+// Close releases the queue sequence and the directory lock
+func (s *BadgerStore) Close() {
+	if s.seq != nil {
+		s.seq.Release()
+	}
+	if s.db != nil {
+		s.db.Close()
+	}
+}
+
+// SetLeaseTTL changes how long a popped job stays leased to its worker
+func (s *BadgerStore) SetLeaseTTL(d time.Duration) {
+	s.leaseTTL = d
+}
+
+func articleKey(id uuid.UUID) []byte    { return []byte(prefixArticle + id.String()) }
+func contentKey(id uuid.UUID) []byte    { return []byte(prefixContent + id.String()) }
+func rawKey(id uuid.UUID) []byte        { return []byte(prefixRaw + id.String()) }
+func diffKey(id uuid.UUID) []byte       { return []byte(prefixDiff + id.String()) }
+func processingKey(id uuid.UUID) []byte { return []byte(prefixProcessing + id.String()) }
+func deadKey(id uuid.UUID) []byte       { return []byte(prefixDead + id.String()) }
+
+// delayedKey sorts by due time, so promotion is a prefix scan that stops at the first future job
+func delayedKey(at time.Time, id uuid.UUID) []byte {
+	key := make([]byte, 0, len(prefixDelayed)+8+36)
+	key = append(key, prefixDelayed...)
+	key = binary.BigEndian.AppendUint64(key, uint64(at.UnixMilli()))
+	return append(key, id.String()...)
+}
+
+// Listing indexes are empty keys ending in listMember, so byte order is time order:
+//
+//	recent:<member>             every article
+//	status:<status>:<member>    articles currently in that status
+//	domain:<domain>:<member>    articles from that domain
+//	tag:<tag>:<member>          articles with that tag
+//	url:<hash>:<member>         snapshots of one page, by model.URLHash of the canonical URL
+//	unread:<member>             articles without ReadAt
+//	favorite:<member>           favorite articles
+//	folder:<folder>:<member>    articles filed in that folder
+//	trash:<member>              trashed articles, which are in none of the above
+func recentKey(a *model.Article) []byte { return []byte(prefixRecent + listMember(a)) }
+func statusKey(a *model.Article) []byte {
+	return []byte(prefixStatus + string(a.Status) + ":" + listMember(a))
+}
+func domainKey(a *model.Article) []byte {
+	return []byte(prefixDomain + a.Domain() + ":" + listMember(a))
+}
+
+func tagKey(tag string, a *model.Article) []byte {
+	return []byte(prefixTag + tag + ":" + listMember(a))
+}
+
+func urlPrefix(canonical string) string { return prefixURL + model.URLHash(canonical) + ":" }
+func urlKey(a *model.Article) []byte    { return []byte(urlPrefix(a.Canonical()) + listMember(a)) }
+
+func unreadKey(a *model.Article) []byte   { return []byte(prefixUnread + listMember(a)) }
+func favoriteKey(a *model.Article) []byte { return []byte(prefixFavorite + listMember(a)) }
+func folderKey(a *model.Article) []byte {
+	return []byte(prefixFolder + string(a.InFolder()) + ":" + listMember(a))
+}
+
+func trashKey(a *model.Article) []byte { return []byte(prefixTrash + listMember(a)) }
+
+// listKeys are the index entries the article should have right now
+func listKeys(a *model.Article) [][]byte {
+	if a.DeletedAt != nil {
+		return [][]byte{trashKey(a)}
+	}
+	keys := [][]byte{recentKey(a), statusKey(a), domainKey(a), urlKey(a), folderKey(a)}
+	for _, tag := range a.Tags {
+		keys = append(keys, tagKey(tag, a))
+	}
+	if a.ReadAt == nil {
+		keys = append(keys, unreadKey(a))
+	}
+	if a.Favorite {
+		keys = append(keys, favoriteKey(a))
+	}
+	return keys
+}
+
+// keyListIndexes marks a store whose indexes are at listIndexVersion
+var keyListIndexes = []byte(fmt.Sprintf("meta:list-indexes:v%d", listIndexVersion))
+
+func queueKey(seq uint64) []byte {
+	key := make([]byte, len(prefixQueue)+8)
+	copy(key, prefixQueue)
+	binary.BigEndian.PutUint64(key[len(prefixQueue):], seq)
+	return key
+}
+
+// Save writes metadata and content in one transaction and queues pending articles
+func (s *BadgerStore) Save(ctx context.Context, article *model.Article) error {
+	// Tags end up in index keys, so only normalized ones get that far
+	article.Tags = model.NormalizeTags(article.Tags)
+	article.Folder = article.InFolder()
+	meta := *article
+	meta.Content = ""
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	queued := false
+	// The search document count makes saves of new articles conflict, so retry
+	err = s.update(func(txn *badger.Txn) error {
+		queued = false
+		// Re-saving a pending article (e.g. a retry bumping Attempts) must not queue it twice
+		isNew, err := putArticle(txn, article, data)
+		if err != nil {
+			return err
+		}
+		if article.Content != "" {
+			if err := putContent(txn, article); err != nil {
+				return err
+			}
+		}
+
+		if article.Status == model.StatusPending && isNew {
+			if err := s.enqueue(txn, article.ID); err != nil {
+				return err
+			}
+			queued = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if queued {
+		s.wake()
+	}
+	return nil
+}
+
+func (s *BadgerStore) SaveContent(ctx context.Context, article *model.Article) error {
+	return s.update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(articleKey(article.ID)); errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		return putContent(txn, article)
+	})
+}
+
+// putContent writes the content and indexes it for search
+func putContent(txn *badger.Txn, a *model.Article) error {
+	if err := txn.Set(contentKey(a.ID), []byte(a.Content)); err != nil {
+		return err
+	}
+	return indexArticle(txn, a)
+}
+
+// putArticle writes the metadata and keeps the listing indexes in step with it.
+// It reports whether the article was new.
+func putArticle(txn *badger.Txn, a *model.Article, data []byte) (bool, error) {
+	var old model.Article
+	err := getJSON(txn, articleKey(a.ID), &old)
+	isNew := errors.Is(err, ErrNotFound)
+	if err != nil && !isNew {
+		return false, err
+	}
+	if !isNew {
+		for _, key := range listKeys(&old) {
+			if err := txn.Delete(key); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if err := txn.Set(articleKey(a.ID), data); err != nil {
+		return false, err
+	}
+	for _, key := range listKeys(a) {
+		if err := txn.Set(key, nil); err != nil {
+			return false, err
+		}
+	}
+	return isNew, nil
+}
+
+// Get loads metadata plus content (if archived)
+func (s *BadgerStore) Get(ctx context.Context, id uuid.UUID) (*model.Article, error) {
+	var article model.Article
+	err := s.db.View(func(txn *badger.Txn) error {
+		if err := getJSON(txn, articleKey(id), &article); err != nil {
+			return err
+		}
+
+		item, err := txn.Get(contentKey(id))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			article.Content = string(val)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &article, nil
+}
+
+// SaveRaw keeps the page exactly as downloaded (zstd-compressed)
+func (s *BadgerStore) SaveRaw(ctx context.Context, id uuid.UUID, raw []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(rawKey(id), compress(raw))
+	})
+}
+
+// GetRaw returns the page as downloaded, or ErrNotFound if it was never kept
+func (s *BadgerStore) GetRaw(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	return getCompressed(s.db, rawKey(id))
+}
+
+func getCompressed(db *badger.DB, key []byte) ([]byte, error) {
+	var compressed []byte
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		compressed, err = item.ValueCopy(nil)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return decompress(compressed)
+}
+
+// List walks one of the listing indexes in key order (reversed for newest first)
+func (s *BadgerStore) List(ctx context.Context, opts ListOptions) ([]model.Article, string, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if err := validOptions(opts); err != nil {
+		return nil, "", err
+	}
+	opts = canonicalOptions(opts)
+	lo, hi, err := memberRange(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	prefix := prefixRecent
+	switch {
+	case opts.Trashed:
+		prefix = prefixTrash
+	case opts.URL != "":
+		prefix = urlPrefix(opts.URL)
+	case opts.Tag != "":
+		prefix = prefixTag + opts.Tag + ":"
+	case opts.Favorite:
+		prefix = prefixFavorite
+	case opts.Unread:
+		prefix = prefixUnread
+	case opts.Folder != "":
+		prefix = prefixFolder + string(opts.Folder) + ":"
+	case opts.Domain != "":
+		prefix = prefixDomain + opts.Domain + ":"
+	case len(opts.Status) == 1:
+		prefix = prefixStatus + string(opts.Status[0]) + ":"
+	}
+	reverse := opts.Order != OrderOldest
+
+	articles := []model.Article{}
+	next := ""
+	err = s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Reverse: reverse, Prefix: []byte(prefix)})
+		defer it.Close()
+
+		seek := []byte(prefix + lo)
+		if reverse {
+			seek = []byte(prefix + hi)
+			if hi == "" {
+				seek = []byte(prefix + "\xff")
+			}
+		}
+
+		for it.Seek(seek); it.Valid(); it.Next() {
+			member := string(it.Item().Key()[len(prefix):])
+			if hi != "" && member >= hi {
+				if reverse {
+					continue // Seek lands on hi itself, which is excluded
+				}
+				return nil
+			}
+			if lo != "" && member < lo {
+				return nil // Only reachable in reverse
+			}
+
+			if len(articles) == limit {
+				// There is at least one more entry, so hand out a cursor
+				next = listMember(&articles[len(articles)-1])
+				return nil
+			}
+
+			id, err := memberID(member)
+			if err != nil {
+				continue
+			}
+			var a model.Article
+			if err := getJSON(txn, articleKey(id), &a); err != nil {
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+				return err
+			}
+			if !listMatch(&a, opts) {
+				continue
+			}
+			articles = append(articles, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return articles, next, nil
+}
+
+// migrateList builds the listing indexes for stores created before
+// listIndexVersion. It writes in batches, so a big store can't overflow one transaction.
+func (s *BadgerStore) migrateList() error {
+	var keys [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		if _, err := txn.Get(keyListIndexes); err == nil {
+			return nil
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		keys = append(keys, keyListIndexes)
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefixArticle), PrefetchValues: true})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var a model.Article
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &a)
+			})
+			if err != nil {
+				continue
+			}
+			keys = append(keys, listKeys(&a)...)
+		}
+		return nil
+	})
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	// The marker goes last, so a crash halfway just means another run
+	for _, key := range append(keys[1:], keys[0]) {
+		if err := wb.Set(key, nil); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// Walk collects the IDs first, so fn is free to write to the store
+func (s *BadgerStore) Walk(ctx context.Context, fn func(id uuid.UUID) error) error {
+	var ids []uuid.UUID
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefixArticle)})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			id, err := uuid.Parse(strings.TrimPrefix(string(it.Item().Key()), prefixArticle))
+			if err != nil {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes metadata, content, the recent entry and any queued jobs
+func (s *BadgerStore) Delete(ctx context.Context, id uuid.UUID) error {
+	return s.update(func(txn *badger.Txn) error {
+		var a model.Article
+		if err := getJSON(txn, articleKey(id), &a); err != nil {
+			return err
+		}
+
+		keys := append(listKeys(&a), articleKey(id), contentKey(id), rawKey(id), diffKey(id), processingKey(id), deadKey(id))
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		if err := s.undelay(txn, id); err != nil {
+			return err
+		}
+		if err := setManifest(txn, id, nil); err != nil {
+			return err
+		}
+		if err := unindexArticle(txn, id); err != nil {
+			return err
+		}
+		return s.dequeue(txn, id)
+	})
+}
+
+// Trash moves the article into the trash index and takes it off the queue.
+// The queue scans make it conflict with any enqueue, hence update's retries.
+func (s *BadgerStore) Trash(ctx context.Context, id uuid.UUID) error {
+	return s.update(func(txn *badger.Txn) error {
+		var a model.Article
+		if err := getJSON(txn, articleKey(id), &a); err != nil || a.DeletedAt != nil {
+			return err
+		}
+		if err := trash(&a); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		if _, err := putArticle(txn, &a, data); err != nil {
+			return err
+		}
+		if err := txn.Delete(deadKey(id)); err != nil {
+			return err
+		}
+		if err := s.undelay(txn, id); err != nil {
+			return err
+		}
+		return s.dequeue(txn, id)
+	})
+}
+
+// Restore takes the article out of the trash. A pending one is queued again.
+func (s *BadgerStore) Restore(ctx context.Context, id uuid.UUID) error {
+	queued := false
+	err := s.update(func(txn *badger.Txn) error {
+		queued = false
+		var a model.Article
+		if err := getJSON(txn, articleKey(id), &a); err != nil || a.DeletedAt == nil {
+			return err
+		}
+		if err := restore(&a); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		if _, err := putArticle(txn, &a, data); err != nil {
+			return err
+		}
+		if a.Status != model.StatusPending {
+			return nil
+		}
+		if err := s.dequeue(txn, id); err != nil {
+			return err
+		}
+		queued = true
+		return s.enqueue(txn, id)
+	})
+	if err != nil {
+		return err
+	}
+	if queued {
+		s.wake()
+	}
+	return nil
+}
+
+// Requeue flips the article back to pending and queues it again
+func (s *BadgerStore) Requeue(ctx context.Context, id uuid.UUID) error {
+	err := s.update(func(txn *badger.Txn) error {
+		var a model.Article
+		if err := getJSON(txn, articleKey(id), &a); err != nil {
+			return err
+		}
+		if err := requeue(&a); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		if _, err := putArticle(txn, &a, data); err != nil {
+			return err
+		}
+		if err := txn.Delete(deadKey(id)); err != nil {
+			return err
+		}
+		if err := s.undelay(txn, id); err != nil {
+			return err
+		}
+		// Already queued (requeued twice, or still pending): keep one job
+		if err := s.dequeue(txn, id); err != nil {
+			return err
+		}
+		return s.enqueue(txn, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.wake()
+	return nil
+}
+
+// UpdateStatus applies the transition in one transaction (see changeMeta).
+// A worker's claim holds until the lease PopQueue gave it runs out.
+func (s *BadgerStore) UpdateStatus(ctx context.Context, id uuid.UUID, ev model.StatusEvent) (*model.Article, error) {
+	if ev.To == model.StatusProcessing && ev.Worker != "" && ev.Until == nil {
+		until := time.Now().Add(s.leaseTTL)
+		ev.Until = &until
+		err := s.db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(processingKey(id))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			return item.Value(func(val []byte) error {
+				if len(val) == 8 {
+					until = time.UnixMilli(int64(binary.BigEndian.Uint64(val)))
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.changeMeta(id, func(a *model.Article) error {
+		return a.Transition(ev)
+	})
+}
+
+// UpdateMeta runs change in one transaction (see changeMeta)
+func (s *BadgerStore) UpdateMeta(ctx context.Context, id uuid.UUID, change func(a *model.Article) error) (*model.Article, error) {
+	return s.changeMeta(id, func(a *model.Article) error {
+		if err := change(a); err != nil {
+			return err
+		}
+		// As in Save: tags end up in index keys
+		a.Tags = model.NormalizeTags(a.Tags)
+		a.Folder = a.InFolder()
+		return nil
+	})
+}
+
+// changeMeta reads, changes and writes an article's metadata in one
+// transaction (see update)
+func (s *BadgerStore) changeMeta(id uuid.UUID, change func(a *model.Article) error) (*model.Article, error) {
+	var a model.Article
+	err := s.update(func(txn *badger.Txn) error {
+		a = model.Article{}
+		if err := getJSON(txn, articleKey(id), &a); err != nil {
+			return err
+		}
+		if err := change(&a); err != nil {
+			return err
+		}
+		data, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		_, err = putArticle(txn, &a, data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// update runs fn in a read-write transaction. Badger aborts a transaction
+// whose reads went stale before it commits (ErrConflict); that just means
+// another writer got there first, so fn runs again on the new state, up to
+// maxRetries times.
+func (s *BadgerStore) update(fn func(txn *badger.Txn) error) error {
+	return retryUpdate(s.db, fn)
+}
+
+// retryUpdate is update for any Badger handle, the hybrid store's included
+func retryUpdate(db *badger.DB, fn func(txn *badger.Txn) error) error {
+	for i := 0; i < maxRetries; i++ {
+		err := db.Update(fn)
+		if !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// PopQueue takes the oldest job and leases it, blocking until one shows up or ctx ends
+func (s *BadgerStore) PopQueue(ctx context.Context) (uuid.UUID, error) {
+	for {
+		// Grab the channel BEFORE looking, so a push that lands
+		// between the look and the wait still wakes us up.
+		s.mu.Lock()
+		ch := s.notify
+		s.mu.Unlock()
+
+		id, ok, err := s.tryPop()
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if ok {
+			return id, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return uuid.Nil, ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+// tryPop moves the head of the queue to processing, retrying if another worker raced us
+func (s *BadgerStore) tryPop() (uuid.UUID, bool, error) {
+	for {
+		var id uuid.UUID
+		found := false
+
+		err := s.db.Update(func(txn *badger.Txn) error {
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefixQueue), PrefetchValues: true, PrefetchSize: 1})
+			defer it.Close()
+
+			it.Rewind()
+			if !it.Valid() {
+				return nil
+			}
+
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := txn.Delete(item.KeyCopy(nil)); err != nil {
+				return err
+			}
+
+			found = true
+			id, err = uuid.Parse(string(val))
+			if err != nil {
+				// Garbage in the queue; it's already deleted, just report it
+				return fmt.Errorf("bad job id %q in queue: %w", val, err)
+			}
+			return txn.Set(processingKey(id), leaseValue(time.Now().Add(s.leaseTTL)))
+		})
+		if errors.Is(err, badger.ErrConflict) {
+			continue
+		}
+		return id, found, err
+	}
+}
+
+// Ack marks a leased job as done
+func (s *BadgerStore) Ack(ctx context.Context, id uuid.UUID) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(processingKey(id))
+	})
+}
+
+// Nack gives a leased job back to the queue
+func (s *BadgerStore) Nack(ctx context.Context, id uuid.UUID) error {
+	err := s.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(processingKey(id)); err != nil {
+			return err
+		}
+		return s.enqueue(txn, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.wake()
+	return nil
+}
+
+// RequeueExpired moves jobs whose lease ran out back to the queue.
+// Leases are stored on disk, so jobs held by a crashed server come back too.
+func (s *BadgerStore) RequeueExpired(ctx context.Context) (int, error) {
+	n := 0
+	err := s.db.Update(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefixProcessing), PrefetchValues: true})
+		defer it.Close()
+
+		now := time.Now()
+		var expired []uuid.UUID
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			err := item.Value(func(val []byte) error {
+				if len(val) == 8 && time.UnixMilli(int64(binary.BigEndian.Uint64(val))).After(now) {
+					return nil
+				}
+				id, err := uuid.Parse(strings.TrimPrefix(string(item.Key()), prefixProcessing))
+				if err == nil {
+					expired = append(expired, id)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, id := range expired {
+			if err := txn.Delete(processingKey(id)); err != nil {
+				return err
+			}
+			if err := s.enqueue(txn, id); err != nil {
+				return err
+			}
+		}
+		n = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if n > 0 {
+		s.wake()
+	}
+	return n, nil
+}
+
+// Schedule settles a leased job and parks it until at
+func (s *BadgerStore) Schedule(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(processingKey(id)); err != nil {
+			return err
+		}
+		return txn.Set(delayedKey(at, id), []byte(id.String()))
+	})
+}
+
+// PromoteDue queues every delayed job whose time has come
+func (s *BadgerStore) PromoteDue(ctx context.Context) (int, error) {
+	n := 0
+	err := s.db.Update(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefixDelayed), PrefetchValues: true})
+		defer it.Close()
+
+		now := delayedKey(time.Now(), uuid.Nil)[:len(prefixDelayed)+8]
+		var keys [][]byte
+		var ids []uuid.UUID
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if bytes.Compare(item.Key()[:len(now)], now) > 0 {
+				break
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if id, err := uuid.Parse(string(val)); err == nil {
+				ids = append(ids, id)
+			}
+			keys = append(keys, item.KeyCopy(nil))
+		}
+
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		for _, id := range ids {
+			if err := s.enqueue(txn, id); err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if n > 0 {
+		s.wake()
+	}
+	return n, nil
+}
+
+// DeadLetter settles a leased job that ran out of attempts
+func (s *BadgerStore) DeadLetter(ctx context.Context, id uuid.UUID) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(processingKey(id)); err != nil {
+			return err
+		}
+		return txn.Set(deadKey(id), leaseValue(time.Now()))
+	})
+}
+
+// ListDeadLetters returns the dead-letter queue, newest first
+func (s *BadgerStore) ListDeadLetters(ctx context.Context) ([]uuid.UUID, error) {
+	type dead struct {
+		id uuid.UUID
+		at uint64
+	}
+	var all []dead
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefixDead), PrefetchValues: true})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			id, err := uuid.Parse(strings.TrimPrefix(string(item.Key()), prefixDead))
+			if err != nil {
+				continue
+			}
+			err = item.Value(func(val []byte) error {
+				if len(val) == 8 {
+					all = append(all, dead{id: id, at: binary.BigEndian.Uint64(val)})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].at > all[j].at })
+	ids := make([]uuid.UUID, len(all))
+	for i, d := range all {
+		ids[i] = d.id
+	}
+	return ids, nil
+}
+
+// RequeueDeadLetter takes a job off the dead-letter queue and gives it a fresh set of attempts
+func (s *BadgerStore) RequeueDeadLetter(ctx context.Context, id uuid.UUID) error {
+	err := s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(deadKey(id))
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.Requeue(ctx, id)
+}
+
+// undelay drops any pending retry for id
+func (s *BadgerStore) undelay(txn *badger.Txn, id uuid.UUID) error {
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefixDelayed)})
+	defer it.Close()
+
+	var keys [][]byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		if bytes.HasSuffix(it.Item().Key(), []byte(id.String())) {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+	}
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func leaseValue(deadline time.Time) []byte {
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, uint64(deadline.UnixMilli()))
+	return val
+}
+
+func (s *BadgerStore) enqueue(txn *badger.Txn, id uuid.UUID) error {
+	n, err := s.seq.Next()
+	if err != nil {
+		return err
+	}
+	return txn.Set(queueKey(n), []byte(id.String()))
+}
+
+// dequeue drops every queued job for id
+func (s *BadgerStore) dequeue(txn *badger.Txn, id uuid.UUID) error {
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefixQueue), PrefetchValues: true})
+	defer it.Close()
+
+	var keys [][]byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		err := it.Item().Value(func(val []byte) error {
+			if string(val) == id.String() {
+				keys = append(keys, it.Item().KeyCopy(nil))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wake releases every goroutine blocked in PopQueue
+func (s *BadgerStore) wake() {
+	s.mu.Lock()
+	close(s.notify)
+	s.notify = make(chan struct{})
+	s.mu.Unlock()
+}
+
+func getJSON(txn *badger.Txn, key []byte, v interface{}) error {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return item.Value(func(val []byte) error {
+		return json.Unmarshal(val, v)
+	})
+}
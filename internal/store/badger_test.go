@@ -0,0 +1,260 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBadgerStore_Save_And_Get(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx := context.Background()
+	article := model.NewArticle("https://example.com")
+	article.Content = "<p>Body</p>"
+	require.NoError(t, st.Save(ctx, &article))
+
+	got, err := st.Get(ctx, article.ID)
+	require.NoError(t, err)
+	assert.Equal(t, article.URL, got.URL)
+	assert.Equal(t, "<p>Body</p>", got.Content)
+
+	_, err = st.Get(ctx, uuid.New())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestBadgerStore_ListPagination(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx := context.Background()
+	var ids []uuid.UUID
+	for i := 0; i < 5; i++ {
+		a := model.NewArticle("https://example.com")
+		a.CreatedAt = time.Unix(int64(1000+i), 0)
+		require.NoError(t, st.Save(ctx, &a))
+		ids = append(ids, a.ID)
+	}
+
+	// Newest first, two at a time
+	var got []uuid.UUID
+	cursor := ""
+	for {
+		page, next, err := st.List(ctx, ListOptions{Cursor: cursor, Limit: 2})
+		require.NoError(t, err)
+		for _, a := range page {
+			got = append(got, a.ID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, []uuid.UUID{ids[4], ids[3], ids[2], ids[1], ids[0]}, got)
+
+	_, _, err = st.List(ctx, ListOptions{Cursor: "garbage"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestBadgerStore_PopQueue_WakesOnSave(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	popped := make(chan uuid.UUID, 1)
+	go func() {
+		id, err := st.PopQueue(ctx)
+		if err == nil {
+			popped <- id
+		}
+	}()
+
+	// Let the consumer block first
+	time.Sleep(50 * time.Millisecond)
+	article := model.NewArticle("https://example.com")
+	require.NoError(t, st.Save(context.Background(), &article))
+
+	select {
+	case id := <-popped:
+		assert.Equal(t, article.ID, id)
+	case <-ctx.Done():
+		t.Fatal("PopQueue was never woken up")
+	}
+}
+
+func TestBadgerStore_QueueSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	st, err := NewBadgerStore(dir)
+	require.NoError(t, err)
+	first := model.NewArticle("https://example.com/1")
+	second := model.NewArticle("https://example.com/2")
+	require.NoError(t, st.Save(ctx, &first))
+	require.NoError(t, st.Save(ctx, &second))
+	st.Close()
+
+	st, err = NewBadgerStore(dir)
+	require.NoError(t, err)
+	defer st.Close()
+
+	// FIFO order is kept across the restart
+	id, err := st.PopQueue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, id)
+
+	id, err = st.PopQueue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, id)
+}
+
+func TestBadgerStore_RequeueKeepsOneJob(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+	ctx := context.Background()
+
+	a := model.NewArticle("https://example.com/a")
+	require.NoError(t, st.Save(ctx, &a))
+	require.NoError(t, st.Requeue(ctx, a.ID))
+	require.NoError(t, st.Requeue(ctx, a.ID))
+	b := model.NewArticle("https://example.com/b")
+	require.NoError(t, st.Save(ctx, &b))
+
+	id, err := st.PopQueue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, a.ID, id)
+	id, err = st.PopQueue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, b.ID, id, "a was queued once, not three times")
+}
+
+// Trash, Restore and Requeue scan the queue, so a worker popping jobs next
+// to them makes them conflict; they have to start over rather than fail
+func TestBadgerStore_TrashWhileQueueing(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+	ctx := context.Background()
+
+	var ids []uuid.UUID
+	for i := 0; i < 20; i++ {
+		a := model.NewArticle(fmt.Sprintf("https://example.com/%d", i))
+		require.NoError(t, st.Save(ctx, &a))
+		ids = append(ids, a.ID)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			a := model.NewArticle(fmt.Sprintf("https://example.com/more/%d", i))
+			assert.NoError(t, st.Save(ctx, &a))
+			_, err := st.PopQueue(ctx)
+			assert.NoError(t, err)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for _, id := range ids {
+		assert.NoError(t, st.Trash(ctx, id))
+		assert.NoError(t, st.Restore(ctx, id))
+		assert.NoError(t, st.Requeue(ctx, id))
+	}
+	<-done
+}
+
+func TestBadgerStore_LeaseExpiresAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	st, err := NewBadgerStore(dir)
+	require.NoError(t, err)
+	article := model.NewArticle("https://example.com")
+	require.NoError(t, st.Save(ctx, &article))
+
+	// Pop and "crash": close without Ack
+	st.SetLeaseTTL(50 * time.Millisecond)
+	_, err = st.PopQueue(ctx)
+	require.NoError(t, err)
+	st.Close()
+
+	st, err = NewBadgerStore(dir)
+	require.NoError(t, err)
+	defer st.Close()
+
+	time.Sleep(60 * time.Millisecond)
+	n, err := st.RequeueExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	id, err := st.PopQueue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, article.ID, id)
+	require.NoError(t, st.Ack(ctx, id))
+
+	n, err = st.RequeueExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "Acked jobs never come back")
+}
+
+func TestBadgerStore_RawRoundTrip(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx := context.Background()
+	id := uuid.New()
+	_, err = st.GetRaw(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	raw := []byte("<html><script>tracking()</script><body>" + string(make([]byte, 4096)) + "</body></html>")
+	require.NoError(t, st.SaveRaw(ctx, id, raw))
+
+	got, err := st.GetRaw(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, raw, got)
+}
+
+func TestBadgerStore_AssetsAreGarbageCollected(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx := context.Background()
+	shared := Asset{Hash: "aaaa", ContentType: "image/png", Data: []byte("png")}
+	own := Asset{Hash: "bbbb", ContentType: "image/gif", Data: []byte("gif")}
+	require.NoError(t, st.PutAsset(ctx, shared))
+	require.NoError(t, st.PutAsset(ctx, own))
+
+	first := model.NewArticle("https://example.com/1")
+	second := model.NewArticle("https://example.com/2")
+	require.NoError(t, st.Save(ctx, &first))
+	require.NoError(t, st.Save(ctx, &second))
+	require.NoError(t, st.SetAssetManifest(ctx, first.ID, []AssetRef{{URL: "u1", Hash: "aaaa"}, {URL: "u2", Hash: "bbbb"}}))
+	require.NoError(t, st.SetAssetManifest(ctx, second.ID, []AssetRef{{URL: "u1", Hash: "aaaa"}}))
+
+	// Deleting the first article frees only the blob nobody else uses
+	require.NoError(t, st.Delete(ctx, first.ID))
+	_, err = st.GetAsset(ctx, "bbbb")
+	assert.ErrorIs(t, err, ErrNotFound)
+	got, err := st.GetAsset(ctx, "aaaa")
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", got.ContentType)
+
+	require.NoError(t, st.Delete(ctx, second.ID))
+	_, err = st.GetAsset(ctx, "aaaa")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
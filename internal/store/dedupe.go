@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+
+	"crusty-buffer/internal/model"
+)
+
+// DedupeMode says what Add does with a URL that is already in the archive.
+// Articles are snapshots: the same page saved twice is two articles, on purpose.
+type DedupeMode string
+
+const (
+	DedupeVersion DedupeMode = "version" // The default: a new snapshot, unless one is still on its way
+	DedupeSkip    DedupeMode = "skip"    // Nothing, if a snapshot exists that didn't fail
+	DedupeAlways  DedupeMode = "always"  // A new snapshot, without looking
+)
+
+// ValidDedupe accepts "" as DedupeVersion
+func ValidDedupe(m DedupeMode) bool {
+	return m == "" || m == DedupeVersion || m == DedupeSkip || m == DedupeAlways
+}
+
+// Lister is the part of Store that only reads listings. client.Client has it too.
+type Lister interface {
+	List(ctx context.Context, opts ListOptions) ([]model.Article, string, error)
+}
+
+// Snapshots lists every article of the page rawURL points at, newest first.
+// Trashed ones don't count.
+func Snapshots(ctx context.Context, st Lister, rawURL string) ([]model.Article, error) {
+	var all []model.Article
+	opts := ListOptions{URL: rawURL, Limit: 200}
+	for {
+		page, next, err := st.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if next == "" {
+			return all, nil
+		}
+		opts.Cursor = next
+	}
+}
+
+// Add queues a new article for rawURL, unless mode finds a snapshot to hand
+// back instead; that one gets the tags added. created says which it was.
+func Add(ctx context.Context, st Store, rawURL string, tags []string, mode DedupeMode) (article *model.Article, created bool, err error) {
+	a := model.NewArticle(rawURL)
+	a.Tags = tags
+	return AddArticle(ctx, st, &a, mode)
+}
+
+// AddArticle is Add for an article built by the caller
+func AddArticle(ctx context.Context, st Store, a *model.Article, mode DedupeMode) (article *model.Article, created bool, err error) {
+	if !ValidDedupe(mode) {
+		return nil, false, ErrInvalidDedupe
+	}
+	if mode != DedupeAlways {
+		snapshots, err := Snapshots(ctx, st, a.URL)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, s := range snapshots {
+			if reuse(s, mode) {
+				if len(a.Tags) == 0 {
+					return &s, false, nil
+				}
+				article, err := UpdateTags(ctx, st, s.ID, a.Tags, nil)
+				return article, false, err
+			}
+		}
+	}
+
+	if err := st.Save(ctx, a); err != nil {
+		return nil, false, err
+	}
+	return a, true, nil
+}
+
+func reuse(a model.Article, mode DedupeMode) bool {
+	switch a.Status {
+	case model.StatusPending, model.StatusProcessing, model.StatusRetrying:
+		return true
+	case model.StatusFailed:
+		return false
+	}
+	return mode == DedupeSkip
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// archive walks an article through the worker's moves
+func archive(t *testing.T, st Store, id uuid.UUID) {
+	t.Helper()
+	for _, to := range []model.ArticleStatus{model.StatusProcessing, model.StatusArchived} {
+		_, err := st.UpdateStatus(context.Background(), id, model.StatusEvent{To: to})
+		require.NoError(t, err)
+	}
+}
+
+func testDedupe(t *testing.T, st Store) {
+	ctx := context.Background()
+
+	first, created, err := Add(ctx, st, "https://Example.com/post?utm_source=rss", nil, "")
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "https://example.com/post", first.CanonicalURL)
+
+	// Still queued: versioning hands the queued one back, with the new tags
+	again, created, err := Add(ctx, st, "https://example.com/post#top", []string{"Go"}, DedupeVersion)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, first.ID, again.ID)
+	assert.Equal(t, []string{"go"}, again.Tags)
+
+	archive(t, st, first.ID)
+	second, created, err := Add(ctx, st, "https://example.com/post", nil, DedupeVersion)
+	require.NoError(t, err)
+	assert.True(t, created, "Archived pages get a new snapshot")
+
+	archive(t, st, second.ID)
+	skipped, created, err := Add(ctx, st, "https://example.com/post", nil, DedupeSkip)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, second.ID, skipped.ID, "The newest one")
+
+	third, created, err := Add(ctx, st, "https://example.com/post", nil, DedupeAlways)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	_, _, err = Add(ctx, st, "https://example.com/post", nil, "sometimes")
+	assert.ErrorIs(t, err, ErrInvalidDedupe)
+
+	other, _, err := Add(ctx, st, "https://example.com/other", nil, "")
+	require.NoError(t, err)
+
+	snapshots, err := Snapshots(ctx, st, "https://EXAMPLE.com/post?fbclid=x")
+	require.NoError(t, err)
+	var ids []uuid.UUID
+	for _, a := range snapshots {
+		ids = append(ids, a.ID)
+	}
+	assert.Equal(t, []uuid.UUID{third.ID, second.ID, first.ID}, ids)
+
+	// The worker found out where the page really lives; the index follows
+	moved, err := st.Get(ctx, other.ID)
+	require.NoError(t, err)
+	moved.CanonicalURL = "https://example.com/post"
+	require.NoError(t, st.Save(ctx, moved))
+	assert.Len(t, listAll(t, st, ListOptions{URL: "https://example.com/post"}), 4)
+	assert.Empty(t, listAll(t, st, ListOptions{URL: "https://example.com/other"}))
+
+	// Trashed snapshots don't count
+	require.NoError(t, st.Trash(ctx, third.ID))
+	assert.Len(t, listAll(t, st, ListOptions{URL: "https://example.com/post"}), 3)
+}
+
+func TestDedupe_Hybrid(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+
+	testDedupe(t, st)
+}
+
+func TestDedupe_Badger(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	testDedupe(t, st)
+}
@@ -0,0 +1,277 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNotSubscribed is returned by DeleteFeed and UpdateFeed for a feed we don't follow
+var ErrNotSubscribed = errors.New("not subscribed to that feed")
+
+// AddFeed subscribes to rawURL, keeping the entries already seen if it's a
+// resubscription. The first fetch is due right away.
+func AddFeed(ctx context.Context, st Store, rawURL, name string, interval time.Duration, backfill int) (model.Feed, error) {
+	f, err := model.NewFeed(rawURL, name, interval, backfill)
+	if err != nil {
+		return model.Feed{}, err
+	}
+	return f, st.SaveFeed(ctx, f)
+}
+
+func sortFeeds(feeds []model.Feed) []model.Feed {
+	sort.Slice(feeds, func(i, j int) bool { return feeds[i].URL < feeds[j].URL })
+	return feeds
+}
+
+// feedField is a feed's key in the feeds hash and the feed: prefix
+func feedField(rawURL string) string { return model.URLHash(model.CanonicalizeURL(rawURL)) }
+
+// guidHash keeps odd GUIDs (they can be anything) out of keys
+func guidHash(guid string) string {
+	sum := sha256.Sum256([]byte(guid))
+	return hex.EncodeToString(sum[:16])
+}
+
+const keyFeeds = "feeds"
+
+func feedSeenKey(rawURL string) string { return "feed:" + feedField(rawURL) + ":seen" }
+
+// Feeds reads the whole feeds hash, keyed like the watches one
+func (s *HybridStore) Feeds(ctx context.Context) ([]model.Feed, error) {
+	vals, err := s.rdb.HGetAll(ctx, keyFeeds).Result()
+	if err != nil {
+		return nil, err
+	}
+	feeds := make([]model.Feed, 0, len(vals))
+	for _, v := range vals {
+		var f model.Feed
+		if err := json.Unmarshal([]byte(v), &f); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+	return sortFeeds(feeds), nil
+}
+
+func (s *HybridStore) SaveFeed(ctx context.Context, f model.Feed) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, keyFeeds, feedField(f.URL), data).Err()
+}
+
+// updateFeedScript sets the field only if it's there, so an unsubscribe
+// that lands first isn't undone
+var updateFeedScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+func (s *HybridStore) UpdateFeed(ctx context.Context, f model.Feed) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	ok, err := updateFeedScript.Run(ctx, s.rdb, []string{keyFeeds}, feedField(f.URL), data).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrNotSubscribed
+	}
+	return nil
+}
+
+func (s *HybridStore) DeleteFeed(ctx context.Context, rawURL string) error {
+	pipe := s.rdb.TxPipeline()
+	n := pipe.HDel(ctx, keyFeeds, feedField(rawURL))
+	pipe.Del(ctx, feedSeenKey(rawURL))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if n.Val() == 0 {
+		return ErrNotSubscribed
+	}
+	return nil
+}
+
+// SeenEntries checks the feed's set of seen GUID hashes
+func (s *HybridStore) SeenEntries(ctx context.Context, feedURL string, guids []string) (map[string]bool, error) {
+	seen := make(map[string]bool, len(guids))
+	if len(guids) == 0 {
+		return seen, nil
+	}
+	members := make([]interface{}, len(guids))
+	for i, guid := range guids {
+		members[i] = guidHash(guid)
+	}
+	found, err := s.rdb.SMIsMember(ctx, feedSeenKey(feedURL), members...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, guid := range guids {
+		seen[guid] = found[i]
+	}
+	return seen, nil
+}
+
+func (s *HybridStore) MarkSeen(ctx context.Context, feedURL string, guids []string) error {
+	if len(guids) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(guids))
+	for i, guid := range guids {
+		members[i] = guidHash(guid)
+	}
+	return s.rdb.SAdd(ctx, feedSeenKey(feedURL), members...).Err()
+}
+
+func feedKey(rawURL string) []byte { return []byte(prefixFeed + feedField(rawURL)) }
+
+func feedSeenPrefix(rawURL string) string { return prefixFeedSeen + feedField(rawURL) + ":" }
+
+// Feeds walks the feed: keys
+func (s *BadgerStore) Feeds(ctx context.Context) ([]model.Feed, error) {
+	var feeds []model.Feed
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(prefixFeed)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var f model.Feed
+				if err := json.Unmarshal(val, &f); err != nil {
+					return err
+				}
+				feeds = append(feeds, f)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return sortFeeds(feeds), err
+}
+
+func (s *BadgerStore) SaveFeed(ctx context.Context, f model.Feed) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(feedKey(f.URL), data)
+	})
+}
+
+// UpdateFeed reads and writes in one transaction; a DeleteFeed committed in
+// between makes it conflict, and the next try finds the feed gone
+func (s *BadgerStore) UpdateFeed(ctx context.Context, f model.Feed) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < maxRetries; i++ {
+		err = s.db.Update(func(txn *badger.Txn) error {
+			if _, err := txn.Get(feedKey(f.URL)); errors.Is(err, badger.ErrKeyNotFound) {
+				return ErrNotSubscribed
+			} else if err != nil {
+				return err
+			}
+			return txn.Set(feedKey(f.URL), data)
+		})
+		if !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// DeleteFeed drops the subscription, then its seen: keys, which may take
+// more than one transaction. Seen keys left without a feed are dropped too.
+func (s *BadgerStore) DeleteFeed(ctx context.Context, rawURL string) error {
+	err := s.db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(feedKey(rawURL)); errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrNotSubscribed
+		} else if err != nil {
+			return err
+		}
+		return txn.Delete(feedKey(rawURL))
+	})
+	if err != nil && !errors.Is(err, ErrNotSubscribed) {
+		return err
+	}
+	if dropErr := deletePrefix(s.db, []byte(feedSeenPrefix(rawURL))); dropErr != nil {
+		return dropErr
+	}
+	return err
+}
+
+// deletePrefix deletes every key under prefix in batches. Unlike DropPrefix
+// it doesn't stop writes to the whole DB while it runs.
+func deletePrefix(db *badger.DB, prefix []byte) error {
+	var keys [][]byte
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+func (s *BadgerStore) SeenEntries(ctx context.Context, feedURL string, guids []string) (map[string]bool, error) {
+	seen := make(map[string]bool, len(guids))
+	prefix := feedSeenPrefix(feedURL)
+	err := s.db.View(func(txn *badger.Txn) error {
+		for _, guid := range guids {
+			_, err := txn.Get([]byte(prefix + guidHash(guid)))
+			if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+			seen[guid] = err == nil
+		}
+		return nil
+	})
+	return seen, err
+}
+
+func (s *BadgerStore) MarkSeen(ctx context.Context, feedURL string, guids []string) error {
+	prefix := feedSeenPrefix(feedURL)
+	return s.db.Update(func(txn *badger.Txn) error {
+		for _, guid := range guids {
+			if err := txn.Set([]byte(prefix+guidHash(guid)), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testFeeds(t *testing.T, st Store) {
+	ctx := context.Background()
+
+	_, err := AddFeed(ctx, st, "https://example.com/feed.xml", "", time.Minute, 0)
+	assert.ErrorIs(t, err, model.ErrFeedInterval)
+
+	f, err := AddFeed(ctx, st, "https://Example.com/feed.xml", "Example Blog", 0, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "example-blog", f.Name)
+	assert.Equal(t, model.Duration(model.DefaultFeedInterval), f.Interval)
+
+	feeds, err := st.Feeds(ctx)
+	require.NoError(t, err)
+	require.Len(t, feeds, 1)
+	assert.Equal(t, "https://example.com/feed.xml", feeds[0].URL)
+	assert.Equal(t, 3, feeds[0].Backfill)
+
+	seen, err := st.SeenEntries(ctx, f.URL, []string{"a", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a": false, "b": false}, seen)
+	require.NoError(t, st.MarkSeen(ctx, f.URL, []string{"a", "tag:odd guid, with spaces"}))
+	seen, err = st.SeenEntries(ctx, "https://example.com/feed.xml", []string{"a", "b", "tag:odd guid, with spaces"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a": true, "b": false, "tag:odd guid, with spaces": true}, seen)
+
+	f.Title = "Renamed"
+	require.NoError(t, st.UpdateFeed(ctx, f))
+	feeds, err = st.Feeds(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed", feeds[0].Title)
+
+	require.NoError(t, st.DeleteFeed(ctx, "https://EXAMPLE.com/feed.xml"))
+	assert.ErrorIs(t, st.DeleteFeed(ctx, f.URL), ErrNotSubscribed)
+	assert.ErrorIs(t, st.UpdateFeed(ctx, f), ErrNotSubscribed)
+	feeds, err = st.Feeds(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, feeds)
+	seen, err = st.SeenEntries(ctx, f.URL, []string{"a"})
+	require.NoError(t, err)
+	assert.False(t, seen["a"], "Unsubscribing forgets the entries")
+}
+
+func TestFeeds_Hybrid(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+
+	testFeeds(t, st)
+}
+
+func TestFeeds_Badger(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	testFeeds(t, st)
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// FlagUpdate changes the reader's flags on an article; nil fields are left alone
+type FlagUpdate struct {
+	Read     *bool         `json:"read,omitempty"`
+	Favorite *bool         `json:"favorite,omitempty"`
+	Folder   *model.Folder `json:"folder,omitempty"`
+}
+
+// UpdateFlags applies u to an article and saves it. Marking an article read
+// that already is keeps the original ReadAt.
+func UpdateFlags(ctx context.Context, st Store, id uuid.UUID, u FlagUpdate) (*model.Article, error) {
+	if u.Folder != nil && !validFolder(*u.Folder) {
+		return nil, ErrInvalidFolder
+	}
+	return updateMeta(ctx, st, id, func(a *model.Article) {
+		if u.Read != nil && !*u.Read {
+			a.ReadAt = nil
+		} else if u.Read != nil && a.ReadAt == nil {
+			now := time.Now()
+			a.ReadAt = &now
+		}
+		if u.Favorite != nil {
+			a.Favorite = *u.Favorite
+		}
+		if u.Folder != nil {
+			a.Folder = *u.Folder
+		}
+	})
+}
+
+func validFolder(f model.Folder) bool {
+	for _, folder := range model.Folders {
+		if f == folder {
+			return true
+		}
+	}
+	return false
+}
+
+// updateMeta changes an article's metadata in the store's atomic
+// read-modify-write (see Store.UpdateMeta), so it never writes back a stale
+// status or undoes a change made meanwhile. The article returned has no content.
+func updateMeta(ctx context.Context, st Store, id uuid.UUID, change func(a *model.Article)) (*model.Article, error) {
+	return st.UpdateMeta(ctx, id, func(a *model.Article) error {
+		change(a)
+		return nil
+	})
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testFlags(t *testing.T, st Store) {
+	ctx := context.Background()
+	yes, no := true, false
+	archive := model.FolderArchive
+
+	a := saveArchived(t, st, "First", "")
+	b := saveArchived(t, st, "Second", "")
+	assert.Equal(t, model.FolderInbox, a.Folder)
+	assert.Equal(t, []uuid.UUID{b.ID, a.ID}, listAll(t, st, ListOptions{Unread: true}))
+	assert.Equal(t, []uuid.UUID{b.ID, a.ID}, listAll(t, st, ListOptions{Folder: model.FolderInbox}))
+
+	got, err := UpdateFlags(ctx, st, a.ID, FlagUpdate{Read: &yes, Favorite: &yes})
+	require.NoError(t, err)
+	require.NotNil(t, got.ReadAt)
+	readAt := *got.ReadAt
+
+	// Reading it again keeps the first ReadAt
+	got, err = UpdateFlags(ctx, st, a.ID, FlagUpdate{Read: &yes})
+	require.NoError(t, err)
+	assert.Equal(t, readAt.UnixNano(), got.ReadAt.UnixNano())
+
+	assert.Equal(t, []uuid.UUID{b.ID}, listAll(t, st, ListOptions{Unread: true}))
+	assert.Equal(t, []uuid.UUID{a.ID}, listAll(t, st, ListOptions{Favorite: true}))
+	assert.Empty(t, listAll(t, st, ListOptions{Favorite: true, Unread: true}))
+
+	_, err = UpdateFlags(ctx, st, b.ID, FlagUpdate{Folder: &archive})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{a.ID}, listAll(t, st, ListOptions{Folder: model.FolderInbox}))
+	assert.Equal(t, []uuid.UUID{b.ID}, listAll(t, st, ListOptions{Folder: model.FolderArchive}))
+	assert.Equal(t, []uuid.UUID{b.ID}, listAll(t, st, ListOptions{Folder: model.FolderArchive, Unread: true}))
+
+	_, err = UpdateFlags(ctx, st, a.ID, FlagUpdate{Read: &no, Favorite: &no})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{b.ID, a.ID}, listAll(t, st, ListOptions{Unread: true}))
+	assert.Empty(t, listAll(t, st, ListOptions{Favorite: true}))
+
+	bogus := model.Folder("someday")
+	_, err = UpdateFlags(ctx, st, a.ID, FlagUpdate{Folder: &bogus})
+	assert.ErrorIs(t, err, ErrInvalidFolder)
+	_, _, err = st.List(ctx, ListOptions{Folder: bogus})
+	assert.ErrorIs(t, err, ErrInvalidFolder)
+	_, err = UpdateFlags(ctx, st, uuid.New(), FlagUpdate{Read: &yes})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// A write landing between the read and the write starts it over, so
+	// neither change is lost
+	calls := 0
+	got, err = st.UpdateMeta(ctx, b.ID, func(a *model.Article) error {
+		if calls++; calls == 1 {
+			require.NoError(t, st.Trash(ctx, b.ID))
+		}
+		a.Favorite = true
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.True(t, got.Favorite)
+	got, err = st.Get(ctx, b.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusDeleted, got.Status)
+	assert.NotNil(t, got.DeletedAt)
+	assert.True(t, got.Favorite)
+}
+
+func TestFlags_Hybrid(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := NewHybridStore(mr.Addr(), "")
+	require.NoError(t, err)
+	defer st.Close()
+
+	testFlags(t, st)
+}
+
+func TestFlags_Badger(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	testFlags(t, st)
+}
+
+// Updating flags must not lose the content it leaves out of the save
+func TestFlags_KeepContent(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+	ctx := context.Background()
+
+	a := saveArchived(t, st, "Gophers", "<p>All about gophers.</p>")
+	yes := true
+	_, err = UpdateFlags(ctx, st, a.ID, FlagUpdate{Favorite: &yes})
+	require.NoError(t, err)
+
+	got, err := st.Get(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>All about gophers.</p>", got.Content)
+	results, _, err := st.Search(ctx, "gophers", SearchOptions{})
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+}
+
+// Stores indexed before unread and folders existed get those built on open
+func TestListing_HybridMigratesToUnreadAndFolders(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	a := model.NewArticle("https://example.com")
+	a.Folder = ""
+	data, err := json.Marshal(a)
+	require.NoError(t, err)
+	require.NoError(t, mr.Set("article:"+a.ID.String(), string(data)))
+	_, err = mr.ZAdd(keyListCreated, 0, listMember(&a))
+	require.NoError(t, err)
+
+	st, err := NewHybridStore(mr.Addr(), "")
+	require.NoError(t, err)
+	defer st.Close()
+
+	assert.Equal(t, []uuid.UUID{a.ID}, listAll(t, st, ListOptions{Unread: true}))
+	assert.Equal(t, []uuid.UUID{a.ID}, listAll(t, st, ListOptions{Folder: model.FolderInbox}))
+}
@@ -3,7 +3,10 @@ package store
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"crusty-buffer/internal/model"
 
@@ -14,8 +17,9 @@ import (
 
 // HybridStore combines Redis (speed/queue) and Badger (heavy storage)
 type HybridStore struct {
-	rdb *redis.Client
-	db  *badger.DB
+	rdb      *redis.Client
+	db       *badger.DB
+	leaseTTL time.Duration
 }
 
 // NewHybridStore initializes databases. 
@@ -42,7 +46,18 @@ func NewHybridStore(redisAddr string, badgerPath string) (*HybridStore, error) {
 		}
 	}
 
-	return &HybridStore{rdb: rdb, db: db}, nil
+	s := &HybridStore{rdb: rdb, db: db, leaseTTL: DefaultLeaseTTL}
+	if err := s.migrateList(context.Background()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to build listing indexes: %w", err)
+	}
+	if db != nil {
+		if err := countIndexDocs(db, false); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to count search documents: %w", err)
+		}
+	}
+	return s, nil
 }
 
 // Close cleans up connections
@@ -57,6 +72,9 @@ func (s *HybridStore) Close() {
 
 // Save combines data: Metadata to Redis + Content to Badger
 func (s *HybridStore) Save(ctx context.Context, article *model.Article) error {
+	// Tags end up in index keys, so only normalized ones get that far
+	article.Tags = model.NormalizeTags(article.Tags)
+	article.Folder = article.InFolder()
 	meta := *article
 	meta.Content = "" 
 
@@ -65,16 +83,25 @@ func (s *HybridStore) Save(ctx context.Context, article *model.Article) error {
 		return err
 	}
 
-	// Save Metadata to Redis Hash
+	// Re-saving a pending article (e.g. a retry bumping Attempts) must not queue it twice
 	key := fmt.Sprintf("article:%s", article.ID)
+	old, err := s.meta(ctx, article.ID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	// Save Metadata to Redis Hash
 	pipe := s.rdb.Pipeline()
 	pipe.Set(ctx, key, data, 0)
 
-	// If it's a new pending article, add to Queue and Recent List
-	if article.Status == model.StatusPending {
-		pipe.LPush(ctx, "queue:archive", article.ID.String())
-		pipe.LPush(ctx, "list:recent", article.ID.String())
-		pipe.LTrim(ctx, "list:recent", 0, 49) // Keep only last 50 items
+	if old != nil {
+		unindexChanged(ctx, pipe, old, article)
+	}
+	indexList(ctx, pipe, article)
+
+	// If it's a new pending article, add to Queue
+	if article.Status == model.StatusPending && old == nil {
+		pipe.LPush(ctx, keyQueue, article.ID.String())
 	}
 	if _, err := pipe.Exec(ctx); err != nil {
 		return err
@@ -87,8 +114,8 @@ func (s *HybridStore) Save(ctx context.Context, article *model.Article) error {
 			// or if server is running in no-disk mode.
 			return fmt.Errorf("cannot save content: badgerdb is not initialized")
 		}
-		err = s.db.Update(func(txn *badger.Txn) error {
-			return txn.Set([]byte(article.ID.String()), []byte(article.Content))
+		err = retryUpdate(s.db, func(txn *badger.Txn) error {
+			return putContent(txn, article)
 		})
 		if err != nil {
 			return err
@@ -98,6 +125,23 @@ func (s *HybridStore) Save(ctx context.Context, article *model.Article) error {
 	return nil
 }
 
+// SaveContent writes the content to Badger if the metadata is still in Redis
+func (s *HybridStore) SaveContent(ctx context.Context, article *model.Article) error {
+	if s.db == nil {
+		return fmt.Errorf("cannot save content: badgerdb is not initialized")
+	}
+	n, err := s.rdb.Exists(ctx, fmt.Sprintf("article:%s", article.ID)).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return retryUpdate(s.db, func(txn *badger.Txn) error {
+		return putContent(txn, article)
+	})
+}
+
 // Get combines data: Metadata from Redis + Content from Badger
 func (s *HybridStore) Get(ctx context.Context, id uuid.UUID) (*model.Article, error) {
 	// Fetch Metadata from Redis
@@ -116,7 +160,11 @@ func (s *HybridStore) Get(ctx context.Context, id uuid.UUID) (*model.Article, er
 	// Fetch Content from Badger (if available AND configured)
 	if s.db != nil {
 		err = s.db.View(func(txn *badger.Txn) error {
-			item, err := txn.Get([]byte(id.String()))
+			item, err := txn.Get(contentKey(id))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				// Archives from before namespaced keys stored content under the bare ID
+				item, err = txn.Get([]byte(id.String()))
+			}
 			if err != nil {
 				return err
 			}
@@ -134,53 +182,674 @@ func (s *HybridStore) Get(ctx context.Context, id uuid.UUID) (*model.Article, er
 	return &article, nil
 }
 
-// List fetches the most recent articles from Redis
-func (s *HybridStore) List(ctx context.Context, limit int) ([]model.Article, error) {
-	ids, err := s.rdb.LRange(ctx, "list:recent", 0, int64(limit-1)).Result()
+// SaveRaw keeps the page exactly as downloaded (zstd-compressed) under raw:<id>
+func (s *HybridStore) SaveRaw(ctx context.Context, id uuid.UUID, raw []byte) error {
+	if s.db == nil {
+		return fmt.Errorf("cannot save raw html: badgerdb is not initialized")
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(rawKey(id), compress(raw))
+	})
+}
+
+// GetRaw returns the page as downloaded, or ErrNotFound if it was never kept
+func (s *HybridStore) GetRaw(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("cannot read raw html: badgerdb is not initialized")
+	}
+	return getCompressed(s.db, rawKey(id))
+}
+
+const defaultListLimit = 50
+
+// List reads one of the listing indexes (see indexList) and fetches the
+// metadata for each batch with a single MGET.
+func (s *HybridStore) List(ctx context.Context, opts ListOptions) ([]model.Article, string, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if err := validOptions(opts); err != nil {
+		return nil, "", err
+	}
+	opts = canonicalOptions(opts)
+	lo, hi, err := memberRange(opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	var articles []model.Article
-	for _, idStr := range ids {
-		val, err := s.rdb.Get(ctx, fmt.Sprintf("article:%s", idStr)).Bytes()
-		if err == redis.Nil {
-			continue
+	key := keyListCreated
+	switch {
+	case opts.Trashed:
+		key = keyListTrash
+	case opts.URL != "":
+		key = urlListKey(opts.URL)
+	case opts.Tag != "":
+		key = tagListKey(opts.Tag)
+	case opts.Favorite:
+		key = keyListFavorites
+	case opts.Unread:
+		key = keyListUnread
+	case opts.Folder != "":
+		key = listFolderKey(opts.Folder)
+	case opts.Domain != "":
+		key = listDomainKey(opts.Domain)
+	case len(opts.Status) == 1:
+		key = listStatusKey(opts.Status[0])
+	}
+
+	// page returns up to n members after the bounds, in Order
+	page := func(n int64) ([]string, error) {
+		by := &redis.ZRangeBy{Min: "-", Max: "+", Count: n}
+		if lo != "" {
+			by.Min = "[" + lo
+		}
+		if hi != "" {
+			by.Max = "(" + hi
+		}
+		if opts.Order == OrderOldest {
+			return s.rdb.ZRangeByLex(ctx, key, by).Result()
+		}
+		return s.rdb.ZRevRangeByLex(ctx, key, by).Result()
+	}
+	// advance moves the bounds past member
+	advance := func(member string) {
+		if opts.Order == OrderOldest {
+			lo = member + "\x00"
+		} else {
+			hi = member
+		}
+	}
+
+	articles := []model.Article{}
+	for {
+		members, err := page(int64(limit))
+		if err != nil {
+			return nil, "", err
+		}
+		if len(members) == 0 {
+			return articles, "", nil
 		}
-		
-		var a model.Article
-		if err := json.Unmarshal(val, &a); err == nil {
+
+		keys := make([]string, len(members))
+		for i, m := range members {
+			id, _ := memberID(m)
+			keys[i] = "article:" + id.String()
+		}
+		vals, err := s.rdb.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, "", err
+		}
+
+		for i, val := range vals {
+			advance(members[i])
+
+			str, ok := val.(string)
+			if !ok {
+				continue // Deleted since it was indexed
+			}
+			var a model.Article
+			if err := json.Unmarshal([]byte(str), &a); err != nil {
+				continue
+			}
+			if !listMatch(&a, opts) {
+				continue
+			}
+
 			articles = append(articles, a)
+			if len(articles) < limit {
+				continue
+			}
+
+			// Only hand out a cursor if there is something left to read
+			rest, err := page(1)
+			if err != nil {
+				return nil, "", err
+			}
+			if len(rest) == 0 {
+				return articles, "", nil
+			}
+			return articles, listMember(&a), nil
+		}
+	}
+}
+
+// Listing indexes in Redis, all sorted sets with score 0 so ZRANGEBYLEX
+// orders them by member (see listMember):
+//
+//	list:created          every article
+//	list:status:<status>  articles currently in that status
+//	list:domain:<domain>  articles from that domain
+//	tag:<tag>             articles with that tag
+//	url:<hash>            snapshots of one page, by model.URLHash of the canonical URL
+//	list:unread           articles without ReadAt
+//	list:favorites        favorite articles
+//	list:folder:<folder>  articles filed in that folder
+//	list:trash            trashed articles, which are in none of the above
+//
+// They replace list:recent, which was trimmed to the last 50 articles.
+// list:version says which indexes exist; see migrateList.
+const (
+	keyListCreated   = "list:created"
+	keyListUnread    = "list:unread"
+	keyListFavorites = "list:favorites"
+	keyListTrash     = "list:trash"
+	keyListLegacy    = "list:recent"
+	keyListVersion   = "list:version"
+)
+
+func listStatusKey(status model.ArticleStatus) string { return "list:status:" + string(status) }
+func listDomainKey(domain string) string              { return "list:domain:" + domain }
+func tagListKey(tag string) string                    { return "tag:" + tag }
+func listFolderKey(folder model.Folder) string       { return "list:folder:" + string(folder) }
+func urlListKey(canonical string) string              { return "url:" + model.URLHash(canonical) }
+
+// indexList files the article under its current status and takes it out of
+// the others. A trashed article only goes into the trash.
+func indexList(ctx context.Context, pipe redis.Pipeliner, a *model.Article) {
+	if a.DeletedAt != nil {
+		unindexList(ctx, pipe, a)
+		pipe.ZAdd(ctx, keyListTrash, redis.Z{Member: listMember(a)})
+		return
+	}
+
+	z := redis.Z{Member: listMember(a)}
+	pipe.ZRem(ctx, keyListTrash, z.Member)
+	pipe.ZAdd(ctx, keyListCreated, z)
+	pipe.ZAdd(ctx, listDomainKey(a.Domain()), z)
+	pipe.ZAdd(ctx, urlListKey(a.Canonical()), z)
+	for _, tag := range a.Tags {
+		pipe.ZAdd(ctx, tagListKey(tag), z)
+	}
+	if a.ReadAt == nil {
+		pipe.ZAdd(ctx, keyListUnread, z)
+	} else {
+		pipe.ZRem(ctx, keyListUnread, z.Member)
+	}
+	if a.Favorite {
+		pipe.ZAdd(ctx, keyListFavorites, z)
+	} else {
+		pipe.ZRem(ctx, keyListFavorites, z.Member)
+	}
+	for _, folder := range model.Folders {
+		if folder == a.InFolder() {
+			pipe.ZAdd(ctx, listFolderKey(folder), z)
+		} else {
+			pipe.ZRem(ctx, listFolderKey(folder), z.Member)
+		}
+	}
+	for _, status := range model.Statuses {
+		if status == a.Status {
+			pipe.ZAdd(ctx, listStatusKey(status), z)
+		} else {
+			pipe.ZRem(ctx, listStatusKey(status), z.Member)
 		}
 	}
+}
 
-	return articles, nil
+// unindexChanged takes the article out of the indexes for tags it no longer
+// has, and out of its old URL's and domain's if those changed
+func unindexChanged(ctx context.Context, pipe redis.Pipeliner, old, a *model.Article) {
+	for _, tag := range old.Tags {
+		if !a.HasTag(tag) {
+			pipe.ZRem(ctx, tagListKey(tag), listMember(old))
+		}
+	}
+	if old.Canonical() != a.Canonical() {
+		pipe.ZRem(ctx, urlListKey(old.Canonical()), listMember(old))
+	}
+	if old.Domain() != a.Domain() {
+		pipe.ZRem(ctx, listDomainKey(old.Domain()), listMember(old))
+	}
 }
 
-// UpdateStatus is a helper to just flip the status flag in Redis
-func (s *HybridStore) UpdateStatus(ctx context.Context, id uuid.UUID, status model.ArticleStatus) error {
-	val, err := s.rdb.Get(ctx, fmt.Sprintf("article:%s", id)).Bytes()
-	if err != nil {
+func unindexList(ctx context.Context, pipe redis.Pipeliner, a *model.Article) {
+	member := listMember(a)
+	pipe.ZRem(ctx, keyListCreated, member)
+	pipe.ZRem(ctx, keyListTrash, member)
+	pipe.ZRem(ctx, listDomainKey(a.Domain()), member)
+	pipe.ZRem(ctx, urlListKey(a.Canonical()), member)
+	for _, tag := range a.Tags {
+		pipe.ZRem(ctx, tagListKey(tag), member)
+	}
+	pipe.ZRem(ctx, keyListUnread, member)
+	pipe.ZRem(ctx, keyListFavorites, member)
+	for _, folder := range model.Folders {
+		pipe.ZRem(ctx, listFolderKey(folder), member)
+	}
+	for _, status := range model.Statuses {
+		pipe.ZRem(ctx, listStatusKey(status), member)
+	}
+}
+
+// migrateList (re)builds the listing indexes when they are older than
+// listIndexVersion, e.g. for data written while list:recent was the only one.
+// It runs once per version: list:version is bumped afterwards.
+func (s *HybridStore) migrateList(ctx context.Context) error {
+	version, err := s.rdb.Get(ctx, keyListVersion).Int()
+	if err != nil && err != redis.Nil {
 		return err
 	}
+	if version >= listIndexVersion {
+		return nil
+	}
 
+	var keys []string
+	iter := s.rdb.Scan(ctx, 0, "article:*", 500).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), 500)]
+		keys = keys[len(batch):]
+
+		vals, err := s.rdb.MGet(ctx, batch...).Result()
+		if err != nil {
+			return err
+		}
+		pipe := s.rdb.Pipeline()
+		for _, val := range vals {
+			str, ok := val.(string)
+			if !ok {
+				continue
+			}
+			var a model.Article
+			if err := json.Unmarshal([]byte(str), &a); err != nil {
+				continue
+			}
+			indexList(ctx, pipe, &a)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, keyListLegacy)
+	pipe.Set(ctx, keyListVersion, listIndexVersion, 0)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Walk SCANs the metadata keys, so it finds everything even if an index is off
+func (s *HybridStore) Walk(ctx context.Context, fn func(id uuid.UUID) error) error {
+	iter := s.rdb.Scan(ctx, 0, "article:*", 100).Iterator()
+	for iter.Next(ctx) {
+		id, err := uuid.Parse(strings.TrimPrefix(iter.Val(), "article:"))
+		if err != nil {
+			continue
+		}
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func matchStatus(status model.ArticleStatus, want []model.ArticleStatus) bool {
+	if len(want) == 0 {
+		return true
+	}
+	for _, w := range want {
+		if status == w {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes the article everywhere: metadata, queues and Badger content
+func (s *HybridStore) Delete(ctx context.Context, id uuid.UUID) error {
+	key := fmt.Sprintf("article:%s", id)
+	val, err := s.rdb.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
 	var article model.Article
 	if err := json.Unmarshal(val, &article); err != nil {
 		return err
 	}
-	
-	article.Status = status
-	return s.Save(ctx, &article)
+
+	if s.db != nil {
+		err = retryUpdate(s.db, func(txn *badger.Txn) error {
+			for _, k := range [][]byte{contentKey(id), rawKey(id), diffKey(id), []byte(id.String())} {
+				if err := txn.Delete(k); err != nil {
+					return err
+				}
+			}
+			if err := unindexArticle(txn, id); err != nil {
+				return err
+			}
+			return setManifest(txn, id, nil)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, key)
+	unindexList(ctx, pipe, &article)
+	pipe.LRem(ctx, keyQueue, 0, id.String())
+	pipe.LRem(ctx, keyProcessing, 0, id.String())
+	pipe.ZRem(ctx, keyLeases, id.String())
+	pipe.ZRem(ctx, keyDelayed, id.String())
+	pipe.LRem(ctx, keyDead, 0, id.String())
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Trash moves the article to list:trash and takes it off the queue.
+// Its content and search terms stay until it's purged.
+func (s *HybridStore) Trash(ctx context.Context, id uuid.UUID) error {
+	_, err := s.changeMeta(ctx, id, func(a *model.Article) error {
+		if a.DeletedAt != nil {
+			return errUnchanged
+		}
+		return trash(a)
+	}, func(pipe redis.Pipeliner, a *model.Article) {
+		pipe.LRem(ctx, keyQueue, 0, id.String())
+		pipe.ZRem(ctx, keyDelayed, id.String())
+		pipe.LRem(ctx, keyDead, 0, id.String())
+	})
+	return err
+}
+
+// Restore takes the article out of the trash. A pending one is queued again.
+func (s *HybridStore) Restore(ctx context.Context, id uuid.UUID) error {
+	_, err := s.changeMeta(ctx, id, func(a *model.Article) error {
+		if a.DeletedAt == nil {
+			return errUnchanged
+		}
+		return restore(a)
+	}, func(pipe redis.Pipeliner, a *model.Article) {
+		if a.Status == model.StatusPending {
+			pipe.LRem(ctx, keyQueue, 0, id.String())
+			pipe.LPush(ctx, keyQueue, id.String())
+		}
+	})
+	return err
+}
+
+// meta reads just the Redis half of an article
+func (s *HybridStore) meta(ctx context.Context, id uuid.UUID) (*model.Article, error) {
+	val, err := s.rdb.Get(ctx, "article:"+id.String()).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var article model.Article
+	if err := json.Unmarshal(val, &article); err != nil {
+		return nil, err
+	}
+	return &article, nil
+}
+
+// changeMeta is an optimistic read-modify-write of an article's metadata:
+// article:<id> is WATCHed while change looks at it, and the write (plus its
+// indexes and whatever queue moves also adds) only goes through in the same
+// MULTI if nobody else wrote the key in between. Otherwise it starts over
+// with the fresh copy, so change always decides on the current status.
+// change returns errUnchanged when there's nothing to write.
+func (s *HybridStore) changeMeta(ctx context.Context, id uuid.UUID, change func(a *model.Article) error, also func(pipe redis.Pipeliner, a *model.Article)) (*model.Article, error) {
+	key := "article:" + id.String()
+	for i := 0; i < maxRetries; i++ {
+		var article model.Article
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			val, err := tx.Get(ctx, key).Bytes()
+			if err == redis.Nil {
+				return ErrNotFound
+			} else if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(val, &article); err != nil {
+				return err
+			}
+			old := article
+			old.Tags = append([]string(nil), article.Tags...)
+			if err := change(&article); err != nil {
+				return err
+			}
+
+			data, err := json.Marshal(article)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, data, 0)
+				unindexChanged(ctx, pipe, &old, &article)
+				indexList(ctx, pipe, &article)
+				if also != nil {
+					also(pipe, &article)
+				}
+				return nil
+			})
+			return err
+		}, key)
+		switch {
+		case err == errUnchanged:
+			return &article, nil
+		case err == redis.TxFailedErr:
+			continue
+		case err != nil:
+			return nil, err
+		}
+		return &article, nil
+	}
+	return nil, ErrConflict
+}
+
+// Requeue resets an article to pending and pushes it back on the queue.
+// The article keeps its place in the listing, which is ordered by CreatedAt.
+func (s *HybridStore) Requeue(ctx context.Context, id uuid.UUID) error {
+	_, err := s.changeMeta(ctx, id, requeue, func(pipe redis.Pipeliner, a *model.Article) {
+		pipe.ZRem(ctx, keyDelayed, id.String())
+		pipe.LRem(ctx, keyDead, 0, id.String())
+		pipe.LRem(ctx, keyQueue, 0, id.String())
+		pipe.LPush(ctx, keyQueue, id.String())
+	})
+	return err
+}
+
+// UpdateMeta runs change under WATCH (see changeMeta)
+func (s *HybridStore) UpdateMeta(ctx context.Context, id uuid.UUID, change func(a *model.Article) error) (*model.Article, error) {
+	return s.changeMeta(ctx, id, func(a *model.Article) error {
+		if err := change(a); err != nil {
+			return err
+		}
+		// As in Save: tags end up in index keys
+		a.Tags = model.NormalizeTags(a.Tags)
+		a.Folder = a.InFolder()
+		return nil
+	}, nil)
+}
+
+// UpdateStatus applies the transition under WATCH (see changeMeta).
+// A worker's claim holds until the lease PopQueue gave it runs out.
+func (s *HybridStore) UpdateStatus(ctx context.Context, id uuid.UUID, ev model.StatusEvent) (*model.Article, error) {
+	if ev.To == model.StatusProcessing && ev.Worker != "" && ev.Until == nil {
+		until := time.Now().Add(s.leaseTTL)
+		ev.Until = &until
+		deadline, err := s.rdb.ZScore(ctx, keyLeases, id.String()).Result()
+		if err == nil {
+			until = time.UnixMilli(int64(deadline))
+		} else if err != redis.Nil {
+			return nil, err
+		}
+	}
+	return s.changeMeta(ctx, id, func(a *model.Article) error {
+		return a.Transition(ev)
+	}, nil)
 }
 
-// PopQueue waits for a job in the Redis queue (Blocking)
+// Reliable queue layout in Redis:
+//
+//	queue:archive     ids waiting for a worker (LPUSH in, taken from the right)
+//	queue:processing  ids a worker has taken but not acked yet
+//	queue:leases      zset id -> unix ms when the worker's lease runs out
+//	queue:delayed     zset id -> unix ms when a failed job may run again
+//	queue:dead        ids that ran out of attempts (newest first)
+//
+// A job only leaves queue:processing through Ack, Nack or the reaper, so a
+// worker dying between PopQueue and Save no longer loses it.
+const (
+	keyQueue      = "queue:archive"
+	keyProcessing = "queue:processing"
+	keyLeases     = "queue:leases"
+	keyDelayed    = "queue:delayed"
+	keyDead       = "queue:dead"
+)
+
+// DefaultLeaseTTL is how long a worker may hold a job before the reaper takes it back
+const DefaultLeaseTTL = 5 * time.Minute
+
+// SetLeaseTTL changes how long a popped job stays leased to its worker
+func (s *HybridStore) SetLeaseTTL(d time.Duration) {
+	s.leaseTTL = d
+}
+
+// PopQueue waits for a job (Blocking) and leases it to the caller.
+// The job must be finished with Ack or Nack.
 func (s *HybridStore) PopQueue(ctx context.Context) (uuid.UUID, error) {
 	// 0 means wait forever until an item arrives
-	result, err := s.rdb.BRPop(ctx, 0, "queue:archive").Result()
+	idStr, err := s.rdb.BLMove(ctx, keyQueue, keyProcessing, "RIGHT", "LEFT", 0).Result()
 	if err != nil {
 		return uuid.Nil, err
 	}
 
-	idStr := result[1]
-	return uuid.Parse(idStr)
-}
\ No newline at end of file
+	// If we die before this ZADD, the reaper gives the orphan a fresh lease first
+	deadline := time.Now().Add(s.leaseTTL).UnixMilli()
+	if err := s.rdb.ZAdd(ctx, keyLeases, redis.Z{Score: float64(deadline), Member: idStr}).Err(); err != nil {
+		return uuid.Nil, err
+	}
+
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		// Garbage in the queue; drop it so it doesn't come back forever
+		s.ackRaw(ctx, idStr)
+		return uuid.Nil, fmt.Errorf("bad job id %q in queue: %w", idStr, err)
+	}
+	return id, nil
+}
+
+// Ack marks a leased job as done
+func (s *HybridStore) Ack(ctx context.Context, id uuid.UUID) error {
+	return s.ackRaw(ctx, id.String())
+}
+
+func (s *HybridStore) ackRaw(ctx context.Context, idStr string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.LRem(ctx, keyProcessing, 1, idStr)
+	pipe.ZRem(ctx, keyLeases, idStr)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Nack gives a leased job back to the queue, at the front so it runs next
+func (s *HybridStore) Nack(ctx context.Context, id uuid.UUID) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.LRem(ctx, keyProcessing, 1, id.String())
+	pipe.ZRem(ctx, keyLeases, id.String())
+	pipe.RPush(ctx, keyQueue, id.String())
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// reapScript runs atomically so a job can't be requeued and acked at the same time.
+// Orphans (moved but never leased) get a fresh lease instead of being requeued on sight,
+// because their worker may still be between BLMOVE and ZADD.
+var reapScript = redis.NewScript(`
+local ids = redis.call('LRANGE', KEYS[1], 0, -1)
+local n = 0
+for _, id in ipairs(ids) do
+  local score = redis.call('ZSCORE', KEYS[2], id)
+  if not score then
+    redis.call('ZADD', KEYS[2], ARGV[2], id)
+  elseif tonumber(score) <= tonumber(ARGV[1]) then
+    redis.call('LREM', KEYS[1], 1, id)
+    redis.call('ZREM', KEYS[2], id)
+    redis.call('RPUSH', KEYS[3], id)
+    n = n + 1
+  end
+end
+return n
+`)
+
+// RequeueExpired moves jobs whose lease ran out back to the queue
+func (s *HybridStore) RequeueExpired(ctx context.Context) (int, error) {
+	now := time.Now()
+	n, err := reapScript.Run(ctx, s.rdb,
+		[]string{keyProcessing, keyLeases, keyQueue},
+		now.UnixMilli(), now.Add(s.leaseTTL).UnixMilli(),
+	).Int()
+	return n, err
+}
+
+// Schedule settles a leased job and parks it in the delayed set until at
+func (s *HybridStore) Schedule(ctx context.Context, id uuid.UUID, at time.Time) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.LRem(ctx, keyProcessing, 1, id.String())
+	pipe.ZRem(ctx, keyLeases, id.String())
+	pipe.ZAdd(ctx, keyDelayed, redis.Z{Score: float64(at.UnixMilli()), Member: id.String()})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// promoteScript moves due jobs from the delayed set to the back of the queue in one step
+var promoteScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for _, id in ipairs(ids) do
+  redis.call('ZREM', KEYS[1], id)
+  redis.call('LPUSH', KEYS[2], id)
+end
+return #ids
+`)
+
+// PromoteDue queues every delayed job whose time has come
+func (s *HybridStore) PromoteDue(ctx context.Context) (int, error) {
+	return promoteScript.Run(ctx, s.rdb, []string{keyDelayed, keyQueue}, time.Now().UnixMilli()).Int()
+}
+
+// DeadLetter settles a leased job that ran out of attempts
+func (s *HybridStore) DeadLetter(ctx context.Context, id uuid.UUID) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.LRem(ctx, keyProcessing, 1, id.String())
+	pipe.ZRem(ctx, keyLeases, id.String())
+	pipe.LRem(ctx, keyDead, 0, id.String())
+	pipe.LPush(ctx, keyDead, id.String())
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ListDeadLetters returns the dead-letter queue, newest first
+func (s *HybridStore) ListDeadLetters(ctx context.Context) ([]uuid.UUID, error) {
+	vals, err := s.rdb.LRange(ctx, keyDead, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(vals))
+	for _, v := range vals {
+		if id, err := uuid.Parse(v); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// RequeueDeadLetter takes a job off the dead-letter queue and gives it a fresh set of attempts
+func (s *HybridStore) RequeueDeadLetter(ctx context.Context, id uuid.UUID) error {
+	pos, err := s.rdb.LPos(ctx, keyDead, id.String(), redis.LPosArgs{}).Result()
+	if err == redis.Nil || (err == nil && pos < 0) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.Requeue(ctx, id)
+}
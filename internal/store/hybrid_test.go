@@ -74,7 +74,7 @@ func TestHybridStore_Save_And_Get(t *testing.T) {
 
 	// 7. VERIFY: Badger (Content)
 	err = badgerDB.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(id.String()))
+		item, err := txn.Get([]byte("content:" + id.String()))
 		if err != nil {
 			return err
 		}
@@ -115,4 +115,193 @@ func TestHybridStore_ClientMode_NoBadger(t *testing.T) {
 	
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "badgerdb is not initialized", "Should prevent saving content without disk storage")
-}
\ No newline at end of file
+}
+func TestHybridStore_ReliableQueue_WorkerDiesMidJob(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	store, err := NewHybridStore(mr.Addr(), "")
+	require.NoError(t, err)
+	defer store.Close()
+	store.SetLeaseTTL(50 * time.Millisecond)
+
+	ctx := context.Background()
+	article := model.NewArticle("http://example.com")
+	require.NoError(t, store.Save(ctx, &article))
+
+	// Worker #1 pops the job... and then "crashes" without Ack/Nack
+	id, err := store.PopQueue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, article.ID, id)
+
+	queue, _ := mr.List(keyQueue)
+	assert.Empty(t, queue, "Job should have left the queue")
+	processing, _ := mr.List(keyProcessing)
+	assert.Equal(t, []string{id.String()}, processing, "Job should be parked in processing")
+
+	// Lease is still valid: the reaper must leave it alone
+	n, err := store.RequeueExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Lease runs out: the reaper puts it back
+	time.Sleep(60 * time.Millisecond)
+	n, err = store.RequeueExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Worker #2 picks it up and finishes properly
+	id, err = store.PopQueue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, article.ID, id)
+	require.NoError(t, store.Ack(ctx, id))
+
+	assert.False(t, mr.Exists(keyProcessing), "Ack should clear processing")
+	assert.False(t, mr.Exists(keyLeases), "Ack should clear the lease")
+}
+
+func TestHybridStore_ReliableQueue_OrphanGetsGracePeriod(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	store, err := NewHybridStore(mr.Addr(), "")
+	require.NoError(t, err)
+	defer store.Close()
+	store.SetLeaseTTL(50 * time.Millisecond)
+
+	// Simulate a worker that died right after BLMOVE, before writing its lease
+	id := uuid.New()
+	mr.Lpush(keyProcessing, id.String())
+
+	ctx := context.Background()
+	n, err := store.RequeueExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "First sighting only starts the lease")
+
+	time.Sleep(60 * time.Millisecond)
+	n, err = store.RequeueExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	queue, _ := mr.List(keyQueue)
+	assert.Equal(t, []string{id.String()}, queue)
+}
+
+func TestHybridStore_Nack(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	store, err := NewHybridStore(mr.Addr(), "")
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	article := model.NewArticle("http://example.com")
+	require.NoError(t, store.Save(ctx, &article))
+
+	id, err := store.PopQueue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, store.Nack(ctx, id))
+
+	queue, _ := mr.List(keyQueue)
+	assert.Equal(t, []string{id.String()}, queue)
+	assert.False(t, mr.Exists(keyProcessing))
+}
+
+func TestHybridStore_DelayedRetryAndDeadLetter(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	store, err := NewHybridStore(mr.Addr(), "")
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	article := model.NewArticle("http://example.com")
+	require.NoError(t, store.Save(ctx, &article))
+
+	// First failure: parked until it's due
+	id, err := store.PopQueue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, store.Schedule(ctx, id, time.Now().Add(time.Hour)))
+
+	n, err := store.PromoteDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "Not due yet")
+
+	mr.ZAdd(keyDelayed, float64(time.Now().Add(-time.Second).UnixMilli()), id.String())
+	n, err = store.PromoteDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Second failure: out of attempts
+	id, err = store.PopQueue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, store.DeadLetter(ctx, id))
+
+	dead, err := store.ListDeadLetters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{article.ID}, dead)
+
+	// Replay from the DLQ
+	require.NoError(t, store.RequeueDeadLetter(ctx, id))
+	dead, _ = store.ListDeadLetters(ctx)
+	assert.Empty(t, dead)
+	queue, _ := mr.List(keyQueue)
+	assert.Equal(t, []string{id.String()}, queue)
+
+	assert.ErrorIs(t, store.RequeueDeadLetter(ctx, uuid.New()), ErrNotFound)
+}
+
+func TestHybridStore_ReadsLegacyContentKey(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	store, err := NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	// An archive written before content moved under content:<id>
+	ctx := context.Background()
+	article := model.NewArticle("http://example.com")
+	article.Status = model.StatusArchived
+	require.NoError(t, store.Save(ctx, &article))
+	require.NoError(t, store.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(article.ID.String()), []byte("<p>old</p>"))
+	}))
+
+	got, err := store.Get(ctx, article.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>old</p>", got.Content)
+}
+
+// Walk has to reach every article, whatever the indexes say
+func TestHybridStore_WalkFindsEverything(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	store, err := NewHybridStore(mr.Addr(), "")
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	want := map[uuid.UUID]bool{}
+	for i := 0; i < 60; i++ {
+		a := model.NewArticle("http://example.com")
+		require.NoError(t, store.Save(ctx, &a))
+		want[a.ID] = true
+	}
+
+	got := map[uuid.UUID]bool{}
+	require.NoError(t, store.Walk(ctx, func(id uuid.UUID) error {
+		got[id] = true
+		return nil
+	}))
+	assert.Equal(t, want, got)
+}
@@ -3,19 +3,122 @@ package store
 import (
 	"context"
 	"errors"
+	"io"
+	"time"
 
 	"github.com/google/uuid"
 	"crusty-buffer/internal/model"
 )
 
 var (
-	ErrNotFound = errors.New("article not found")
+	ErrNotFound      = errors.New("article not found")
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidOrder  = errors.New("invalid sort order")
+	ErrInvalidFolder = errors.New("invalid folder")
+	ErrConflict      = errors.New("article kept changing, gave up")
+	ErrInvalidDedupe = errors.New("invalid dedupe mode")
 )
 
+// ListOptions narrows down a List call.
+// An empty Cursor starts from the first article in Order.
+type ListOptions struct {
+	Cursor string
+	Limit  int
+	Status []model.ArticleStatus
+	Domain string    // Host without "www.", see model.Article.Domain
+	Tag    string    // A normalized tag, see model.NormalizeTag
+	URL    string    // Snapshots of one page: same canonical URL (see model.CanonicalizeURL)
+	Since  time.Time // Created at or after; zero for no bound
+	Until  time.Time // Created before; zero for no bound
+	Order  SortOrder
+
+	// The reader's flags; zero values don't filter
+	Unread   bool
+	Favorite bool
+	Folder   model.Folder
+
+	// Trashed lists the trash instead of everything else
+	Trashed bool
+}
+
 type Store interface {
 	Save(ctx context.Context, article *model.Article) error
 	Get(ctx context.Context, id uuid.UUID) (*model.Article, error)
-	List(ctx context.Context, limit int) ([]model.Article, error)
-	UpdateStatus(ctx context.Context, id uuid.UUID, status model.ArticleStatus) error
+	// SaveContent writes just the content of a stored article, and its search
+	// terms, leaving the metadata to UpdateMeta. ErrNotFound if it's gone.
+	SaveContent(ctx context.Context, article *model.Article) error
+	// SaveRaw/GetRaw keep the page as downloaded, so extraction can be redone offline
+	SaveRaw(ctx context.Context, id uuid.UUID, raw []byte) error
+	GetRaw(ctx context.Context, id uuid.UUID) ([]byte, error)
+	// SaveDiff/GetDiff keep a watch snapshot's text diff against its PreviousID
+	SaveDiff(ctx context.Context, id uuid.UUID, diff string) error
+	GetDiff(ctx context.Context, id uuid.UUID) (string, error)
+	// Assets are content-addressed blobs (images) shared between articles.
+	// SetAssetManifest records which ones an article uses; unreferenced blobs are deleted.
+	PutAsset(ctx context.Context, a Asset) error
+	GetAsset(ctx context.Context, hash string) (*Asset, error)
+	GetAssetManifest(ctx context.Context, id uuid.UUID) ([]AssetRef, error)
+	SetAssetManifest(ctx context.Context, id uuid.UUID, refs []AssetRef) error
+	// List returns one page of articles and the cursor for the next one ("" when done)
+	List(ctx context.Context, opts ListOptions) ([]model.Article, string, error)
+	// Walk calls fn with the ID of every stored article, in no particular order.
+	// It's meant for migrations, which must not miss anything List has trimmed away.
+	Walk(ctx context.Context, fn func(id uuid.UUID) error) error
+	// Search ranks archived articles against a full-text query. Save and Delete keep the index current.
+	Search(ctx context.Context, query string, opts SearchOptions) ([]SearchResult, string, error)
+	// Tags counts the articles per tag, most used first
+	Tags(ctx context.Context) ([]TagCount, error)
+	// Trash hides an article from List and Search (and takes it off the queue) until Restore
+	Trash(ctx context.Context, id uuid.UUID) error
+	Restore(ctx context.Context, id uuid.UUID) error
+	// Delete purges an article for good: metadata, content, raw HTML, indexes, jobs and unused assets
+	Delete(ctx context.Context, id uuid.UUID) error
+	// Requeue moves an article back to pending and queues it. Trashed ones can't be.
+	Requeue(ctx context.Context, id uuid.UUID) error
+	// UpdateStatus moves an article to ev.To (see model.Article.Transition) and returns
+	// its metadata as written. Check and write are atomic, so of two workers racing
+	// for the same article one gets model.ErrIllegalTransition.
+	UpdateStatus(ctx context.Context, id uuid.UUID, ev model.StatusEvent) (*model.Article, error)
+	// UpdateMeta runs change on the current metadata (no content) and writes the
+	// result, with the same atomicity as UpdateStatus. It returns what was written.
+	UpdateMeta(ctx context.Context, id uuid.UUID, change func(a *model.Article) error) (*model.Article, error)
+	// PopQueue blocks for the next job and leases it to the caller
 	PopQueue(ctx context.Context) (uuid.UUID, error)
-}
\ No newline at end of file
+	// Ack marks a popped job as done so it is never redelivered
+	Ack(ctx context.Context, id uuid.UUID) error
+	// Nack hands a popped job straight back to the queue
+	Nack(ctx context.Context, id uuid.UUID) error
+	// RequeueExpired returns jobs whose lease ran out to the queue
+	RequeueExpired(ctx context.Context) (int, error)
+	// Schedule settles a popped job and queues it again once at has passed
+	Schedule(ctx context.Context, id uuid.UUID, at time.Time) error
+	// PromoteDue moves scheduled jobs that are due onto the queue
+	PromoteDue(ctx context.Context) (int, error)
+	// DeadLetter settles a popped job that ran out of attempts
+	DeadLetter(ctx context.Context, id uuid.UUID) error
+	ListDeadLetters(ctx context.Context) ([]uuid.UUID, error)
+	RequeueDeadLetter(ctx context.Context, id uuid.UUID) error
+	// The watch list, one entry per canonical URL. SaveWatch replaces the
+	// entry for w.URL; UpdateWatch only records a check on an existing one.
+	// DeleteWatch and UpdateWatch take any spelling of the URL.
+	Watches(ctx context.Context) ([]model.Watch, error)
+	SaveWatch(ctx context.Context, w model.Watch) error
+	UpdateWatch(ctx context.Context, rawURL string, checked time.Time) error
+	DeleteWatch(ctx context.Context, rawURL string) error
+	// Feed subscriptions, like the watch list one per canonical URL.
+	// DeleteFeed forgets which of its entries were seen, too. UpdateFeed
+	// only replaces a feed still subscribed, else it's ErrNotSubscribed.
+	Feeds(ctx context.Context) ([]model.Feed, error)
+	SaveFeed(ctx context.Context, f model.Feed) error
+	UpdateFeed(ctx context.Context, f model.Feed) error
+	DeleteFeed(ctx context.Context, rawURL string) error
+	// SeenEntries says which of a feed's entry GUIDs MarkSeen has recorded
+	SeenEntries(ctx context.Context, feedURL string, guids []string) (map[string]bool, error)
+	MarkSeen(ctx context.Context, feedURL string, guids []string) error
+	// Backup writes a consistent snapshot of everything to w, see backup.go.
+	// LoadBackup restores one made by the same backend: its articles replace
+	// any with the same ID, and without merge the store has to be empty.
+	Backup(ctx context.Context, w io.Writer) (*BackupInfo, error)
+	LoadBackup(ctx context.Context, r io.ReadSeeker, merge bool) (*BackupInfo, error)
+	Close()
+}
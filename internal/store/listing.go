@@ -0,0 +1,119 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// SortOrder is the order List returns articles in, by CreatedAt
+type SortOrder string
+
+const (
+	OrderNewest SortOrder = "newest" // The default
+	OrderOldest SortOrder = "oldest"
+)
+
+// listIndexVersion goes up whenever an index is added, so stores opened by an
+// older version get it built on open: 1 added status, domain and trash,
+// 2 unread, favorites and folders, 3 canonical URLs.
+const listIndexVersion = 3
+
+// Every listing index (Redis sorted sets, Badger key prefixes) holds the
+// same member per article: zero-padded CreatedAt nanoseconds, then the ID.
+// Byte order is time order, IDs break ties, and the member of the last
+// article on a page is the cursor for the next one.
+func listMember(a *model.Article) string {
+	return fmt.Sprintf("%020d:%s", a.CreatedAt.UnixNano(), a.ID)
+}
+
+func timeBound(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+func memberID(member string) (uuid.UUID, error) {
+	return uuid.Parse(member[strings.LastIndexByte(member, ':')+1:])
+}
+
+// memberRange turns opts into bounds on members: lo is inclusive, hi exclusive,
+// "" leaves that side open. The cursor itself was already handed out, so it's excluded.
+func memberRange(opts ListOptions) (lo, hi string, err error) {
+	if !opts.Since.IsZero() {
+		lo = timeBound(opts.Since)
+	}
+	if !opts.Until.IsZero() {
+		hi = timeBound(opts.Until)
+	}
+
+	if opts.Cursor == "" {
+		return lo, hi, nil
+	}
+	if i := strings.IndexByte(opts.Cursor, ':'); i != 20 {
+		return "", "", ErrInvalidCursor
+	}
+	if _, err := memberID(opts.Cursor); err != nil {
+		return "", "", ErrInvalidCursor
+	}
+
+	if opts.Order == OrderOldest {
+		// Smallest string greater than the cursor
+		if c := opts.Cursor + "\x00"; c > lo {
+			lo = c
+		}
+	} else if hi == "" || opts.Cursor < hi {
+		hi = opts.Cursor
+	}
+	return lo, hi, nil
+}
+
+// validOrder accepts "" as OrderNewest
+func validOrder(o SortOrder) bool {
+	return o == "" || o == OrderNewest || o == OrderOldest
+}
+
+// canonicalOptions spells opts.URL the way the index does
+func canonicalOptions(opts ListOptions) ListOptions {
+	if opts.URL != "" {
+		opts.URL = model.CanonicalizeURL(opts.URL)
+	}
+	return opts
+}
+
+// validOptions checks the options List can't just ignore
+func validOptions(opts ListOptions) error {
+	if !validOrder(opts.Order) {
+		return ErrInvalidOrder
+	}
+	if opts.Folder != "" && !validFolder(opts.Folder) {
+		return ErrInvalidFolder
+	}
+	return nil
+}
+
+// listMatch is the filtering List does itself, on top of the one index it reads.
+// Checking the filter that picked the index again is cheap, so everything is checked.
+func listMatch(a *model.Article, opts ListOptions) bool {
+	if !matchStatus(a.Status, opts.Status) {
+		return false
+	}
+	if opts.Domain != "" && a.Domain() != opts.Domain {
+		return false
+	}
+	if opts.Tag != "" && !a.HasTag(opts.Tag) {
+		return false
+	}
+	if opts.URL != "" && a.Canonical() != opts.URL {
+		return false
+	}
+	if opts.Unread && a.ReadAt != nil {
+		return false
+	}
+	if opts.Favorite && !a.Favorite {
+		return false
+	}
+	return opts.Folder == "" || a.InFolder() == opts.Folder
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedListing saves n articles one minute apart, alternating domains,
+// with every third one failed. It returns them oldest first.
+func seedListing(t *testing.T, st Store, n int) []model.Article {
+	t.Helper()
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	articles := make([]model.Article, n)
+	for i := range articles {
+		a := model.NewArticle(fmt.Sprintf("https://www.site%d.example/post/%d", i%2, i))
+		a.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, st.Save(ctx, &a))
+		if i%3 == 0 {
+			for _, to := range []model.ArticleStatus{model.StatusProcessing, model.StatusFailed} {
+				_, err := st.UpdateStatus(ctx, a.ID, model.StatusEvent{To: to})
+				require.NoError(t, err)
+			}
+			a.Status = model.StatusFailed
+		}
+		articles[i] = a
+	}
+	return articles
+}
+
+// listAll follows cursors until the end
+func listAll(t *testing.T, st Store, opts ListOptions) []uuid.UUID {
+	t.Helper()
+	var ids []uuid.UUID
+	for {
+		page, next, err := st.List(context.Background(), opts)
+		require.NoError(t, err)
+		for _, a := range page {
+			ids = append(ids, a.ID)
+		}
+		if next == "" {
+			return ids
+		}
+		opts.Cursor = next
+	}
+}
+
+func testListing(t *testing.T, st Store) {
+	articles := seedListing(t, st, 120)
+	ids := func(keep func(i int, a model.Article) bool, newestFirst bool) []uuid.UUID {
+		var out []uuid.UUID
+		for i, a := range articles {
+			if keep(i, a) {
+				out = append(out, a.ID)
+			}
+		}
+		if newestFirst {
+			for l, r := 0, len(out)-1; l < r; l, r = l+1, r-1 {
+				out[l], out[r] = out[r], out[l]
+			}
+		}
+		return out
+	}
+	all := func(int, model.Article) bool { return true }
+
+	// Nothing is trimmed any more
+	assert.Equal(t, ids(all, true), listAll(t, st, ListOptions{Limit: 7}))
+	assert.Equal(t, ids(all, false), listAll(t, st, ListOptions{Limit: 7, Order: OrderOldest}))
+
+	failed := func(_ int, a model.Article) bool { return a.Status == model.StatusFailed }
+	assert.Equal(t, ids(failed, true), listAll(t, st, ListOptions{Limit: 9, Status: []model.ArticleStatus{model.StatusFailed}}))
+
+	site1 := func(i int, _ model.Article) bool { return i%2 == 1 }
+	assert.Equal(t, ids(site1, true), listAll(t, st, ListOptions{Limit: 5, Domain: "site1.example"}))
+
+	site1Pending := func(i int, a model.Article) bool { return i%2 == 1 && a.Status == model.StatusPending }
+	assert.Equal(t, ids(site1Pending, false), listAll(t, st, ListOptions{
+		Limit: 4, Domain: "site1.example", Status: []model.ArticleStatus{model.StatusPending}, Order: OrderOldest,
+	}))
+
+	// Since is inclusive, Until exclusive
+	window := func(i int, _ model.Article) bool { return i >= 10 && i < 20 }
+	assert.Equal(t, ids(window, true), listAll(t, st, ListOptions{
+		Limit: 3, Since: articles[10].CreatedAt, Until: articles[20].CreatedAt,
+	}))
+	assert.Equal(t, ids(window, false), listAll(t, st, ListOptions{
+		Limit: 3, Since: articles[10].CreatedAt, Until: articles[20].CreatedAt, Order: OrderOldest,
+	}))
+
+	// The last page doesn't hand out a cursor that leads nowhere
+	_, next, err := st.List(context.Background(), ListOptions{Limit: 120})
+	require.NoError(t, err)
+	assert.Empty(t, next)
+
+	_, _, err = st.List(context.Background(), ListOptions{Cursor: "bogus"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, _, err = st.List(context.Background(), ListOptions{Order: "sideways"})
+	assert.ErrorIs(t, err, ErrInvalidOrder)
+
+	// Status changes move articles between indexes, deletes drop them
+	require.NoError(t, st.Requeue(context.Background(), articles[0].ID))
+	require.NoError(t, st.Delete(context.Background(), articles[1].ID))
+	pending := listAll(t, st, ListOptions{Status: []model.ArticleStatus{model.StatusPending}})
+	assert.Contains(t, pending, articles[0].ID)
+	assert.NotContains(t, listAll(t, st, ListOptions{}), articles[1].ID)
+	assert.NotContains(t, listAll(t, st, ListOptions{Domain: "site1.example"}), articles[1].ID)
+
+	// A new URL on another host moves the article to that domain
+	moved, err := st.Get(context.Background(), articles[5].ID)
+	require.NoError(t, err)
+	moved.URL = "https://moved.example/post/5"
+	require.NoError(t, st.Save(context.Background(), moved))
+	assert.NotContains(t, listAll(t, st, ListOptions{Domain: "site1.example"}), moved.ID)
+	assert.Equal(t, []uuid.UUID{moved.ID}, listAll(t, st, ListOptions{Domain: "moved.example"}))
+}
+
+func TestListing_Hybrid(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := NewHybridStore(mr.Addr(), "")
+	require.NoError(t, err)
+	defer st.Close()
+
+	testListing(t, st)
+
+	// listMatch hides stale index entries from List, so look at the index itself
+	members, err := mr.ZMembers(listDomainKey("site1.example"))
+	require.NoError(t, err)
+	assert.Len(t, members, len(listAll(t, st, ListOptions{Domain: "site1.example"})), "Nothing left behind in the old domain")
+}
+
+func TestListing_Badger(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	testListing(t, st)
+}
+
+// Stores from before the indexes only had list:recent (last 50) and the metadata
+func TestListing_HybridMigratesLegacyList(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	var want []uuid.UUID
+	for i := 0; i < 60; i++ {
+		a := model.NewArticle("https://example.com")
+		a.CreatedAt = time.Date(2023, 1, 1, 0, i, 0, 0, time.UTC)
+		data, err := json.Marshal(a)
+		require.NoError(t, err)
+		require.NoError(t, mr.Set("article:"+a.ID.String(), string(data)))
+		if i >= 10 {
+			mr.Lpush("list:recent", a.ID.String())
+		}
+		want = append([]uuid.UUID{a.ID}, want...)
+	}
+
+	st, err := NewHybridStore(mr.Addr(), "")
+	require.NoError(t, err)
+	defer st.Close()
+
+	assert.Equal(t, want, listAll(t, st, ListOptions{Limit: 25}))
+	assert.False(t, mr.Exists("list:recent"))
+}
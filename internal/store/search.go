@@ -0,0 +1,509 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	"golang.org/x/net/html"
+)
+
+// SearchOptions narrows down a Search call. The cursor is an offset into the ranked results.
+type SearchOptions struct {
+	Cursor string
+	Limit  int
+	Status []model.ArticleStatus
+}
+
+// SearchResult is one hit. Highlights are [start, end) byte ranges into Snippet
+// where a query term matched, so each frontend can mark them up its own way.
+type SearchResult struct {
+	Article    model.Article `json:"article"`
+	Score      float64       `json:"score"`
+	Snippet    string        `json:"snippet"`
+	Highlights [][2]int      `json:"highlights,omitempty"`
+}
+
+// The full-text index lives in Badger for both stores:
+//
+//	idx:<term>:<articleID>    uint32 weighted term frequency
+//	idxdoc:<articleID>        the article's terms, newline-separated, so it can be unindexed
+//	meta:index-docs           uint64 count of idxdoc keys, for the IDF
+//
+// Terms are lowercase letters and digits only, so ':' can't appear in one and
+// a prefix scan over idx:<term> finds every term starting with it.
+const (
+	prefixIndex    = "idx:"
+	prefixIndexDoc = "idxdoc:"
+	keyIndexDocs   = "meta:index-docs"
+)
+
+func indexKey(term string, id uuid.UUID) []byte {
+	return []byte(prefixIndex + term + ":" + id.String())
+}
+func indexDocKey(id uuid.UUID) []byte { return []byte(prefixIndexDoc + id.String()) }
+
+// A word in the title counts as much as five in the body
+var fieldWeights = []struct {
+	weight uint32
+	text   func(a *model.Article) string
+}{
+	{5, func(a *model.Article) string { return a.Title }},
+	{3, func(a *model.Article) string { return a.Byline }},
+	{2, func(a *model.Article) string { return a.Excerpt }},
+	{1, func(a *model.Article) string { return htmlText(a.Content) }},
+}
+
+const (
+	defaultSearchLimit = 20
+	snippetWords       = 30
+)
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"but": true, "by": true, "for": true, "if": true, "in": true, "into": true, "is": true,
+	"it": true, "no": true, "not": true, "of": true, "on": true, "or": true, "such": true,
+	"that": true, "the": true, "their": true, "then": true, "there": true, "these": true,
+	"they": true, "this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// indexArticle replaces whatever was indexed for the article with its current text
+func indexArticle(txn *badger.Txn, a *model.Article) error {
+	had, err := dropTerms(txn, a.ID)
+	if err != nil {
+		return err
+	}
+
+	freq := make(map[string]uint32)
+	for _, field := range fieldWeights {
+		for _, tok := range tokenize(field.text(a)) {
+			freq[tok.term] += field.weight
+		}
+	}
+	if len(freq) == 0 {
+		if had {
+			return addIndexDocs(txn, -1)
+		}
+		return nil
+	}
+	if !had {
+		if err := addIndexDocs(txn, 1); err != nil {
+			return err
+		}
+	}
+
+	terms := make([]string, 0, len(freq))
+	for term, n := range freq {
+		val := make([]byte, 4)
+		binary.BigEndian.PutUint32(val, n)
+		if err := txn.Set(indexKey(term, a.ID), val); err != nil {
+			return err
+		}
+		terms = append(terms, term)
+	}
+	return txn.Set(indexDocKey(a.ID), []byte(strings.Join(terms, "\n")))
+}
+
+func unindexArticle(txn *badger.Txn, id uuid.UUID) error {
+	had, err := dropTerms(txn, id)
+	if err != nil || !had {
+		return err
+	}
+	return addIndexDocs(txn, -1)
+}
+
+// dropTerms deletes the article's index entries and reports whether it had any
+func dropTerms(txn *badger.Txn, id uuid.UUID) (bool, error) {
+	terms, had, err := docTerms(txn, id)
+	if err != nil || !had {
+		return false, err
+	}
+
+	for _, term := range terms {
+		if err := txn.Delete(indexKey(term, id)); err != nil {
+			return false, err
+		}
+	}
+	return true, txn.Delete(indexDocKey(id))
+}
+
+// docTerms reads the terms the article is indexed under
+func docTerms(txn *badger.Txn, id uuid.UUID) ([]string, bool, error) {
+	item, err := txn.Get(indexDocKey(id))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	var terms []string
+	err = item.Value(func(val []byte) error {
+		terms = strings.Split(string(val), "\n")
+		return nil
+	})
+	return terms, err == nil, err
+}
+
+// addIndexDocs moves the document count by delta. A store without the count
+// yet (one indexed before it existed) is left alone until countIndexDocs runs.
+func addIndexDocs(txn *badger.Txn, delta int64) error {
+	item, err := txn.Get([]byte(keyIndexDocs))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var n uint64
+	err = item.Value(func(val []byte) error {
+		if len(val) == 8 {
+			n = binary.BigEndian.Uint64(val)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if delta < 0 && n < uint64(-delta) {
+		n = 0
+	} else {
+		n = uint64(int64(n) + delta)
+	}
+	return txn.Set([]byte(keyIndexDocs), binary.BigEndian.AppendUint64(nil, n))
+}
+
+// indexDocs reads the document count, counting the idxdoc keys only for a
+// store that doesn't have it yet
+func indexDocs(txn *badger.Txn) (int, error) {
+	item, err := txn.Get([]byte(keyIndexDocs))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return countKeys(txn, []byte(prefixIndexDoc)), nil
+	} else if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = item.Value(func(val []byte) error {
+		if len(val) == 8 {
+			n = int(binary.BigEndian.Uint64(val))
+		}
+		return nil
+	})
+	return n, err
+}
+
+// countIndexDocs (re)counts the idxdoc keys into meta:index-docs. It runs
+// when a store opens without the count and after a backup load, which
+// writes idxdoc keys behind indexArticle's back.
+func countIndexDocs(db *badger.DB, force bool) error {
+	return retryUpdate(db, func(txn *badger.Txn) error {
+		if !force {
+			if _, err := txn.Get([]byte(keyIndexDocs)); err == nil {
+				return nil
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+		}
+		n := countKeys(txn, []byte(prefixIndexDoc))
+		return txn.Set([]byte(keyIndexDocs), binary.BigEndian.AppendUint64(nil, uint64(n)))
+	})
+}
+
+// queryTerm is one word of a query; "foo*" matches every term starting with foo
+type queryTerm struct {
+	term   string
+	prefix bool
+}
+
+func parseQuery(q string) []queryTerm {
+	var terms []queryTerm
+	for _, field := range strings.Fields(q) {
+		prefix := strings.HasSuffix(field, "*")
+		for _, tok := range tokenize(field) {
+			terms = append(terms, queryTerm{term: tok.term})
+		}
+		if prefix && len(terms) > 0 {
+			terms[len(terms)-1].prefix = true
+		}
+	}
+	return terms
+}
+
+func (q queryTerm) matches(term string) bool {
+	if q.prefix {
+		return strings.HasPrefix(term, q.term)
+	}
+	return term == q.term
+}
+
+// rankedIDs returns every article matching all query terms, best first
+func rankedIDs(db *badger.DB, terms []queryTerm) ([]uuid.UUID, map[uuid.UUID]float64, error) {
+	scores := make(map[uuid.UUID]float64)
+	err := db.View(func(txn *badger.Txn) error {
+		docs, err := indexDocs(txn)
+		if err != nil {
+			return err
+		}
+
+		for i, q := range terms {
+			prefix := prefixIndex + q.term
+			if !q.prefix {
+				prefix += ":"
+			}
+
+			postings := make(map[uuid.UUID]float64)
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefix), PrefetchValues: true})
+			for it.Rewind(); it.Valid(); it.Next() {
+				key := string(it.Item().Key())
+				id, err := uuid.Parse(key[strings.LastIndexByte(key, ':')+1:])
+				if err != nil {
+					continue
+				}
+				err = it.Item().Value(func(val []byte) error {
+					if len(val) == 4 {
+						postings[id] += float64(binary.BigEndian.Uint32(val))
+					}
+					return nil
+				})
+				if err != nil {
+					it.Close()
+					return err
+				}
+			}
+			it.Close()
+
+			// Rare terms say more about a document than common ones
+			idf := math.Log(1 + float64(docs)/float64(max(len(postings), 1)))
+
+			// Every term has to match
+			next := make(map[uuid.UUID]float64, len(postings))
+			for id, tf := range postings {
+				if prev, ok := scores[id]; ok || i == 0 {
+					next[id] = prev + (1+math.Log(tf))*idf
+				}
+			}
+			scores = next
+			if len(scores) == 0 {
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i].String() < ids[j].String()
+	})
+	return ids, scores, nil
+}
+
+// search is Search for both stores; load reads an article with its content
+func search(ctx context.Context, db *badger.DB, query string, opts SearchOptions, load func(uuid.UUID) (*model.Article, error)) ([]SearchResult, string, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	offset := 0
+	if opts.Cursor != "" {
+		n, err := strconv.Atoi(opts.Cursor)
+		if err != nil || n < 0 {
+			return nil, "", ErrInvalidCursor
+		}
+		offset = n
+	}
+
+	terms := parseQuery(query)
+	if len(terms) == 0 {
+		return []SearchResult{}, "", nil
+	}
+	ids, scores, err := rankedIDs(db, terms)
+	if err != nil {
+		return nil, "", err
+	}
+
+	results := []SearchResult{}
+	for offset < len(ids) && len(results) < limit {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		id := ids[offset]
+		offset++
+
+		a, err := load(id)
+		if errors.Is(err, ErrNotFound) {
+			continue // Deleted between indexing and now
+		} else if err != nil {
+			return nil, "", err
+		}
+		if a.DeletedAt != nil || !matchStatus(a.Status, opts.Status) {
+			continue
+		}
+
+		text := htmlText(a.Content)
+		if text == "" {
+			text = a.Excerpt
+		}
+		snippet, highlights := makeSnippet(text, terms)
+		a.Content = ""
+		results = append(results, SearchResult{Article: *a, Score: scores[id], Snippet: snippet, Highlights: highlights})
+	}
+
+	if offset >= len(ids) {
+		return results, "", nil
+	}
+	return results, strconv.Itoa(offset), nil
+}
+
+// makeSnippet cuts a window of text around the first match and marks every match in it
+func makeSnippet(text string, terms []queryTerm) (string, [][2]int) {
+	text = strings.Join(strings.Fields(text), " ")
+	toks := tokenize(text)
+	if len(toks) == 0 {
+		return "", nil
+	}
+
+	first := 0
+	for i, tok := range toks {
+		if matchesAny(tok.term, terms) {
+			first = i
+			break
+		}
+	}
+
+	start := max(first-snippetWords/3, 0)
+	end := min(start+snippetWords, len(toks))
+
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString("… ")
+	}
+	shift := sb.Len() - toks[start].start
+	sb.WriteString(text[toks[start].start:toks[end-1].end])
+	if end < len(toks) {
+		sb.WriteString(" …")
+	}
+
+	var highlights [][2]int
+	for _, tok := range toks[start:end] {
+		if matchesAny(tok.term, terms) {
+			highlights = append(highlights, [2]int{tok.start + shift, tok.end + shift})
+		}
+	}
+	return sb.String(), highlights
+}
+
+func matchesAny(term string, terms []queryTerm) bool {
+	for _, q := range terms {
+		if q.matches(term) {
+			return true
+		}
+	}
+	return false
+}
+
+type token struct {
+	term       string
+	start, end int // Byte offsets into the tokenized text
+}
+
+// tokenize splits text into lowercase words of letters and digits, skipping stop words
+func tokenize(text string) []token {
+	var toks []token
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		term := strings.ToLower(text[start:end])
+		if utf8.RuneCountInString(term) > 1 && len(term) <= 64 && !stopWords[term] {
+			toks = append(toks, token{term: term, start: start, end: end})
+		}
+		start = -1
+	}
+
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+	}
+	flush(len(text))
+	return toks
+}
+
+// htmlText returns the visible text of an HTML fragment
+func htmlText(fragment string) string {
+	if fragment == "" {
+		return ""
+	}
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	var sb strings.Builder
+	skip := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(sb.String()), " ")
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if string(name) == "script" || string(name) == "style" {
+				skip++
+			}
+			sb.WriteByte(' ')
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if (string(name) == "script" || string(name) == "style") && skip > 0 {
+				skip--
+			}
+			sb.WriteByte(' ')
+		case html.TextToken:
+			if skip == 0 {
+				sb.Write(z.Text())
+			}
+		}
+	}
+}
+
+func countKeys(txn *badger.Txn, prefix []byte) int {
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+	defer it.Close()
+	n := 0
+	for it.Rewind(); it.Valid(); it.Next() {
+		n++
+	}
+	return n
+}
+
+func (s *BadgerStore) Search(ctx context.Context, query string, opts SearchOptions) ([]SearchResult, string, error) {
+	return search(ctx, s.db, query, opts, func(id uuid.UUID) (*model.Article, error) {
+		return s.Get(ctx, id)
+	})
+}
+
+func (s *HybridStore) Search(ctx context.Context, query string, opts SearchOptions) ([]SearchResult, string, error) {
+	if s.db == nil {
+		return nil, "", errNoBadger
+	}
+	return search(ctx, s.db, query, opts, func(id uuid.UUID) (*model.Article, error) {
+		return s.Get(ctx, id)
+	})
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func saveArchived(t *testing.T, s Store, title, content string) model.Article {
+	t.Helper()
+	a := model.NewArticle("https://example.com/" + title)
+	a.Status = model.StatusArchived
+	a.Title = title
+	a.Content = content
+	require.NoError(t, s.Save(context.Background(), &a))
+	return a
+}
+
+func TestSearch_RanksAndHighlights(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+	ctx := context.Background()
+
+	gophers := saveArchived(t, st, "Gophers", "<p>All about gophers and their burrows.</p>")
+	mention := saveArchived(t, st, "Rust", "<p>A post about Rust that mentions gophers once.</p>")
+	saveArchived(t, st, "Cooking", "<p>Nothing to see here.</p>")
+
+	results, next, err := st.Search(ctx, "gophers", SearchOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, next)
+	require.Len(t, results, 2)
+	assert.Equal(t, gophers.ID, results[0].Article.ID, "A title match beats a body match")
+	assert.Equal(t, mention.ID, results[1].Article.ID)
+	assert.Empty(t, results[0].Article.Content, "Results carry metadata only")
+
+	r := results[1]
+	require.NotEmpty(t, r.Highlights)
+	h := r.Highlights[0]
+	assert.Equal(t, "gophers", r.Snippet[h[0]:h[1]])
+
+	// All terms must match; a trailing * matches prefixes
+	results, _, err = st.Search(ctx, "rust gophers", SearchOptions{})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, mention.ID, results[0].Article.ID)
+
+	results, _, err = st.Search(ctx, "burr*", SearchOptions{})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, gophers.ID, results[0].Article.ID)
+
+	// Paging
+	results, next, err = st.Search(ctx, "gophers", SearchOptions{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.NotEmpty(t, next)
+	results, next, err = st.Search(ctx, "gophers", SearchOptions{Limit: 1, Cursor: next})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, mention.ID, results[0].Article.ID)
+	assert.Empty(t, next)
+}
+
+func TestSearch_HybridKeepsIndexCurrent(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+	ctx := context.Background()
+
+	a := saveArchived(t, st, "Draft", "<p>The first version talks about zebras.</p>")
+
+	results, _, err := st.Search(ctx, "zebras", SearchOptions{})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	// Re-saving replaces the old terms
+	a.Content = "<p>The second version is about giraffes.</p>"
+	require.NoError(t, st.Save(ctx, &a))
+	results, _, err = st.Search(ctx, "zebras", SearchOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+	results, _, err = st.Search(ctx, "giraffes", SearchOptions{})
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	require.NoError(t, st.Delete(ctx, a.ID))
+	results, _, err = st.Search(ctx, "giraffes", SearchOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestSearch_CountsDocuments(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+	ctx := context.Background()
+
+	docs := func() int {
+		t.Helper()
+		var n int
+		require.NoError(t, st.db.View(func(txn *badger.Txn) error {
+			_, err := txn.Get([]byte(keyIndexDocs))
+			require.NoError(t, err, "The count is kept, not recounted")
+			n, err = indexDocs(txn)
+			return err
+		}))
+		return n
+	}
+	assert.Equal(t, 0, docs())
+
+	a := saveArchived(t, st, "One", "<p>First.</p>")
+	b := saveArchived(t, st, "Two", "<p>Second.</p>")
+	assert.Equal(t, 2, docs())
+
+	// Re-indexing an article doesn't count it again
+	a.Content = "<p>First, edited.</p>"
+	require.NoError(t, st.Save(ctx, &a))
+	assert.Equal(t, 2, docs())
+
+	require.NoError(t, st.Delete(ctx, b.ID))
+	assert.Equal(t, 1, docs())
+
+	// A store from before the count gets it on open
+	require.NoError(t, st.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(keyIndexDocs))
+	}))
+	require.NoError(t, countIndexDocs(st.db, false))
+	assert.Equal(t, 1, docs())
+}
+
+func TestHTMLText(t *testing.T) {
+	assert.Equal(t, "Hello world !", htmlText("<p>Hello <b>world</b></p><script>var x</script><p>!</p>"))
+}
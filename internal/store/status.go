@@ -0,0 +1,52 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"crusty-buffer/internal/model"
+)
+
+// errUnchanged tells a read-modify-write that there is nothing to write
+var errUnchanged = errors.New("unchanged")
+
+// maxRetries bounds how often an optimistic read-modify-write starts over
+// before giving up with ErrConflict
+const maxRetries = 10
+
+// The status changes Trash, Restore and Requeue make, shared by both stores.
+// Each runs inside the store's read-modify-write, so it sees the current status.
+
+func trash(a *model.Article) error {
+	now := time.Now()
+	if err := a.Transition(model.StatusEvent{To: model.StatusDeleted, At: now}); err != nil {
+		return err
+	}
+	a.DeletedAt = &now
+	return nil
+}
+
+func restore(a *model.Article) error {
+	a.DeletedAt = nil
+	if a.Status != model.StatusDeleted {
+		return nil // Trashed before articles had a deleted status
+	}
+	return a.Transition(model.StatusEvent{To: a.RestoreStatus()})
+}
+
+func requeue(a *model.Article) error {
+	if a.DeletedAt != nil {
+		return fmt.Errorf("%w: %s is in the trash", model.ErrIllegalTransition, a.ID)
+	}
+	if a.Status != model.StatusPending {
+		if err := a.Transition(model.StatusEvent{To: model.StatusPending}); err != nil {
+			return err
+		}
+	}
+	a.ErrorMessage = ""
+	a.ErrorCode = ""
+	a.Attempts = 0
+	a.NextAttemptAt = nil
+	return nil
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStatus(t *testing.T, st Store) {
+	ctx := context.Background()
+	a := model.NewArticle("https://example.com/post")
+	require.NoError(t, st.Save(ctx, &a))
+
+	// Illegal moves are rejected and leave no trace
+	_, err := st.UpdateStatus(ctx, a.ID, model.StatusEvent{To: model.StatusArchived})
+	assert.ErrorIs(t, err, model.ErrIllegalTransition)
+	_, err = st.UpdateStatus(ctx, a.ID, model.StatusEvent{From: model.StatusProcessing, To: model.StatusFailed})
+	assert.ErrorIs(t, err, model.ErrIllegalTransition, "Compare-and-set on the wrong status")
+
+	got, err := st.UpdateStatus(ctx, a.ID, model.StatusEvent{To: model.StatusProcessing, Worker: "w1"})
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusProcessing, got.Status)
+	assert.Equal(t, "w1", got.Worker())
+
+	// A second claim (a duplicate job, say) doesn't take it from w1
+	_, err = st.UpdateStatus(ctx, a.ID, model.StatusEvent{To: model.StatusProcessing, Worker: "w2"})
+	assert.ErrorIs(t, err, model.ErrIllegalTransition)
+	require.NotNil(t, got.History[1].Until)
+	assert.True(t, got.History[1].Until.After(time.Now()))
+
+	// Once the lease ran out w2 can have the job; w1 can't finish it any more
+	later := got.History[1].Until.Add(time.Second)
+	_, err = st.UpdateStatus(ctx, a.ID, model.StatusEvent{To: model.StatusProcessing, Worker: "w2", At: later})
+	require.NoError(t, err)
+	_, err = st.UpdateStatus(ctx, a.ID, model.StatusEvent{From: model.StatusProcessing, To: model.StatusArchived, Worker: "w1"})
+	assert.ErrorIs(t, err, model.ErrIllegalTransition)
+
+	// Of many racing finishes exactly one gets through
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	won := 0
+	for i := 0; i < 8; i++ {
+		to := model.StatusArchived
+		if i%2 == 1 {
+			to = model.StatusFailed
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := st.UpdateStatus(ctx, a.ID, model.StatusEvent{From: model.StatusProcessing, To: to, Worker: "w2"})
+			if err == nil {
+				mu.Lock()
+				won++
+				mu.Unlock()
+			} else {
+				assert.ErrorIs(t, err, model.ErrIllegalTransition)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, won)
+
+	saved, err := st.Get(ctx, a.ID)
+	require.NoError(t, err)
+	require.Len(t, saved.History, 4)
+	var to []model.ArticleStatus
+	for _, ev := range saved.History {
+		to = append(to, ev.To)
+	}
+	assert.Equal(t, model.StatusProcessing, saved.History[2].From)
+	assert.Equal(t, "w2", saved.History[3].Worker)
+	assert.Equal(t, saved.Status, to[3])
+	assert.Equal(t, []model.ArticleStatus{model.StatusPending, model.StatusProcessing, model.StatusProcessing}, to[:3])
+
+	// Trash and restore go through the machine too
+	finished := saved.Status
+	require.NoError(t, st.Trash(ctx, a.ID))
+	assert.ErrorIs(t, st.Requeue(ctx, a.ID), model.ErrIllegalTransition, "No requeueing from the trash")
+	require.NoError(t, st.Restore(ctx, a.ID))
+	saved, err = st.Get(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, finished, saved.Status, "Restored to where it was")
+	assert.Nil(t, saved.DeletedAt)
+	assert.Len(t, saved.History, 6)
+
+	require.NoError(t, st.Requeue(ctx, a.ID))
+	saved, err = st.Get(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusPending, saved.Status)
+}
+
+func TestStatus_Hybrid(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+
+	testStatus(t, st)
+}
+
+func TestStatus_Badger(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	testStatus(t, st)
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// TagCount is a tag and how many articles outside the trash have it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// UpdateTags adds and removes tags on an article and saves it
+func UpdateTags(ctx context.Context, st Store, id uuid.UUID, add, remove []string) (*model.Article, error) {
+	return updateMeta(ctx, st, id, func(a *model.Article) {
+		drop := make(map[string]bool, len(remove))
+		for _, tag := range model.NormalizeTags(remove) {
+			drop[tag] = true
+		}
+		var tags []string
+		for _, tag := range model.NormalizeTags(append(a.Tags, add...)) {
+			if !drop[tag] {
+				tags = append(tags, tag)
+			}
+		}
+		a.Tags = tags
+	})
+}
+
+// sortTags puts the most used tags first, ties by name
+func sortTags(tags []TagCount) []TagCount {
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags
+}
+
+// Tags counts the members of every tag:<tag> sorted set
+func (s *HybridStore) Tags(ctx context.Context) ([]TagCount, error) {
+	var keys []string
+	iter := s.rdb.Scan(ctx, 0, "tag:*", 500).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	pipe := s.rdb.Pipeline()
+	cards := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cards[i] = pipe.ZCard(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	tags := []TagCount{}
+	for i, key := range keys {
+		if n := cards[i].Val(); n > 0 {
+			tags = append(tags, TagCount{Tag: strings.TrimPrefix(key, "tag:"), Count: int(n)})
+		}
+	}
+	return sortTags(tags), nil
+}
+
+// Tags counts the tag:<tag>:<member> keys
+func (s *BadgerStore) Tags(ctx context.Context) ([]TagCount, error) {
+	counts := make(map[string]int)
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefixTag)})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			rest := string(it.Item().Key()[len(prefixTag):])
+			if i := strings.IndexByte(rest, ':'); i > 0 {
+				counts[rest[:i]]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]TagCount, 0, len(counts))
+	for tag, n := range counts {
+		tags = append(tags, TagCount{Tag: tag, Count: n})
+	}
+	return sortTags(tags), nil
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTags(t *testing.T, st Store) {
+	ctx := context.Background()
+
+	a := model.NewArticle("https://example.com/a")
+	a.Tags = []string{"Go", "research", "go", " Deep Dive "}
+	require.NoError(t, st.Save(ctx, &a))
+	assert.Equal(t, []string{"deep-dive", "go", "research"}, a.Tags, "Save normalizes")
+
+	b := model.NewArticle("https://example.com/b")
+	b.Tags = []string{"go"}
+	require.NoError(t, st.Save(ctx, &b))
+
+	assert.Equal(t, []uuid.UUID{b.ID, a.ID}, listAll(t, st, ListOptions{Tag: "go"}))
+	assert.Equal(t, []uuid.UUID{a.ID}, listAll(t, st, ListOptions{Tag: "research"}))
+	assert.Empty(t, listAll(t, st, ListOptions{Tag: "go", Domain: "other.example"}))
+
+	tags, err := st.Tags(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []TagCount{{"go", 2}, {"deep-dive", 1}, {"research", 1}}, tags)
+
+	got, err := UpdateTags(ctx, st, a.ID, []string{"Later"}, []string{"go", "deep dive"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"later", "research"}, got.Tags)
+	assert.Equal(t, []uuid.UUID{b.ID}, listAll(t, st, ListOptions{Tag: "go"}))
+	assert.Empty(t, listAll(t, st, ListOptions{Tag: "deep-dive"}))
+
+	// Trashed articles don't count, and come back with their tags
+	require.NoError(t, st.Trash(ctx, b.ID))
+	tags, err = st.Tags(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []TagCount{{"later", 1}, {"research", 1}}, tags)
+	assert.Equal(t, []uuid.UUID{b.ID}, listAll(t, st, ListOptions{Trashed: true, Tag: "go"}))
+
+	require.NoError(t, st.Restore(ctx, b.ID))
+	assert.Equal(t, []uuid.UUID{b.ID}, listAll(t, st, ListOptions{Tag: "go"}))
+
+	require.NoError(t, st.Delete(ctx, b.ID))
+	assert.Empty(t, listAll(t, st, ListOptions{Tag: "go"}))
+}
+
+func TestTags_Hybrid(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := NewHybridStore(mr.Addr(), "")
+	require.NoError(t, err)
+	defer st.Close()
+
+	testTags(t, st)
+}
+
+func TestTags_Badger(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	testTags(t, st)
+}
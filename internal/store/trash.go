@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Purger is the part of Store that EmptyTrash needs. client.Client has it too.
+type Purger interface {
+	Lister
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// EmptyTrash purges every trashed article that was deleted before cutoff
+func EmptyTrash(ctx context.Context, st Purger, cutoff time.Time) (int, error) {
+	// Collect first, so purging doesn't shift the pages under the cursor
+	var ids []uuid.UUID
+	opts := ListOptions{Trashed: true, Limit: 200}
+	for {
+		page, next, err := st.List(ctx, opts)
+		if err != nil {
+			return 0, err
+		}
+		for _, a := range page {
+			if a.DeletedAt != nil && a.DeletedAt.Before(cutoff) {
+				ids = append(ids, a.ID)
+			}
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+
+	purged := 0
+	for _, id := range ids {
+		// Gone already means another caller purged it, and counted it
+		if err := st.Delete(ctx, id); errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTrash(t *testing.T, st Store) {
+	ctx := context.Background()
+
+	pending := model.NewArticle("https://example.com/pending")
+	require.NoError(t, st.Save(ctx, &pending))
+	archived := saveArchived(t, st, "Gophers", "<p>All about gophers.</p>")
+
+	require.NoError(t, st.Trash(ctx, pending.ID))
+	require.NoError(t, st.Trash(ctx, archived.ID))
+	require.NoError(t, st.Trash(ctx, archived.ID), "Trashing twice is fine")
+	assert.ErrorIs(t, st.Trash(ctx, uuid.New()), ErrNotFound)
+
+	// Gone from everything but the trash
+	assert.Empty(t, listAll(t, st, ListOptions{}))
+	assert.Empty(t, listAll(t, st, ListOptions{Status: []model.ArticleStatus{model.StatusPending}}))
+	assert.Empty(t, listAll(t, st, ListOptions{Domain: "example.com"}))
+	assert.ElementsMatch(t, []uuid.UUID{pending.ID, archived.ID}, listAll(t, st, ListOptions{Trashed: true}))
+	assert.ElementsMatch(t, []uuid.UUID{pending.ID, archived.ID}, listAll(t, st, ListOptions{Trashed: true, Status: []model.ArticleStatus{model.StatusDeleted}}))
+
+	results, _, err := st.Search(ctx, "gophers", SearchOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	got, err := st.Get(ctx, archived.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, got.DeletedAt)
+	assert.Equal(t, model.StatusDeleted, got.Status)
+	assert.Equal(t, "<p>All about gophers.</p>", got.Content, "Content stays until the purge")
+
+	require.NoError(t, st.Restore(ctx, pending.ID))
+	assert.Equal(t, []uuid.UUID{pending.ID}, listAll(t, st, ListOptions{}))
+	id, err := st.PopQueue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pending.ID, id, "Restoring queues a pending article again")
+
+	// Only articles trashed before the cutoff are purged
+	n, err := EmptyTrash(ctx, st, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	n, err = EmptyTrash(ctx, st, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = st.Get(ctx, archived.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Empty(t, listAll(t, st, ListOptions{Trashed: true}))
+}
+
+// purgedMeanwhile purges an article behind EmptyTrash's back, between its
+// listing and its delete
+type purgedMeanwhile struct {
+	Store
+	id uuid.UUID
+}
+
+func (p purgedMeanwhile) Delete(ctx context.Context, id uuid.UUID) error {
+	if id == p.id {
+		if err := p.Store.Delete(ctx, id); err != nil {
+			return err
+		}
+	}
+	return p.Store.Delete(ctx, id)
+}
+
+func TestEmptyTrash_CountsOnlyItsOwnPurges(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+	ctx := context.Background()
+
+	a := saveArchived(t, st, "A", "")
+	b := saveArchived(t, st, "B", "")
+	require.NoError(t, st.Trash(ctx, a.ID))
+	require.NoError(t, st.Trash(ctx, b.ID))
+
+	n, err := EmptyTrash(ctx, purgedMeanwhile{Store: st, id: a.ID}, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Empty(t, listAll(t, st, ListOptions{Trashed: true}))
+}
+
+func TestTrash_Hybrid(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+
+	testTrash(t, st)
+}
+
+func TestTrash_Badger(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	testTrash(t, st)
+}
@@ -0,0 +1,234 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"crusty-buffer/internal/diff"
+	"crusty-buffer/internal/model"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNotWatched is returned by DeleteWatch and UpdateWatch for a URL that isn't on the watch list
+var ErrNotWatched = errors.New("url is not watched")
+
+// AddWatch puts rawURL on the watch list, replacing whatever interval and
+// tags it had. The first snapshot is due right away.
+func AddWatch(ctx context.Context, st Store, rawURL string, interval time.Duration, tags []string) (model.Watch, error) {
+	w, err := model.NewWatch(rawURL, interval, tags)
+	if err != nil {
+		return model.Watch{}, err
+	}
+	return w, st.SaveWatch(ctx, w)
+}
+
+// SnapshotDiff is a unified diff of the readable text of two articles. When
+// from is gone, the diff stored with to (against its PreviousID) stands in.
+func SnapshotDiff(ctx context.Context, st Store, from, to uuid.UUID) (string, error) {
+	b, err := st.Get(ctx, to)
+	if err != nil {
+		return "", err
+	}
+	a, err := st.Get(ctx, from)
+	if errors.Is(err, ErrNotFound) && b.PreviousID != nil && *b.PreviousID == from {
+		return st.GetDiff(ctx, to)
+	} else if err != nil {
+		return "", err
+	}
+	return TextDiff(a, b), nil
+}
+
+// TextDiff is what SnapshotDiff and the worker compare: a's text against b's
+func TextDiff(a, b *model.Article) string {
+	edits := diff.Compute(diff.Lines(a.Content), diff.Lines(b.Content))
+	return diff.Unified(edits, snapshotLabel(a), snapshotLabel(b), 3)
+}
+
+func snapshotLabel(a *model.Article) string {
+	at := a.CreatedAt
+	if a.ArchivedAt != nil {
+		at = *a.ArchivedAt
+	}
+	return fmt.Sprintf("%s\t%s", a.ID, at.UTC().Format(time.RFC3339))
+}
+
+func sortWatches(watches []model.Watch) []model.Watch {
+	sort.Slice(watches, func(i, j int) bool { return watches[i].URL < watches[j].URL })
+	return watches
+}
+
+const keyWatches = "watches"
+
+// SaveDiff compresses the diff into Badger next to the content
+func (s *HybridStore) SaveDiff(ctx context.Context, id uuid.UUID, d string) error {
+	if s.db == nil {
+		return fmt.Errorf("cannot save diff: badgerdb is not initialized")
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(diffKey(id), compress([]byte(d)))
+	})
+}
+
+// GetDiff returns the stored diff, or ErrNotFound if the article has none
+func (s *HybridStore) GetDiff(ctx context.Context, id uuid.UUID) (string, error) {
+	if s.db == nil {
+		return "", fmt.Errorf("cannot read diff: badgerdb is not initialized")
+	}
+	d, err := getCompressed(s.db, diffKey(id))
+	return string(d), err
+}
+
+// Watches reads the whole watches hash; it's keyed by the URL's hash
+func (s *HybridStore) Watches(ctx context.Context) ([]model.Watch, error) {
+	vals, err := s.rdb.HGetAll(ctx, keyWatches).Result()
+	if err != nil {
+		return nil, err
+	}
+	watches := make([]model.Watch, 0, len(vals))
+	for _, v := range vals {
+		var w model.Watch
+		if err := json.Unmarshal([]byte(v), &w); err != nil {
+			return nil, err
+		}
+		watches = append(watches, w)
+	}
+	return sortWatches(watches), nil
+}
+
+func (s *HybridStore) SaveWatch(ctx context.Context, w model.Watch) error {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, keyWatches, watchField(w.URL), data).Err()
+}
+
+// UpdateWatch records a check on the watch as it is now, so an edit or a
+// removal since it was read isn't undone. The hash is WATCHed for that.
+func (s *HybridStore) UpdateWatch(ctx context.Context, rawURL string, checked time.Time) error {
+	field := watchField(rawURL)
+	for i := 0; i < maxRetries; i++ {
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			val, err := tx.HGet(ctx, keyWatches, field).Bytes()
+			if err == redis.Nil {
+				return ErrNotWatched
+			} else if err != nil {
+				return err
+			}
+			var w model.Watch
+			if err := json.Unmarshal(val, &w); err != nil {
+				return err
+			}
+			w.Checked(checked)
+			data, err := json.Marshal(w)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, keyWatches, field, data)
+				return nil
+			})
+			return err
+		}, keyWatches)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+func (s *HybridStore) DeleteWatch(ctx context.Context, rawURL string) error {
+	n, err := s.rdb.HDel(ctx, keyWatches, watchField(rawURL)).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotWatched
+	}
+	return nil
+}
+
+func watchField(rawURL string) string { return model.URLHash(model.CanonicalizeURL(rawURL)) }
+func watchKey(rawURL string) []byte   { return []byte(prefixWatch + watchField(rawURL)) }
+
+func (s *BadgerStore) SaveDiff(ctx context.Context, id uuid.UUID, d string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(diffKey(id), compress([]byte(d)))
+	})
+}
+
+// GetDiff returns the stored diff, or ErrNotFound if the article has none
+func (s *BadgerStore) GetDiff(ctx context.Context, id uuid.UUID) (string, error) {
+	d, err := getCompressed(s.db, diffKey(id))
+	return string(d), err
+}
+
+// Watches walks the watch: keys
+func (s *BadgerStore) Watches(ctx context.Context) ([]model.Watch, error) {
+	var watches []model.Watch
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(prefixWatch)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var w model.Watch
+				if err := json.Unmarshal(val, &w); err != nil {
+					return err
+				}
+				watches = append(watches, w)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return sortWatches(watches), err
+}
+
+func (s *BadgerStore) SaveWatch(ctx context.Context, w model.Watch) error {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(watchKey(w.URL), data)
+	})
+}
+
+func (s *BadgerStore) UpdateWatch(ctx context.Context, rawURL string, checked time.Time) error {
+	return s.update(func(txn *badger.Txn) error {
+		var w model.Watch
+		if err := getJSON(txn, watchKey(rawURL), &w); errors.Is(err, ErrNotFound) {
+			return ErrNotWatched
+		} else if err != nil {
+			return err
+		}
+		w.Checked(checked)
+		data, err := json.Marshal(w)
+		if err != nil {
+			return err
+		}
+		return txn.Set(watchKey(rawURL), data)
+	})
+}
+
+func (s *BadgerStore) DeleteWatch(ctx context.Context, rawURL string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(watchKey(rawURL)); errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrNotWatched
+		} else if err != nil {
+			return err
+		}
+		return txn.Delete(watchKey(rawURL))
+	})
+}
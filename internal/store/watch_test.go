@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"crusty-buffer/internal/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testWatches(t *testing.T, st Store) {
+	ctx := context.Background()
+
+	_, err := AddWatch(ctx, st, "https://example.com/news", time.Second, nil)
+	assert.ErrorIs(t, err, model.ErrWatchInterval)
+
+	w, err := AddWatch(ctx, st, "https://Example.com/news?utm_source=x", time.Hour, []string{"News"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/news", w.URL)
+	_, err = AddWatch(ctx, st, "https://example.com/a", 24*time.Hour, nil)
+	require.NoError(t, err)
+
+	// Same page: replaced, not added
+	_, err = AddWatch(ctx, st, "https://example.com/news", 2*time.Hour, []string{"news"})
+	require.NoError(t, err)
+
+	watches, err := st.Watches(ctx)
+	require.NoError(t, err)
+	require.Len(t, watches, 2)
+	assert.Equal(t, "https://example.com/a", watches[0].URL)
+	assert.Equal(t, model.Duration(2*time.Hour), watches[1].Interval)
+	assert.Equal(t, []string{"news"}, watches[1].Tags)
+
+	// A check only sets when it was checked, on the watch as it is now
+	now := time.Now()
+	require.NoError(t, st.UpdateWatch(ctx, "https://EXAMPLE.com/news", now))
+	watches, err = st.Watches(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, model.Duration(2*time.Hour), watches[1].Interval)
+	assert.Equal(t, []string{"news"}, watches[1].Tags)
+	assert.Equal(t, now.Add(2*time.Hour).Unix(), watches[1].NextCheckAt.Unix())
+
+	require.NoError(t, st.DeleteWatch(ctx, "https://EXAMPLE.com/news"))
+	assert.ErrorIs(t, st.DeleteWatch(ctx, "https://example.com/news"), ErrNotWatched)
+	assert.ErrorIs(t, st.UpdateWatch(ctx, "https://example.com/news", now), ErrNotWatched, "A check doesn't bring it back")
+	watches, err = st.Watches(ctx)
+	require.NoError(t, err)
+	assert.Len(t, watches, 1)
+}
+
+func testSnapshotDiff(t *testing.T, st Store) {
+	ctx := context.Background()
+
+	a := model.NewArticle("https://example.com/news")
+	a.Content = "<p>one</p><p>two</p>"
+	require.NoError(t, st.Save(ctx, &a))
+	b := model.NewArticle("https://example.com/news")
+	b.Content = "<p>one</p><p>three</p>"
+	b.PreviousID = &a.ID
+	require.NoError(t, st.Save(ctx, &b))
+
+	d, err := SnapshotDiff(ctx, st, a.ID, b.ID)
+	require.NoError(t, err)
+	assert.Contains(t, d, "-two\n+three\n")
+
+	same, err := SnapshotDiff(ctx, st, a.ID, a.ID)
+	require.NoError(t, err)
+	assert.Empty(t, same)
+
+	// Once the older one is purged, the stored diff stands in
+	require.NoError(t, st.SaveDiff(ctx, b.ID, d))
+	require.NoError(t, st.Delete(ctx, a.ID))
+	stored, err := SnapshotDiff(ctx, st, a.ID, b.ID)
+	require.NoError(t, err)
+	assert.Equal(t, d, stored)
+
+	require.NoError(t, st.Delete(ctx, b.ID))
+	_, err = st.GetDiff(ctx, b.ID)
+	assert.ErrorIs(t, err, ErrNotFound, "Purged with the article")
+}
+
+func TestWatches_Hybrid(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+
+	testWatches(t, st)
+	testSnapshotDiff(t, st)
+}
+
+func TestWatches_Badger(t *testing.T) {
+	st, err := NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	testWatches(t, st)
+	testSnapshotDiff(t, st)
+}
@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"crusty-buffer/internal/model"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/publicsuffix"
+)
+
+// canonicalURL is where a fetched page really lives, spelled canonically:
+// its <link rel=canonical> if it has a believable one, else finalURL, the
+// URL the redirects ended at.
+func canonicalURL(raw []byte, finalURL string) string {
+	final, err := url.Parse(finalURL)
+	if err != nil {
+		return model.CanonicalizeURL(finalURL)
+	}
+	if link := resolveURL(final, canonicalLink(raw)); link != "" {
+		u, err := url.Parse(link)
+		// Some sites point every page at their front page; that's not this page.
+		// And a page can't claim to be another site's, or it could file itself
+		// under that site's snapshots and domain.
+		if err == nil && sameSite(u, final) && (strings.Trim(u.Path, "/") != "" || strings.Trim(final.Path, "/") == "") {
+			return model.CanonicalizeURL(link)
+		}
+	}
+	return model.CanonicalizeURL(finalURL)
+}
+
+// sameSite says whether a and b are on the same host, or at least the same
+// registrable domain (www.example.com and example.com)
+func sameSite(a, b *url.URL) bool {
+	ha, hb := strings.ToLower(a.Hostname()), strings.ToLower(b.Hostname())
+	if ha == hb {
+		return true
+	}
+	da, err := publicsuffix.EffectiveTLDPlusOne(ha)
+	if err != nil {
+		return false
+	}
+	db, err := publicsuffix.EffectiveTLDPlusOne(hb)
+	return err == nil && da == db
+}
+
+// canonicalLink finds the href of <link rel=canonical> in the page's head
+func canonicalLink(raw []byte) string {
+	z := html.NewTokenizer(bytes.NewReader(raw))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.DataAtom {
+			case atom.Body:
+				return ""
+			case atom.Link:
+				var rel, href string
+				for _, attr := range tok.Attr {
+					switch attr.Key {
+					case "rel":
+						rel = attr.Val
+					case "href":
+						href = attr.Val
+					}
+				}
+				for _, r := range strings.Fields(rel) {
+					if strings.EqualFold(r, "canonical") && href != "" {
+						return href
+					}
+				}
+			}
+		}
+	}
+}
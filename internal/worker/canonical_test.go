@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	page := func(head string) []byte {
+		return []byte(`<html><head>` + head + `</head><body><link rel="canonical" href="/in-body"><p>Hi</p></body></html>`)
+	}
+
+	// Relative links resolve against where the redirects ended
+	assert.Equal(t, "https://www.example.com/post/1",
+		canonicalURL(page(`<link rel="Canonical" href="/post/1?utm_campaign=x">`), "https://www.example.com/p?id=1"))
+	// No link: the final URL
+	assert.Equal(t, "https://example.com/landed",
+		canonicalURL(page(`<title>No link</title>`), "https://Example.com/landed#top"))
+	// A front page canonical on an inner page is a site bug, ignored
+	assert.Equal(t, "https://example.com/post/2",
+		canonicalURL(page(`<link rel="canonical" href="https://example.com/">`), "https://example.com/post/2"))
+	// Only http(s)
+	assert.Equal(t, "https://example.com/post/3",
+		canonicalURL(page(`<link rel="canonical" href="javascript:alert(1)">`), "https://example.com/post/3"))
+	// Another site's URL isn't this page's; the same site under another host is
+	assert.Equal(t, "https://example.com/post/4",
+		canonicalURL(page(`<link rel="canonical" href="https://evil.example.org/post/4">`), "https://example.com/post/4"))
+	assert.Equal(t, "https://example.com/post/5",
+		canonicalURL(page(`<link rel="canonical" href="https://example.com/post/5">`), "https://amp.example.com/post/5"))
+	// Hosts under a public suffix are separate sites
+	assert.Equal(t, "https://a.github.io/post/6",
+		canonicalURL(page(`<link rel="canonical" href="https://b.github.io/post/6">`), "https://a.github.io/post/6"))
+}
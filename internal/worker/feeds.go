@@ -0,0 +1,131 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"crusty-buffer/internal/feed"
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// WithFeedInterval sets how often the subscriptions are checked for feeds that are due (default 1m)
+func WithFeedInterval(d time.Duration) Option {
+	return func(w *Worker) {
+		if d > 0 {
+			w.feedInterval = d
+		}
+	}
+}
+
+func newFeedClient(userAgent string) *feed.Client {
+	return feed.NewClient(&http.Client{Timeout: 30 * time.Second}, userAgent)
+}
+
+// pollFeeds periodically fetches every feed that is due
+func (w *Worker) pollFeeds(ctx context.Context) {
+	ticker := time.NewTicker(w.feedInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.checkFeeds(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				w.logger.Error("Polling feeds failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// checkFeeds fetches the feeds due at now. A feed that can't be fetched only
+// records the error and is tried again next interval; store errors stop the round.
+func (w *Worker) checkFeeds(ctx context.Context, now time.Time) error {
+	feeds, err := w.store.Feeds(ctx)
+	if err != nil {
+		return err
+	}
+	for _, f := range feeds {
+		if !f.Due(now) {
+			continue
+		}
+		logger := w.logger.With(zap.String("feed", f.URL))
+		n, fetchErr, err := w.pollFeed(ctx, &f)
+		if err != nil {
+			return err
+		}
+		if fetchErr != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.Warn("Failed to fetch feed", zap.Error(fetchErr))
+		} else if n > 0 {
+			logger.Info("Queued new feed entries", zap.Int("count", n))
+		}
+		f.Fetched(now, fetchErr)
+		if err := w.store.UpdateFeed(ctx, f); errors.Is(err, store.ErrNotSubscribed) {
+			// Removed while it was fetched: don't bring it back, and drop
+			// the entries MarkSeen just recorded for it
+			logger.Info("Feed was unsubscribed during the fetch")
+			if err := w.store.DeleteFeed(ctx, f.URL); err != nil && !errors.Is(err, store.ErrNotSubscribed) {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pollFeed fetches one feed and queues its new entries, returning how many.
+// fetchErr is the feed's fault, err the store's. On the first fetch only the
+// newest f.Backfill entries are queued; the rest are just marked seen.
+func (w *Worker) pollFeed(ctx context.Context, f *model.Feed) (n int, fetchErr, err error) {
+	res, fetchErr := w.feeds.Fetch(ctx, f.URL, f.ETag, f.LastModified)
+	if fetchErr != nil {
+		return 0, fetchErr, nil
+	}
+	f.ETag, f.LastModified = res.ETag, res.LastModified
+	if res.NotModified {
+		return 0, nil, nil
+	}
+	f.Named(res.Feed.Title)
+
+	guids := make([]string, len(res.Feed.Entries))
+	for i, e := range res.Feed.Entries {
+		guids[i] = e.GUID
+	}
+	seen, err := w.store.SeenEntries(ctx, f.URL, guids)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	first := f.LastFetchAt == nil
+	var fresh []string
+	for i, e := range res.Feed.Entries {
+		if seen[e.GUID] {
+			continue
+		}
+		if !first || i < f.Backfill {
+			// The same post may come from another feed, or have been saved by
+			// hand; then it only gets this feed's tag
+			a := model.NewArticle(e.Link)
+			a.Tags = []string{f.Name}
+			if _, created, err := store.AddArticle(ctx, w.store, &a, store.DedupeSkip); err != nil {
+				if seenErr := w.store.MarkSeen(ctx, f.URL, fresh); seenErr != nil {
+					w.logger.Error("Failed to mark feed entries seen", zap.String("feed", f.URL), zap.Error(seenErr))
+				}
+				return n, nil, err
+			} else if created {
+				n++
+			}
+		}
+		fresh = append(fresh, e.GUID)
+	}
+	return n, nil, w.store.MarkSeen(ctx, f.URL, fresh)
+}
@@ -0,0 +1,156 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// feedFixture serves an RSS feed of whatever entries it holds, with an ETag per version
+type feedFixture struct {
+	mu       sync.Mutex
+	entries  []string // Paths, newest first
+	version  int
+	requests int
+	notMod   int
+	broken   bool
+}
+
+func (f *feedFixture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if f.broken {
+		http.NotFound(w, r)
+		return
+	}
+	etag := fmt.Sprintf(`"v%d"`, f.version)
+	if r.Header.Get("If-None-Match") == etag {
+		f.notMod++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "application/rss+xml")
+	var items strings.Builder
+	for _, path := range f.entries {
+		fmt.Fprintf(&items, "<item><title>%s</title><link>%s</link><guid>guid%s</guid></item>", path, path, path)
+	}
+	fmt.Fprintf(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Test Blog</title>%s</channel></rss>`, items.String())
+}
+
+func (f *feedFixture) publish(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = append([]string{path}, f.entries...)
+	f.version++
+}
+
+// TestWorker_PollsFeeds checks new entries are queued once, tagged with the
+// feed's name, and that unchanged feeds are only asked for a 304
+func TestWorker_PollsFeeds(t *testing.T) {
+	fixture := &feedFixture{entries: []string{"/posts/3", "/posts/2", "/posts/1"}}
+	srv := httptest.NewServer(fixture)
+	defer srv.Close()
+
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx := context.Background()
+	_, err = store.AddFeed(ctx, st, srv.URL+"/feed.xml", "", 0, 2)
+	require.NoError(t, err)
+
+	// Saved by hand before: it only picks up the tag
+	byHand := model.NewArticle(srv.URL + "/posts/4")
+	require.NoError(t, st.Save(ctx, &byHand))
+
+	w := NewWorker(st, zap.NewNop())
+	queued := func() []string {
+		t.Helper()
+		articles, _, err := st.List(ctx, store.ListOptions{Tag: "test-blog", Order: store.OrderOldest})
+		require.NoError(t, err)
+		var paths []string
+		for _, a := range articles {
+			paths = append(paths, strings.TrimPrefix(a.URL, srv.URL))
+		}
+		return paths
+	}
+
+	now := time.Now()
+	require.NoError(t, w.checkFeeds(ctx, now))
+	assert.ElementsMatch(t, []string{"/posts/3", "/posts/2"}, queued(), "Only the backfill")
+
+	feeds, err := st.Feeds(ctx)
+	require.NoError(t, err)
+	require.Len(t, feeds, 1)
+	assert.Equal(t, "test-blog", feeds[0].Name)
+	assert.Equal(t, "Test Blog", feeds[0].Title)
+	assert.Equal(t, `"v0"`, feeds[0].ETag)
+
+	// Not due yet, then due but unchanged
+	require.NoError(t, w.checkFeeds(ctx, now.Add(time.Minute)))
+	assert.Equal(t, 1, fixture.requests)
+	require.NoError(t, w.checkFeeds(ctx, now.Add(time.Hour)))
+	assert.Equal(t, 1, fixture.notMod)
+	assert.Len(t, queued(), 2)
+
+	fixture.publish("/posts/4")
+	fixture.publish("/posts/5")
+	require.NoError(t, w.checkFeeds(ctx, now.Add(2*time.Hour)))
+	assert.ElementsMatch(t, []string{"/posts/4", "/posts/3", "/posts/2", "/posts/5"}, queued())
+	got, err := st.Get(ctx, byHand.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test-blog"}, got.Tags)
+
+	// A broken feed is recorded and retried next time
+	fixture.mu.Lock()
+	fixture.broken = true
+	fixture.mu.Unlock()
+	require.NoError(t, w.checkFeeds(ctx, now.Add(3*time.Hour)))
+	feeds, err = st.Feeds(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, feeds[0].LastError, "404")
+}
+
+// TestWorker_FeedRemovedDuringFetch checks a feed unsubscribed while it's
+// fetched stays gone, seen entries included
+func TestWorker_FeedRemovedDuringFetch(t *testing.T) {
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+	ctx := context.Background()
+
+	var feedURL string
+	fixture := &feedFixture{entries: []string{"/posts/1"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, st.DeleteFeed(ctx, feedURL))
+		fixture.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	f, err := store.AddFeed(ctx, st, srv.URL+"/feed.xml", "", 0, 0)
+	require.NoError(t, err)
+	feedURL = f.URL
+
+	w := NewWorker(st, zap.NewNop())
+	require.NoError(t, w.checkFeeds(ctx, time.Now()))
+	feeds, err := st.Feeds(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, feeds)
+	seen, err := st.SeenEntries(ctx, feedURL, []string{"guid/posts/1"})
+	require.NoError(t, err)
+	assert.False(t, seen["guid/posts/1"])
+}
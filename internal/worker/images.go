@@ -0,0 +1,182 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"crusty-buffer/internal/store"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Fetcher downloads a single resource (an image) with a size cap.
+// DefaultScraper implements it with the same HTTP client it uses for pages.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string, maxSize int64) (data []byte, contentType string, err error)
+}
+
+// ImageConfig bounds how much a single article may pull in
+type ImageConfig struct {
+	Enabled   bool
+	MaxImages int   // Per article; the rest keep their remote src
+	MaxSize   int64 // Per image, in bytes
+}
+
+// DefaultImageConfig keeps images for offline reading, within reason
+func DefaultImageConfig() ImageConfig {
+	return ImageConfig{
+		Enabled:   true,
+		MaxImages: 50,
+		MaxSize:   5 << 20,
+	}
+}
+
+// AssetPath is where web.Server serves stored blobs
+const AssetPath = "/assets/"
+
+// localizeImages downloads the images referenced by content, stores them as
+// content-addressed assets and points every <img> at the local copy.
+// known maps remote URL -> hash from a previous run; those are reused without
+// any network, and with fetch=false nothing else is downloaded either.
+// Images that can't be fetched simply keep their remote src.
+func (w *Worker) localizeImages(ctx context.Context, logger *zap.Logger, content, baseURL string, known map[string]string, fetch bool) (string, []store.AssetRef) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		logger.Warn("Could not parse content for images", zap.Error(err))
+		return content, nil
+	}
+
+	base, _ := url.Parse(baseURL)
+	hashes := make(map[string]string) // remote URL -> hash, for this run
+	var refs []store.AssetRef
+	fetched := 0
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			// <picture><source srcset> would win over our local <img>, so drop it
+			if c.Type == html.ElementNode && c.DataAtom == atom.Source && n.DataAtom == atom.Picture {
+				n.RemoveChild(c)
+				c = next
+				continue
+			}
+			walk(c)
+			c = next
+		}
+
+		if n.Type != html.ElementNode || n.DataAtom != atom.Img {
+			return
+		}
+		src := resolveURL(base, attr(n, "src"))
+		if src == "" {
+			return
+		}
+
+		hash, ok := hashes[src]
+		if !ok {
+			hash, ok = known[src]
+		}
+		if !ok && fetch && fetched < w.images.MaxImages && ctx.Err() == nil {
+			fetched++
+			hash, ok = w.fetchImage(ctx, logger, src)
+		}
+		if !ok {
+			return
+		}
+
+		if _, seen := hashes[src]; !seen {
+			hashes[src] = hash
+			refs = append(refs, store.AssetRef{URL: src, Hash: hash})
+		}
+		setAttr(n, "src", AssetPath+hash)
+		removeAttr(n, "srcset")
+	}
+
+	var sb strings.Builder
+	for _, n := range nodes {
+		walk(n)
+		html.Render(&sb, n)
+	}
+	return sb.String(), refs
+}
+
+// fetchImage downloads and stores one image, returning its hash
+func (w *Worker) fetchImage(ctx context.Context, logger *zap.Logger, src string) (string, bool) {
+	data, contentType, err := w.fetcher.Fetch(ctx, src, w.images.MaxSize)
+	if err != nil {
+		logger.Debug("Image download failed", zap.String("src", src), zap.Error(err))
+		return "", false
+	}
+
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		logger.Debug("Not an image", zap.String("src", src), zap.String("content_type", contentType))
+		return "", false
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	err = w.store.PutAsset(context.WithoutCancel(ctx), store.Asset{Hash: hash, ContentType: contentType, Data: data})
+	if err != nil {
+		logger.Warn("Failed to store image", zap.String("src", src), zap.Error(err))
+		return "", false
+	}
+	return hash, true
+}
+
+func resolveURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, AssetPath) {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeAttr(n *html.Node, key string) {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if a.Key != key {
+			attrs = append(attrs, a)
+		}
+	}
+	n.Attr = attrs
+}
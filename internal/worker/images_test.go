@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// A 1x1 GIF
+var pixel = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\xff\xff\xff!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+func TestWorker_LocalizesImages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a.gif", "/copy-of-a.gif":
+			w.Write(pixel)
+		case "/huge.gif":
+			w.Write(append(pixel, make([]byte, 4096)...))
+		case "/page.html":
+			fmt.Fprint(w, "<html>not an image</html>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	w := NewWorker(st, zap.NewNop(), WithImages(ImageConfig{Enabled: true, MaxImages: 10, MaxSize: 1024}))
+
+	content := fmt.Sprintf(`<div><picture><source srcset="%[1]s/a.webp"/><img src="%[1]s/a.gif" srcset="%[1]s/a@2x.gif 2x"/></picture>`+
+		`<img src="/copy-of-a.gif"/><img src="%[1]s/missing.gif"/><img src="%[1]s/huge.gif"/>`+
+		`<img src="%[1]s/page.html"/><img src="data:image/gif;base64,R0lGOD"/></div>`, srv.URL)
+	article := model.NewArticle(srv.URL + "/post")
+	article.Content = content
+
+	require.NoError(t, w.storeImages(context.Background(), context.Background(), zap.NewNop(), &article, srv.URL+"/post", true))
+
+	// Both URLs serve the same bytes: one blob, two manifest entries
+	refs, err := st.GetAssetManifest(context.Background(), article.ID)
+	require.NoError(t, err)
+	require.Len(t, refs, 2)
+	assert.Equal(t, refs[0].Hash, refs[1].Hash)
+
+	local := AssetPath + refs[0].Hash
+	assert.Equal(t, 2, strings.Count(article.Content, `src="`+local+`"`))
+	assert.NotContains(t, article.Content, "srcset", "srcset/source would bypass the local copy")
+	assert.Contains(t, article.Content, srv.URL+"/missing.gif", "Failed downloads keep their remote src")
+	assert.Contains(t, article.Content, srv.URL+"/huge.gif", "Oversized images are skipped")
+	assert.Contains(t, article.Content, srv.URL+"/page.html", "Non-images are skipped")
+	assert.Contains(t, article.Content, "data:image/gif", "data: URIs are already offline")
+
+	asset, err := st.GetAsset(context.Background(), refs[0].Hash)
+	require.NoError(t, err)
+	assert.Equal(t, "image/gif", asset.ContentType)
+	assert.Equal(t, pixel, asset.Data)
+}
+
+func TestWorker_ImageCountLimit(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write(append(pixel, []byte(r.URL.Path)...)) // Distinct bytes per image
+	}))
+	defer srv.Close()
+
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	w := NewWorker(st, zap.NewNop(), WithImages(ImageConfig{Enabled: true, MaxImages: 2, MaxSize: 1024}))
+
+	article := model.NewArticle(srv.URL)
+	for i := 0; i < 5; i++ {
+		article.Content += fmt.Sprintf(`<img src="%s/%d.gif"/>`, srv.URL, i)
+	}
+	require.NoError(t, w.storeImages(context.Background(), context.Background(), zap.NewNop(), &article, srv.URL, true))
+
+	assert.Equal(t, 2, hits)
+	assert.Equal(t, 2, strings.Count(article.Content, AssetPath))
+}
+
+// noFinalURL is a scraper that can't tell where redirects ended up
+type noFinalURL struct{ MockScraper }
+
+func (s *noFinalURL) Scrape(ctx context.Context, url string, opts ScrapeOptions) (*Page, error) {
+	page, err := s.MockScraper.Scrape(ctx, url, opts)
+	if page != nil {
+		page.FinalURL = ""
+	}
+	return page, err
+}
+
+// TestWorker_ImagesWithoutFinalURL checks relative images resolve against
+// the article's URL when the scraper has no final URL
+func TestWorker_ImagesWithoutFinalURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pixel)
+	}))
+	defer srv.Close()
+
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+	ctx := context.Background()
+
+	w := NewWorker(st, zap.NewNop(), WithPoliteness(PolitenessConfig{}), WithImages(ImageConfig{Enabled: true, MaxImages: 10, MaxSize: 1024}))
+	w.scraper = &noFinalURL{MockScraper{MockTitle: "Post", MockContent: `<p><img src="/a.gif"/></p>`}}
+
+	article := model.NewArticle(srv.URL + "/post")
+	require.NoError(t, st.Save(ctx, &article))
+	id, err := st.PopQueue(ctx)
+	require.NoError(t, err)
+	w.processJob(ctx, id, "w1")
+
+	refs, err := st.GetAssetManifest(ctx, article.ID)
+	require.NoError(t, err)
+	require.Len(t, refs, 1)
+	assert.Equal(t, srv.URL+"/a.gif", refs[0].URL)
+}
@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultUserAgent is sent with every request the worker makes
+const DefaultUserAgent = "crusty-buffer/1.0 (+https://github.com/LeeFred3042U/crusty-buffer)"
+
+// PolitenessConfig keeps bulk imports from hammering a single host.
+// Each host gets a token bucket (Rate tokens per second, up to Burst) and
+// two requests to the same host are never closer together than MinDelay.
+type PolitenessConfig struct {
+	Rate      float64
+	Burst     int
+	MinDelay  time.Duration
+	Robots    bool // Fetch and obey robots.txt
+	UserAgent string
+}
+
+// DefaultPoliteness allows one page per host per second with a small burst
+func DefaultPoliteness() PolitenessConfig {
+	return PolitenessConfig{
+		Rate:      1,
+		Burst:     2,
+		MinDelay:  500 * time.Millisecond,
+		UserAgent: DefaultUserAgent,
+	}
+}
+
+// hostLimiter is one token bucket. tokens may go negative: that's a
+// reservation for a caller that is already sleeping until its turn.
+type hostLimiter struct {
+	tokens float64
+	last   time.Time // when tokens was last refilled
+	next   time.Time // earliest start for the next request (MinDelay)
+}
+
+type limiter struct {
+	cfg PolitenessConfig
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimiter
+}
+
+func newLimiter(cfg PolitenessConfig) *limiter {
+	return &limiter{cfg: cfg, hosts: make(map[string]*hostLimiter)}
+}
+
+// Wait blocks until the caller may hit the host of rawURL, or ctx ends
+func (l *limiter) Wait(ctx context.Context, rawURL string) error {
+	delay := l.reserve(hostOf(rawURL), time.Now())
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve books the next slot for host and returns how long to wait for it
+func (l *limiter) reserve(host string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := float64(max(l.cfg.Burst, 1))
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostLimiter{tokens: burst, last: now}
+		l.hosts[host] = h
+	}
+
+	start := now
+	if l.cfg.Rate > 0 {
+		// Refill, then take one token; if that leaves us in debt, wait it off
+		h.tokens = min(burst, h.tokens+now.Sub(h.last).Seconds()*l.cfg.Rate)
+		h.last = now
+		h.tokens--
+		if h.tokens < 0 {
+			start = now.Add(time.Duration(-h.tokens / l.cfg.Rate * float64(time.Second)))
+		}
+	}
+	if start.Before(h.next) {
+		start = h.next
+	}
+	h.next = start.Add(l.cfg.MinDelay)
+
+	return start.Sub(now)
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return strings.ToLower(u.Host)
+}
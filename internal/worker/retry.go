@@ -0,0 +1,45 @@
+package worker
+
+import "time"
+
+// RetryPolicy decides how often a failing scrape is retried and how long to wait in between.
+// The wait doubles every attempt: BaseDelay, 2*BaseDelay, 4*BaseDelay... capped at MaxDelay.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy rides out a flaky DNS or a 5xx without hammering the site:
+// 5 attempts spread over roughly 15 minutes.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Minute,
+		MaxDelay:    time.Hour,
+	}
+}
+
+// Backoff returns the wait before the next try, given how many attempts already failed
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// Exhausted reports whether a job that failed this many times should go to the dead-letter queue
+func (p RetryPolicy) Exhausted(attempts int) bool {
+	return attempts >= p.MaxAttempts
+}
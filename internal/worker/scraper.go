@@ -0,0 +1,252 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+)
+
+// Scraper defines the interface for downloading web pages.
+// This allows us to mock the "Download" step in tests.
+// Implementations must give up as soon as ctx is cancelled.
+type Scraper interface {
+	Scrape(ctx context.Context, url string, opts ScrapeOptions) (*Page, error)
+}
+
+// Page is a downloaded page: what readability made of it, plus the original bytes
+type Page struct {
+	readability.Article
+	Raw      []byte // HTML exactly as the server sent it
+	FinalURL string // Where redirects ended up
+}
+
+// ScrapeOptions are per-call knobs
+type ScrapeOptions struct {
+	Timeout time.Duration // 0 means only ctx limits the call
+}
+
+var (
+	ErrTooLarge        = errors.New("response body too large")
+	ErrTooManyRedirect = errors.New("too many redirects")
+)
+
+// ScraperConfig shapes the HTTP client behind DefaultScraper
+type ScraperConfig struct {
+	UserAgent    string
+	Proxy        string // e.g. "http://proxy:3128"; empty falls back to HTTP(S)_PROXY
+	MaxBodySize  int64  // bytes; anything bigger fails with ErrTooLarge
+	MaxRedirects int
+
+	InsecureSkipVerify bool   // Accept any TLS certificate (self-signed intranet pages)
+	CAFile             string // Extra PEM roots to trust on top of the system pool
+
+	Cookies bool // Keep a cookie jar, for sites that bounce through a consent redirect
+}
+
+// DefaultScraperConfig is what NewWorker uses out of the box
+func DefaultScraperConfig() ScraperConfig {
+	return ScraperConfig{
+		UserAgent:    DefaultUserAgent,
+		MaxBodySize:  10 << 20,
+		MaxRedirects: 10,
+		Cookies:      true,
+	}
+}
+
+// DefaultScraper is the real implementation that uses the internet.
+// It does the download itself and only hands the body to readability.
+type DefaultScraper struct {
+	client *http.Client
+	cfg    ScraperConfig
+}
+
+// NewDefaultScraper builds the HTTP client described by cfg
+func NewDefaultScraper(cfg ScraperConfig) (*DefaultScraper, error) {
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = DefaultUserAgent
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %w", cfg.Proxy, err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return ErrTooManyRedirect
+			}
+			return nil
+		},
+	}
+	if cfg.Cookies {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		client.Jar = jar
+	}
+
+	return &DefaultScraper{client: client, cfg: cfg}, nil
+}
+
+// mustDefaultScraper is only for the built-in config, which can't fail
+func mustDefaultScraper() *DefaultScraper {
+	sc, err := NewDefaultScraper(DefaultScraperConfig())
+	if err != nil {
+		panic(err)
+	}
+	return sc
+}
+
+func (s *DefaultScraper) Scrape(ctx context.Context, pageURL string, opts ScrapeOptions) (*Page, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	body, finalURL, err := s.fetch(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Relative links and images resolve against where we ended up, not where we started
+	art, err := Extract(body, finalURL.String())
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Article: *art, Raw: body, FinalURL: finalURL.String()}, nil
+}
+
+// Extract runs readability over already-downloaded HTML. It never touches the
+// network, which is what makes reprocessing stored raw pages possible.
+func Extract(raw []byte, pageURL string) (*readability.Article, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page url: %w", err)
+	}
+	art, err := readability.FromReader(bytes.NewReader(raw), u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+	return &art, nil
+}
+
+// fetch downloads pageURL and returns the body plus the URL after redirects
+func (s *DefaultScraper) fetch(ctx context.Context, pageURL string) ([]byte, *url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", s.cfg.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.5")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, nil, fmt.Errorf("failed to fetch page: HTTP %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, _ := mime.ParseMediaType(ct)
+		if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+			return nil, nil, fmt.Errorf("unsupported content type %q", mediaType)
+		}
+	}
+
+	// Read one byte past the limit so "exactly at the limit" still passes
+	reader := io.Reader(resp.Body)
+	if s.cfg.MaxBodySize > 0 {
+		reader = io.LimitReader(resp.Body, s.cfg.MaxBodySize+1)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.cfg.MaxBodySize > 0 && int64(len(body)) > s.cfg.MaxBodySize {
+		return nil, nil, ErrTooLarge
+	}
+
+	return body, resp.Request.URL, nil
+}
+
+// Fetch downloads a single non-page resource (an image), capped at maxSize bytes
+func (s *DefaultScraper) Fetch(ctx context.Context, resourceURL string, maxSize int64) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resourceURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", s.cfg.UserAgent)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, "", fmt.Errorf("failed to fetch %s: HTTP %d", resourceURL, resp.StatusCode)
+	}
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		return nil, "", ErrTooLarge
+	}
+
+	reader := io.Reader(resp.Body)
+	if maxSize > 0 {
+		reader = io.LimitReader(resp.Body, maxSize+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", err
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, "", ErrTooLarge
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return data, contentType, nil
+}
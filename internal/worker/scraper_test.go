@@ -0,0 +1,101 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPage = `<html><head><title>Hello Page</title></head><body>
+<article><h1>Hello Page</h1>
+<p>` + "Plenty of words so readability believes this is an article body. " + `</p>
+<p><a href="next.html">next</a></p>
+</article></body></html>`
+
+func TestDefaultScraper_FollowsRedirectAndResolvesAgainstFinalURL(t *testing.T) {
+	var gotUA string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/posts/hello", http.StatusMovedPermanently)
+		case "/posts/hello":
+			gotUA = r.UserAgent()
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, testPage)
+		}
+	}))
+	defer srv.Close()
+
+	sc, err := NewDefaultScraper(ScraperConfig{UserAgent: "crusty-test", MaxRedirects: 3})
+	require.NoError(t, err)
+
+	art, err := sc.Scrape(context.Background(), srv.URL+"/old", ScrapeOptions{Timeout: 5 * time.Second})
+	require.NoError(t, err)
+
+	assert.Equal(t, "crusty-test", gotUA)
+	assert.Equal(t, "Hello Page", art.Title)
+	assert.Contains(t, art.Content, srv.URL+"/posts/next.html", "Links resolve against the final URL")
+}
+
+func TestDefaultScraper_Limits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/big":
+			fmt.Fprint(w, "<html><body>"+strings.Repeat("x", 2048)+"</body></html>")
+		case "/pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			fmt.Fprint(w, "%PDF-1.4")
+		case "/gone":
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	sc, err := NewDefaultScraper(ScraperConfig{MaxBodySize: 1024, MaxRedirects: 2})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = sc.Scrape(ctx, srv.URL+"/loop", ScrapeOptions{})
+	assert.ErrorIs(t, err, ErrTooManyRedirect)
+
+	_, err = sc.Scrape(ctx, srv.URL+"/big", ScrapeOptions{})
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	_, err = sc.Scrape(ctx, srv.URL+"/pdf", ScrapeOptions{})
+	assert.ErrorContains(t, err, "unsupported content type")
+
+	_, err = sc.Scrape(ctx, srv.URL+"/gone", ScrapeOptions{})
+	assert.ErrorContains(t, err, "HTTP 404")
+}
+
+func TestDefaultScraper_CancelAbortsDownload(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	sc, err := NewDefaultScraper(DefaultScraperConfig())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = sc.Scrape(ctx, srv.URL, ScrapeOptions{Timeout: 30 * time.Second})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"crusty-buffer/internal/diff"
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// WithWatchInterval sets how often the watch list is checked for due pages (default 1m)
+func WithWatchInterval(d time.Duration) Option {
+	return func(w *Worker) {
+		if d > 0 {
+			w.watchInterval = d
+		}
+	}
+}
+
+// watch periodically queues a fresh snapshot of every watched page that is due
+func (w *Worker) watch(ctx context.Context) {
+	ticker := time.NewTicker(w.watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.checkWatches(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				w.logger.Error("Checking the watch list failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// checkWatches queues a snapshot for each watch due at now. A snapshot still
+// on its way counts, so a slow site doesn't pile up jobs.
+func (w *Worker) checkWatches(ctx context.Context, now time.Time) error {
+	watches, err := w.store.Watches(ctx)
+	if err != nil {
+		return err
+	}
+	for _, watch := range watches {
+		if !watch.Due(now) {
+			continue
+		}
+		a := model.NewArticle(watch.URL)
+		a.Watched = true
+		a.Tags = watch.Tags
+		article, created, err := store.AddArticle(ctx, w.store, &a, store.DedupeVersion)
+		if err != nil {
+			return err
+		}
+		if created {
+			w.logger.Info("Queued watched page", zap.String("url", watch.URL), zap.String("id", article.ID.String()))
+		}
+		// Only the check is written, so an edit since Watches() stands
+		if err := w.store.UpdateWatch(ctx, watch.URL, now); errors.Is(err, store.ErrNotWatched) {
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// previousSnapshot is the newest archived snapshot of the article's page
+// other than the article itself, with its content. nil if there is none.
+func (w *Worker) previousSnapshot(ctx context.Context, article *model.Article) (*model.Article, error) {
+	snapshots, err := store.Snapshots(ctx, w.store, article.Canonical())
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range snapshots {
+		if s.ID == article.ID || s.Status != model.StatusArchived {
+			continue
+		}
+		prev, err := w.store.Get(ctx, s.ID)
+		if err != nil {
+			return nil, err
+		}
+		if prev.ContentHash == "" {
+			// Archived before hashes were kept
+			prev.ContentHash = diff.Hash(diff.Lines(prev.Content))
+		}
+		return prev, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// TestWorker_WatchedSnapshots checks due watches get queued, unchanged
+// snapshots are dropped and changed ones keep a diff
+func TestWorker_WatchedSnapshots(t *testing.T) {
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx := context.Background()
+	_, err = store.AddWatch(ctx, st, "http://example.com/news", time.Hour, []string{"news"})
+	require.NoError(t, err)
+
+	scraper := &MockScraper{MockTitle: "News", MockContent: "<p>Nothing happened.</p>"}
+	w := NewWorker(st, zap.NewNop(), WithPoliteness(PolitenessConfig{}))
+	w.scraper = scraper
+
+	// snapshot runs the scheduler at now and processes whatever it queued
+	snapshot := func(now time.Time) model.Article {
+		t.Helper()
+		require.NoError(t, w.checkWatches(ctx, now))
+		id, err := st.PopQueue(ctx)
+		require.NoError(t, err)
+		w.processJob(ctx, id, "w1")
+		a, err := st.Get(ctx, id)
+		if err == store.ErrNotFound {
+			return model.Article{ID: id}
+		}
+		require.NoError(t, err)
+		return *a
+	}
+
+	now := time.Now()
+	first := snapshot(now)
+	assert.Equal(t, model.StatusArchived, first.Status)
+	assert.True(t, first.Watched)
+	assert.Equal(t, []string{"news"}, first.Tags)
+	assert.NotEmpty(t, first.ContentHash)
+	assert.Nil(t, first.PreviousID)
+
+	// Not due again until the interval is up
+	require.NoError(t, w.checkWatches(ctx, now.Add(time.Minute)))
+	snapshots, err := store.Snapshots(ctx, st, "http://example.com/news")
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+
+	unchanged := snapshot(now.Add(time.Hour))
+	assert.Empty(t, unchanged.Status, "Unchanged snapshots aren't kept")
+	snapshots, err = store.Snapshots(ctx, st, "http://example.com/news")
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+
+	scraper.MockContent = "<p>Something happened.</p>"
+	changed := snapshot(now.Add(2 * time.Hour))
+	assert.Equal(t, model.StatusArchived, changed.Status)
+	require.NotNil(t, changed.PreviousID)
+	assert.Equal(t, first.ID, *changed.PreviousID)
+
+	d, err := st.GetDiff(ctx, changed.ID)
+	require.NoError(t, err)
+	assert.Contains(t, d, "-Nothing happened.\n+Something happened.\n")
+
+	watches, err := st.Watches(ctx)
+	require.NoError(t, err)
+	require.Len(t, watches, 1)
+	assert.Equal(t, now.Add(3*time.Hour).Unix(), watches[0].NextCheckAt.Unix())
+}
+
+// editDuringSave runs edit the first time an article is saved
+type editDuringSave struct {
+	store.Store
+	edit func()
+}
+
+func (s *editDuringSave) Save(ctx context.Context, a *model.Article) error {
+	if s.edit != nil {
+		s.edit()
+		s.edit = nil
+	}
+	return s.Store.Save(ctx, a)
+}
+
+// TestWorker_WatchEditedDuringCheck checks a watch changed or removed while
+// its snapshot is queued keeps the change
+func TestWorker_WatchEditedDuringCheck(t *testing.T) {
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+	ctx := context.Background()
+
+	edited, err := store.AddWatch(ctx, st, "http://example.com/edited", time.Hour, nil)
+	require.NoError(t, err)
+	_, err = store.AddWatch(ctx, st, "http://example.com/removed", time.Hour, nil)
+	require.NoError(t, err)
+
+	wrapped := &editDuringSave{Store: st, edit: func() {
+		edited.Interval = model.Duration(2 * time.Hour)
+		require.NoError(t, st.SaveWatch(ctx, edited))
+		require.NoError(t, st.DeleteWatch(ctx, "http://example.com/removed"))
+	}}
+	w := NewWorker(wrapped, zap.NewNop())
+	now := time.Now()
+	require.NoError(t, w.checkWatches(ctx, now))
+
+	watches, err := st.Watches(ctx)
+	require.NoError(t, err)
+	require.Len(t, watches, 1, "The removed watch stays removed")
+	assert.Equal(t, model.Duration(2*time.Hour), watches[0].Interval)
+	assert.Equal(t, now.Add(2*time.Hour).Unix(), watches[0].NextCheckAt.Unix())
+}
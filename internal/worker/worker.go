@@ -2,9 +2,20 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"crusty-buffer/internal/diff"
+	"crusty-buffer/internal/feed"
 	"crusty-buffer/internal/model"
+	"crusty-buffer/internal/robots"
+	"crusty-buffer/internal/sanitize"
 	"crusty-buffer/internal/store"
 
 	"github.com/go-shiori/go-readability"
@@ -12,98 +23,616 @@ import (
 	"go.uber.org/zap"
 )
 
-// Scraper defines the interface for downloading web pages.
-// This allows us to mock the "Download" step in tests.
-type Scraper interface {
-	Scrape(url string, timeout time.Duration) (*readability.Article, error)
+type Worker struct {
+	store           store.Store
+	logger          *zap.Logger
+	scraper         Scraper 
+	fetcher         Fetcher // nil: images are never downloaded
+	images          ImageConfig
+	sanitizer       *sanitize.Sanitizer
+	retry           RetryPolicy
+	scrapeTimeout   time.Duration
+	concurrency     int
+	limiter         *limiter
+	robots          *robots.Cache // nil when robots.txt is ignored
+	robotsUA        string        // Set by WithPoliteness; NewWorker builds robots from it
+	reapInterval    time.Duration
+	promoteInterval time.Duration
+	trashRetention  time.Duration // 0 keeps trashed articles forever
+	trashInterval   time.Duration
+	watchInterval   time.Duration
+	feeds           *feed.Client
+	feedInterval    time.Duration
+	id              string // Names this process in article History; each consumer adds "/<n>"
 }
 
-// DefaultScraper is the real implementation that uses the internet
-type DefaultScraper struct{}
+// Option tweaks a Worker at construction time
+type Option func(*Worker)
 
-func (s *DefaultScraper) Scrape(url string, timeout time.Duration) (*readability.Article, error) {
-	// We return a pointer to the article
-	art, err := readability.FromURL(url, timeout)
-	return &art, err
+// WithRetryPolicy replaces DefaultRetryPolicy
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(w *Worker) {
+		w.retry = p
+	}
 }
 
-type Worker struct {
-	store   store.Store
-	logger  *zap.Logger
-	scraper Scraper 
+// WithScraper replaces the DefaultScraper, e.g. one built with custom ScraperConfig.
+// If sc can also Fetch, images are downloaded through it too.
+func WithScraper(sc Scraper) Option {
+	return func(w *Worker) {
+		w.scraper = sc
+		w.fetcher, _ = sc.(Fetcher)
+	}
+}
+
+// WithImages replaces DefaultImageConfig
+func WithImages(cfg ImageConfig) Option {
+	return func(w *Worker) {
+		w.images = cfg
+	}
+}
+
+// WithSanitizePolicy replaces sanitize.DefaultPolicy for newly extracted content
+func WithSanitizePolicy(p sanitize.Policy) Option {
+	return func(w *Worker) {
+		w.sanitizer = sanitize.New(p)
+	}
+}
+
+// DefaultTrashRetention is how long a trashed article waits before it's purged
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// WithTrashRetention sets how long trashed articles are kept. 0 never empties the trash.
+func WithTrashRetention(d time.Duration) Option {
+	return func(w *Worker) {
+		w.trashRetention = d
+	}
+}
+
+// WithScrapeTimeout caps how long a single page download may take (default 30s)
+func WithScrapeTimeout(d time.Duration) Option {
+	return func(w *Worker) {
+		if d > 0 {
+			w.scrapeTimeout = d
+		}
+	}
+}
+
+// WithConcurrency sets how many jobs are processed in parallel (default 1)
+func WithConcurrency(n int) Option {
+	return func(w *Worker) {
+		if n > 0 {
+			w.concurrency = n
+		}
+	}
+}
+
+// WithPoliteness replaces DefaultPoliteness. A zero Rate and MinDelay turn rate limiting off.
+func WithPoliteness(cfg PolitenessConfig) Option {
+	return func(w *Worker) {
+		if cfg.UserAgent == "" {
+			cfg.UserAgent = DefaultUserAgent
+		}
+		w.limiter = newLimiter(cfg)
+		w.robotsUA = ""
+		if cfg.Robots {
+			w.robotsUA = cfg.UserAgent
+		}
+		w.feeds = newFeedClient(cfg.UserAgent)
+	}
 }
 
 // NewWorker initializes the worker with the DefaultScraper
-func NewWorker(store store.Store, logger *zap.Logger) *Worker {
-	return &Worker{
-		store:   store,
-		logger:  logger,
-		scraper: &DefaultScraper{},
+func NewWorker(store store.Store, logger *zap.Logger, opts ...Option) *Worker {
+	scraper := mustDefaultScraper()
+	w := &Worker{
+		store:           store,
+		logger:          logger,
+		scraper:         scraper,
+		fetcher:         scraper,
+		images:          DefaultImageConfig(),
+		sanitizer:       sanitize.New(sanitize.DefaultPolicy()),
+		scrapeTimeout:   30 * time.Second,
+		retry:           DefaultRetryPolicy(),
+		concurrency:     1,
+		limiter:         newLimiter(DefaultPoliteness()),
+		reapInterval:    30 * time.Second,
+		promoteInterval: time.Second,
+		trashRetention:  DefaultTrashRetention,
+		trashInterval:   time.Hour,
+		watchInterval:   time.Minute,
+		feeds:           newFeedClient(DefaultUserAgent),
+		feedInterval:    time.Minute,
+		id:              defaultID(),
+	}
+	for _, opt := range opts {
+		opt(w)
 	}
+	// After the options, so the scraper is the one that will be used
+	if w.robotsUA != "" {
+		w.robots = robots.NewCache(w.robotsClient(), w.robotsUA)
+	}
+	return w
 }
 
-// Start runs the worker loop
+// robotsClient asks for robots.txt the way pages are fetched: through the
+// DefaultScraper's transport, so its proxy, CA file and TLS settings apply
+func (w *Worker) robotsClient() *http.Client {
+	client := &http.Client{Timeout: 10 * time.Second}
+	if sc, ok := w.scraper.(*DefaultScraper); ok {
+		client.Transport = sc.client.Transport
+	}
+	return client
+}
+
+// defaultID is host-pid, enough to tell workers apart in an article's History
+func defaultID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Start runs the worker pool and blocks until ctx is cancelled
+// AND every in-flight job has been finished, so callers can simply wait for it to return.
 func (w *Worker) Start(ctx context.Context) {
-	w.logger.Info("Worker started. Waiting for jobs...")
+	w.logger.Info("Worker started. Waiting for jobs...", zap.Int("concurrency", w.concurrency))
+
+	var wg sync.WaitGroup
+	wg.Add(5 + w.concurrency)
+
+	go func() {
+		defer wg.Done()
+		w.reap(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		w.promote(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		w.emptyTrash(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		w.watch(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		w.pollFeeds(ctx)
+	}()
+
+	for i := 0; i < w.concurrency; i++ {
+		name := fmt.Sprintf("%s/%d", w.id, i)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx, name)
+		}()
+	}
+
+	wg.Wait()
+	w.logger.Info("Worker shutting down")
+}
 
+// loop is one consumer: pop, process, repeat. name goes into the History of
+// every article it touches.
+func (w *Worker) loop(ctx context.Context, name string) {
 	for {
-		// Wait for job (Blocking call to Redis)
+		// Wait for job (Blocking call to the store)
 		id, err := w.store.PopQueue(ctx)
 		if err != nil {
 			if ctx.Err() != nil {
-				w.logger.Info("Worker shutting down")
 				return
 			}
 			w.logger.Error("Queue error", zap.Error(err))
-			time.Sleep(time.Second)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
 			continue
 		}
 
 		// Process
-		w.processJob(ctx, id)
+		w.processJob(ctx, id, name)
+	}
+}
+
+// reap periodically hands jobs from dead workers back to the queue
+func (w *Worker) reap(ctx context.Context) {
+	ticker := time.NewTicker(w.reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := w.store.RequeueExpired(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					w.logger.Error("Reaper error", zap.Error(err))
+				}
+				continue
+			}
+			if n > 0 {
+				w.logger.Warn("Requeued jobs with expired leases", zap.Int("count", n))
+			}
+		}
+	}
+}
+
+// promote moves retries whose backoff has elapsed back onto the queue
+func (w *Worker) promote(ctx context.Context) {
+	ticker := time.NewTicker(w.promoteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := w.store.PromoteDue(ctx); err != nil && ctx.Err() == nil {
+				w.logger.Error("Failed to promote retries", zap.Error(err))
+			}
+		}
 	}
 }
 
-func (w *Worker) processJob(ctx context.Context, id uuid.UUID) {
-	logger := w.logger.With(zap.String("job_id", id.String()))
+// emptyTrash periodically purges articles that have been in the trash longer than trashRetention
+func (w *Worker) emptyTrash(ctx context.Context) {
+	if w.trashRetention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(w.trashInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := store.EmptyTrash(ctx, w.store, time.Now().Add(-w.trashRetention))
+			if err != nil && ctx.Err() == nil {
+				w.logger.Error("Emptying trash failed", zap.Error(err))
+			}
+			if n > 0 {
+				w.logger.Info("Purged old articles from the trash", zap.Int("count", n))
+			}
+		}
+	}
+}
+
+// processJob archives one article. ctx only signals shutdown: store writes use
+// a non-cancelling copy, so a job that already got its page is still saved while draining.
+func (w *Worker) processJob(ctx context.Context, id uuid.UUID, name string) {
+	logger := w.logger.With(zap.String("job_id", id.String()), zap.String("worker", name))
 	logger.Info("Processing started")
+	storeCtx := context.WithoutCancel(ctx)
 
-	// Fetch the Pending Article
-	article, err := w.store.Get(ctx, id)
+	// Claim the article. This fails if it was trashed, already archived, or
+	// another worker has it, and then there is nothing for us to do. The job
+	// of an article another worker has is theirs to settle.
+	article, err := w.store.UpdateStatus(storeCtx, id, model.StatusEvent{To: model.StatusProcessing, Worker: name})
 	if err != nil {
-		logger.Error("Job failed: Article not found", zap.Error(err))
+		if errors.Is(err, model.ErrHeld) {
+			logger.Info("Skipping job, another worker has it", zap.Error(err))
+		} else if errors.Is(err, store.ErrNotFound) || errors.Is(err, model.ErrIllegalTransition) {
+			logger.Info("Skipping job", zap.Error(err))
+			w.ack(logger, id)
+		} else {
+			logger.Error("Failed to claim article", zap.Error(err))
+			w.nack(logger, id, "")
+		}
+		return
+	}
+
+	// Be polite: ask robots.txt first, then wait for this host's turn
+	if w.robots != nil {
+		allowed, err := w.robots.Allowed(ctx, article.URL)
+		if errors.Is(err, robots.ErrUnavailable) {
+			// Retried like a failed download, not given up on
+			logger.Warn("robots.txt unavailable", zap.String("url", article.URL))
+			w.failJob(storeCtx, logger, article, name, model.ErrorScrape, "robots.txt unavailable")
+			return
+		}
+		if err == nil && !allowed {
+			logger.Warn("Blocked by robots.txt", zap.String("url", article.URL))
+			w.failJob(storeCtx, logger, article, name, model.ErrorRobotsDisallowed, "blocked by robots.txt")
+			return
+		}
+	}
+	if err := w.limiter.Wait(ctx, article.URL); err != nil {
+		// Only fails when shutting down
+		w.nack(logger, id, name)
 		return
 	}
 
 	// Download & Scrape (Using the Interface)
 	logger.Info("Downloading", zap.String("url", article.URL))
 
-	parsedArticle, err := w.scraper.Scrape(article.URL, 30*time.Second)
+	page, err := w.scraper.Scrape(ctx, article.URL, ScrapeOptions{Timeout: w.scrapeTimeout})
 	if err != nil {
-		logger.Error("Scraping failed", zap.Error(err))
-		w.failJob(ctx, article, err.Error())
+		if ctx.Err() != nil {
+			// Shutting down, not the page's fault
+			w.nack(logger, id, name)
+			return
+		}
+		logger.Error("Scraping failed", zap.Error(err), zap.Int("attempt", article.Attempts+1))
+		w.failJob(storeCtx, logger, article, name, model.ErrorScrape, err.Error())
+		return
+	}
+
+	// Keep the page as downloaded, so extraction can be redone later without the network
+	if err := w.store.SaveRaw(storeCtx, id, page.Raw); err != nil {
+		logger.Error("Failed to save raw html", zap.Error(err))
+		w.nack(logger, id, name)
 		return
 	}
 
 	// Update Article
-	article.Title = parsedArticle.Title
-	article.Content = parsedArticle.Content
-	article.Excerpt = parsedArticle.Excerpt
-	article.Status = model.StatusArchived
-	now := time.Now()
-	article.ArchivedAt = &now
+	w.populate(article, &page.Article)
+	finalURL := page.FinalURL
+	if finalURL == "" {
+		finalURL = article.URL
+	}
+	article.CanonicalURL = canonicalURL(page.Raw, finalURL)
+	if err := w.storeImages(storeCtx, ctx, logger, article, finalURL, true); err != nil {
+		logger.Error("Failed to save image manifest", zap.Error(err))
+		w.nack(logger, id, name)
+		return
+	}
+
+	// A watched page that hasn't changed isn't worth another snapshot;
+	// one that has gets a diff against the last
+	var changes string
+	if article.Watched {
+		prev, err := w.previousSnapshot(storeCtx, article)
+		if err != nil {
+			logger.Error("Failed to find the previous snapshot", zap.Error(err))
+			w.nack(logger, id, name)
+			return
+		}
+		if prev != nil {
+			if prev.ContentHash == article.ContentHash {
+				logger.Info("Watched page unchanged, dropping the snapshot", zap.String("previous_id", prev.ID.String()))
+				if err := w.store.Delete(storeCtx, id); err != nil {
+					logger.Error("Failed to drop unchanged snapshot", zap.Error(err))
+				}
+				w.ack(logger, id)
+				return
+			}
+			article.PreviousID = &prev.ID
+			changes = store.TextDiff(prev, article)
+		}
+	}
 
-	// Save the result
-	if err := w.store.Save(ctx, article); err != nil {
-		logger.Error("Failed to save result", zap.Error(err))
+	// Save the result, if the article is still ours
+	if !w.finish(storeCtx, logger, article, name, model.StatusArchived, "") {
 		return
 	}
+	if err := w.store.SaveContent(storeCtx, article); err != nil {
+		logger.Error("Failed to save content", zap.Error(err))
+	}
+	if article.PreviousID != nil {
+		if err := w.store.SaveDiff(storeCtx, id, changes); err != nil {
+			logger.Error("Failed to save diff", zap.Error(err))
+		}
+	}
+	w.ack(logger, id)
 
 	logger.Info("Archiving complete", zap.String("title", article.Title))
 }
 
-func (w *Worker) failJob(ctx context.Context, article *model.Article, msg string) {
-	article.Status = model.StatusFailed
+// Reprocess re-runs extraction on the stored raw HTML of an article.
+// Useful after readability got smarter, or when the first pass mangled a page.
+func (w *Worker) Reprocess(ctx context.Context, id uuid.UUID) (*model.Article, error) {
+	article, err := w.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := w.store.GetRaw(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("no raw html stored for %s: %w", id, err)
+	}
+
+	parsed, err := Extract(raw, article.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	w.populate(article, parsed)
+	// Offline: only images we already have are rewritten
+	if err := w.storeImages(ctx, ctx, w.logger, article, article.URL, false); err != nil {
+		return nil, err
+	}
+	saved, err := w.store.UpdateMeta(ctx, id, func(a *model.Article) error {
+		copyResult(a, article)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	saved.Content = article.Content
+	if err := w.store.SaveContent(ctx, saved); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// storeImages localizes the article's images and records its asset manifest.
+// fetchCtx bounds the downloads (so shutdown stops them); storeCtx is for the writes.
+func (w *Worker) storeImages(storeCtx, fetchCtx context.Context, logger *zap.Logger, article *model.Article, baseURL string, fetch bool) error {
+	if !w.images.Enabled || article.Content == "" {
+		return nil
+	}
+
+	old, err := w.store.GetAssetManifest(storeCtx, article.ID)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]string, len(old))
+	for _, ref := range old {
+		known[ref.URL] = ref.Hash
+	}
+
+	content, refs := w.localizeImages(fetchCtx, logger, article.Content, baseURL, known, fetch && w.fetcher != nil)
+	if err := w.store.SetAssetManifest(storeCtx, article.ID, refs); err != nil {
+		return err
+	}
+	article.Content = content
+
+	// The lead image is usually in the body too; if so, point at our copy
+	base, _ := url.Parse(baseURL)
+	lead := resolveURL(base, article.Image)
+	for _, ref := range refs {
+		if ref.URL == lead {
+			article.Image = AssetPath + ref.Hash
+			break
+		}
+	}
+	return nil
+}
+
+// populate copies what readability found into the article. Its status is the caller's business.
+// Content is sanitized here, before images are localized and anything is saved.
+func (w *Worker) populate(article *model.Article, parsed *readability.Article) {
+	article.Title = parsed.Title
+	article.Content = w.sanitizer.Sanitize(parsed.Content)
+	article.Excerpt = parsed.Excerpt
+	article.Byline = parsed.Byline
+	article.SiteName = parsed.SiteName
+	article.Image = parsed.Image
+	article.Favicon = parsed.Favicon
+	article.Language = parsed.Language
+	article.PublishedTime = parsed.PublishedTime
+	article.Length = parsed.Length
+	article.WordCount = len(strings.Fields(parsed.TextContent))
+	article.ReadingTime = model.ReadingMinutes(article.WordCount)
+	article.ContentHash = diff.Hash(diff.Lines(article.Content))
+	article.ErrorMessage = ""
+	article.ErrorCode = ""
+	article.NextAttemptAt = nil
+	now := time.Now()
+	article.ArchivedAt = &now
+}
+
+// copyResult copies what processing produced from src into dst: the page's
+// fields, the retry bookkeeping and where the page really lives. The rest
+// (status, tags, the reader's flags, the trash) may have changed since src
+// was read, and is left to whoever changed it.
+func copyResult(dst, src *model.Article) {
+	dst.Title = src.Title
+	dst.Excerpt = src.Excerpt
+	dst.ArchivedAt = src.ArchivedAt
+	dst.ErrorMessage = src.ErrorMessage
+	dst.ErrorCode = src.ErrorCode
+	dst.CanonicalURL = src.CanonicalURL
+	dst.Attempts = src.Attempts
+	dst.NextAttemptAt = src.NextAttemptAt
+	dst.ContentHash = src.ContentHash
+	dst.PreviousID = src.PreviousID
+	dst.Byline = src.Byline
+	dst.SiteName = src.SiteName
+	dst.Image = src.Image
+	dst.Favicon = src.Favicon
+	dst.Language = src.Language
+	dst.PublishedTime = src.PublishedTime
+	dst.Length = src.Length
+	dst.WordCount = src.WordCount
+	dst.ReadingTime = src.ReadingTime
+}
+
+// retryable is false for failures that will fail the same way next time
+func retryable(code model.ErrorCode) bool {
+	return code != model.ErrorRobotsDisallowed
+}
+
+// ack and nack use a fresh context: the job must be settled even while ctx is being cancelled
+func (w *Worker) ack(logger *zap.Logger, id uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.store.Ack(ctx, id); err != nil {
+		logger.Error("Failed to ack job", zap.Error(err))
+	}
+}
+
+// nack also hands the article back to pending if name (the worker) still has it
+func (w *Worker) nack(logger *zap.Logger, id uuid.UUID, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if name != "" {
+		ev := model.StatusEvent{From: model.StatusProcessing, To: model.StatusPending, Worker: name}
+		if _, err := w.store.UpdateStatus(ctx, id, ev); err != nil && !errors.Is(err, model.ErrIllegalTransition) {
+			logger.Error("Failed to reset status", zap.Error(err))
+		}
+	}
+	if err := w.store.Nack(ctx, id); err != nil {
+		logger.Error("Failed to nack job", zap.Error(err))
+	}
+}
+
+// finish moves the article out of processing, recording msg as the error,
+// and writes the result (see copyResult) in the same update. Content is the
+// caller's to save after. It fails if the article was trashed, purged or
+// taken over by another worker meanwhile, and false is returned. The job is
+// settled then, since its result is no longer wanted, unless another worker
+// has it now and settles it. Otherwise article is what was written, content kept.
+func (w *Worker) finish(ctx context.Context, logger *zap.Logger, article *model.Article, name string, to model.ArticleStatus, msg string) bool {
+	ev := model.StatusEvent{From: model.StatusProcessing, To: to, Worker: name, Error: msg}
+	done, err := w.store.UpdateMeta(ctx, article.ID, func(a *model.Article) error {
+		if err := a.Transition(ev); err != nil {
+			return err
+		}
+		copyResult(a, article)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, model.ErrHeld) {
+			logger.Warn("Another worker took the article over, dropping the result", zap.Error(err))
+		} else if errors.Is(err, store.ErrNotFound) || errors.Is(err, model.ErrIllegalTransition) {
+			logger.Warn("Article moved on while processing, dropping the result", zap.Error(err))
+			w.ack(logger, article.ID)
+		} else {
+			logger.Error("Failed to update status", zap.Error(err))
+			w.nack(logger, article.ID, name)
+		}
+		return false
+	}
+	done.Content = article.Content
+	*article = *done
+	return true
+}
+
+// failJob either schedules another attempt or, once the policy gives up (or
+// retrying can't help), marks the article failed and parks the job in the dead-letter queue.
+func (w *Worker) failJob(ctx context.Context, logger *zap.Logger, article *model.Article, name string, code model.ErrorCode, msg string) {
+	article.Attempts++
 	article.ErrorMessage = msg
-	w.store.Save(ctx, article)
+	article.ErrorCode = code
+
+	if !retryable(code) || w.retry.Exhausted(article.Attempts) {
+		article.NextAttemptAt = nil
+		if !w.finish(ctx, logger, article, name, model.StatusFailed, msg) {
+			return
+		}
+		if err := w.store.DeadLetter(ctx, article.ID); err != nil {
+			logger.Error("Failed to dead-letter job", zap.Error(err))
+		}
+		logger.Warn("Giving up, moved to dead-letter queue", zap.Int("attempts", article.Attempts))
+		return
+	}
+
+	next := time.Now().Add(w.retry.Backoff(article.Attempts))
+	article.NextAttemptAt = &next
+	if !w.finish(ctx, logger, article, name, model.StatusRetrying, msg) {
+		return
+	}
+	if err := w.store.Schedule(ctx, article.ID, next); err != nil {
+		logger.Error("Failed to schedule retry", zap.Error(err))
+		w.nack(logger, article.ID, "")
+		return
+	}
+	logger.Info("Retry scheduled", zap.Time("next_attempt_at", next))
 }
\ No newline at end of file
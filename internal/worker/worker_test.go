@@ -2,6 +2,10 @@ package worker
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 	"fmt"
@@ -12,6 +16,7 @@ import (
 	"github.com/alicebob/miniredis/v2"
 	"github.com/dgraph-io/badger/v4"
 	"github.com/go-shiori/go-readability"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -21,17 +26,44 @@ type MockScraper struct {
 	MockTitle   string
 	MockContent string
 	ShouldFail  bool
+	Delay       time.Duration // Pretend the download takes this long
+	OnScrape    func()        // Runs at the start of every Scrape
+
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
 }
 
-// Scrape simulates article scraping
-func (m *MockScraper) Scrape(url string, timeout time.Duration) (*readability.Article, error) {
+// Scrape simulates article scraping; like the real one, it gives up when ctx ends
+func (m *MockScraper) Scrape(ctx context.Context, url string, opts ScrapeOptions) (*Page, error) {
+	if m.OnScrape != nil {
+		m.OnScrape()
+	}
+	n := m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+	for {
+		max := m.maxInFlight.Load()
+		if n <= max || m.maxInFlight.CompareAndSwap(max, n) {
+			break
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(m.Delay):
+	}
+
 	if m.ShouldFail {
 		return nil, fmt.Errorf("simulated 404 error")
 	}
-	return &readability.Article{
-		Title:   m.MockTitle,
-		Content: m.MockContent,
-		Excerpt: "A short summary",
+	return &Page{
+		Article: readability.Article{
+			Title:   m.MockTitle,
+			Content: m.MockContent,
+			Excerpt: "A short summary",
+		},
+		Raw:      []byte("<html><body>" + m.MockContent + "</body></html>"),
+		FinalURL: url,
 	}, nil
 }
 
@@ -92,9 +124,9 @@ func TestWorker_HandlesScrapeFailure(t *testing.T) {
 	st, _ := store.NewHybridStore(mr.Addr(), t.TempDir())
 	defer st.Close()
 
-	// Setup Worker with a BROKEN Scraper
+	// Setup Worker with a BROKEN Scraper and no second chances
 	logger := zap.NewNop()
-	w := NewWorker(st, logger)
+	w := NewWorker(st, logger, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
 	w.scraper = &MockScraper{
 		ShouldFail: true, // This will cause Scrape() to error
 	}
@@ -115,4 +147,523 @@ func TestWorker_HandlesScrapeFailure(t *testing.T) {
 
 	assert.Equal(t, model.StatusFailed, savedArticle.Status)
 	assert.Equal(t, "simulated 404 error", savedArticle.ErrorMessage)
-}
\ No newline at end of file
+	assert.Equal(t, 1, savedArticle.Attempts)
+
+	// Out of attempts means dead-lettered
+	dead, err := st.ListDeadLetters(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{article.ID}, dead)
+}
+// TestWorker_RecoversJobFromDeadWorker pops a job without finishing it (a
+// worker that died mid-job) and checks the next worker's reaper picks it up
+func TestWorker_RecoversJobFromDeadWorker(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := store.NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+	st.SetLeaseTTL(50 * time.Millisecond)
+
+	article := model.NewArticle("http://fake-url.com")
+	require.NoError(t, st.Save(context.Background(), &article))
+
+	// The dead worker: took the job, never acked
+	_, err = st.PopQueue(context.Background())
+	require.NoError(t, err)
+
+	w := NewWorker(st, zap.NewNop())
+	w.reapInterval = 20 * time.Millisecond
+	w.scraper = &MockScraper{MockTitle: "Recovered"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go w.Start(ctx)
+
+	assert.Eventually(t, func() bool {
+		got, err := st.Get(context.Background(), article.ID)
+		return err == nil && got.Status == model.StatusArchived
+	}, 2*time.Second, 20*time.Millisecond)
+	cancel()
+
+	processing, _ := mr.List("queue:processing")
+	assert.Empty(t, processing, "Recovered job should be acked")
+}
+
+// TestWorker_SecondClaimWaitsForLease has two workers claim the same article:
+// the second gets it only once the first one's lease ran out and was reaped
+func TestWorker_SecondClaimWaitsForLease(t *testing.T) {
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+	st.SetLeaseTTL(50 * time.Millisecond)
+
+	ctx := context.Background()
+	article := model.NewArticle("http://example.com/post")
+	require.NoError(t, st.Save(ctx, &article))
+	id, err := st.PopQueue(ctx)
+	require.NoError(t, err)
+	_, err = st.UpdateStatus(ctx, id, model.StatusEvent{To: model.StatusProcessing, Worker: "w1"})
+	require.NoError(t, err)
+
+	w := NewWorker(st, zap.NewNop(), WithPoliteness(PolitenessConfig{}))
+	w.scraper = &MockScraper{MockTitle: "Title", MockContent: "<p>Body</p>"}
+	w.processJob(ctx, id, "w2")
+	got, err := st.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusProcessing, got.Status)
+	assert.Equal(t, "w1", got.Worker(), "w1 still has it")
+	assert.Empty(t, got.Title)
+
+	time.Sleep(60 * time.Millisecond)
+	n, err := st.RequeueExpired(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	id, err = st.PopQueue(ctx)
+	require.NoError(t, err)
+	w.processJob(ctx, id, "w2")
+	got, err = st.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusArchived, got.Status)
+	assert.Equal(t, "w2", got.History[len(got.History)-1].Worker)
+}
+
+// FlakyScraper fails the first FailTimes calls, then succeeds
+type FlakyScraper struct {
+	FailTimes int
+	calls     int
+}
+
+func (f *FlakyScraper) Scrape(ctx context.Context, url string, opts ScrapeOptions) (*Page, error) {
+	f.calls++
+	if f.calls <= f.FailTimes {
+		return nil, fmt.Errorf("simulated 503 error")
+	}
+	return &Page{Article: readability.Article{Title: "Finally"}, FinalURL: url}, nil
+}
+
+// TestWorker_RetriesTransientFailures checks a failing scrape is retried
+// after its backoff instead of being marked failed straight away
+func TestWorker_RetriesTransientFailures(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := store.NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+
+	scraper := &FlakyScraper{FailTimes: 2}
+	w := NewWorker(st, zap.NewNop(), WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    50 * time.Millisecond,
+	}), WithPoliteness(PolitenessConfig{}))
+	w.promoteInterval = 5 * time.Millisecond
+	w.scraper = scraper
+
+	article := model.NewArticle("http://flaky.com")
+	require.NoError(t, st.Save(context.Background(), &article))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go w.Start(ctx)
+
+	assert.Eventually(t, func() bool {
+		got, err := st.Get(context.Background(), article.ID)
+		return err == nil && got.Status == model.StatusArchived
+	}, 2*time.Second, 10*time.Millisecond)
+	cancel()
+
+	got, err := st.Get(context.Background(), article.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Attempts)
+	assert.Equal(t, "Finally", got.Title)
+	assert.Empty(t, got.ErrorMessage)
+	assert.Equal(t, 3, scraper.calls)
+
+	dead, err := st.ListDeadLetters(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, dead)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	assert.Equal(t, time.Second, p.Backoff(1))
+	assert.Equal(t, 2*time.Second, p.Backoff(2))
+	assert.Equal(t, 8*time.Second, p.Backoff(4))
+	assert.Equal(t, 10*time.Second, p.Backoff(5), "Capped at MaxDelay")
+	assert.Equal(t, 10*time.Second, p.Backoff(60), "No overflow on silly attempt counts")
+
+	assert.False(t, p.Exhausted(9))
+	assert.True(t, p.Exhausted(10))
+}
+
+// TestWorker_ProcessesJobsInParallel checks N workers really run N scrapes at once
+func TestWorker_ProcessesJobsInParallel(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := store.NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+
+	const n = 4
+	scraper := &MockScraper{MockTitle: "Parallel", Delay: 200 * time.Millisecond}
+	w := NewWorker(st, zap.NewNop(), WithConcurrency(n), WithPoliteness(PolitenessConfig{}))
+	w.scraper = scraper
+
+	var ids []uuid.UUID
+	for i := 0; i < n; i++ {
+		a := model.NewArticle(fmt.Sprintf("http://fake-url.com/%d", i))
+		require.NoError(t, st.Save(context.Background(), &a))
+		ids = append(ids, a.ID)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	go w.Start(ctx)
+
+	assert.Eventually(t, func() bool {
+		for _, id := range ids {
+			got, err := st.Get(context.Background(), id)
+			if err != nil || got.Status != model.StatusArchived {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// One at a time would take n*200ms
+	assert.Less(t, time.Since(start), time.Duration(n)*200*time.Millisecond)
+	assert.Equal(t, int32(n), scraper.maxInFlight.Load())
+}
+
+// TestWorker_CancelDrainsInFlightJobs checks cancel aborts running downloads,
+// hands those jobs back to the queue and only then lets Start return
+func TestWorker_CancelDrainsInFlightJobs(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := store.NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+
+	// A download that would take far longer than the test
+	scraper := &MockScraper{MockTitle: "Never", Delay: time.Minute}
+	w := NewWorker(st, zap.NewNop(), WithConcurrency(2), WithPoliteness(PolitenessConfig{}))
+	w.scraper = scraper
+
+	var ids []uuid.UUID
+	for i := 0; i < 3; i++ {
+		a := model.NewArticle(fmt.Sprintf("http://fake-url.com/%d", i))
+		require.NoError(t, st.Save(context.Background(), &a))
+		ids = append(ids, a.ID)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Start(ctx)
+	}()
+
+	// Cancel while both workers are mid-scrape
+	assert.Eventually(t, func() bool { return scraper.inFlight.Load() == 2 }, time.Second, 5*time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start did not return after cancel")
+	}
+	assert.Equal(t, int32(0), scraper.inFlight.Load(), "Start returned with scrapes still running")
+
+	for _, id := range ids {
+		got, err := st.Get(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, model.StatusPending, got.Status, "Shutdown is not the page's fault")
+		assert.Zero(t, got.Attempts)
+	}
+
+	queue, _ := mr.List("queue:archive")
+	assert.Len(t, queue, 3, "Aborted jobs go back on the queue")
+	processing, _ := mr.List("queue:processing")
+	assert.Empty(t, processing, "Nothing should be left leased")
+}
+
+func TestLimiter_SpacesRequestsPerHost(t *testing.T) {
+	l := newLimiter(PolitenessConfig{Rate: 1, Burst: 2, MinDelay: 100 * time.Millisecond})
+	now := time.Now()
+
+	// Burst of 2, but still MinDelay apart
+	assert.Equal(t, time.Duration(0), l.reserve("a.com", now))
+	assert.Equal(t, 100*time.Millisecond, l.reserve("a.com", now))
+	// Bucket is empty: wait for a full token
+	assert.Equal(t, time.Second, l.reserve("a.com", now))
+
+	// Other hosts don't care
+	assert.Equal(t, time.Duration(0), l.reserve("b.com", now))
+}
+
+// TestWorker_RobotsDisallowed checks a URL blocked by robots.txt fails
+// straight away with its own error code and is never scraped
+func TestWorker_RobotsDisallowed(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	}))
+	defer site.Close()
+
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	st, err := store.NewHybridStore(mr.Addr(), t.TempDir())
+	require.NoError(t, err)
+	defer st.Close()
+
+	scraper := &MockScraper{MockTitle: "Should not happen"}
+	w := NewWorker(st, zap.NewNop(), WithPoliteness(PolitenessConfig{Robots: true}))
+	w.scraper = scraper
+
+	article := model.NewArticle(site.URL + "/private/page")
+	require.NoError(t, st.Save(context.Background(), &article))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go w.Start(ctx)
+
+	assert.Eventually(t, func() bool {
+		got, err := st.Get(context.Background(), article.ID)
+		return err == nil && got.Status == model.StatusFailed
+	}, 2*time.Second, 10*time.Millisecond)
+	cancel()
+
+	got, err := st.Get(context.Background(), article.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.ErrorRobotsDisallowed, got.ErrorCode)
+	assert.Equal(t, 1, got.Attempts, "No retries for a robots block")
+	assert.Equal(t, int32(0), scraper.maxInFlight.Load(), "Scraper must not be called")
+}
+
+// TestWorker_RobotsThroughScraperProxy checks robots.txt is fetched with the
+// scraper's transport, whichever order the options come in
+func TestWorker_RobotsThroughScraperProxy(t *testing.T) {
+	var asked atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked.Store(r.URL.String())
+		fmt.Fprint(w, "User-agent: *\nDisallow: /\n")
+	}))
+	defer proxy.Close()
+
+	scraper, err := NewDefaultScraper(ScraperConfig{Proxy: proxy.URL})
+	require.NoError(t, err)
+	w := NewWorker(nil, zap.NewNop(), WithPoliteness(PolitenessConfig{Robots: true}), WithScraper(scraper))
+
+	allowed, err := w.robots.Allowed(context.Background(), "http://unreachable.invalid/page")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, "http://unreachable.invalid/robots.txt", asked.Load())
+}
+
+// TestWorker_Reprocess checks extraction can be redone from the stored raw HTML
+func TestWorker_Reprocess(t *testing.T) {
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx := context.Background()
+	article := model.NewArticle("http://example.com/post")
+	article.Status = model.StatusArchived
+	article.Title = "Mangled"
+	require.NoError(t, st.Save(ctx, &article))
+	require.NoError(t, st.SaveRaw(ctx, article.ID, []byte(
+		`<html><head><title>Proper Title</title></head><body><article><p>`+
+			"Enough words here for readability to pick this paragraph up as the body. "+
+			`</p></article></body></html>`)))
+
+	w := NewWorker(st, zap.NewNop())
+	w.scraper = &MockScraper{ShouldFail: true} // Proves the network is never used
+
+	got, err := w.Reprocess(ctx, article.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Proper Title", got.Title)
+
+	saved, err := st.Get(ctx, article.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Proper Title", saved.Title)
+	assert.Contains(t, saved.Content, "Enough words here")
+}
+
+// TestWorker_SanitizesContent checks nothing executable makes it into the store
+func TestWorker_SanitizesContent(t *testing.T) {
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx := context.Background()
+	article := model.NewArticle("http://example.com/post")
+	require.NoError(t, st.Save(ctx, &article))
+
+	w := NewWorker(st, zap.NewNop(), WithPoliteness(PolitenessConfig{}))
+	w.scraper = &MockScraper{
+		MockTitle:   "Evil",
+		MockContent: `<p onclick="alert(1)" style="color:red">fake</p><script>alert(1)</script><iframe src="https://evil.example"></iframe>`,
+	}
+
+	id, err := st.PopQueue(ctx)
+	require.NoError(t, err)
+	w.processJob(ctx, id, "test")
+
+	saved, err := st.Get(ctx, article.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusArchived, saved.Status)
+	assert.Equal(t, "<p>fake</p>", saved.Content)
+}
+
+// TestWorker_Metadata checks what readability finds besides the text ends up on the article
+func TestWorker_Metadata(t *testing.T) {
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx := context.Background()
+	article := model.NewArticle("http://example.com/post")
+	require.NoError(t, st.Save(ctx, &article))
+	require.NoError(t, st.SaveRaw(ctx, article.ID, []byte(`<html lang="en-GB"><head>
+<title>Proper Title</title>
+<meta property="og:site_name" content="Example Times">
+<meta name="author" content="Jane Doe">
+<meta property="article:published_time" content="2024-03-01T10:00:00Z">
+<meta property="og:image" content="https://example.com/lead.jpg">
+</head><body><article><p>`+strings.Repeat("word ", 500)+`</p></article></body></html>`)))
+
+	w := NewWorker(st, zap.NewNop(), WithImages(ImageConfig{}))
+	got, err := w.Reprocess(ctx, article.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Example Times", got.SiteName)
+	assert.Equal(t, "Jane Doe", got.Byline)
+	assert.Equal(t, "en-GB", got.Language)
+	assert.Equal(t, "https://example.com/lead.jpg", got.Image)
+	require.NotNil(t, got.PublishedTime)
+	assert.Equal(t, 2024, got.PublishedTime.Year())
+	assert.Equal(t, 500, got.WordCount)
+	assert.Equal(t, 3, got.ReadingTime)
+	assert.Positive(t, got.Length)
+}
+
+// TestWorker_EmptiesOldTrash checks the background purge leaves recent deletions alone
+func TestWorker_EmptiesOldTrash(t *testing.T) {
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	old := model.NewArticle("http://example.com/old")
+	old.Status = model.StatusArchived
+	deleted := time.Now().Add(-48 * time.Hour)
+	old.DeletedAt = &deleted
+	require.NoError(t, st.Save(ctx, &old))
+
+	recent := model.NewArticle("http://example.com/recent")
+	recent.Status = model.StatusArchived
+	require.NoError(t, st.Save(ctx, &recent))
+	require.NoError(t, st.Trash(ctx, recent.ID))
+
+	w := NewWorker(st, zap.NewNop(), WithTrashRetention(24*time.Hour))
+	w.trashInterval = 10 * time.Millisecond
+	go w.Start(ctx)
+
+	assert.Eventually(t, func() bool {
+		_, err := st.Get(ctx, old.ID)
+		return err == store.ErrNotFound
+	}, 2*time.Second, 20*time.Millisecond)
+
+	_, err = st.Get(ctx, recent.ID)
+	assert.NoError(t, err)
+}
+
+// TestWorker_TrashedWhileProcessing checks a result is dropped once the article
+// has moved on, and that a normal run records who did what
+func TestWorker_TrashedWhileProcessing(t *testing.T) {
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx := context.Background()
+	kept := model.NewArticle("http://example.com/kept")
+	require.NoError(t, st.Save(ctx, &kept))
+	trashed := model.NewArticle("http://example.com/trashed")
+	require.NoError(t, st.Save(ctx, &trashed))
+
+	scraper := &MockScraper{MockTitle: "Title", MockContent: "<p>Body</p>"}
+	w := NewWorker(st, zap.NewNop(), WithPoliteness(PolitenessConfig{}))
+	w.scraper = scraper
+
+	id, err := st.PopQueue(ctx)
+	require.NoError(t, err)
+	require.Equal(t, kept.ID, id)
+	w.processJob(ctx, id, "w1")
+
+	got, err := st.Get(ctx, kept.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusArchived, got.Status)
+	require.Len(t, got.History, 3)
+	assert.Equal(t, model.StatusProcessing, got.History[1].To)
+	assert.Equal(t, "w1", got.History[1].Worker)
+	assert.Equal(t, "w1", got.History[2].Worker)
+
+	scraper.OnScrape = func() { require.NoError(t, st.Trash(ctx, trashed.ID)) }
+	id, err = st.PopQueue(ctx)
+	require.NoError(t, err)
+	w.processJob(ctx, id, "w1")
+
+	got, err = st.Get(ctx, trashed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusDeleted, got.Status)
+	assert.Empty(t, got.Title, "The result must not bring it back")
+	assert.Equal(t, model.StatusProcessing, got.History[len(got.History)-1].From)
+}
+
+// TestWorker_KeepsReaderChangesWhileProcessing checks the result doesn't
+// overwrite tags or flags set after the article was claimed
+func TestWorker_KeepsReaderChangesWhileProcessing(t *testing.T) {
+	st, err := store.NewBadgerStore("")
+	require.NoError(t, err)
+	defer st.Close()
+
+	ctx := context.Background()
+	article := model.NewArticle("http://example.com/post")
+	article.Tags = []string{"first"}
+	require.NoError(t, st.Save(ctx, &article))
+
+	yes := true
+	scraper := &MockScraper{MockTitle: "Title", MockContent: "<p>Body</p>"}
+	scraper.OnScrape = func() {
+		// crusty add --tag of the same URL, then the reader favorites it
+		_, created, err := store.Add(ctx, st, "http://example.com/post", []string{"second"}, store.DedupeVersion)
+		require.NoError(t, err)
+		require.False(t, created)
+		_, err = store.UpdateFlags(ctx, st, article.ID, store.FlagUpdate{Favorite: &yes})
+		require.NoError(t, err)
+	}
+	w := NewWorker(st, zap.NewNop(), WithPoliteness(PolitenessConfig{}))
+	w.scraper = scraper
+
+	id, err := st.PopQueue(ctx)
+	require.NoError(t, err)
+	w.processJob(ctx, id, "w1")
+
+	got, err := st.Get(ctx, article.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusArchived, got.Status)
+	assert.Equal(t, "Title", got.Title)
+	assert.Equal(t, "<p>Body</p>", got.Content)
+	assert.Equal(t, []string{"first", "second"}, got.Tags)
+	assert.True(t, got.Favorite)
+}